@@ -50,10 +50,210 @@ type RedisClusterSpec struct {
 	// Labels for the Redis pods.
 	Labels map[string]string `json:"labels,omitempty"`
 
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// The port Redis listens on for clients. It must match the port set in the
+	// Redis configuration. Default is 6379.
+	Port int32 `json:"port,omitempty"`
+
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// The port of the cluster bus. It must match the cluster-port set in the Redis
+	// configuration. Default is port + 10000.
+	BusPort int32 `json:"busPort,omitempty"`
+
+	// +optional
+	// Flag that makes every node announce the DNS name of its headless Service
+	// (cluster-announce-hostname) so that clients are redirected to stable
+	// hostnames instead of pod IPs. Requires Redis 7. Default is false.
+	AnnounceHostnames bool `json:"announceHostnames,omitempty"`
+
+	// +optional
+	// Exposes every node outside of the Kubernetes cluster through its own Service
+	// and makes the nodes announce the external addresses, so that the redirects
+	// sent to the clients can be followed from outside. Disabled by default.
+	ExternalAccess *ExternalAccessSpec `json:"externalAccess,omitempty"`
+
+	// +optional
+	// Restores the leadership of the designated leader pods after Redis failed
+	// over on its own. Disabled by default.
+	PreferredLeaders *PreferredLeadersSpec `json:"preferredLeaders,omitempty"`
+
+	// +optional
+	// How the slots that are not served by any leader or left open by an
+	// interrupted migration are repaired. Disabled only reports them through the
+	// status conditions; OpenSlots finishes or rolls back the interrupted
+	// migrations; All also assigns the uncovered slots to the leaders, which
+	// accepts the loss of the keys they held. Default is Disabled.
+	SlotRepair SlotRepairPolicy `json:"slotRepair,omitempty"`
+
+	// +optional
+	// Migrates slots from the largest leaders to the smallest ones when their
+	// sizes differ too much. Disabled by default.
+	Rebalance *RebalanceSpec `json:"rebalance,omitempty"`
+
+	// +optional
+	// Pins slot ranges to leaders, e.g. to isolate the hash tags of a tenant on
+	// a shard of its own. The pinned slots are assigned when the cluster is
+	// created, moved back to their leader when another leader serves them, even
+	// with the rebalancing disabled, and never moved away by the rebalancing.
+	// The drift is reported through the SlotAssignmentsApplied condition. Only
+	// the leaders of the cluster can be pinned: adding leaders to a running
+	// cluster is not supported, an assignment to a new leader number is invalid.
+	SlotAssignments []SlotAssignment `json:"slotAssignments,omitempty"`
+
+	// +optional
+	// Restores the latest backup of a shard that lost its leader and all its
+	// followers to the recreated leader pod. Disabled by default.
+	Backup *BackupSpec `json:"backup,omitempty"`
+
+	// +optional
+	// Lets the operator assign the slots of a shard that lost its leader and
+	// all its followers to a new empty leader when the recreated pod could
+	// load no data from its persistent volume nor from a backup. Default is
+	// false: the cluster stays in Recovering until the leader loads the data
+	// or the loss is allowed.
+	AllowDataLoss bool `json:"allowDataLoss,omitempty"`
+
 	// PodSpec for Redis pods.
 	RedisPodSpec corev1.PodSpec `json:"redisPodSpec"`
 }
 
+// SlotRepairPolicy defines which slot problems the operator repairs
+// +kubebuilder:validation:Enum=Disabled;OpenSlots;All
+type SlotRepairPolicy string
+
+const (
+	SlotRepairDisabled  SlotRepairPolicy = "Disabled"
+	SlotRepairOpenSlots SlotRepairPolicy = "OpenSlots"
+	SlotRepairAll       SlotRepairPolicy = "All"
+)
+
+// SlotAssignment pins a slot range to a leader
+type SlotAssignment struct {
+	// Name of the assignment, e.g. the tenant owning the slots.
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:Pattern=`^[0-9]+(-[0-9]+)?$`
+	// The slot range, e.g. 1000-1099.
+	Slots string `json:"slots"`
+
+	// +kubebuilder:validation:Minimum=0
+	// The leader number of the shard serving the slots.
+	LeaderNumber int `json:"leaderNumber"`
+}
+
+// RebalanceSpec configures the slot migrations that even out the size of the leaders.
+type RebalanceSpec struct {
+	// Flag that toggles the automatic rebalancing.
+	Enabled bool `json:"enabled"`
+
+	// +optional
+	// Any new value starts a rebalancing, even when the automatic rebalancing is
+	// disabled.
+	Trigger string `json:"trigger,omitempty"`
+
+	// +optional
+	// The size of a leader: the used_memory or the number of keys reported by
+	// INFO. Default is Memory.
+	Metric RebalanceMetric `json:"metric,omitempty"`
+
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// Maximum difference between the size of a leader and the average size of
+	// the leaders, in percent of the average. Default is 10.
+	TolerancePercent int32 `json:"tolerancePercent,omitempty"`
+
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	// Maximum number of slots migrated per reconcile loop. Default is 16.
+	SlotsPerReconcile int32 `json:"slotsPerReconcile,omitempty"`
+
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	// Number of keys moved by a single MIGRATE command. Default is 100.
+	KeysPerBatch int32 `json:"keysPerBatch,omitempty"`
+
+	// +optional
+	// Pause between two MIGRATE commands. Default is no pause.
+	BatchInterval *metav1.Duration `json:"batchInterval,omitempty"`
+
+	// +optional
+	// Minimum time between the end of a rebalancing and the start of an
+	// automatic one. Default is 1h.
+	MinInterval *metav1.Duration `json:"minInterval,omitempty"`
+}
+
+// RebalanceMetric is the measure of the size of a leader
+// +kubebuilder:validation:Enum=Memory;Keys
+type RebalanceMetric string
+
+const (
+	RebalanceByMemory RebalanceMetric = "Memory"
+	RebalanceByKeys   RebalanceMetric = "Keys"
+)
+
+// PreferredLeadersSpec configures the graceful failovers that move the leadership
+// back to the designated leader pods.
+type PreferredLeadersSpec struct {
+	// Flag that toggles the restoration of the designated leaders.
+	Enabled bool `json:"enabled"`
+
+	// +optional
+	// Minimum time between two failovers started by the operator to restore a
+	// leader. Default is 5m.
+	MinInterval *metav1.Duration `json:"minInterval,omitempty"`
+
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	// Maximum difference between the replication offset of a leader and the offsets
+	// of its followers for the shard to be considered in sync. Shards that lag
+	// behind more are skipped. Default is 1048576 (1MiB).
+	MaxReplicationLag int64 `json:"maxReplicationLag,omitempty"`
+}
+
+// ExternalAccessSpec configures the Services that expose the nodes outside of
+// the Kubernetes cluster.
+type ExternalAccessSpec struct {
+	// Flag that toggles the external access.
+	Enabled bool `json:"enabled"`
+
+	// +optional
+	// +kubebuilder:validation:Enum=LoadBalancer;NodePort
+	// The type of the Service created for every node. A LoadBalancer node is
+	// announced with the IP of its load balancer, or with its hostname when it
+	// has no IP like the AWS ELBs (Redis 7 or later), a NodePort node with the
+	// address of the Kubernetes node running its pod. Default is LoadBalancer.
+	Type corev1.ServiceType `json:"type,omitempty"`
+
+	// +optional
+	// Annotations for the Services, e.g. to configure the load balancers.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// BackupSpec configures the init container that restores the latest backup
+// of a lost shard before Redis starts in its recreated leader pod.
+type BackupSpec struct {
+	// The image of the restore container, e.g. one with the client of the
+	// object storage holding the backups.
+	Image string `json:"image"`
+
+	// The command of the restore container. It mounts the volumes of the Redis
+	// container and must copy the latest RDB file of the shard, told by the
+	// LEADER_NUMBER environment variable, to the data directory of Redis. It
+	// must succeed without copying anything when the shard has no backup or
+	// when the persistent volume already holds the data of the shard.
+	Command []string `json:"command"`
+
+	// +optional
+	// A Secret whose keys are set as environment variables of the restore
+	// container, e.g. the credentials of the object storage.
+	SecretName string `json:"secretName,omitempty"`
+}
+
 // RedisClusterStatus defines the observed state of RedisCluster
 type RedisClusterStatus struct {
 	// A list of pointers to currently running pods.
@@ -67,6 +267,135 @@ type RedisClusterStatus struct {
 	// The total expected pod number when the cluster is ready and stable.
 	// +optional
 	TotalExpectedPods int `json:"totalExpectedPods,omitempty"`
+
+	// The time of the last failover started to restore a designated leader.
+	// +optional
+	LastLeaderRestoration *metav1.Time `json:"lastLeaderRestoration,omitempty"`
+
+	// The latest observations of the state of the cluster.
+	// +optional
+	Conditions []RedisClusterCondition `json:"conditions,omitempty"`
+
+	// The plan and the progress of the last slot rebalancing.
+	// +optional
+	Rebalance *RebalanceStatus `json:"rebalance,omitempty"`
+
+	// The recoveries of the shards that lost their leader and all their
+	// followers, one per leader number.
+	// +optional
+	ShardRecoveries []ShardRecoveryStatus `json:"shardRecoveries,omitempty"`
+}
+
+// ShardRecoveryStatus is the progress of the recovery of a shard that lost its
+// leader and all its followers
+type ShardRecoveryStatus struct {
+	// The leader number of the shard.
+	LeaderNumber string `json:"leaderNumber"`
+
+	// The current step of the recovery.
+	Phase ShardRecoveryPhase `json:"phase"`
+
+	// The slot ranges served by the lost shard, e.g. 0-5460.
+	// +optional
+	Slots string `json:"slots,omitempty"`
+
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// A description of the last step.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// ShardRecoveryPhase is a step of the recovery of a lost shard
+type ShardRecoveryPhase string
+
+const (
+	// The pods of the shard are being recreated
+	ShardRecoveryRecreating ShardRecoveryPhase = "Recreating"
+	// The new leader has no data and the spec does not allow the data loss
+	ShardRecoveryWaitingForData ShardRecoveryPhase = "WaitingForData"
+	// The new leader restored the data of the shard and serves its slots
+	ShardRecoveryRestored ShardRecoveryPhase = "Restored"
+	// The slots of the shard were assigned to a new empty leader
+	ShardRecoveryDataLost ShardRecoveryPhase = "DataLost"
+)
+
+// RebalanceStatus is the plan of a slot rebalancing and its progress
+type RebalanceStatus struct {
+	// InProgress while slots are being migrated, then Completed.
+	Phase RebalancePhase `json:"phase"`
+
+	// The trigger of the spec when the rebalancing started.
+	// +optional
+	Trigger string `json:"trigger,omitempty"`
+
+	// The size measure used to plan the rebalancing.
+	Metric RebalanceMetric `json:"metric"`
+
+	// The slot ranges to migrate.
+	// +optional
+	Moves []SlotMove `json:"moves,omitempty"`
+
+	// The number of slots to migrate.
+	TotalSlots int32 `json:"totalSlots"`
+
+	// The number of slots migrated so far.
+	MigratedSlots int32 `json:"migratedSlots"`
+
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// The last error met while migrating the slots.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// RebalancePhase is the phase of a slot rebalancing
+type RebalancePhase string
+
+const (
+	RebalanceInProgress RebalancePhase = "InProgress"
+	RebalanceCompleted  RebalancePhase = "Completed"
+)
+
+// SlotMove is a range of slots migrated from a leader to another one
+type SlotMove struct {
+	// The slot range, e.g. 100-120.
+	Slots string `json:"slots"`
+
+	// The leader number of the source.
+	From string `json:"from"`
+
+	// The leader number of the target.
+	To string `json:"to"`
+}
+
+// RedisClusterCondition describes an aspect of the state of the cluster
+type RedisClusterCondition struct {
+	// Type of the condition, e.g. SlotsCovered.
+	Type string `json:"type"`
+
+	// Status of the condition: True, False or Unknown.
+	Status corev1.ConditionStatus `json:"status"`
+
+	// The last time the status changed.
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+
+	// A CamelCase reason for the last transition.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// A human readable description of the last transition.
+	// +optional
+	Message string `json:"message,omitempty"`
 }
 
 // +kubebuilder:object:root=true
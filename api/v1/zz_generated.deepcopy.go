@@ -22,9 +22,125 @@ package v1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupSpec) DeepCopyInto(out *BackupSpec) {
+	*out = *in
+	if in.Command != nil {
+		in, out := &in.Command, &out.Command
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupSpec.
+func (in *BackupSpec) DeepCopy() *BackupSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExternalAccessSpec) DeepCopyInto(out *ExternalAccessSpec) {
+	*out = *in
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExternalAccessSpec.
+func (in *ExternalAccessSpec) DeepCopy() *ExternalAccessSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ExternalAccessSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PreferredLeadersSpec) DeepCopyInto(out *PreferredLeadersSpec) {
+	*out = *in
+	if in.MinInterval != nil {
+		in, out := &in.MinInterval, &out.MinInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PreferredLeadersSpec.
+func (in *PreferredLeadersSpec) DeepCopy() *PreferredLeadersSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PreferredLeadersSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RebalanceSpec) DeepCopyInto(out *RebalanceSpec) {
+	*out = *in
+	if in.BatchInterval != nil {
+		in, out := &in.BatchInterval, &out.BatchInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.MinInterval != nil {
+		in, out := &in.MinInterval, &out.MinInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RebalanceSpec.
+func (in *RebalanceSpec) DeepCopy() *RebalanceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RebalanceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RebalanceStatus) DeepCopyInto(out *RebalanceStatus) {
+	*out = *in
+	if in.Moves != nil {
+		in, out := &in.Moves, &out.Moves
+		*out = make([]SlotMove, len(*in))
+		copy(*out, *in)
+	}
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RebalanceStatus.
+func (in *RebalanceStatus) DeepCopy() *RebalanceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RebalanceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RedisCluster) DeepCopyInto(out *RedisCluster) {
 	*out = *in
@@ -52,6 +168,22 @@ func (in *RedisCluster) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedisClusterCondition) DeepCopyInto(out *RedisClusterCondition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedisClusterCondition.
+func (in *RedisClusterCondition) DeepCopy() *RedisClusterCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(RedisClusterCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RedisClusterList) DeepCopyInto(out *RedisClusterList) {
 	*out = *in
@@ -108,6 +240,31 @@ func (in *RedisClusterSpec) DeepCopyInto(out *RedisClusterSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.ExternalAccess != nil {
+		in, out := &in.ExternalAccess, &out.ExternalAccess
+		*out = new(ExternalAccessSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PreferredLeaders != nil {
+		in, out := &in.PreferredLeaders, &out.PreferredLeaders
+		*out = new(PreferredLeadersSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Rebalance != nil {
+		in, out := &in.Rebalance, &out.Rebalance
+		*out = new(RebalanceSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SlotAssignments != nil {
+		in, out := &in.SlotAssignments, &out.SlotAssignments
+		*out = make([]SlotAssignment, len(*in))
+		copy(*out, *in)
+	}
+	if in.Backup != nil {
+		in, out := &in.Backup, &out.Backup
+		*out = new(BackupSpec)
+		(*in).DeepCopyInto(*out)
+	}
 	in.RedisPodSpec.DeepCopyInto(&out.RedisPodSpec)
 }
 
@@ -129,6 +286,29 @@ func (in *RedisClusterStatus) DeepCopyInto(out *RedisClusterStatus) {
 		*out = make([]corev1.ObjectReference, len(*in))
 		copy(*out, *in)
 	}
+	if in.LastLeaderRestoration != nil {
+		in, out := &in.LastLeaderRestoration, &out.LastLeaderRestoration
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]RedisClusterCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Rebalance != nil {
+		in, out := &in.Rebalance, &out.Rebalance
+		*out = new(RebalanceStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ShardRecoveries != nil {
+		in, out := &in.ShardRecoveries, &out.ShardRecoveries
+		*out = make([]ShardRecoveryStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedisClusterStatus.
@@ -140,3 +320,56 @@ func (in *RedisClusterStatus) DeepCopy() *RedisClusterStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ShardRecoveryStatus) DeepCopyInto(out *ShardRecoveryStatus) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ShardRecoveryStatus.
+func (in *ShardRecoveryStatus) DeepCopy() *ShardRecoveryStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ShardRecoveryStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SlotAssignment) DeepCopyInto(out *SlotAssignment) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SlotAssignment.
+func (in *SlotAssignment) DeepCopy() *SlotAssignment {
+	if in == nil {
+		return nil
+	}
+	out := new(SlotAssignment)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SlotMove) DeepCopyInto(out *SlotMove) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SlotMove.
+func (in *SlotMove) DeepCopy() *SlotMove {
+	if in == nil {
+		return nil
+	}
+	out := new(SlotMove)
+	in.DeepCopyInto(out)
+	return out
+}
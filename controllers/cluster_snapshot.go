@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	dbv1 "github.com/PayU/Redis-Operator/api/v1"
+	"github.com/PayU/Redis-Operator/controllers/rediscli"
+)
+
+// NodeSnapshot holds what a single Redis node reported about itself and about
+// the cluster at the time the snapshot was taken.
+// Err is set when the node could not be queried; the other fields are then
+// the ones that were collected before the failure (possibly none).
+type NodeSnapshot struct {
+	IP          string
+	ID          string
+	Info        *rediscli.RedisInfo
+	ClusterInfo *rediscli.RedisClusterInfo
+	Nodes       *rediscli.RedisClusterNodes
+	Err         error
+}
+
+// Healthy returns true if the node was reachable and reports an ok cluster state.
+// A cluster that fails only because some slots are not served does not make
+// its nodes unhealthy: the slots are repaired or their shard is recovered on
+// their own.
+func (n *NodeSnapshot) Healthy() bool {
+	if n == nil || n.Err != nil || n.ClusterInfo == nil {
+		return false
+	}
+	info := *n.ClusterInfo
+	return info["cluster_state"] == "ok" || onlyMissesSlots(info)
+}
+
+// Returns true if the node joined a cluster where every slot is either served,
+// not assigned or owned by a master flagged as failed by the majority. A node
+// that only suspects a master (pfail), e.g. on the minority side of a
+// partition, is not considered healthy.
+func onlyMissesSlots(info rediscli.RedisClusterInfo) bool {
+	knownNodes, _ := strconv.Atoi(info["cluster_known_nodes"])
+	assigned, _ := strconv.Atoi(info["cluster_slots_assigned"])
+	served, _ := strconv.Atoi(info["cluster_slots_ok"])
+	failed, _ := strconv.Atoi(info["cluster_slots_fail"])
+	return knownNodes > 1 && served > 0 && served+failed == assigned && (assigned < rediscli.ClusterSlotCount || failed > 0)
+}
+
+// ClusterSnapshot is the state of all the pods of a RedisCluster and of the
+// Redis nodes running in them, collected in one parallel pass
+type ClusterSnapshot struct {
+	Pods  []corev1.Pod
+	Nodes map[string]*NodeSnapshot // indexed by pod IP
+}
+
+// Node returns the snapshot of the Redis node with the given IP or nil if the
+// IP does not belong to a running pod of the cluster
+func (s *ClusterSnapshot) Node(ip string) *NodeSnapshot {
+	return s.Nodes[ip]
+}
+
+// PodOf returns the pod whose Redis node answers with the ID of the node, or
+// nil if no pod of the snapshot runs it. Unlike the announced address, the ID
+// also tells the pod of a node announcing an external endpoint.
+func (s *ClusterSnapshot) PodOf(node *rediscli.RedisClusterNode) *corev1.Pod {
+	for i := range s.Pods {
+		if n := s.Nodes[s.Pods[i].Status.PodIP]; n != nil && n.ID != "" && n.ID == node.ID {
+			return &s.Pods[i]
+		}
+	}
+	return nil
+}
+
+// HealthyNodes returns the snapshots of the nodes that are reachable and in an ok cluster state
+func (s *ClusterSnapshot) HealthyNodes() []*NodeSnapshot {
+	var nodes []*NodeSnapshot
+	for _, pod := range s.Pods {
+		if node := s.Nodes[pod.Status.PodIP]; pod.DeletionTimestamp == nil && node.Healthy() {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// Queries INFO, CLUSTER INFO, CLUSTER NODES and CLUSTER MYID on all the pods in
+// parallel. Pods that are terminating or have no IP yet are not queried.
+func takeClusterSnapshot(ctx context.Context, redisCLI rediscli.RedisAdmin, pods []corev1.Pod) *ClusterSnapshot {
+	snapshot := &ClusterSnapshot{Pods: pods, Nodes: make(map[string]*NodeSnapshot)}
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil || pod.Status.PodIP == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(ip string) {
+			defer wg.Done()
+			node := queryNode(ctx, redisCLI, ip)
+			lock.Lock()
+			snapshot.Nodes[ip] = node
+			lock.Unlock()
+		}(pod.Status.PodIP)
+	}
+	wg.Wait()
+	return snapshot
+}
+
+func queryNode(ctx context.Context, redisCLI rediscli.RedisAdmin, ip string) *NodeSnapshot {
+	node := &NodeSnapshot{IP: ip}
+	if node.ClusterInfo, node.Err = redisCLI.ClusterInfo(ctx, ip); node.Err != nil {
+		return node
+	}
+	if node.ID, node.Err = redisCLI.MyClusterID(ctx, ip); node.Err != nil {
+		return node
+	}
+	if node.Nodes, node.Err = redisCLI.ClusterNodes(ctx, ip); node.Err != nil {
+		return node
+	}
+	node.Info, node.Err = redisCLI.Info(ctx, ip)
+	return node
+}
+
+// Returns the snapshot of the cluster taken during the current reconcile loop,
+// taking a new one if there is none or if it was invalidated by a change to
+// the cluster
+func (r *RedisClusterReconciler) getClusterSnapshot(ctx context.Context, redisCluster *dbv1.RedisCluster) (*ClusterSnapshot, error) {
+	key := types.NamespacedName{Name: redisCluster.Name, Namespace: redisCluster.Namespace}
+	r.snapshotLock.Lock()
+	defer r.snapshotLock.Unlock()
+	if snapshot, found := r.snapshots[key]; found {
+		return snapshot, nil
+	}
+
+	pods, err := r.getRedisClusterPods(ctx, redisCluster)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := takeClusterSnapshot(ctx, r.RedisCLI, pods)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if r.snapshots == nil {
+		r.snapshots = make(map[types.NamespacedName]*ClusterSnapshot)
+	}
+	r.snapshots[key] = snapshot
+	return snapshot, nil
+}
+
+// Drops the cached cluster snapshots; must be called after every command that
+// changes the pods, the roles or the membership of the Redis nodes
+func (r *RedisClusterReconciler) invalidateClusterSnapshot() {
+	r.snapshotLock.Lock()
+	r.snapshots = nil
+	r.snapshotLock.Unlock()
+}
@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/PayU/Redis-Operator/controllers/rediscli"
+)
+
+func TestClusterSnapshotCache(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	env.reconcileUntil(Ready, 5)
+	ctx := context.Background()
+
+	snapshot, err := env.reconciler.getClusterSnapshot(ctx, env.getRedisCluster())
+	if err != nil {
+		t.Fatalf("Failed to take cluster snapshot: %v", err)
+	}
+	if len(snapshot.HealthyNodes()) != 6 {
+		t.Fatalf("Expected 6 healthy nodes, found %d", len(snapshot.HealthyNodes()))
+	}
+	for _, node := range snapshot.HealthyNodes() {
+		if node.ID == "" || node.Info == nil || node.Nodes == nil || len(*node.Nodes) != 6 {
+			t.Errorf("Incomplete snapshot for node %s: %+v", node.IP, node)
+		}
+	}
+
+	cached, _ := env.reconciler.getClusterSnapshot(ctx, env.getRedisCluster())
+	if cached != snapshot {
+		t.Errorf("Expected the cached snapshot to be reused")
+	}
+
+	env.redis.StopNode(env.getPod("redis-node-3").Status.PodIP)
+	env.reconciler.invalidateClusterSnapshot()
+	fresh, _ := env.reconciler.getClusterSnapshot(ctx, env.getRedisCluster())
+	if fresh == snapshot {
+		t.Fatalf("Expected a new snapshot after invalidation")
+	}
+	if len(fresh.HealthyNodes()) != 5 {
+		t.Errorf("Expected 5 healthy nodes after stopping a node, found %d", len(fresh.HealthyNodes()))
+	}
+}
+
+func TestOnlyMissesSlots(t *testing.T) {
+	tests := []struct {
+		info     string
+		expected bool
+	}{
+		{"cluster_known_nodes:6\r\ncluster_slots_assigned:16000\r\ncluster_slots_ok:16000\r\ncluster_slots_fail:0", true},
+		{"cluster_known_nodes:6\r\ncluster_slots_assigned:16384\r\ncluster_slots_ok:10923\r\ncluster_slots_fail:5461", true},
+		{"cluster_known_nodes:6\r\ncluster_slots_assigned:16384\r\ncluster_slots_ok:10923\r\ncluster_slots_pfail:5461\r\ncluster_slots_fail:0", false},
+		{"cluster_known_nodes:6\r\ncluster_slots_assigned:16384\r\ncluster_slots_ok:16384\r\ncluster_slots_fail:0", false},
+		{"cluster_known_nodes:1\r\ncluster_slots_assigned:0\r\ncluster_slots_ok:0\r\ncluster_slots_fail:0", false},
+	}
+	for _, test := range tests {
+		if missesSlots := onlyMissesSlots(*rediscli.NewRedisClusterInfo(test.info)); missesSlots != test.expected {
+			t.Errorf("onlyMissesSlots(%q) = %t, expected %t", test.info, missesSlots, test.expected)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dbv1 "github.com/PayU/Redis-Operator/api/v1"
+)
+
+// Types of the conditions reported in the RedisCluster status
+const (
+	// ConditionSlotsCovered tells if every slot is served by a leader and no
+	// slot is left open by a migration
+	ConditionSlotsCovered = "SlotsCovered"
+	// ConditionSlotsRepaired tells if the last slot repair succeeded
+	ConditionSlotsRepaired = "SlotsRepaired"
+	// ConditionSlotAssignmentsApplied tells if the pinned slots are served by
+	// the leaders of the slotAssignments
+	ConditionSlotAssignmentsApplied = "SlotAssignmentsApplied"
+	// ConditionQuorumAvailable tells if the majority of the masters serving
+	// slots is reachable; it is false while their replicas take over
+	ConditionQuorumAvailable = "QuorumAvailable"
+	// ConditionPartitioned tells if the nodes disagree on the cluster, like on
+	// the two sides of a network partition; it is unknown while the disagreement
+	// is only suspected and the recovery waits until it is false
+	ConditionPartitioned = "Partitioned"
+)
+
+// Returns the condition of the given type or nil if the status has none
+func findCondition(status *dbv1.RedisClusterStatus, conditionType string) *dbv1.RedisClusterCondition {
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == conditionType {
+			return &status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// Sets a condition of the status. The transition time only changes with the
+// status of the condition, so that setting the same condition on every
+// reconcile loop does not update the resource.
+func setCondition(status *dbv1.RedisClusterStatus, conditionType string, conditionStatus corev1.ConditionStatus, reason string, message string) {
+	condition := findCondition(status, conditionType)
+	if condition == nil {
+		status.Conditions = append(status.Conditions, dbv1.RedisClusterCondition{Type: conditionType})
+		condition = &status.Conditions[len(status.Conditions)-1]
+	}
+	if condition.Status != conditionStatus {
+		condition.Status = conditionStatus
+		condition.LastTransitionTime = metav1.Now()
+	}
+	condition.Reason = reason
+	condition.Message = message
+}
+
+// Removes the condition of the given type from the status
+func removeCondition(status *dbv1.RedisClusterStatus, conditionType string) {
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == conditionType {
+			status.Conditions = append(status.Conditions[:i], status.Conditions[i+1:]...)
+			return
+		}
+	}
+}
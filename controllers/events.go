@@ -0,0 +1,15 @@
+package controllers
+
+import (
+	dbv1 "github.com/PayU/Redis-Operator/api/v1"
+)
+
+// Logs a message and publishes it as an event of the RedisCluster resource.
+// eventType is corev1.EventTypeNormal or corev1.EventTypeWarning and reason a
+// CamelCase identifier of the event.
+func (r *RedisClusterReconciler) recordEvent(redisCluster *dbv1.RedisCluster, eventType string, reason string, message string) {
+	r.Log.Info(reason + ": " + message)
+	if r.Recorder != nil {
+		r.Recorder.Event(redisCluster, eventType, reason, message)
+	}
+}
@@ -0,0 +1,282 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dbv1 "github.com/PayU/Redis-Operator/api/v1"
+	"github.com/PayU/Redis-Operator/controllers/rediscli"
+)
+
+// NodeEndpoint is the address a Redis node announces to the clients and to the
+// other nodes of the cluster. An endpoint with a hostname and no IP, like the
+// one of an AWS load balancer, makes the node announce the IP of its pod to the
+// other nodes and redirect the clients to the hostname.
+type NodeEndpoint struct {
+	IP       string
+	Hostname string
+	Port     int32
+	BusPort  int32
+}
+
+func (e NodeEndpoint) String() string {
+	host := e.IP
+	if e.Hostname != "" {
+		host = e.Hostname
+	}
+	return fmt.Sprintf("%s@%d", rediscli.NodeAddress{IP: host, Port: strconv.Itoa(int(e.Port))}.HostPort(), e.BusPort)
+}
+
+func isExternalAccessEnabled(redisCluster *dbv1.RedisCluster) bool {
+	return redisCluster.Spec.ExternalAccess != nil && redisCluster.Spec.ExternalAccess.Enabled
+}
+
+func externalServiceType(redisCluster *dbv1.RedisCluster) corev1.ServiceType {
+	if redisCluster.Spec.ExternalAccess.Type == "" {
+		return corev1.ServiceTypeLoadBalancer
+	}
+	return redisCluster.Spec.ExternalAccess.Type
+}
+
+func externalServiceName(nodeNumber string) string {
+	return fmt.Sprintf("redis-node-%s-external", nodeNumber)
+}
+
+// LoadBalancer or NodePort Service exposing the client and cluster bus ports of a
+// node outside of the Kubernetes cluster
+func (r *RedisClusterReconciler) makeExternalService(ctx context.Context, redisCluster *dbv1.RedisCluster, nodeNumber string) (corev1.Service, error) {
+	service, err := r.makeSelectorService(ctx, redisCluster, externalServiceName(nodeNumber), map[string]string{"node-number": nodeNumber})
+	if err != nil {
+		return service, err
+	}
+	service.Annotations = redisCluster.Spec.ExternalAccess.Annotations
+	service.Spec.Type = externalServiceType(redisCluster)
+	// the node must be reachable by the other nodes while it joins the cluster
+	service.Spec.PublishNotReadyAddresses = true
+	service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{
+		Name:       "redis-bus-port",
+		Port:       redisBusPort(redisCluster),
+		TargetPort: intstr.FromInt(int(redisBusPort(redisCluster))),
+	})
+	return service, nil
+}
+
+// Returns the external endpoint of the node running in the pod, or nil if the
+// Service has no address yet
+func (r *RedisClusterReconciler) getExternalEndpoint(ctx context.Context, redisCluster *dbv1.RedisCluster, pod *corev1.Pod) (*NodeEndpoint, error) {
+	var service corev1.Service
+	key := client.ObjectKey{Namespace: redisCluster.Namespace, Name: externalServiceName(pod.Labels["node-number"])}
+	if err := r.Get(ctx, key, &service); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	if len(service.Spec.Ports) != 2 {
+		return nil, nil
+	}
+
+	switch service.Spec.Type {
+	case corev1.ServiceTypeLoadBalancer:
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				return &NodeEndpoint{IP: ingress.IP, Port: service.Spec.Ports[0].Port, BusPort: service.Spec.Ports[1].Port}, nil
+			}
+		}
+		// some load balancers only get a hostname, like the AWS ELBs
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			if ingress.Hostname != "" {
+				return &NodeEndpoint{Hostname: ingress.Hostname, Port: service.Spec.Ports[0].Port, BusPort: service.Spec.Ports[1].Port}, nil
+			}
+		}
+		return nil, nil
+	case corev1.ServiceTypeNodePort:
+		if pod.Spec.NodeName == "" || service.Spec.Ports[0].NodePort == 0 || service.Spec.Ports[1].NodePort == 0 {
+			return nil, nil
+		}
+		var node corev1.Node
+		if err := r.Get(ctx, client.ObjectKey{Name: pod.Spec.NodeName}, &node); err != nil {
+			return nil, client.IgnoreNotFound(err)
+		}
+		ip := nodeAddress(&node, corev1.NodeExternalIP)
+		if ip == "" {
+			ip = nodeAddress(&node, corev1.NodeInternalIP)
+		}
+		if ip == "" {
+			return nil, nil
+		}
+		return &NodeEndpoint{IP: ip, Port: service.Spec.Ports[0].NodePort, BusPort: service.Spec.Ports[1].NodePort}, nil
+	}
+	return nil, errors.Errorf("Unsupported type %s of service %s", service.Spec.Type, service.Name)
+}
+
+func nodeAddress(node *corev1.Node, addressType corev1.NodeAddressType) string {
+	for _, address := range node.Status.Addresses {
+		if address.Type == addressType {
+			return address.Address
+		}
+	}
+	return ""
+}
+
+// Waits until the Service of the pod got an external address
+func (r *RedisClusterReconciler) waitForExternalEndpoint(ctx context.Context, redisCluster *dbv1.RedisCluster, pod *corev1.Pod) (*NodeEndpoint, error) {
+	var endpoint *NodeEndpoint
+	r.Log.Info(fmt.Sprintf("Waiting for the external address of %s", pod.Name))
+	err := pollImmediate(ctx, genericCheckInterval, genericCheckTimeout, func() (bool, error) {
+		var err error
+		endpoint, err = r.getExternalEndpoint(ctx, redisCluster, pod)
+		return endpoint != nil, err
+	})
+	if err != nil {
+		return nil, errors.Errorf("Failed to get the external address of %s: %v", pod.Name, err)
+	}
+	return endpoint, nil
+}
+
+// Makes the Redis nodes of the pods announce the external addresses of their
+// Services; does nothing unless externalAccess is enabled
+func (r *RedisClusterReconciler) announceExternalEndpoints(ctx context.Context, redisCluster *dbv1.RedisCluster, pods ...corev1.Pod) error {
+	if !isExternalAccessEnabled(redisCluster) {
+		return nil
+	}
+	for i := range pods {
+		endpoint, err := r.waitForExternalEndpoint(ctx, redisCluster, &pods[i])
+		if err != nil {
+			return err
+		}
+		if err := r.announceEndpoint(ctx, redisCluster, &pods[i], *endpoint, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the hostname the node of the pod announces with the endpoint: the
+// hostname of the endpoint, else the stable hostname of the node when
+// announceHostnames is set, else none
+func endpointHostname(redisCluster *dbv1.RedisCluster, pod *corev1.Pod, endpoint NodeEndpoint) string {
+	if endpoint.Hostname == "" && redisCluster.Spec.AnnounceHostnames {
+		return nodeHostname(redisCluster, pod.Labels["node-number"])
+	}
+	return endpoint.Hostname
+}
+
+// Sets the announced address of the node of the pod; the zero endpoint makes
+// the node announce its own address again. The hostname is only set when the
+// endpoint has one or when it replaces the announced hostname, as Redis
+// versions before 7 don't know it.
+func (r *RedisClusterReconciler) announceEndpoint(ctx context.Context, redisCluster *dbv1.RedisCluster, pod *corev1.Pod, endpoint NodeEndpoint, announcedHostname string) error {
+	nodeIP := pod.Status.PodIP
+	if endpoint == (NodeEndpoint{}) {
+		r.Log.Info(fmt.Sprintf("Announcing the pod address on %s", nodeIP))
+	} else {
+		r.Log.Info(fmt.Sprintf("Announcing address %s on %s", endpoint, nodeIP))
+	}
+	settings := [][2]string{
+		{"cluster-announce-ip", endpoint.IP},
+		{"cluster-announce-port", strconv.Itoa(int(endpoint.Port))},
+		{"cluster-announce-bus-port", strconv.Itoa(int(endpoint.BusPort))},
+	}
+	if hostname := endpointHostname(redisCluster, pod, endpoint); hostname != announcedHostname {
+		preferredEndpoint := "ip"
+		if hostname != "" {
+			preferredEndpoint = "hostname"
+		}
+		settings = append(settings, [2]string{"cluster-announce-hostname", hostname}, [2]string{"cluster-preferred-endpoint-type", preferredEndpoint})
+	}
+	for _, setting := range settings {
+		if _, err := r.RedisCLI.ConfigSet(ctx, nodeIP, setting[0], setting[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Announces the external address again on the nodes that announce another
+// address: restarted Redis processes and pods recreated on another Kubernetes
+// node. When the external access is disabled the nodes announce their pod
+// address again.
+func (r *RedisClusterReconciler) syncExternalEndpoints(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
+	snapshot, err := r.getClusterSnapshot(ctx, redisCluster)
+	if err != nil {
+		return err
+	}
+	for i, pod := range snapshot.Pods {
+		node := snapshot.Node(pod.Status.PodIP)
+		if pod.DeletionTimestamp != nil || node == nil || node.Err != nil || node.Nodes == nil {
+			continue
+		}
+		myself := node.Nodes.Myself()
+		if myself == nil {
+			continue
+		}
+		var endpoint NodeEndpoint
+		if isExternalAccessEnabled(redisCluster) {
+			external, err := r.getExternalEndpoint(ctx, redisCluster, &snapshot.Pods[i])
+			if err != nil {
+				return err
+			}
+			if external == nil {
+				r.Log.Info(fmt.Sprintf("The service of %s has no external address yet", pod.Name))
+				continue
+			}
+			endpoint = *external
+		}
+		announced := announcedEndpoint(myself)
+		if !isEndpointAnnounced(redisCluster, &snapshot.Pods[i], endpoint, announced) {
+			r.invalidateClusterSnapshot()
+			if err := r.announceEndpoint(ctx, redisCluster, &snapshot.Pods[i], endpoint, announced.Hostname); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the address announced by a node as seen in CLUSTER NODES
+func announcedEndpoint(node *rediscli.RedisClusterNode) NodeEndpoint {
+	port, _ := strconv.Atoi(node.Address.Port)
+	busPort, _ := strconv.Atoi(node.Address.BusPort)
+	return NodeEndpoint{IP: node.Address.IP, Hostname: node.Hostname(), Port: int32(port), BusPort: int32(busPort)}
+}
+
+// Returns true if the node of the pod announces the endpoint. An endpoint
+// without IP stands for the IP of the pod, which a node that did not learn it
+// yet reports as empty, and the zero endpoint for the address of the pod.
+func isEndpointAnnounced(redisCluster *dbv1.RedisCluster, pod *corev1.Pod, endpoint NodeEndpoint, announced NodeEndpoint) bool {
+	if announced.Hostname != endpointHostname(redisCluster, pod, endpoint) {
+		return false
+	}
+	if endpoint == (NodeEndpoint{}) {
+		endpoint.Port, endpoint.BusPort = redisPort(redisCluster), redisBusPort(redisCluster)
+	}
+	if endpoint.IP == "" && (announced.IP == "" || announced.IP == pod.Status.PodIP) {
+		endpoint.IP = announced.IP
+	}
+	return announced.IP == endpoint.IP && announced.Port == endpoint.Port && announced.BusPort == endpoint.BusPort
+}
+
+// Deletes the external Services of the nodes when the external access is disabled
+func (r *RedisClusterReconciler) deleteExternalServices(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
+	nodeCount := redisCluster.Spec.LeaderCount * (redisCluster.Spec.LeaderFollowersCount + 1)
+	for nodeNumber := 0; nodeNumber < nodeCount; nodeNumber++ {
+		var service corev1.Service
+		key := client.ObjectKey{Namespace: redisCluster.Namespace, Name: externalServiceName(strconv.Itoa(nodeNumber))}
+		if err := r.Get(ctx, key, &service); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		r.Log.Info(fmt.Sprintf("Deleting service %s", service.Name))
+		if err := r.Delete(ctx, &service); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dbv1 "github.com/PayU/Redis-Operator/api/v1"
+)
+
+// Labels of the Kubernetes nodes holding their zone, the deprecated one last
+var zoneLabels = []string{"topology.kubernetes.io/zone", "failure-domain.beta.kubernetes.io/zone"}
+
+// failoverCandidate is a follower that can be promoted in place of its leader
+type failoverCandidate struct {
+	// IP is the IP of the pod of the follower
+	IP         string
+	NodeNumber string
+	Zone       string
+	// LinkUp is true if the follower is connected to its leader and not
+	// loading the dataset of the leader
+	LinkUp bool
+	// Offset is the replication offset processed by the follower, -1 if unknown
+	Offset int64
+	// ZoneLeaders is the number of leaders of other shards in the zone
+	ZoneLeaders int
+}
+
+// NoFailoverCandidateError is returned when none of the followers of a failed
+// leader can be promoted in its place
+type NoFailoverCandidateError struct {
+	LeaderNumber string
+}
+
+func (e *NoFailoverCandidateError) Error() string {
+	return fmt.Sprintf("no failover candidate for leader %s", e.LeaderNumber)
+}
+
+func IsNoFailoverCandidate(err error) bool {
+	var noCandidate *NoFailoverCandidateError
+	return errors.As(err, &noCandidate)
+}
+
+func (c *failoverCandidate) String() string {
+	return fmt.Sprintf("%s[%s](link up: %t, offset: %d, zone: %q)", c.NodeNumber, c.IP, c.LinkUp, c.Offset, c.Zone)
+}
+
+// Returns the zone of the Kubernetes node running the pod, or the empty string
+// if it is unknown
+func (r *RedisClusterReconciler) podZone(ctx context.Context, pod *corev1.Pod, zones map[string]string) string {
+	if pod == nil || pod.Spec.NodeName == "" {
+		return ""
+	}
+	if zone, found := zones[pod.Spec.NodeName]; found {
+		return zone
+	}
+	var node corev1.Node
+	zone := ""
+	if err := r.Get(ctx, client.ObjectKey{Name: pod.Spec.NodeName}, &node); err == nil {
+		for _, label := range zoneLabels {
+			if zone = node.Labels[label]; zone != "" {
+				break
+			}
+		}
+	}
+	zones[pod.Spec.NodeName] = zone
+	return zone
+}
+
+// Ranks the followers that can replace the leader, the best first. The leader
+// and the followers are given by the IP of their pod. Followers
+// that don't answer or are not replicas are left out. A follower connected to
+// the leader comes first, then the one with the highest replication offset,
+// which loses the fewest writes, then one outside of the zone of the leader
+// and with the fewest leaders in its zone, so that the leaders stay spread
+// over the zones. Followers that are equal keep their order. Also returns the
+// reason the first follower was preferred over the second one.
+func (r *RedisClusterReconciler) rankFailoverCandidates(ctx context.Context, redisCluster *dbv1.RedisCluster, leaderIP string, followerIPs []string) ([]failoverCandidate, string, error) {
+	pods, err := r.getRedisClusterPods(ctx, redisCluster)
+	if err != nil {
+		return nil, "", err
+	}
+	podsByIP := make(map[string]*corev1.Pod)
+	for i := range pods {
+		podsByIP[pods[i].Status.PodIP] = &pods[i]
+	}
+	zones := make(map[string]string)
+	leaderZone := r.podZone(ctx, podsByIP[leaderIP], zones)
+	zoneLeaders := make(map[string]int)
+	for i := range pods {
+		if pods[i].Labels["redis-node-role"] == "leader" && pods[i].Status.PodIP != leaderIP {
+			zoneLeaders[r.podZone(ctx, &pods[i], zones)]++
+		}
+	}
+
+	var candidates []failoverCandidate
+	for _, followerIP := range followerIPs {
+		info, err := r.RedisCLI.Info(ctx, followerIP)
+		if err != nil || info.Role() != "slave" {
+			continue
+		}
+		candidate := failoverCandidate{IP: followerIP, LinkUp: info.IsMasterLinkUp() && !info.IsSyncing(), Offset: -1}
+		if offset, ok := info.SlaveReplOffset(); ok {
+			candidate.Offset = offset
+		}
+		if pod := podsByIP[followerIP]; pod != nil {
+			candidate.NodeNumber = pod.Labels["node-number"]
+			candidate.Zone = r.podZone(ctx, pod, zones)
+			candidate.ZoneLeaders = zoneLeaders[candidate.Zone]
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		order, _ := compareFailoverCandidates(&candidates[i], &candidates[j], leaderZone)
+		return order < 0
+	})
+	switch len(candidates) {
+	case 0:
+		return candidates, "", nil
+	case 1:
+		return candidates, "only reachable follower", nil
+	}
+	_, reason := compareFailoverCandidates(&candidates[0], &candidates[1], leaderZone)
+	return candidates, reason, nil
+}
+
+// Returns a negative number if a is a better candidate than b, a positive one
+// if b is better and 0 if they are equal, along with the criterion that decided
+func compareFailoverCandidates(a *failoverCandidate, b *failoverCandidate, leaderZone string) (int, string) {
+	if a.LinkUp != b.LinkUp {
+		if a.LinkUp {
+			return -1, "connected to the leader"
+		}
+		return 1, "connected to the leader"
+	}
+	if a.Offset != b.Offset {
+		if a.Offset > b.Offset {
+			return -1, "highest replication offset"
+		}
+		return 1, "highest replication offset"
+	}
+	if leaderZone != "" {
+		aOutside, bOutside := a.Zone != leaderZone, b.Zone != leaderZone
+		if aOutside != bOutside {
+			if aOutside {
+				return -1, "outside of the zone of the leader"
+			}
+			return 1, "outside of the zone of the leader"
+		}
+	}
+	if a.ZoneLeaders != b.ZoneLeaders {
+		return a.ZoneLeaders - b.ZoneLeaders, "fewest leaders in its zone"
+	}
+	return 0, "first of equal followers"
+}
+
+// Ranks the followers and logs the chosen one with the reason it was preferred
+// over the next one
+func (r *RedisClusterReconciler) chooseFailoverCandidates(ctx context.Context, redisCluster *dbv1.RedisCluster, leaderIP string, followerIPs []string) ([]failoverCandidate, error) {
+	candidates, reason, err := r.rankFailoverCandidates(ctx, redisCluster, leaderIP, followerIPs)
+	if err != nil || len(candidates) == 0 {
+		return candidates, err
+	}
+	var ranking []string
+	for i := range candidates {
+		ranking = append(ranking, candidates[i].String())
+	}
+	r.Log.Info(fmt.Sprintf("Failover candidate for leader %s: %s, %s. Ranking: %s",
+		leaderIP, candidates[0].IP, reason, strings.Join(ranking, " ")))
+	return candidates, nil
+}
@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	dbv1 "github.com/PayU/Redis-Operator/api/v1"
+	"github.com/PayU/Redis-Operator/controllers/rediscli"
+)
+
+// RedisHealthMonitor periodically checks the Redis nodes of every Ready cluster
+// and pushes a reconcile request through Events whenever it finds degradation
+// that is not visible to Kubernetes (hanging processes, lost cluster state,
+// nodes that dropped out of the gossip mesh)
+type RedisHealthMonitor struct {
+	client.Client
+	Log       logr.Logger
+	RedisCLI  rediscli.RedisAdmin
+	Namespace string
+	Interval  time.Duration
+	Events    chan event.GenericEvent
+}
+
+// Start implements manager.Runnable; it blocks until the stop channel is closed
+func (m *RedisHealthMonitor) Start(stop <-chan struct{}) error {
+	m.Log.Info(fmt.Sprintf("Starting Redis health monitor (interval: %v)", m.Interval))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+	wait.Until(func() { m.checkClusters(ctx) }, m.Interval, stop)
+	return nil
+}
+
+func (m *RedisHealthMonitor) checkClusters(ctx context.Context) {
+	var redisClusters dbv1.RedisClusterList
+	if err := m.List(ctx, &redisClusters, client.InNamespace(m.Namespace)); err != nil {
+		m.Log.Error(err, "Health monitor could not list RedisCluster resources")
+		return
+	}
+	for i := range redisClusters.Items {
+		redisCluster := &redisClusters.Items[i]
+		// clusters that are not Ready are already handled by an ongoing reconcile
+		if getCurrentClusterState(redisCluster) != Ready {
+			continue
+		}
+		degraded, reason := m.isClusterDegraded(ctx, redisCluster)
+		if degraded {
+			m.Log.Info(fmt.Sprintf("Health monitor found degraded cluster %s: %s", redisCluster.Name, reason))
+			select {
+			case m.Events <- event.GenericEvent{Meta: redisCluster, Object: redisCluster}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// Returns true and a short description when at least one node reports a
+// failed cluster state, an unexpected view of the cluster nodes or open slots
+func (m *RedisHealthMonitor) isClusterDegraded(ctx context.Context, redisCluster *dbv1.RedisCluster) (bool, string) {
+	var pods corev1.PodList
+	err := m.List(ctx, &pods, client.InNamespace(redisCluster.Namespace), client.MatchingLabels(redisCluster.Spec.PodLabelSelector))
+	if err != nil {
+		m.Log.Error(err, "Health monitor could not list Redis pods")
+		return false, ""
+	}
+
+	expectedNodes := redisCluster.Spec.LeaderCount * (redisCluster.Spec.LeaderFollowersCount + 1)
+	if len(pods.Items) != expectedNodes {
+		return true, fmt.Sprintf("expected %d pods, found %d", expectedNodes, len(pods.Items))
+	}
+
+	snapshot := takeClusterSnapshot(withNodePorts(ctx, redisCluster), m.RedisCLI, pods.Items)
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil || pod.Status.PodIP == "" {
+			return true, fmt.Sprintf("pod %s is not available", pod.Name)
+		}
+		node := snapshot.Node(pod.Status.PodIP)
+		if node.Err != nil {
+			return true, fmt.Sprintf("could not query %s: %v", pod.Name, node.Err)
+		}
+		if node.ClusterInfo == nil || (*node.ClusterInfo)["cluster_state"] != "ok" {
+			return true, fmt.Sprintf("cluster state on %s is not ok", pod.Name)
+		}
+		if node.Nodes == nil || len(*node.Nodes) != expectedNodes {
+			return true, fmt.Sprintf("%s does not know all the %d nodes", pod.Name, expectedNodes)
+		}
+		for _, clusterNode := range *node.Nodes {
+			if clusterNode.IsFailing() {
+				return true, fmt.Sprintf("%s reports node %s as failing", pod.Name, clusterNode.ID)
+			}
+		}
+		if myself := node.Nodes.Myself(); myself != nil && len(myself.OpenSlots) != 0 {
+			return true, fmt.Sprintf("%s has %d open slots", pod.Name, len(myself.OpenSlots))
+		}
+	}
+	if partition := detectPartition(snapshot); partition != nil {
+		return true, fmt.Sprintf("nodes disagree (%s): %s", partition.Reason, partition.Message)
+	}
+	return false, ""
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/PayU/Redis-Operator/controllers/rediscli"
+	"github.com/PayU/Redis-Operator/controllers/rediscli/fake"
+)
+
+// degradedRedis alters the replies of a node of the fake cluster
+type degradedRedis struct {
+	*fake.Cluster
+	ip string
+	// clusterState replaces the cluster_state of the CLUSTER INFO of the node
+	clusterState string
+	// forgetsNode drops the last node of the CLUSTER NODES of the node
+	forgetsNode bool
+}
+
+func (r *degradedRedis) ClusterInfo(ctx context.Context, nodeIP string) (*rediscli.RedisClusterInfo, error) {
+	info, err := r.Cluster.ClusterInfo(ctx, nodeIP)
+	if err == nil && nodeIP == r.ip && r.clusterState != "" {
+		(*info)["cluster_state"] = r.clusterState
+	}
+	return info, err
+}
+
+func (r *degradedRedis) ClusterNodes(ctx context.Context, nodeIP string) (*rediscli.RedisClusterNodes, error) {
+	nodes, err := r.Cluster.ClusterNodes(ctx, nodeIP)
+	if err == nil && nodeIP == r.ip && r.forgetsNode {
+		known := (*nodes)[:len(*nodes)-1]
+		nodes = &known
+	}
+	return nodes, err
+}
+
+// The monitor requests a reconcile of a Ready cluster only when one of its
+// nodes reports a degraded state
+func TestHealthMonitor(t *testing.T) {
+	tests := []struct {
+		name     string
+		degrade  func(redis *degradedRedis)
+		expected bool
+	}{
+		{"healthy", func(redis *degradedRedis) {}, false},
+		{"failed cluster state", func(redis *degradedRedis) { redis.clusterState = "fail" }, true},
+		{"forgotten node", func(redis *degradedRedis) { redis.forgetsNode = true }, true},
+		{"unreachable node", func(redis *degradedRedis) { redis.StopNode(redis.ip) }, true},
+	}
+	for _, test := range tests {
+		env := newTestEnv(t, 3, 1)
+		env.reconcileUntil(Ready, 5)
+		redis := &degradedRedis{Cluster: env.redis, ip: env.getPod("redis-node-4").Status.PodIP}
+		test.degrade(redis)
+
+		monitor := &RedisHealthMonitor{
+			Client:    env.client,
+			Log:       env.reconciler.Log,
+			RedisCLI:  redis,
+			Namespace: env.redisCluster.Namespace,
+			Events:    make(chan event.GenericEvent, 1),
+		}
+		monitor.checkClusters(context.Background())
+		select {
+		case request := <-monitor.Events:
+			if !test.expected {
+				t.Errorf("%s: unexpected reconcile request for %s", test.name, request.Meta.GetName())
+			}
+		default:
+			if test.expected {
+				t.Errorf("%s: no reconcile request", test.name)
+			}
+		}
+	}
+}
@@ -1,16 +1,21 @@
 package controllers
 
 import (
+	"context"
 	"time"
 
+	"k8s.io/apimachinery/pkg/util/wait"
+
 	dbv1 "github.com/PayU/Redis-Operator/api/v1"
+	"github.com/PayU/Redis-Operator/controllers/rediscli"
 )
 
 // RedisClusterState describes the current
 // reconcile state of the redis cluster
 type RedisClusterState string
 
-const (
+// The polling intervals and timeouts are variables so the tests can shorten them
+var (
 	syncCheckInterval     = 500 * time.Millisecond
 	syncCheckTimeout      = 10 * time.Second
 	loadCheckInterval     = 500 * time.Millisecond
@@ -42,6 +47,51 @@ const (
 	Updating RedisClusterState = "Updating"
 )
 
+// pollImmediate runs the condition right away and then every interval until it
+// succeeds, the timeout expires or ctx is cancelled. Cancellation is reported
+// with the context error so callers that tolerate wait.ErrWaitTimeout still stop.
+func pollImmediate(ctx context.Context, interval, timeout time.Duration, condition wait.ConditionFunc) error {
+	pollCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := wait.PollImmediateUntil(interval, condition, pollCtx.Done())
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// poll is like pollImmediate but waits one interval before the first check
+func poll(ctx context.Context, interval, timeout time.Duration, condition wait.ConditionFunc) error {
+	pollCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := wait.PollUntil(interval, condition, pollCtx.Done())
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Returns the port Redis listens on for clients
+func redisPort(redisCluster *dbv1.RedisCluster) int32 {
+	if redisCluster.Spec.Port == 0 {
+		return rediscli.DefaultPort
+	}
+	return redisCluster.Spec.Port
+}
+
+// Returns the port of the cluster bus
+func redisBusPort(redisCluster *dbv1.RedisCluster) int32 {
+	if redisCluster.Spec.BusPort == 0 {
+		return redisPort(redisCluster) + 10000
+	}
+	return redisCluster.Spec.BusPort
+}
+
+// Makes the Redis commands sent with the returned context use the ports of the cluster
+func withNodePorts(ctx context.Context, redisCluster *dbv1.RedisCluster) context.Context {
+	return rediscli.WithNodePorts(ctx, int(redisPort(redisCluster)), int(redisBusPort(redisCluster)))
+}
+
 func getCurrentClusterState(redisCluster *dbv1.RedisCluster) RedisClusterState {
 	if len(redisCluster.Status.ClusterState) == 0 {
 		return NotExists
@@ -49,26 +99,31 @@ func getCurrentClusterState(redisCluster *dbv1.RedisCluster) RedisClusterState {
 	return RedisClusterState(redisCluster.Status.ClusterState)
 }
 
-func (r *RedisClusterReconciler) handleInitializingCluster(redisCluster *dbv1.RedisCluster) error {
+func (r *RedisClusterReconciler) handleInitializingCluster(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
 	r.Log.Info("Handling initializing cluster...")
-	if err := r.createNewRedisCluster(redisCluster); err != nil {
+	if err := r.createNewRedisCluster(ctx, redisCluster); err != nil {
 		return err
 	}
 	redisCluster.Status.ClusterState = string(InitializingFollowers)
 	return nil
 }
 
-func (r *RedisClusterReconciler) handleInitializingFollowers(redisCluster *dbv1.RedisCluster) error {
+func (r *RedisClusterReconciler) handleInitializingFollowers(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
 	r.Log.Info("Handling initializing followers...")
-	if err := r.initializeFollowers(redisCluster); err != nil {
+	if err := r.initializeFollowers(ctx, redisCluster); err != nil {
 		return err
 	}
 	redisCluster.Status.ClusterState = string(Ready)
 	return nil
 }
 
-func (r *RedisClusterReconciler) handleReadyState(redisCluster *dbv1.RedisCluster) error {
-	complete, err := r.isClusterComplete(redisCluster)
+func (r *RedisClusterReconciler) handleReadyState(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
+	if err := r.createMissingServices(ctx, redisCluster); err != nil {
+		r.Log.Info("Could not create the cluster services")
+		return err
+	}
+
+	complete, err := r.isClusterComplete(ctx, redisCluster)
 	if err != nil {
 		r.Log.Info("Could not check if cluster is complete")
 		return err
@@ -77,8 +132,38 @@ func (r *RedisClusterReconciler) handleReadyState(redisCluster *dbv1.RedisCluste
 		redisCluster.Status.ClusterState = string(Recovering)
 		return nil
 	}
+	partitioned, err := r.checkPartition(ctx, redisCluster)
+	if err != nil {
+		r.Log.Info("Could not check if cluster is partitioned")
+		return err
+	}
+	if partitioned {
+		r.Log.Info("Cluster is partitioned, postponing the repairs")
+		return nil
+	}
+	if err = r.removeSurgePods(ctx, redisCluster); err != nil {
+		r.Log.Info("Could not remove the surge pods")
+		return err
+	}
 
-	uptodate, err := r.isClusterUpToDate(redisCluster)
+	clusterView, err := r.NewRedisClusterView(ctx, redisCluster)
+	if err != nil {
+		return err
+	}
+	if err = r.fixRoleDrift(ctx, clusterView); err != nil {
+		r.Log.Info("Could not fix the role drift")
+		return err
+	}
+	if err = r.checkSlots(ctx, redisCluster); err != nil {
+		r.Log.Info("Could not repair the slots")
+		return err
+	}
+	if err = r.checkSlotAssignments(ctx, redisCluster); err != nil {
+		r.Log.Info("Could not check the slot assignments")
+		return err
+	}
+
+	uptodate, err := r.isClusterUpToDate(ctx, redisCluster)
 	if err != nil {
 		r.Log.Info("Could not check if cluster is updated")
 		redisCluster.Status.ClusterState = string(Recovering)
@@ -88,13 +173,29 @@ func (r *RedisClusterReconciler) handleReadyState(redisCluster *dbv1.RedisCluste
 		redisCluster.Status.ClusterState = string(Updating)
 		return nil
 	}
+	if err = r.restorePreferredLeaders(ctx, redisCluster); err != nil {
+		r.Log.Info("Could not restore the preferred leaders")
+		return err
+	}
+	if err = r.rebalanceSlots(ctx, redisCluster); err != nil {
+		r.Log.Info("Could not rebalance the slots")
+		return err
+	}
 	r.Log.Info("Cluster is healthy")
 	return nil
 }
 
-func (r *RedisClusterReconciler) handleRecoveringState(redisCluster *dbv1.RedisCluster) error {
+func (r *RedisClusterReconciler) handleRecoveringState(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
 	r.Log.Info("Handling cluster recovery...")
-	if err := r.recoverCluster(redisCluster); err != nil {
+	partitioned, err := r.checkPartition(ctx, redisCluster)
+	if err != nil {
+		return err
+	}
+	if partitioned {
+		r.Log.Info("Cluster is partitioned, postponing the recovery")
+		return nil
+	}
+	if err := r.recoverCluster(ctx, redisCluster); err != nil {
 		r.Log.Info("Cluster recovery failed")
 		return err
 	}
@@ -102,9 +203,17 @@ func (r *RedisClusterReconciler) handleRecoveringState(redisCluster *dbv1.RedisC
 	return nil
 }
 
-func (r *RedisClusterReconciler) handleUpdatingState(redisCluster *dbv1.RedisCluster) error {
+func (r *RedisClusterReconciler) handleUpdatingState(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
 	r.Log.Info("Handling rolling update...")
-	if err := r.updateCluster(redisCluster); err != nil {
+	partitioned, err := r.checkPartition(ctx, redisCluster)
+	if err != nil {
+		return err
+	}
+	if partitioned {
+		r.Log.Info("Cluster is partitioned, postponing the rolling update")
+		return nil
+	}
+	if err := r.updateCluster(ctx, redisCluster); err != nil {
 		r.Log.Info("Rolling update failed")
 		redisCluster.Status.ClusterState = string(Recovering)
 		return err
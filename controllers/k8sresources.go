@@ -3,23 +3,40 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 
 	dbv1 "github.com/PayU/Redis-Operator/api/v1"
+	"github.com/PayU/Redis-Operator/controllers/rediscli"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
-	"k8s.io/apimachinery/pkg/util/wait"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func (r *RedisClusterReconciler) getRedisClusterPods(redisCluster *dbv1.RedisCluster, podType ...string) ([]corev1.Pod, error) {
+const (
+	// Annotation holding the ID of the Redis node running in the pod
+	redisNodeIDAnnotation = "redis-node-id"
+
+	// Annotation holding the slot ranges served by the Redis node, only set on leaders
+	redisSlotsAnnotation = "redis-slots"
+
+	// Label set to true on the followers that are in sync with their leader,
+	// used by the read replica Service
+	replicaReadyLabel = "redis-replica-ready"
+)
+
+func (r *RedisClusterReconciler) getRedisClusterPods(ctx context.Context, redisCluster *dbv1.RedisCluster, podType ...string) ([]corev1.Pod, error) {
 	pods := &corev1.PodList{}
-	matchingLabels := redisCluster.Spec.PodLabelSelector
+	matchingLabels := make(map[string]string)
+	for k, v := range redisCluster.Spec.PodLabelSelector {
+		matchingLabels[k] = v
+	}
 
 	if len(podType) > 0 && strings.TrimSpace(podType[0]) != "" {
 		pt := strings.TrimSpace(podType[0])
@@ -28,7 +45,7 @@ func (r *RedisClusterReconciler) getRedisClusterPods(redisCluster *dbv1.RedisClu
 		}
 	}
 
-	err := r.List(context.Background(), pods, client.InNamespace(redisCluster.ObjectMeta.Namespace), client.MatchingLabels(matchingLabels))
+	err := r.List(ctx, pods, client.InNamespace(redisCluster.ObjectMeta.Namespace), client.MatchingLabels(matchingLabels))
 	if err != nil {
 		return nil, err
 	}
@@ -41,39 +58,92 @@ func (r *RedisClusterReconciler) getRedisClusterPods(redisCluster *dbv1.RedisClu
 	return sortedPods, nil
 }
 
-func (r *RedisClusterReconciler) getPodByIP(namespace string, podIP string) (corev1.Pod, error) {
-	var podList corev1.PodList
-	err := r.List(context.Background(), &podList, client.InNamespace(namespace), client.MatchingFields{"status.podIP": podIP})
-	if err != nil {
-		return corev1.Pod{}, err
-	}
-	if len(podList.Items) == 0 {
-		return corev1.Pod{}, apierrors.NewNotFound(corev1.Resource("Pod"), "")
-	}
-	return podList.Items[0], nil
-}
-
-func (r *RedisClusterReconciler) deletePodsByIP(namespace string, ip ...string) ([]corev1.Pod, error) {
+// Deletes the pods by name and returns the ones that still existed. The pods
+// are not looked up by IP: the IP of a deleted pod can already be reused by
+// another one.
+func (r *RedisClusterReconciler) deletePods(ctx context.Context, pods ...corev1.Pod) ([]corev1.Pod, error) {
 	var deletedPods []corev1.Pod
-	for _, ip := range ip {
-		pod, err := r.getPodByIP(namespace, ip)
-		if err != nil {
-			if apierrors.IsNotFound(err) {
-				continue
-			}
-			return nil, err
-		}
-		if err := r.Delete(context.Background(), &pod); err != nil {
+	defer r.invalidateClusterSnapshot()
+	for i := range pods {
+		if err := r.Delete(ctx, &pods[i]); err != nil {
 			if apierrors.IsNotFound(err) {
 				continue
 			}
 			return nil, err
 		}
-		deletedPods = append(deletedPods, pod)
+		deletedPods = append(deletedPods, pods[i])
 	}
 	return deletedPods, nil
 }
 
+// Updates the redis-node-role and redis-replica-ready labels and the Redis node
+// ID and slot range annotations of the pods from the state reported by the
+// Redis nodes. Pods that are terminating or whose Redis node can't be reached
+// are left unchanged.
+func (r *RedisClusterReconciler) syncPodRoleLabels(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
+	snapshot, err := r.getClusterSnapshot(ctx, redisCluster)
+	if err != nil {
+		return err
+	}
+	for i := range snapshot.Pods {
+		pod := &snapshot.Pods[i]
+		node := snapshot.Node(pod.Status.PodIP)
+		if pod.DeletionTimestamp != nil || node == nil || node.Err != nil || node.Nodes == nil {
+			continue
+		}
+		myself := node.Nodes.Myself()
+		if myself == nil {
+			continue
+		}
+
+		// an empty value removes the label or annotation
+		labels := map[string]string{"redis-node-role": "follower", replicaReadyLabel: "false"}
+		annotations := map[string]string{redisNodeIDAnnotation: node.ID, redisSlotsAnnotation: ""}
+		if myself.IsMaster() {
+			labels["redis-node-role"] = "leader"
+			labels[replicaReadyLabel] = ""
+			annotations[redisSlotsAnnotation] = myself.Slots.String()
+		} else if isReplicaReady(node.Info) {
+			labels[replicaReadyLabel] = "true"
+		}
+
+		original := pod.DeepCopy()
+		pod.Labels = mergeMetadata(pod.Labels, labels)
+		pod.Annotations = mergeMetadata(pod.Annotations, annotations)
+		if reflect.DeepEqual(original.Labels, pod.Labels) && reflect.DeepEqual(original.Annotations, pod.Annotations) {
+			continue
+		}
+		r.Log.Info(fmt.Sprintf("Updating role of pod %s: %s (%s)", pod.Name, pod.Labels["redis-node-role"], node.ID))
+		if err := r.Patch(ctx, pod, client.MergeFrom(original)); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// A replica is ready to serve reads when its link to the leader is up and it
+// is neither syncing nor loading the dataset
+func isReplicaReady(info *rediscli.RedisInfo) bool {
+	return info != nil && info.IsMasterLinkUp() && !info.IsSyncing() && !info.IsLoading()
+}
+
+// Returns a copy of the metadata map with the updates applied; updates with
+// an empty value remove the key
+func mergeMetadata(metadata map[string]string, updates map[string]string) map[string]string {
+	merged := make(map[string]string)
+	for k, v := range metadata {
+		merged[k] = v
+	}
+	for k, v := range updates {
+		if v == "" {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
 func getSelectorRequirementFromPodLabelSelector(redisCluster *dbv1.RedisCluster) []metav1.LabelSelectorRequirement {
 	lsr := []metav1.LabelSelectorRequirement{}
 	for k, v := range redisCluster.Spec.PodLabelSelector {
@@ -82,7 +152,7 @@ func getSelectorRequirementFromPodLabelSelector(redisCluster *dbv1.RedisCluster)
 	return lsr
 }
 
-func (r *RedisClusterReconciler) makeRedisPod(redisCluster *dbv1.RedisCluster, nodeRole string, leaderNumber string, nodeNumber string, preferredLabelSelectorRequirement []metav1.LabelSelectorRequirement) corev1.Pod {
+func (r *RedisClusterReconciler) makeRedisPod(ctx context.Context, redisCluster *dbv1.RedisCluster, nodeRole string, leaderNumber string, nodeNumber string, preferredLabelSelectorRequirement []metav1.LabelSelectorRequirement) corev1.Pod {
 	var affinity corev1.Affinity
 	podLabels := make(map[string]string)
 
@@ -142,6 +212,11 @@ func (r *RedisClusterReconciler) makeRedisPod(redisCluster *dbv1.RedisCluster, n
 
 	spec := redisCluster.Spec.RedisPodSpec.DeepCopy()
 	spec.Affinity = &affinity
+	if len(spec.Containers) != 0 {
+		// the Redis container is the first one
+		addContainerPort(&spec.Containers[0], "redis-client", redisPort(redisCluster))
+		addContainerPort(&spec.Containers[0], "redis-bus", redisBusPort(redisCluster))
+	}
 
 	pod := corev1.Pod{
 		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
@@ -157,9 +232,19 @@ func (r *RedisClusterReconciler) makeRedisPod(redisCluster *dbv1.RedisCluster, n
 	return pod
 }
 
-func (r *RedisClusterReconciler) makeFollowerPod(redisCluster *dbv1.RedisCluster, nodeNumber string, leaderNumber string) (corev1.Pod, error) {
+// Declares a port of the container unless it is already declared
+func addContainerPort(container *corev1.Container, name string, port int32) {
+	for _, containerPort := range container.Ports {
+		if containerPort.ContainerPort == port {
+			return
+		}
+	}
+	container.Ports = append(container.Ports, corev1.ContainerPort{Name: name, ContainerPort: port, Protocol: corev1.ProtocolTCP})
+}
+
+func (r *RedisClusterReconciler) makeFollowerPod(ctx context.Context, redisCluster *dbv1.RedisCluster, nodeNumber string, leaderNumber string) (corev1.Pod, error) {
 	preferredLabelSelectorRequirement := []metav1.LabelSelectorRequirement{{Key: "leader-number", Operator: metav1.LabelSelectorOpIn, Values: []string{leaderNumber}}}
-	pod := r.makeRedisPod(redisCluster, "follower", leaderNumber, nodeNumber, preferredLabelSelectorRequirement)
+	pod := r.makeRedisPod(ctx, redisCluster, "follower", leaderNumber, nodeNumber, preferredLabelSelectorRequirement)
 
 	if err := ctrl.SetControllerReference(redisCluster, &pod, r.Scheme); err != nil {
 		return pod, err
@@ -168,7 +253,7 @@ func (r *RedisClusterReconciler) makeFollowerPod(redisCluster *dbv1.RedisCluster
 	return pod, nil
 }
 
-func (r *RedisClusterReconciler) createRedisFollowerPods(redisCluster *dbv1.RedisCluster, nodeNumbers ...NodeNumbers) ([]corev1.Pod, error) {
+func (r *RedisClusterReconciler) createRedisFollowerPods(ctx context.Context, redisCluster *dbv1.RedisCluster, nodeNumbers ...NodeNumbers) ([]corev1.Pod, error) {
 	if len(nodeNumbers) == 0 {
 		return nil, errors.Errorf("Failed to create Redis followers - no node numbers")
 	}
@@ -176,19 +261,20 @@ func (r *RedisClusterReconciler) createRedisFollowerPods(redisCluster *dbv1.Redi
 	var followerPods []corev1.Pod
 	createOpts := []client.CreateOption{client.FieldOwner("redis-operator-controller")}
 
+	defer r.invalidateClusterSnapshot()
 	for _, nodeNumber := range nodeNumbers {
-		pod, err := r.makeFollowerPod(redisCluster, nodeNumber[0], nodeNumber[1])
+		pod, err := r.makeFollowerPod(ctx, redisCluster, nodeNumber[0], nodeNumber[1])
 		if err != nil {
 			return nil, err
 		}
-		err = r.Create(context.Background(), &pod, createOpts...)
+		err = r.Create(ctx, &pod, createOpts...)
 		if err != nil && !apierrors.IsAlreadyExists(err) {
 			return nil, err
 		}
 		followerPods = append(followerPods, pod)
 	}
 
-	followerPods, err := r.waitForPodNetworkInterface(followerPods...)
+	followerPods, err := r.waitForPodNetworkInterface(ctx, followerPods...)
 	if err != nil {
 		return nil, err
 	}
@@ -197,9 +283,9 @@ func (r *RedisClusterReconciler) createRedisFollowerPods(redisCluster *dbv1.Redi
 	return followerPods, nil
 }
 
-func (r *RedisClusterReconciler) makeLeaderPod(redisCluster *dbv1.RedisCluster, nodeNumber string) (corev1.Pod, error) {
+func (r *RedisClusterReconciler) makeLeaderPod(ctx context.Context, redisCluster *dbv1.RedisCluster, nodeNumber string) (corev1.Pod, error) {
 	preferredLabelSelectorRequirement := []metav1.LabelSelectorRequirement{{Key: "redis-node-role", Operator: metav1.LabelSelectorOpIn, Values: []string{"leader"}}}
-	pod := r.makeRedisPod(redisCluster, "leader", nodeNumber, nodeNumber, preferredLabelSelectorRequirement)
+	pod := r.makeRedisPod(ctx, redisCluster, "leader", nodeNumber, nodeNumber, preferredLabelSelectorRequirement)
 
 	if err := ctrl.SetControllerReference(redisCluster, &pod, r.Scheme); err != nil {
 		return pod, err
@@ -208,7 +294,7 @@ func (r *RedisClusterReconciler) makeLeaderPod(redisCluster *dbv1.RedisCluster,
 }
 
 // Creates one or more leader pods; waits for available IP before returing
-func (r *RedisClusterReconciler) createRedisLeaderPods(redisCluster *dbv1.RedisCluster, nodeNumbers ...string) ([]corev1.Pod, error) {
+func (r *RedisClusterReconciler) createRedisLeaderPods(ctx context.Context, redisCluster *dbv1.RedisCluster, nodeNumbers ...string) ([]corev1.Pod, error) {
 
 	if len(nodeNumbers) == 0 {
 		return nil, errors.New("Failed to create leader pods - no node numbers")
@@ -216,32 +302,38 @@ func (r *RedisClusterReconciler) createRedisLeaderPods(redisCluster *dbv1.RedisC
 
 	var leaderPods []corev1.Pod
 	for _, nodeNumber := range nodeNumbers {
-		pod, err := r.makeLeaderPod(redisCluster, nodeNumber)
+		pod, err := r.makeLeaderPod(ctx, redisCluster, nodeNumber)
 		if err != nil {
 			return nil, err
 		}
 		leaderPods = append(leaderPods, pod)
 	}
 
+	leaderPods, err := r.createPods(ctx, leaderPods...)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Log.Info(fmt.Sprintf("New leader pods created: %v ", nodeNumbers))
+	return leaderPods, nil
+}
+
+// Creates the pods; waits for available IP before returning
+func (r *RedisClusterReconciler) createPods(ctx context.Context, pods ...corev1.Pod) ([]corev1.Pod, error) {
 	applyOpts := []client.CreateOption{client.FieldOwner("redis-operator-controller")}
 
-	for i := range leaderPods {
-		err := r.Create(context.Background(), &leaderPods[i], applyOpts...)
+	defer r.invalidateClusterSnapshot()
+	for i := range pods {
+		err := r.Create(ctx, &pods[i], applyOpts...)
 		if err != nil && !apierrors.IsAlreadyExists(err) && !apierrors.IsConflict(err) {
 			return nil, err
 		}
 	}
 
-	leaderPods, err := r.waitForPodNetworkInterface(leaderPods...)
-	if err != nil {
-		return nil, err
-	}
-
-	r.Log.Info(fmt.Sprintf("New leader pods created: %v ", nodeNumbers))
-	return leaderPods, nil
+	return r.waitForPodNetworkInterface(ctx, pods...)
 }
 
-func (r *RedisClusterReconciler) makeService(redisCluster *dbv1.RedisCluster) (corev1.Service, error) {
+func (r *RedisClusterReconciler) makeService(ctx context.Context, redisCluster *dbv1.RedisCluster) (corev1.Service, error) {
 	service := corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "redis-cluster-service",
@@ -251,8 +343,8 @@ func (r *RedisClusterReconciler) makeService(redisCluster *dbv1.RedisCluster) (c
 			Ports: []corev1.ServicePort{
 				{
 					Name:       "redis-client-port",
-					Port:       6379,
-					TargetPort: intstr.FromInt(6379),
+					Port:       redisPort(redisCluster),
+					TargetPort: intstr.FromInt(int(redisPort(redisCluster))),
 				},
 			},
 			Selector: redisCluster.Spec.PodLabelSelector,
@@ -266,19 +358,158 @@ func (r *RedisClusterReconciler) makeService(redisCluster *dbv1.RedisCluster) (c
 	return service, nil
 }
 
-func (r *RedisClusterReconciler) createRedisService(redisCluster *dbv1.RedisCluster) (*corev1.Service, error) {
-	svc, err := r.makeService(redisCluster)
+// Makes a Service that selects the pods with the given labels on top of the pod label selector
+func (r *RedisClusterReconciler) makeSelectorService(ctx context.Context, redisCluster *dbv1.RedisCluster, name string, selector map[string]string) (corev1.Service, error) {
+	service, err := r.makeService(ctx, redisCluster)
 	if err != nil {
-		return nil, err
+		return service, err
 	}
-	err = r.Create(context.Background(), &svc)
-	if !apierrors.IsAlreadyExists(err) {
-		return nil, err
+	service.Name = name
+	service.Spec.Selector = make(map[string]string)
+	for k, v := range redisCluster.Spec.PodLabelSelector {
+		service.Spec.Selector[k] = v
+	}
+	for k, v := range selector {
+		service.Spec.Selector[k] = v
+	}
+	return service, nil
+}
+
+// Service that only selects the current leaders, to be used for writes
+func (r *RedisClusterReconciler) makeLeaderService(ctx context.Context, redisCluster *dbv1.RedisCluster) (corev1.Service, error) {
+	return r.makeSelectorService(ctx, redisCluster, "redis-cluster-leader-service", map[string]string{"redis-node-role": "leader"})
+}
+
+// Service that only selects the followers in sync with their leader, to be used
+// for reads by clients that send READONLY
+func (r *RedisClusterReconciler) makeReplicaService(ctx context.Context, redisCluster *dbv1.RedisCluster) (corev1.Service, error) {
+	return r.makeSelectorService(ctx, redisCluster, "redis-cluster-replica-service", map[string]string{"redis-node-role": "follower", replicaReadyLabel: "true"})
+}
+
+// Headless Service giving a stable DNS name to a node: redis-node-<number>.<namespace>.svc
+// Not ready addresses are published so that the name resolves while the node joins the cluster.
+func (r *RedisClusterReconciler) makeNodeService(ctx context.Context, redisCluster *dbv1.RedisCluster, nodeNumber string) (corev1.Service, error) {
+	service, err := r.makeSelectorService(ctx, redisCluster, fmt.Sprintf("redis-node-%s", nodeNumber), map[string]string{"node-number": nodeNumber})
+	if err != nil {
+		return service, err
+	}
+	service.Spec.ClusterIP = corev1.ClusterIPNone
+	service.Spec.PublishNotReadyAddresses = true
+	return service, nil
+}
+
+// Creates the Services of the cluster that don't exist yet: the Service selecting
+// all the nodes, the leader and replica Services, a headless Service per node and,
+// with externalAccess, an external Service per node.
+// The ports of the existing Services are updated when the Redis port changed and
+// the Services of the nodes removed from the layout are deleted.
+func (r *RedisClusterReconciler) createMissingServices(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
+	var services []corev1.Service
+	for _, makeService := range []func(context.Context, *dbv1.RedisCluster) (corev1.Service, error){r.makeService, r.makeLeaderService, r.makeReplicaService} {
+		service, err := makeService(ctx, redisCluster)
+		if err != nil {
+			return err
+		}
+		services = append(services, service)
+	}
+	nodeCount := redisCluster.Spec.LeaderCount * (redisCluster.Spec.LeaderFollowersCount + 1)
+	for nodeNumber := 0; nodeNumber < nodeCount; nodeNumber++ {
+		service, err := r.makeNodeService(ctx, redisCluster, strconv.Itoa(nodeNumber))
+		if err != nil {
+			return err
+		}
+		services = append(services, service)
+		if isExternalAccessEnabled(redisCluster) {
+			if service, err = r.makeExternalService(ctx, redisCluster, strconv.Itoa(nodeNumber)); err != nil {
+				return err
+			}
+			services = append(services, service)
+		}
+	}
+	if !isExternalAccessEnabled(redisCluster) {
+		if err := r.deleteExternalServices(ctx, redisCluster); err != nil {
+			return err
+		}
+	}
+	if err := r.deleteStaleNodeServices(ctx, redisCluster, nodeCount); err != nil {
+		return err
+	}
+
+	for i := range services {
+		var existing corev1.Service
+		err := r.Get(ctx, client.ObjectKey{Namespace: services[i].Namespace, Name: services[i].Name}, &existing)
+		if err == nil {
+			if err := r.updateServicePorts(ctx, &existing, services[i].Spec.Ports); err != nil {
+				return err
+			}
+			continue
+		}
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		r.Log.Info(fmt.Sprintf("Creating service %s", services[i].Name))
+		if err := r.Create(ctx, &services[i]); err != nil && !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deletes the headless and external Services of the node numbers that are no
+// longer part of the cluster layout, e.g. after the number of followers was
+// reduced. The Services of the surge pods, whose node numbers follow the
+// layout, are kept.
+func (r *RedisClusterReconciler) deleteStaleNodeServices(ctx context.Context, redisCluster *dbv1.RedisCluster, nodeCount int) error {
+	pods, err := r.getRedisClusterPods(ctx, redisCluster)
+	if err != nil {
+		return err
+	}
+	surgeNodeNumbers := make(map[string]struct{})
+	for i := range pods {
+		if isSurgePod(&pods[i]) {
+			surgeNodeNumbers[pods[i].Labels["node-number"]] = EMPTY
+		}
+	}
+	var services corev1.ServiceList
+	if err := r.List(ctx, &services, client.InNamespace(redisCluster.Namespace)); err != nil {
+		return err
+	}
+	for i := range services.Items {
+		service := &services.Items[i]
+		if !metav1.IsControlledBy(service, redisCluster) || !strings.HasPrefix(service.Name, "redis-node-") {
+			continue
+		}
+		nodeNumber := strings.TrimSuffix(strings.TrimPrefix(service.Name, "redis-node-"), "-external")
+		if number, err := strconv.Atoi(nodeNumber); err != nil || number < nodeCount {
+			continue
+		}
+		if _, isSurge := surgeNodeNumbers[nodeNumber]; isSurge {
+			continue
+		}
+		r.Log.Info(fmt.Sprintf("Deleting service %s, node %s is not part of the cluster", service.Name, nodeNumber))
+		if err := r.Delete(ctx, service); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sets the port and target port of the Service ports that differ from the given ones
+func (r *RedisClusterReconciler) updateServicePorts(ctx context.Context, service *corev1.Service, ports []corev1.ServicePort) error {
+	changed := len(service.Spec.Ports) != len(ports)
+	for i := 0; !changed && i < len(ports); i++ {
+		changed = service.Spec.Ports[i].Port != ports[i].Port || service.Spec.Ports[i].TargetPort != ports[i].TargetPort
+	}
+	if !changed {
+		return nil
 	}
-	return &svc, nil
+	r.Log.Info(fmt.Sprintf("Updating the ports of service %s", service.Name))
+	patch := client.MergeFrom(service.DeepCopy())
+	service.Spec.Ports = ports
+	return r.Patch(ctx, service, patch)
 }
 
-func (r *RedisClusterReconciler) waitForPodReady(pods ...corev1.Pod) ([]corev1.Pod, error) {
+func (r *RedisClusterReconciler) waitForPodReady(ctx context.Context, pods ...corev1.Pod) ([]corev1.Pod, error) {
 	var readyPods []corev1.Pod
 	for _, pod := range pods {
 		key, err := client.ObjectKeyFromObject(&pod)
@@ -286,8 +517,8 @@ func (r *RedisClusterReconciler) waitForPodReady(pods ...corev1.Pod) ([]corev1.P
 			return nil, err
 		}
 		r.Log.Info(fmt.Sprintf("Waiting for pod ready: %s(%s)", pod.Name, pod.Status.PodIP))
-		if pollErr := wait.PollImmediate(genericCheckInterval, genericCheckTimeout, func() (bool, error) {
-			err := r.Get(context.Background(), key, &pod)
+		if pollErr := pollImmediate(ctx, genericCheckInterval, genericCheckTimeout, func() (bool, error) {
+			err := r.Get(ctx, key, &pod)
 			if err != nil {
 				return false, err
 			}
@@ -309,13 +540,13 @@ func (r *RedisClusterReconciler) waitForPodReady(pods ...corev1.Pod) ([]corev1.P
 }
 
 // Method used to wait for one or more pods to have an IP address
-func (r *RedisClusterReconciler) waitForPodNetworkInterface(pods ...corev1.Pod) ([]corev1.Pod, error) {
+func (r *RedisClusterReconciler) waitForPodNetworkInterface(ctx context.Context, pods ...corev1.Pod) ([]corev1.Pod, error) {
 	r.Log.Info(fmt.Sprintf("Waiting for pod network interfaces..."))
 	var readyPods []corev1.Pod
 	for _, pod := range pods {
 		key, err := client.ObjectKeyFromObject(&pod)
-		if pollErr := wait.PollImmediate(genericCheckInterval, genericCheckTimeout, func() (bool, error) {
-			if err = r.Get(context.Background(), key, &pod); err != nil {
+		if pollErr := pollImmediate(ctx, genericCheckInterval, genericCheckTimeout, func() (bool, error) {
+			if err = r.Get(ctx, key, &pod); err != nil {
 				if apierrors.IsNotFound(err) {
 					return false, nil
 				}
@@ -334,15 +565,15 @@ func (r *RedisClusterReconciler) waitForPodNetworkInterface(pods ...corev1.Pod)
 }
 
 // TODO should wait as long as delete grace period
-func (r *RedisClusterReconciler) waitForPodDelete(pods ...corev1.Pod) error {
+func (r *RedisClusterReconciler) waitForPodDelete(ctx context.Context, pods ...corev1.Pod) error {
 	for _, p := range pods {
 		key, err := client.ObjectKeyFromObject(&p)
 		if err != nil {
 			return err
 		}
 		r.Log.Info(fmt.Sprintf("Waiting for pod delete: %s", p.Name))
-		if pollErr := wait.Poll(genericCheckInterval, genericCheckTimeout, func() (bool, error) {
-			err := r.Get(context.Background(), key, &p)
+		if pollErr := poll(ctx, genericCheckInterval, genericCheckTimeout, func() (bool, error) {
+			err := r.Get(ctx, key, &p)
 			if err != nil {
 				if apierrors.IsNotFound(err) {
 					return true, nil
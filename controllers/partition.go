@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	dbv1 "github.com/PayU/Redis-Operator/api/v1"
+	"github.com/PayU/Redis-Operator/controllers/rediscli"
+)
+
+// Reasons of the Partitioned condition, from the most to the least severe
+const (
+	// two nodes see different masters serving the same slot
+	partitionSplitBrain = "SplitBrain"
+	// a node can't talk to another node that answers the operator
+	partitionNodesDisconnected = "NodesDisconnected"
+	// two nodes see the same master with different config epochs, or two
+	// masters share an epoch
+	partitionEpochsDiverged = "EpochsDiverged"
+)
+
+// Delay of the next reconcile loop while the nodes disagree, since the health
+// monitor only watches Ready clusters
+const partitionRequeueInterval = 5 * time.Second
+
+// The nodes may disagree for a short while when the gossip has not spread a
+// change yet, like right after a failover. A disagreement is only reported as
+// a partition once it lasts for this period, a variable so the tests can
+// shorten it.
+var partitionGracePeriod = 10 * time.Second
+
+// clusterPartition is a disagreement between the CLUSTER NODES tables of the
+// reachable nodes
+type clusterPartition struct {
+	Reason  string
+	Message string
+}
+
+// Compares the CLUSTER NODES tables of the nodes that answered and returns the
+// most severe disagreement between them, or nil if they agree. Nodes that
+// don't answer the operator either are failed, not partitioned, and are left
+// out of the comparison.
+func detectPartition(snapshot *ClusterSnapshot) *clusterPartition {
+	tables := make(map[string]*rediscli.RedisClusterNodes)
+	reachable := make(map[string]string)
+	var ips []string
+	for _, pod := range snapshot.Pods {
+		node := snapshot.Node(pod.Status.PodIP)
+		if pod.DeletionTimestamp != nil || node == nil || node.Err != nil || node.Nodes == nil {
+			continue
+		}
+		tables[node.IP] = node.Nodes
+		reachable[node.ID] = node.IP
+		ips = append(ips, node.IP)
+	}
+	sort.Strings(ips)
+
+	if partition := slotOwnerConflict(ips, tables); partition != nil {
+		return partition
+	}
+	for _, ip := range ips {
+		for _, node := range *tables[ip] {
+			otherIP, answers := reachable[node.ID]
+			if !answers || node.Flags.Myself {
+				continue
+			}
+			if node.LinkState == "disconnected" || node.Flags.PFail || node.Flags.Fail {
+				return &clusterPartition{
+					Reason:  partitionNodesDisconnected,
+					Message: fmt.Sprintf("%s can't reach %s (%s) although both answer", ip, otherIP, node.ID),
+				}
+			}
+		}
+	}
+	return epochConflict(ips, tables)
+}
+
+// Returns a split brain if two tables disagree on the master of a slot. A slot
+// that a table does not assign is not a conflict: the node may not have
+// learned about it yet.
+func slotOwnerConflict(ips []string, tables map[string]*rediscli.RedisClusterNodes) *clusterPartition {
+	var owners [rediscli.ClusterSlotCount]string
+	var ownerIPs [rediscli.ClusterSlotCount]string
+	for _, ip := range ips {
+		for _, master := range tables[ip].Masters() {
+			for _, slots := range master.Slots {
+				for slot := slots.First; slot <= slots.Last; slot++ {
+					if owners[slot] == "" {
+						owners[slot], ownerIPs[slot] = master.ID, ip
+						continue
+					}
+					if owners[slot] != master.ID {
+						return &clusterPartition{
+							Reason: partitionSplitBrain,
+							Message: fmt.Sprintf("%s sees master %s serving slot %d, %s sees master %s",
+								ownerIPs[slot], owners[slot], slot, ip, master.ID),
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Returns diverging epochs if two tables see a master with different config
+// epochs, or if a table shows two masters serving slots with the same epoch
+func epochConflict(ips []string, tables map[string]*rediscli.RedisClusterNodes) *clusterPartition {
+	epochs := make(map[string]int64)
+	epochIPs := make(map[string]string)
+	for _, ip := range ips {
+		masters := masterEpochs(tables[ip])
+		var ids []string
+		for id := range masters {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		owners := make(map[int64]string)
+		for _, id := range ids {
+			epoch := masters[id]
+			if other, found := owners[epoch]; found {
+				return &clusterPartition{
+					Reason:  partitionEpochsDiverged,
+					Message: fmt.Sprintf("%s sees masters %s and %s with the same config epoch %d", ip, other, id, epoch),
+				}
+			}
+			owners[epoch] = id
+			if seen, found := epochs[id]; found && seen != epoch {
+				return &clusterPartition{
+					Reason:  partitionEpochsDiverged,
+					Message: fmt.Sprintf("%s sees master %s with config epoch %d, %s with %d", epochIPs[id], id, seen, ip, epoch),
+				}
+			}
+			epochs[id], epochIPs[id] = epoch, ip
+		}
+	}
+	return nil
+}
+
+// Returns true while the nodes disagree, whether the partition is confirmed
+// or only suspected
+func partitionSuspected(redisCluster *dbv1.RedisCluster) bool {
+	condition := findCondition(&redisCluster.Status, ConditionPartitioned)
+	return condition != nil && condition.Status != corev1.ConditionFalse
+}
+
+// Compares the views of the nodes and reports a partition in the status.
+// Returns true while the nodes disagree: the nodes on each side of a partition
+// see a different cluster, and deleting pods, forgetting nodes or moving slots
+// based on the view of one side could lose data once the partition heals.
+// These actions are postponed until the nodes agree again. A disagreement is
+// first suspected, with the Partitioned condition unknown, and only confirmed
+// if it is still there on a later check once the grace period is over.
+func (r *RedisClusterReconciler) checkPartition(ctx context.Context, redisCluster *dbv1.RedisCluster) (bool, error) {
+	snapshot, err := r.getClusterSnapshot(ctx, redisCluster)
+	if err != nil {
+		return false, err
+	}
+	status := &redisCluster.Status
+	condition := findCondition(status, ConditionPartitioned)
+	wasPartitioned := condition != nil && condition.Status == corev1.ConditionTrue
+
+	partition := detectPartition(snapshot)
+	if partition == nil {
+		if wasPartitioned {
+			r.recordEvent(redisCluster, corev1.EventTypeNormal, "PartitionHealed", "The nodes agree on the cluster again")
+		}
+		if condition == nil || condition.Status != corev1.ConditionFalse {
+			setCondition(status, ConditionPartitioned, corev1.ConditionFalse, "NodesAgree", "The nodes agree on the masters, their links and their config epochs")
+		}
+		return false, nil
+	}
+	if !wasPartitioned {
+		if condition == nil || condition.Status != corev1.ConditionUnknown {
+			r.Log.Info(fmt.Sprintf("Nodes disagree (%s): %s, waiting %v before reporting a partition", partition.Reason, partition.Message, partitionGracePeriod))
+			setCondition(status, ConditionPartitioned, corev1.ConditionUnknown, partition.Reason, partition.Message)
+			return true, nil
+		}
+		if time.Since(condition.LastTransitionTime.Time) < partitionGracePeriod {
+			setCondition(status, ConditionPartitioned, corev1.ConditionUnknown, partition.Reason, partition.Message)
+			return true, nil
+		}
+	}
+	if !wasPartitioned || condition.Reason != partition.Reason {
+		r.recordEvent(redisCluster, corev1.EventTypeWarning, "PartitionDetected", fmt.Sprintf("%s: %s", partition.Reason, partition.Message))
+	}
+	setCondition(status, ConditionPartitioned, corev1.ConditionTrue, partition.Reason, partition.Message)
+	return true, nil
+}
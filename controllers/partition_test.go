@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/PayU/Redis-Operator/controllers/rediscli"
+)
+
+func TestDetectPartition(t *testing.T) {
+	agreed := map[string]string{
+		"10.0.0.1": "a 10.0.0.1:6379@16379 myself,master - 0 0 1 connected 0-8191\nb 10.0.0.2:6379@16379 master - 0 0 2 connected 8192-16383",
+		"10.0.0.2": "a 10.0.0.1:6379@16379 master - 0 0 1 connected 0-8191\nb 10.0.0.2:6379@16379 myself,master - 0 0 2 connected 8192-16383",
+	}
+	tests := []struct {
+		name     string
+		tables   map[string]string
+		expected string
+	}{
+		{"agreed", agreed, ""},
+		{"split brain", map[string]string{
+			"10.0.0.1": agreed["10.0.0.1"],
+			"10.0.0.2": "a 10.0.0.1:6379@16379 master,fail - 0 0 1 disconnected\nb 10.0.0.2:6379@16379 myself,master - 0 0 3 connected 0-16383",
+		}, partitionSplitBrain},
+		{"disconnected", map[string]string{
+			"10.0.0.1": "a 10.0.0.1:6379@16379 myself,master - 0 0 1 connected 0-8191\nb 10.0.0.2:6379@16379 master,fail? - 0 0 2 disconnected 8192-16383",
+			"10.0.0.2": agreed["10.0.0.2"],
+		}, partitionNodesDisconnected},
+		{"diverging epochs", map[string]string{
+			"10.0.0.1": agreed["10.0.0.1"],
+			"10.0.0.2": "a 10.0.0.1:6379@16379 master - 0 0 3 connected 0-8191\nb 10.0.0.2:6379@16379 myself,master - 0 0 2 connected 8192-16383",
+		}, partitionEpochsDiverged},
+	}
+	for _, test := range tests {
+		snapshot := &ClusterSnapshot{Nodes: make(map[string]*NodeSnapshot)}
+		for ip, table := range test.tables {
+			pod := corev1.Pod{}
+			pod.Status.PodIP = ip
+			snapshot.Pods = append(snapshot.Pods, pod)
+			nodes := rediscli.NewRedisClusterNodes(table)
+			snapshot.Nodes[ip] = &NodeSnapshot{IP: ip, ID: nodes.Myself().ID, Nodes: nodes}
+		}
+		reason := ""
+		if partition := detectPartition(snapshot); partition != nil {
+			reason = partition.Reason
+		}
+		if reason != test.expected {
+			t.Errorf("%s: detected %q, expected %q", test.name, reason, test.expected)
+		}
+	}
+}
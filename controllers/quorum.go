@@ -0,0 +1,260 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/pkg/errors"
+
+	dbv1 "github.com/PayU/Redis-Operator/api/v1"
+	"github.com/PayU/Redis-Operator/controllers/rediscli"
+)
+
+// quorumState is what the reachable nodes report about the masters serving slots
+type quorumState struct {
+	// IPs of the nodes that answered, indexed by node ID
+	nodeIPs map[string]string
+	// the CLUSTER NODES table of the reachable node that knows most nodes
+	nodes   *rediscli.RedisClusterNodes
+	masters []*rediscli.RedisClusterNode
+	// the unreachable masters, in the order their shards are taken over
+	lost []*rediscli.RedisClusterNode
+}
+
+// Collects the masters serving slots from the snapshot. A master is lost when
+// no pod of the cluster answers with its node ID. The lost masters serving most
+// slots come first, so that the takeovers restore as much of the keyspace as
+// possible as early as possible. Returns nil if no node could be reached.
+func newQuorumState(snapshot *ClusterSnapshot) *quorumState {
+	state := &quorumState{nodeIPs: make(map[string]string)}
+	for _, pod := range snapshot.Pods {
+		node := snapshot.Node(pod.Status.PodIP)
+		if pod.DeletionTimestamp != nil || node == nil || node.Err != nil || node.Nodes == nil {
+			continue
+		}
+		state.nodeIPs[node.ID] = node.IP
+		if state.nodes == nil || len(*node.Nodes) > len(*state.nodes) {
+			state.nodes = node.Nodes
+		}
+	}
+	if state.nodes == nil {
+		return nil
+	}
+	for _, master := range state.nodes.Masters() {
+		if !master.HasSlots() {
+			continue
+		}
+		state.masters = append(state.masters, master)
+		if _, reachable := state.nodeIPs[master.ID]; !reachable {
+			state.lost = append(state.lost, master)
+		}
+	}
+	sort.Slice(state.lost, func(i, j int) bool {
+		if state.lost[i].Slots.Count() != state.lost[j].Slots.Count() {
+			return state.lost[i].Slots.Count() > state.lost[j].Slots.Count()
+		}
+		return state.lost[i].ID < state.lost[j].ID
+	})
+	return state
+}
+
+// Returns true if the majority of the masters serving slots can be reached,
+// which Redis needs to confirm failures and to vote for a failover
+func (q *quorumState) hasQuorum() bool {
+	return 2*(len(q.masters)-len(q.lost)) > len(q.masters)
+}
+
+// Returns the IPs of the reachable replicas of the master
+func (q *quorumState) replicaIPs(masterID string) []string {
+	replicas := q.nodes.Replicas(masterID)
+	sort.Slice(replicas, func(i, j int) bool { return replicas[i].ID < replicas[j].ID })
+	var ips []string
+	for _, replica := range replicas {
+		if ip, reachable := q.nodeIPs[replica.ID]; reachable {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+func (q *quorumState) reachableIPs() []string {
+	var ips []string
+	for _, ip := range q.nodeIPs {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	return ips
+}
+
+// Detects the loss of the majority of the masters serving slots. Without the
+// majority Redis neither fails over on its own nor with CLUSTER FAILOVER FORCE,
+// so a reachable replica of each lost master is promoted with CLUSTER FAILOVER
+// TAKEOVER. The shards are taken over one at a time and every reachable node
+// must agree on the new master and its config epoch before the next one: a
+// takeover bumps the epoch without the agreement of the other masters and two
+// concurrent ones could claim the same epoch. Shards without a reachable
+// replica are left to the recovery of the lost shards.
+func (r *RedisClusterReconciler) recoverQuorum(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
+	snapshot, err := r.getClusterSnapshot(ctx, redisCluster)
+	if err != nil {
+		return err
+	}
+	quorum := newQuorumState(snapshot)
+	if quorum == nil {
+		return nil
+	}
+	status := &redisCluster.Status
+	if quorum.hasQuorum() {
+		if condition := findCondition(status, ConditionQuorumAvailable); condition == nil || condition.Status != corev1.ConditionTrue {
+			setCondition(status, ConditionQuorumAvailable, corev1.ConditionTrue, "MajorityReachable", "The majority of the masters serving slots is reachable")
+		}
+		return nil
+	}
+
+	message := fmt.Sprintf("%d of the %d masters serving slots are unreachable", len(quorum.lost), len(quorum.masters))
+	setCondition(status, ConditionQuorumAvailable, corev1.ConditionFalse, "QuorumLost", message)
+	r.recordEvent(redisCluster, corev1.EventTypeWarning, "QuorumLost", message)
+	r.Log.Info("Masters serving slots: " + quorum.String())
+
+	ips := quorum.reachableIPs()
+	for _, master := range quorum.lost {
+		masterIP := ""
+		for i := range snapshot.Pods {
+			if isPodOfClusterNode(&snapshot.Pods[i], master) {
+				masterIP = snapshot.Pods[i].Status.PodIP
+			}
+		}
+		candidates, err := r.chooseFailoverCandidates(ctx, redisCluster, masterIP, quorum.replicaIPs(master.ID))
+		if err != nil {
+			return err
+		}
+		if len(candidates) == 0 {
+			r.Log.Info(fmt.Sprintf("[WARN] Lost master %s has no reachable replica, slots %s wait for the recovery of its shard", master.ID, master.Slots.String()))
+			continue
+		}
+		replicaIP := candidates[0].IP
+		message := fmt.Sprintf("Promoting replica %s in place of master %s (slots %s)", replicaIP, master.ID, master.Slots.String())
+		setCondition(status, ConditionQuorumAvailable, corev1.ConditionFalse, "TakeoverInProgress", message)
+		r.persistStatus(ctx, redisCluster)
+		r.recordEvent(redisCluster, corev1.EventTypeNormal, "Takeover", message)
+		if err := r.doFailover(ctx, replicaIP, "takeover"); err != nil {
+			return errors.Wrapf(err, "Takeover of master %s failed", master.ID)
+		}
+		if err := r.waitForEpochConvergence(ctx, ips...); err != nil {
+			setCondition(status, ConditionQuorumAvailable, corev1.ConditionFalse, "EpochsDiverged", err.Error())
+			return err
+		}
+	}
+
+	if err := r.waitForEpochConvergence(ctx, ips...); err != nil {
+		setCondition(status, ConditionQuorumAvailable, corev1.ConditionFalse, "EpochsDiverged", err.Error())
+		return err
+	}
+	message = "The replicas of the lost masters took over their slots and the config epochs converged"
+	setCondition(status, ConditionQuorumAvailable, corev1.ConditionTrue, "EpochsConverged", message)
+	r.recordEvent(redisCluster, corev1.EventTypeNormal, "QuorumRestored", message)
+	return nil
+}
+
+// Waits until the nodes agree on the masters serving slots and their config
+// epochs, and no two of these masters share an epoch
+func (r *RedisClusterReconciler) waitForEpochConvergence(ctx context.Context, nodeIPs ...string) error {
+	var divergence string
+	err := pollImmediate(ctx, syncCheckInterval, syncCheckTimeout, func() (bool, error) {
+		tables := make(map[string]*rediscli.RedisClusterNodes)
+		for _, ip := range nodeIPs {
+			nodes, err := r.RedisCLI.ClusterNodes(ctx, ip)
+			if err != nil {
+				divergence = err.Error()
+				return false, nil
+			}
+			tables[ip] = nodes
+		}
+		divergence = epochDivergence(tables)
+		return divergence == "", nil
+	})
+	if err != nil {
+		return errors.Errorf("Config epochs did not converge: %s", divergence)
+	}
+	return nil
+}
+
+// Returns a description of the first disagreement between the CLUSTER NODES
+// tables, indexed by the IP of the node that produced them, on the masters
+// serving slots and their config epochs, or the empty string
+func epochDivergence(tables map[string]*rediscli.RedisClusterNodes) string {
+	var ips []string
+	for ip := range tables {
+		ips = append(ips, ip)
+	}
+	if len(ips) == 0 {
+		return ""
+	}
+	sort.Strings(ips)
+
+	reference := masterEpochs(tables[ips[0]])
+	owners := make(map[int64]string)
+	var ids []string
+	for id := range reference {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if other, found := owners[reference[id]]; found {
+			return fmt.Sprintf("masters %s and %s share the config epoch %d", other, id, reference[id])
+		}
+		owners[reference[id]] = id
+	}
+
+	for _, ip := range ips[1:] {
+		masters := masterEpochs(tables[ip])
+		if len(masters) != len(reference) {
+			return fmt.Sprintf("%s sees %d masters serving slots, %s sees %d", ip, len(masters), ips[0], len(reference))
+		}
+		for _, id := range ids {
+			if epoch, found := masters[id]; !found || epoch != reference[id] {
+				return fmt.Sprintf("%s does not see master %s with config epoch %d like %s", ip, id, reference[id], ips[0])
+			}
+		}
+	}
+	return ""
+}
+
+// Returns the config epochs of the masters serving slots, indexed by node ID
+func masterEpochs(nodes *rediscli.RedisClusterNodes) map[string]int64 {
+	masters := make(map[string]int64)
+	for _, master := range nodes.Masters() {
+		if master.HasSlots() {
+			masters[master.ID] = master.ConfigEpoch
+		}
+	}
+	return masters
+}
+
+// Saves the status right away, so that the progress of a long operation is
+// visible before the end of the reconcile loop. Failures are only logged, the
+// status is saved again at the end of the loop.
+func (r *RedisClusterReconciler) persistStatus(ctx context.Context, redisCluster *dbv1.RedisCluster) {
+	if err := r.Status().Update(ctx, redisCluster); err != nil {
+		if apierrors.IsConflict(err) {
+			r.Log.Info("Conflict when saving the status of " + redisCluster.Name)
+			return
+		}
+		r.Log.Info(fmt.Sprintf("Failed to save the status of %s: %v", redisCluster.Name, err))
+	}
+}
+
+// Describes the masters of the state, used in the logs
+func (q *quorumState) String() string {
+	var masters []string
+	for _, master := range q.masters {
+		_, reachable := q.nodeIPs[master.ID]
+		masters = append(masters, fmt.Sprintf("%s(%s, reachable: %t)", master.ID, master.Slots.String(), reachable))
+	}
+	return strings.Join(masters, " ")
+}
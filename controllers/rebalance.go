@@ -0,0 +1,384 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/pkg/errors"
+
+	dbv1 "github.com/PayU/Redis-Operator/api/v1"
+	"github.com/PayU/Redis-Operator/controllers/rediscli"
+)
+
+const (
+	defaultRebalanceTolerance         = 10
+	defaultRebalanceSlotsPerReconcile = 16
+	defaultRebalanceMinInterval       = time.Hour
+
+	// Delay of the next reconcile loop while a rebalancing is in progress
+	rebalanceRequeueInterval = time.Second
+)
+
+// shardLoad is the size of a shard measured on its leader and the estimated
+// size of each of its slots
+type shardLoad struct {
+	leaderNumber string
+	ip           string
+	size         int64
+	slotRanges   rediscli.SlotRanges
+	slots        map[int]int64
+}
+
+// plannedMove is a slot to migrate from a shard to another one
+type plannedMove struct {
+	slot     int
+	from, to string
+}
+
+func rebalanceInProgress(redisCluster *dbv1.RedisCluster) bool {
+	status := redisCluster.Status.Rebalance
+	return status != nil && status.Phase == dbv1.RebalanceInProgress
+}
+
+// Migrates the next slots of the rebalancing in progress or, if there is none,
+// plans a new one when the size of the leaders is out of the tolerance or when
+// a new trigger was set in the spec. The rebalancing in progress is continued
+// even without spec.rebalance, like the one moving the pinned slots back to
+// their leaders. The slots are only moved while all of them are covered.
+func (r *RedisClusterReconciler) rebalanceSlots(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
+	if covered := findCondition(&redisCluster.Status, ConditionSlotsCovered); covered == nil || covered.Status != corev1.ConditionTrue {
+		return nil
+	}
+	if rebalanceInProgress(redisCluster) {
+		return r.continueRebalance(ctx, redisCluster)
+	}
+	spec := redisCluster.Spec.Rebalance
+	if spec == nil {
+		return nil
+	}
+
+	status := redisCluster.Status.Rebalance
+	triggered := spec.Trigger != "" && (status == nil || status.Trigger != spec.Trigger)
+	if !triggered {
+		if !spec.Enabled {
+			return nil
+		}
+		minInterval := defaultRebalanceMinInterval
+		if spec.MinInterval != nil {
+			minInterval = spec.MinInterval.Duration
+		}
+		if status != nil && status.CompletionTime != nil && time.Since(status.CompletionTime.Time) < minInterval {
+			return nil
+		}
+	}
+
+	metric := spec.Metric
+	if metric == "" {
+		metric = dbv1.RebalanceByMemory
+	}
+	tolerance := int64(defaultRebalanceTolerance)
+	if spec.TolerancePercent != 0 {
+		tolerance = int64(spec.TolerancePercent)
+	}
+	pinned, err := pinnedSlots(redisCluster)
+	if err != nil {
+		return err
+	}
+	shards, err := r.measureShards(ctx, redisCluster, metric)
+	if err != nil {
+		return err
+	}
+	now := metav1.Now()
+	newStatus := &dbv1.RebalanceStatus{Phase: dbv1.RebalanceCompleted, Trigger: spec.Trigger, Metric: metric, StartTime: &now, CompletionTime: &now}
+	drift := driftedSlots(shards, pinned)
+	if len(drift) == 0 && isBalanced(shards, tolerance) {
+		if triggered {
+			newStatus.Message = "The leaders are balanced"
+			redisCluster.Status.Rebalance = newStatus
+		}
+		return nil
+	}
+
+	for _, shard := range shards {
+		if err = r.measureSlots(ctx, shard, metric); err != nil {
+			return err
+		}
+	}
+	// the pinned slots are moved back to their leaders first and are not
+	// moved by the balancing
+	applyMoves(shards, drift)
+	moves := append(drift, planSlotMoves(shards, tolerance, pinned)...)
+	if len(moves) == 0 {
+		// recorded so that the slots are not measured again before the minimum interval
+		newStatus.Message = "No slot migration improves the balance of the leaders"
+		redisCluster.Status.Rebalance = newStatus
+		return nil
+	}
+	newStatus.Phase = dbv1.RebalanceInProgress
+	newStatus.CompletionTime = nil
+	newStatus.Moves = groupSlotMoves(moves)
+	newStatus.TotalSlots = int32(len(moves))
+	redisCluster.Status.Rebalance = newStatus
+	r.Log.Info(fmt.Sprintf("Rebalancing %d slots by %s: %v", len(moves), metric, newStatus.Moves))
+	return r.continueRebalance(ctx, redisCluster)
+}
+
+// Measures the size of every leader from the INFO of the cluster snapshot
+func (r *RedisClusterReconciler) measureShards(ctx context.Context, redisCluster *dbv1.RedisCluster, metric dbv1.RebalanceMetric) ([]*shardLoad, error) {
+	snapshot, err := r.getClusterSnapshot(ctx, redisCluster)
+	if err != nil {
+		return nil, err
+	}
+	clusterView, err := r.NewRedisClusterView(ctx, redisCluster)
+	if err != nil {
+		return nil, err
+	}
+	var shards []*shardLoad
+	for _, leader := range *clusterView {
+		if leader.Failed || leader.Pod == nil {
+			return nil, errors.Errorf("Leader [%s] is not available", leader.LeaderNumber)
+		}
+		node := snapshot.Node(leader.Pod.Status.PodIP)
+		if node == nil || node.Info == nil || node.Nodes == nil || node.Nodes.Myself() == nil {
+			return nil, errors.Errorf("Leader [%s] was not measured", leader.LeaderNumber)
+		}
+		size := node.Info.KeyCount()
+		if metric == dbv1.RebalanceByMemory {
+			memory, ok := node.Info.UsedMemory()
+			if !ok {
+				return nil, errors.Errorf("Leader [%s] does not report its used memory", leader.LeaderNumber)
+			}
+			size = memory
+		}
+		shards = append(shards, &shardLoad{leaderNumber: leader.LeaderNumber, ip: node.IP, size: size, slotRanges: node.Nodes.Myself().Slots})
+	}
+	return shards, nil
+}
+
+// Estimates the size of every slot of a shard from its number of keys; with
+// the memory metric the used memory of the leader is split among the keys.
+// The keys are counted with pipelined commands. The slots whose keys could not
+// be counted are left out of the shard, so they are not moved.
+func (r *RedisClusterReconciler) measureSlots(ctx context.Context, shard *shardLoad, metric dbv1.RebalanceMetric) error {
+	var slots []int
+	for _, slotRange := range shard.slotRanges {
+		for slot := slotRange.First; slot <= slotRange.Last; slot++ {
+			slots = append(slots, slot)
+		}
+	}
+	keys, err := r.RedisCLI.ClusterCountKeysInSlots(ctx, shard.ip, slots...)
+	if keys == nil {
+		return err
+	}
+	if err != nil {
+		r.Log.Info(fmt.Sprintf("Measured %d of the %d slots of leader [%s]: %v", len(keys), len(slots), shard.leaderNumber, err))
+	}
+	var totalKeys int64
+	for _, count := range keys {
+		totalKeys += count
+	}
+	shard.slots = make(map[int]int64, len(keys))
+	for slot, count := range keys {
+		switch {
+		case metric == dbv1.RebalanceByKeys:
+			shard.slots[slot] = count
+		case totalKeys != 0:
+			shard.slots[slot] = shard.size * count / totalKeys
+		default:
+			shard.slots[slot] = shard.size / int64(len(keys))
+		}
+	}
+	return nil
+}
+
+// Returns true if the size of every shard is within the tolerance, in percent
+// of the average size
+func isBalanced(shards []*shardLoad, tolerancePercent int64) bool {
+	average, allowed := averageLoad(shards, tolerancePercent)
+	for _, shard := range shards {
+		if shard.size-average > allowed || average-shard.size > allowed {
+			return false
+		}
+	}
+	return true
+}
+
+func averageLoad(shards []*shardLoad, tolerancePercent int64) (int64, int64) {
+	if len(shards) == 0 {
+		return 0, 0
+	}
+	var total int64
+	for _, shard := range shards {
+		total += shard.size
+	}
+	average := total / int64(len(shards))
+	return average, average * tolerancePercent / 100
+}
+
+// Plans the slot migrations that bring the shards within the tolerance. The
+// slots of the largest shard are moved one at a time to the smallest shard,
+// choosing the largest slot that fits in the load exceeding on one and missing
+// on the other, and never a slot that would reverse their imbalance. The sizes
+// of the shards are updated. Pinned slots are not moved.
+func planSlotMoves(shards []*shardLoad, tolerancePercent int64, pinned map[int]string) []plannedMove {
+	average, allowed := averageLoad(shards, tolerancePercent)
+	var moves []plannedMove
+	for len(shards) > 1 && len(moves) < rediscli.ClusterSlotCount {
+		sort.SliceStable(shards, func(i, j int) bool { return shards[i].size < shards[j].size })
+		lightest, heaviest := shards[0], shards[len(shards)-1]
+		if heaviest.size-average <= allowed && average-lightest.size <= allowed {
+			break
+		}
+		gap := heaviest.size - lightest.size
+		ideal := heaviest.size - average
+		if missing := average - lightest.size; missing < ideal {
+			ideal = missing
+		}
+
+		slot := -1
+		for candidate, size := range heaviest.slots {
+			if _, isPinned := pinned[candidate]; isPinned || size <= 0 || size >= gap {
+				continue
+			}
+			if slot >= 0 && !betterSlot(size, candidate, heaviest.slots[slot], slot, ideal) {
+				continue
+			}
+			slot = candidate
+		}
+		if slot < 0 || len(heaviest.slots) == 1 {
+			break
+		}
+
+		size := heaviest.slots[slot]
+		delete(heaviest.slots, slot)
+		lightest.slots[slot] = size
+		heaviest.size -= size
+		lightest.size += size
+		moves = append(moves, plannedMove{slot: slot, from: heaviest.leaderNumber, to: lightest.leaderNumber})
+	}
+	return moves
+}
+
+// Returns true if the slot of size a is a better move than the slot of size b:
+// the largest slot not above the ideal size, else the smallest one. Ties go to
+// the lowest slot number.
+func betterSlot(a int64, slotA int, b int64, slotB int, ideal int64) bool {
+	switch {
+	case a == b:
+		return slotA < slotB
+	case a <= ideal && b <= ideal:
+		return a > b
+	case a <= ideal || b <= ideal:
+		return a <= ideal
+	default:
+		return a < b
+	}
+}
+
+// Groups the consecutive slots moved between the same shards in ranges
+func groupSlotMoves(moves []plannedMove) []dbv1.SlotMove {
+	sorted := append([]plannedMove(nil), moves...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].from != sorted[j].from {
+			return sorted[i].from < sorted[j].from
+		}
+		if sorted[i].to != sorted[j].to {
+			return sorted[i].to < sorted[j].to
+		}
+		return sorted[i].slot < sorted[j].slot
+	})
+	var groups []dbv1.SlotMove
+	var current rediscli.SlotRange
+	for i, move := range sorted {
+		if i > 0 && move.from == sorted[i-1].from && move.to == sorted[i-1].to && move.slot == current.Last+1 {
+			current.Last = move.slot
+			groups[len(groups)-1].Slots = current.String()
+			continue
+		}
+		current = rediscli.SlotRange{First: move.slot, Last: move.slot}
+		groups = append(groups, dbv1.SlotMove{Slots: current.String(), From: move.from, To: move.to})
+	}
+	return groups
+}
+
+// Migrates the next slots of the rebalancing in progress, at most
+// slotsPerReconcile of them. Slots that are already served by their target or
+// that moved to another leader in the meantime are counted as migrated.
+func (r *RedisClusterReconciler) continueRebalance(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
+	spec := redisCluster.Spec.Rebalance
+	status := redisCluster.Status.Rebalance
+	budget := int32(defaultRebalanceSlotsPerReconcile)
+	throttle := defaultMigrationThrottle
+	if spec != nil {
+		if spec.SlotsPerReconcile != 0 {
+			budget = spec.SlotsPerReconcile
+		}
+		if spec.KeysPerBatch != 0 {
+			throttle.batchSize = int(spec.KeysPerBatch)
+		}
+		if spec.BatchInterval != nil {
+			throttle.interval = spec.BatchInterval.Duration
+		}
+	}
+
+	coverage, err := r.getSlotCoverage(ctx, redisCluster)
+	if err != nil {
+		return err
+	}
+	clusterView, err := r.NewRedisClusterView(ctx, redisCluster)
+	if err != nil {
+		return err
+	}
+	leaderIDs := make(map[string]string)
+	for _, leader := range *clusterView {
+		if !leader.Failed {
+			leaderIDs[leader.LeaderNumber] = leader.RedisID
+		}
+	}
+
+	var migrated int32
+	for _, move := range status.Moves {
+		slots, ok := rediscli.ParseSlotRange(move.Slots)
+		if !ok {
+			return errors.Errorf("Malformed slot range %s in the rebalancing plan", move.Slots)
+		}
+		from, to := leaderIDs[move.From], leaderIDs[move.To]
+		for slot := slots.First; slot <= slots.Last; slot++ {
+			owner := coverage.view.SlotOwner(slot)
+			if owner == nil || owner.ID != from {
+				migrated++
+				continue
+			}
+			if budget == 0 || err != nil {
+				continue
+			}
+			if _, available := coverage.masters[to]; to == "" || !available {
+				err = errors.Errorf("Leader [%s] is not available", move.To)
+				continue
+			}
+			if err = r.migrateSlot(ctx, coverage.masters, slot, from, to, throttle); err == nil {
+				migrated++
+				budget--
+			}
+		}
+	}
+
+	status.MigratedSlots = migrated
+	if err != nil {
+		status.Message = err.Error()
+		return err
+	}
+	status.Message = ""
+	if migrated == status.TotalSlots {
+		now := metav1.Now()
+		status.Phase = dbv1.RebalanceCompleted
+		status.CompletionTime = &now
+		r.Log.Info(fmt.Sprintf("Rebalancing of %d slots completed", migrated))
+	}
+	return nil
+}
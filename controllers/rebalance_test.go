@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	dbv1 "github.com/PayU/Redis-Operator/api/v1"
+	"github.com/PayU/Redis-Operator/controllers/rediscli"
+)
+
+func newShardLoad(leaderNumber string, slots map[int]int64) *shardLoad {
+	shard := &shardLoad{leaderNumber: leaderNumber, slots: slots}
+	for _, size := range slots {
+		shard.size += size
+	}
+	return shard
+}
+
+func TestPlanSlotMoves(t *testing.T) {
+	shards := []*shardLoad{
+		newShardLoad("0", map[int]int64{0: 100, 1: 100, 2: 100, 3: 100, 4: 100, 5: 100}),
+		newShardLoad("1", map[int]int64{10: 0}),
+		newShardLoad("2", map[int]int64{20: 0}),
+	}
+	moves := planSlotMoves(shards, 10, nil)
+	expected := []plannedMove{{0, "0", "1"}, {1, "0", "2"}, {2, "0", "2"}, {3, "0", "1"}}
+	if !reflect.DeepEqual(moves, expected) {
+		t.Errorf("Unexpected moves %+v", moves)
+	}
+	if !isBalanced(shards, 10) {
+		t.Errorf("Shards are not balanced after the moves: %+v %+v %+v", shards[0], shards[1], shards[2])
+	}
+}
+
+// The largest slot fitting in the imbalance is moved first
+func TestPlanSlotMovesLargestFit(t *testing.T) {
+	shards := []*shardLoad{
+		newShardLoad("0", map[int]int64{0: 300, 1: 300, 2: 200, 3: 100, 4: 100}),
+		newShardLoad("1", map[int]int64{10: 100}),
+		newShardLoad("2", map[int]int64{20: 100}),
+	}
+	moves := planSlotMoves(shards, 10, nil)
+	expected := []plannedMove{{0, "0", "1"}, {1, "0", "2"}}
+	if !reflect.DeepEqual(moves, expected) {
+		t.Errorf("Unexpected moves %+v", moves)
+	}
+}
+
+func TestGroupSlotMoves(t *testing.T) {
+	moves := []plannedMove{{7, "1", "2"}, {5, "0", "2"}, {3, "0", "2"}, {4, "0", "2"}, {6, "0", "1"}}
+	expected := []dbv1.SlotMove{{Slots: "6", From: "0", To: "1"}, {Slots: "3-5", From: "0", To: "2"}, {Slots: "7", From: "1", To: "2"}}
+	if groups := groupSlotMoves(moves); !reflect.DeepEqual(groups, expected) {
+		t.Errorf("Unexpected grouped moves %+v", groups)
+	}
+}
+
+// A single slot larger than the gap between the shards can't be moved
+func TestPlanSlotMovesHotSlot(t *testing.T) {
+	shards := []*shardLoad{
+		newShardLoad("0", map[int]int64{0: 1000, 1: 0}),
+		newShardLoad("1", map[int]int64{10: 0}),
+		newShardLoad("2", map[int]int64{20: 0}),
+	}
+	if moves := planSlotMoves(shards, 10, nil); len(moves) != 0 {
+		t.Errorf("Unexpected moves %+v", moves)
+	}
+}
+
+func TestPlanSlotMovesPinned(t *testing.T) {
+	shards := []*shardLoad{
+		newShardLoad("0", map[int]int64{0: 100, 1: 100, 2: 100, 3: 100, 4: 100, 5: 100}),
+		newShardLoad("1", map[int]int64{10: 0}),
+		newShardLoad("2", map[int]int64{20: 0}),
+	}
+	moves := planSlotMoves(shards, 10, map[int]string{0: "0", 1: "0"})
+	expected := []plannedMove{{2, "0", "1"}, {3, "0", "2"}, {4, "0", "2"}, {5, "0", "1"}}
+	if !reflect.DeepEqual(moves, expected) {
+		t.Errorf("Unexpected moves %+v", moves)
+	}
+}
+
+// failingSlotCounts fails the key count of some slots, like a node answering
+// their commands with an error reply
+type failingSlotCounts struct {
+	rediscli.RedisAdmin
+	failed map[int]bool
+}
+
+func (f *failingSlotCounts) ClusterCountKeysInSlots(ctx context.Context, nodeIP string, slots ...int) (map[int]int64, error) {
+	counts, err := f.RedisAdmin.ClusterCountKeysInSlots(ctx, nodeIP, slots...)
+	if err != nil {
+		return nil, err
+	}
+	for slot := range f.failed {
+		if _, found := counts[slot]; found {
+			delete(counts, slot)
+			err = errors.Errorf("Failed to count the keys of slot %d", slot)
+		}
+	}
+	return counts, err
+}
+
+// A slot whose keys could not be counted is left out of the measure
+func TestMeasureSlotsFailedSlot(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	env.reconcileUntil(Ready, 5)
+	slot, ip := env.firstSlotOf("redis-node-0")
+	env.redis.SetSlotKeys(ip, slot+1, 20)
+	env.reconciler.RedisCLI = &failingSlotCounts{RedisAdmin: env.redis, failed: map[int]bool{slot: true}}
+
+	shard := &shardLoad{leaderNumber: "0", ip: ip, slotRanges: rediscli.SlotRanges{{First: slot, Last: slot + 9}}}
+	if err := env.reconciler.measureSlots(context.Background(), shard, dbv1.RebalanceByKeys); err != nil {
+		t.Fatalf("Failed to measure the slots: %v", err)
+	}
+	if _, found := shard.slots[slot]; found || len(shard.slots) != 9 {
+		t.Errorf("Expected 9 measured slots without slot %d, found %d", slot, len(shard.slots))
+	}
+	if shard.slots[slot+1] != 20 {
+		t.Errorf("Expected 20 keys in slot %d, found %d", slot+1, shard.slots[slot+1])
+	}
+}
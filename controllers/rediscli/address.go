@@ -0,0 +1,113 @@
+package rediscli
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// NodeAddress is a node address as reported by CLUSTER NODES: ip:port@cport
+// followed, since Redis 7, by ,hostname. Redis does not enclose IPv6 addresses
+// in brackets, so the port is the part after the last colon.
+type NodeAddress struct {
+	IP       string
+	Port     string
+	BusPort  string
+	Hostname string
+}
+
+// ParseNodeAddress splits a CLUSTER NODES address in its parts; missing parts
+// are left empty
+func ParseNodeAddress(addr string) NodeAddress {
+	var address NodeAddress
+	if i := strings.Index(addr, ","); i != -1 {
+		addr, address.Hostname = addr[:i], addr[i+1:]
+	}
+	if i := strings.LastIndex(addr, "@"); i != -1 {
+		addr, address.BusPort = addr[:i], addr[i+1:]
+	}
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		address.IP, address.Port = host, port
+	} else if i := strings.LastIndex(addr, ":"); i != -1 {
+		address.IP, address.Port = addr[:i], addr[i+1:]
+	} else {
+		address.IP = addr
+	}
+	return address
+}
+
+// HostPort returns the address in the host:port format, with brackets for IPv6
+func (a NodeAddress) HostPort() string {
+	return net.JoinHostPort(a.IP, a.Port)
+}
+
+// SplitHostPort splits an address in host and port. It accepts a bare IPv4 or
+// IPv6 address or hostname (the port is then empty), host:port and [ipv6]:port.
+// An unbracketed string that is a valid IPv6 address is never split, other
+// unbracketed strings with several colons are split at the last one.
+func SplitHostPort(addr string) (string, string) {
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		return host, port
+	}
+	trimmed := strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	if net.ParseIP(trimmed) != nil || !strings.Contains(trimmed, ":") {
+		return trimmed, ""
+	}
+	i := strings.LastIndex(addr, ":")
+	return strings.TrimSuffix(strings.TrimPrefix(addr[:i], "["), "]"), addr[i+1:]
+}
+
+const (
+	// DefaultPort is the port Redis listens on for clients when none is configured
+	DefaultPort = 6379
+	// busPortOffset is the distance between the client port and the default cluster bus port
+	busPortOffset = 10000
+)
+
+type nodePortsKey struct{}
+
+type nodePorts struct {
+	port    int
+	busPort int
+}
+
+// WithNodePorts returns a context that makes the commands reach the nodes
+// addressed without an explicit port on the given client port, and introduce
+// them to the cluster with the given port and cluster bus port. Zero values
+// select the defaults: 6379 and the client port + 10000.
+func WithNodePorts(ctx context.Context, port int, busPort int) context.Context {
+	return context.WithValue(ctx, nodePortsKey{}, nodePorts{port: port, busPort: busPort})
+}
+
+// NodePorts returns the client and cluster bus ports set on the context with
+// WithNodePorts, or the defaults
+func NodePorts(ctx context.Context) (int, int) {
+	ports, _ := ctx.Value(nodePortsKey{}).(nodePorts)
+	if ports.port == 0 {
+		ports.port = DefaultPort
+	}
+	if ports.busPort == 0 {
+		ports.busPort = ports.port + busPortOffset
+	}
+	return ports.port, ports.busPort
+}
+
+// ResolveNodeAddress resolves the address of a node given as a bare IP or
+// hostname, or as host:port, to the host and the client and cluster bus ports.
+// The bus port is the one set on the context with WithNodePorts; when none is
+// set, the bus port of an address with an explicit port is that port + 10000.
+func ResolveNodeAddress(ctx context.Context, node string) (string, string, string) {
+	ports, _ := ctx.Value(nodePortsKey{}).(nodePorts)
+	port, busPort := NodePorts(ctx)
+	host, explicitPort := SplitHostPort(node)
+	if explicitPort != "" && explicitPort != strconv.Itoa(port) {
+		if p, err := strconv.Atoi(explicitPort); err == nil {
+			port = p
+			if ports.busPort == 0 {
+				busPort = p + busPortOffset
+			}
+		}
+	}
+	return host, strconv.Itoa(port), strconv.Itoa(busPort)
+}
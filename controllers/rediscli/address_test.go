@@ -0,0 +1,76 @@
+package rediscli
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseNodeAddress(t *testing.T) {
+	tests := []struct {
+		addr     string
+		expected NodeAddress
+	}{
+		{"10.0.0.1:6379@16379", NodeAddress{IP: "10.0.0.1", Port: "6379", BusPort: "16379"}},
+		{"10.0.0.1:6379", NodeAddress{IP: "10.0.0.1", Port: "6379"}},
+		{"10.0.0.1:6379@16379,redis-node-0.default.svc", NodeAddress{IP: "10.0.0.1", Port: "6379", BusPort: "16379", Hostname: "redis-node-0.default.svc"}},
+		{"fd00::a:1:6379@16379", NodeAddress{IP: "fd00::a:1", Port: "6379", BusPort: "16379"}},
+		{"[fd00::a:1]:6379@16379", NodeAddress{IP: "fd00::a:1", Port: "6379", BusPort: "16379"}},
+		{"fd00::a:1:7000@17000,redis-node-1", NodeAddress{IP: "fd00::a:1", Port: "7000", BusPort: "17000", Hostname: "redis-node-1"}},
+		{":0@0", NodeAddress{IP: "", Port: "0", BusPort: "0"}},
+	}
+	for _, test := range tests {
+		if address := ParseNodeAddress(test.addr); address != test.expected {
+			t.Errorf("ParseNodeAddress(%q) = %+v, expected %+v", test.addr, address, test.expected)
+		}
+	}
+	if hostPort := ParseNodeAddress("fd00::a:1:6379@16379").HostPort(); hostPort != "[fd00::a:1]:6379" {
+		t.Errorf("Unexpected host and port %s", hostPort)
+	}
+}
+
+func TestSplitHostPort(t *testing.T) {
+	tests := []struct {
+		addr, host, port string
+	}{
+		{"10.0.0.1", "10.0.0.1", ""},
+		{"10.0.0.1:6379", "10.0.0.1", "6379"},
+		{"fd00::a:1", "fd00::a:1", ""},
+		{"[fd00::a:1]", "fd00::a:1", ""},
+		{"[fd00::a:1]:6379", "fd00::a:1", "6379"},
+		{"redis-node-0.default.svc:6379", "redis-node-0.default.svc", "6379"},
+	}
+	for _, test := range tests {
+		if host, port := SplitHostPort(test.addr); host != test.host || port != test.port {
+			t.Errorf("SplitHostPort(%q) = %q, %q, expected %q, %q", test.addr, host, port, test.host, test.port)
+		}
+	}
+}
+
+func TestNewRedisInfoIPv6(t *testing.T) {
+	info := NewRedisInfo("# Replication\r\nrole:slave\r\nmaster_host:fd00::a:1\r\nmaster_port:6379\r\n")
+	if info.Replication["master_host"] != "fd00::a:1" {
+		t.Errorf("Unexpected master host %q", info.Replication["master_host"])
+	}
+}
+
+func TestResolveNodeAddress(t *testing.T) {
+	ctx := WithNodePorts(context.Background(), 7000, 7100)
+	tests := []struct {
+		ctx                     context.Context
+		addr, host, port, cport string
+	}{
+		{context.Background(), "10.0.0.1", "10.0.0.1", "6379", "16379"},
+		{ctx, "10.0.0.1", "10.0.0.1", "7000", "7100"},
+		{ctx, "10.0.0.1:7000", "10.0.0.1", "7000", "7100"},
+		{ctx, "10.0.0.1:7001", "10.0.0.1", "7001", "7100"},
+		{WithNodePorts(context.Background(), 7000, 0), "10.0.0.1:7001", "10.0.0.1", "7001", "17001"},
+		{context.Background(), "10.0.0.1:7001", "10.0.0.1", "7001", "17001"},
+		{ctx, "fd00::a:1", "fd00::a:1", "7000", "7100"},
+		{ctx, "[fd00::a:1]:7000", "fd00::a:1", "7000", "7100"},
+	}
+	for _, test := range tests {
+		if host, port, cport := ResolveNodeAddress(test.ctx, test.addr); host != test.host || port != test.port || cport != test.cport {
+			t.Errorf("ResolveNodeAddress(%q) = %q, %q, %q, expected %q, %q, %q", test.addr, host, port, cport, test.host, test.port, test.cport)
+		}
+	}
+}
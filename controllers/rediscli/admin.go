@@ -0,0 +1,39 @@
+package rediscli
+
+import (
+	"context"
+	"time"
+)
+
+// RedisAdmin is the set of Redis administration commands used by the operator.
+// It is implemented by RedisCLI and by the in-memory cluster of the fake package.
+// Nodes are addressed by IP, using the ports set with WithNodePorts, or by host:port.
+type RedisAdmin interface {
+	ClusterCreate(ctx context.Context, leaderIPs []string) (string, error)
+	ClusterCheck(ctx context.Context, nodeIP string) (string, error)
+	AddFollower(ctx context.Context, newNodeIP string, nodeIP string, leaderID string) (string, error)
+	DelNode(ctx context.Context, nodeIP string, nodeID string) (string, error)
+	ClusterInfo(ctx context.Context, nodeIP string) (*RedisClusterInfo, error)
+	Info(ctx context.Context, nodeIP string) (*RedisInfo, error)
+	Ping(ctx context.Context, nodeIP string, message ...string) (string, error)
+	ClusterNodes(ctx context.Context, nodeIP string) (*RedisClusterNodes, error)
+	ClusterSlots(ctx context.Context, nodeIP string) (*RedisClusterSlots, error)
+	ClusterShards(ctx context.Context, nodeIP string) (*RedisClusterShards, error)
+	MyClusterID(ctx context.Context, nodeIP string) (string, error)
+	ClusterForget(ctx context.Context, nodeIP string, forgetNodeID string) (string, error)
+	ClusterReplicas(ctx context.Context, nodeIP string, leaderNodeID string) (*RedisClusterNodes, error)
+	ClusterFailover(ctx context.Context, nodeIP string, opt ...string) (string, error)
+	ClusterMeet(ctx context.Context, nodeIP string, newNodeIP string, newNodePort string, newNodeBusPort ...string) (string, error)
+	ClusterReset(ctx context.Context, nodeIP string, opt ...string) (string, error)
+	Flushall(ctx context.Context, nodeIP string, opt ...string) (string, error)
+	ClusterReplicate(ctx context.Context, nodeIP string, leaderID string) (string, error)
+	ConfigSet(ctx context.Context, nodeIP string, parameter string, value string) (string, error)
+	ClusterAddSlots(ctx context.Context, nodeIP string, slots ...int) (string, error)
+	ClusterSetSlot(ctx context.Context, nodeIP string, slot int, subcommand string, nodeID ...string) (string, error)
+	ClusterCountKeysInSlot(ctx context.Context, nodeIP string, slot int) (int64, error)
+	ClusterCountKeysInSlots(ctx context.Context, nodeIP string, slots ...int) (map[int]int64, error)
+	ClusterGetKeysInSlot(ctx context.Context, nodeIP string, slot int, count int) ([]string, error)
+	Migrate(ctx context.Context, nodeIP string, targetIP string, keys []string, timeout time.Duration) (string, error)
+}
+
+var _ RedisAdmin = &RedisCLI{}
@@ -0,0 +1,472 @@
+package rediscli
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RedisClusterNodes command: https://redis.io/commands/cluster-nodes
+type RedisClusterNodes []RedisClusterNode
+
+// RedisClusterNode is a line of the CLUSTER NODES output:
+// <id> <ip:port@cport[,hostname]> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> <slot> ... <slot>
+type RedisClusterNode struct {
+	ID string
+	// Addr is the address as reported by Redis, Address is its parsed form
+	Addr    string
+	Address NodeAddress
+	Flags   NodeFlags
+	// Leader is the ID of the master replicated by the node, empty for masters
+	Leader      string
+	PingSent    int64
+	PongRecv    int64
+	ConfigEpoch int64
+	LinkState   string
+	// Slots are the slot ranges served by the node, OpenSlots the slots being
+	// migrated to or imported from other nodes
+	Slots     SlotRanges
+	OpenSlots []OpenSlot
+}
+
+// NodeFlags are the flags of a node: https://redis.io/commands/cluster-nodes
+type NodeFlags struct {
+	Myself     bool
+	Master     bool
+	Slave      bool
+	PFail      bool // "fail?": the node is unreachable for the node that produced the output
+	Fail       bool // the failure was confirmed by the majority of the masters
+	Handshake  bool
+	NoAddr     bool
+	NoFailover bool
+}
+
+// ParseNodeFlags parses a comma separated list of flags; unknown flags are ignored
+func ParseNodeFlags(flags string) NodeFlags {
+	var nodeFlags NodeFlags
+	for _, flag := range strings.Split(flags, ",") {
+		switch flag {
+		case "myself":
+			nodeFlags.Myself = true
+		case "master":
+			nodeFlags.Master = true
+		case "slave":
+			nodeFlags.Slave = true
+		case "fail?":
+			nodeFlags.PFail = true
+		case "fail":
+			nodeFlags.Fail = true
+		case "handshake":
+			nodeFlags.Handshake = true
+		case "noaddr":
+			nodeFlags.NoAddr = true
+		case "nofailover":
+			nodeFlags.NoFailover = true
+		}
+	}
+	return nodeFlags
+}
+
+func (f NodeFlags) String() string {
+	var flags []string
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{
+		{f.Myself, "myself"},
+		{f.Master, "master"},
+		{f.Slave, "slave"},
+		{f.PFail, "fail?"},
+		{f.Fail, "fail"},
+		{f.Handshake, "handshake"},
+		{f.NoAddr, "noaddr"},
+		{f.NoFailover, "nofailover"},
+	} {
+		if flag.set {
+			flags = append(flags, flag.name)
+		}
+	}
+	if len(flags) == 0 {
+		return "noflags"
+	}
+	return strings.Join(flags, ",")
+}
+
+// SlotRange is an inclusive range of hash slots
+type SlotRange struct {
+	First int
+	Last  int
+}
+
+func (s SlotRange) String() string {
+	if s.First == s.Last {
+		return strconv.Itoa(s.First)
+	}
+	return strconv.Itoa(s.First) + "-" + strconv.Itoa(s.Last)
+}
+
+// Contains returns true if the slot is in the range
+func (s SlotRange) Contains(slot int) bool {
+	return slot >= s.First && slot <= s.Last
+}
+
+// Count returns the number of slots in the range
+func (s SlotRange) Count() int {
+	return s.Last - s.First + 1
+}
+
+// SlotRanges is a list of slot ranges, as served by a node
+type SlotRanges []SlotRange
+
+// String formats the ranges like CLUSTER NODES does, separated by commas
+func (s SlotRanges) String() string {
+	ranges := make([]string, len(s))
+	for i, slotRange := range s {
+		ranges[i] = slotRange.String()
+	}
+	return strings.Join(ranges, ",")
+}
+
+// Contains returns true if the slot is in one of the ranges
+func (s SlotRanges) Contains(slot int) bool {
+	for _, slotRange := range s {
+		if slotRange.Contains(slot) {
+			return true
+		}
+	}
+	return false
+}
+
+// Count returns the number of slots in the ranges
+func (s SlotRanges) Count() int {
+	count := 0
+	for _, slotRange := range s {
+		count += slotRange.Count()
+	}
+	return count
+}
+
+// ParseSlotRange parses a slot or a range of slots (first-last)
+func ParseSlotRange(slots string) (SlotRange, bool) {
+	bounds := strings.SplitN(slots, "-", 2)
+	first, err := strconv.Atoi(bounds[0])
+	if err != nil || first < 0 || first >= ClusterSlotCount {
+		return SlotRange{}, false
+	}
+	last := first
+	if len(bounds) == 2 {
+		if last, err = strconv.Atoi(bounds[1]); err != nil || last < first || last >= ClusterSlotCount {
+			return SlotRange{}, false
+		}
+	}
+	return SlotRange{First: first, Last: last}, true
+}
+
+// ParseSlotRanges parses slot ranges separated by commas, as formatted by SlotRanges.String
+func ParseSlotRanges(slots string) (SlotRanges, bool) {
+	var ranges SlotRanges
+	if slots == "" {
+		return ranges, true
+	}
+	for _, field := range strings.Split(slots, ",") {
+		slotRange, ok := ParseSlotRange(field)
+		if !ok {
+			return nil, false
+		}
+		ranges = append(ranges, slotRange)
+	}
+	return ranges, true
+}
+
+// SlotState tells if an open slot is being migrated or imported
+type SlotState string
+
+const (
+	SlotMigrating SlotState = "migrating"
+	SlotImporting SlotState = "importing"
+)
+
+// OpenSlot is a slot in the middle of a migration: [slot->-target] on the node
+// migrating it and [slot-<-source] on the node importing it
+type OpenSlot struct {
+	Slot  int
+	State SlotState
+	// NodeID is the target node of a migrating slot, the source node of an importing slot
+	NodeID string
+}
+
+// Parses an open slot entry including the square brackets
+func parseOpenSlot(entry string) (OpenSlot, bool) {
+	entry = strings.TrimSuffix(strings.TrimPrefix(entry, "["), "]")
+	for separator, state := range map[string]SlotState{"->-": SlotMigrating, "-<-": SlotImporting} {
+		parts := strings.SplitN(entry, separator, 2)
+		if len(parts) != 2 {
+			continue
+		}
+		slot, err := strconv.Atoi(parts[0])
+		if err != nil || slot < 0 || slot >= ClusterSlotCount {
+			return OpenSlot{}, false
+		}
+		return OpenSlot{Slot: slot, State: state, NodeID: parts[1]}, true
+	}
+	return OpenSlot{}, false
+}
+
+// NewRedisClusterNodes is a constructor for RedisClusterNodes. It accepts the
+// output of CLUSTER NODES and of CLUSTER REPLICAS; lines with less than 8 fields
+// are skipped, malformed numbers and slots are ignored.
+func NewRedisClusterNodes(rawData string) *RedisClusterNodes {
+	nodes := RedisClusterNodes{}
+	nodeLines := strings.Split(rawData, "\n")
+	for _, nodeLine := range nodeLines {
+		nodeInfo := strings.Fields(nodeLine)
+		if len(nodeInfo) != 0 && strings.Contains(nodeInfo[0], ")") { // special case for CLUSTER REPLICAS output
+			nodeInfo = nodeInfo[1:]
+		}
+		if len(nodeInfo) < 8 {
+			continue
+		}
+		node := RedisClusterNode{
+			ID:        nodeInfo[0],
+			Addr:      nodeInfo[1],
+			Address:   ParseNodeAddress(nodeInfo[1]),
+			Flags:     ParseNodeFlags(nodeInfo[2]),
+			LinkState: nodeInfo[7],
+		}
+		if nodeInfo[3] != "-" {
+			node.Leader = nodeInfo[3]
+		}
+		node.PingSent, _ = strconv.ParseInt(nodeInfo[4], 10, 64)
+		node.PongRecv, _ = strconv.ParseInt(nodeInfo[5], 10, 64)
+		node.ConfigEpoch, _ = strconv.ParseInt(nodeInfo[6], 10, 64)
+		for _, slots := range nodeInfo[8:] {
+			if strings.HasPrefix(slots, "[") {
+				if openSlot, ok := parseOpenSlot(slots); ok {
+					node.OpenSlots = append(node.OpenSlots, openSlot)
+				}
+			} else if slotRange, ok := ParseSlotRange(slots); ok {
+				node.Slots = append(node.Slots, slotRange)
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return &nodes
+}
+
+// IsFailing method return true when the current redis node is in failing state
+// and needs to be forgotten by the cluster
+func (r *RedisClusterNode) IsFailing() bool {
+	return r.Flags.Fail
+}
+
+// IsMaster returns true if the node is flagged as master
+func (r *RedisClusterNode) IsMaster() bool {
+	return r.Flags.Master
+}
+
+// HasSlots returns true if the node is serving at least one slot
+func (r *RedisClusterNode) HasSlots() bool {
+	return len(r.Slots) != 0
+}
+
+// OwnsSlot returns true if the node is serving the slot
+func (r *RedisClusterNode) OwnsSlot(slot int) bool {
+	return r.Slots.Contains(slot)
+}
+
+// Returns the hostname announced by the node, present in the address after the
+// bus port since Redis 7 (ip:port@cport,hostname), or the empty string
+func (r *RedisClusterNode) Hostname() string {
+	return r.Address.Hostname
+}
+
+// Returns the IP and the client port from the node address (ip:port@cport)
+func (r *RedisClusterNode) IPAndPort() (string, string) {
+	return r.Address.IP, r.Address.Port
+}
+
+// Returns the cluster bus port from the node address (ip:port@cport)
+func (r *RedisClusterNode) BusPort() string {
+	return r.Address.BusPort
+}
+
+// Returns the node address in the host:port format, to be used as the address
+// of commands sent to the node
+func (r *RedisClusterNode) HostPort() string {
+	if r.Address.IP == "" {
+		return ""
+	}
+	return r.Address.HostPort()
+}
+
+// Returns the entry of the node that produced the CLUSTER NODES output or nil
+// if it is missing
+func (r *RedisClusterNodes) Myself() *RedisClusterNode {
+	for i := range *r {
+		if (*r)[i].Flags.Myself {
+			return &(*r)[i]
+		}
+	}
+	return nil
+}
+
+// Returns the node with the given ID or nil if it is not in the list
+func (r *RedisClusterNodes) GetNodeByID(id string) *RedisClusterNode {
+	for i := range *r {
+		if (*r)[i].ID == id {
+			return &(*r)[i]
+		}
+	}
+	return nil
+}
+
+// Returns the IP and port for a given Redis ID or empty strings if ID not found
+func (r *RedisClusterNodes) GetIPForID(id string) (string, string) {
+	if node := r.GetNodeByID(id); node != nil {
+		return node.IPAndPort()
+	}
+	return "", ""
+}
+
+// Returns the Redis node ID for a specified IP or empty string if IP not found
+// Supports the IP and IP:port format
+func (r *RedisClusterNodes) GetIDForIP(ip string) string {
+	host, _ := SplitHostPort(ip)
+	for _, info := range *r {
+		if nodeIP, _ := info.IPAndPort(); nodeIP == host {
+			return info.ID
+		}
+	}
+	return ""
+}
+
+// Masters returns the nodes flagged as master
+func (r *RedisClusterNodes) Masters() []*RedisClusterNode {
+	var masters []*RedisClusterNode
+	for i := range *r {
+		if (*r)[i].IsMaster() {
+			masters = append(masters, &(*r)[i])
+		}
+	}
+	return masters
+}
+
+// Replicas returns the nodes replicating the master with the given ID
+func (r *RedisClusterNodes) Replicas(masterID string) []*RedisClusterNode {
+	var replicas []*RedisClusterNode
+	for i := range *r {
+		if (*r)[i].Leader == masterID && masterID != "" {
+			replicas = append(replicas, &(*r)[i])
+		}
+	}
+	return replicas
+}
+
+// SlotOwner returns the master serving the slot or nil if the slot is not assigned
+func (r *RedisClusterNodes) SlotOwner(slot int) *RedisClusterNode {
+	for i := range *r {
+		if (*r)[i].IsMaster() && (*r)[i].OwnsSlot(slot) {
+			return &(*r)[i]
+		}
+	}
+	return nil
+}
+
+// Returns for every slot whether it is served by a master accepted by the filter
+func (r *RedisClusterNodes) coverage(filter func(*RedisClusterNode) bool) [ClusterSlotCount]bool {
+	var covered [ClusterSlotCount]bool
+	for i := range *r {
+		node := &(*r)[i]
+		if !node.IsMaster() || !filter(node) {
+			continue
+		}
+		for _, slotRange := range node.Slots {
+			for slot := slotRange.First; slot <= slotRange.Last; slot++ {
+				covered[slot] = true
+			}
+		}
+	}
+	return covered
+}
+
+// AssignedSlots returns the number of slots assigned to a master, failing or not
+func (r *RedisClusterNodes) AssignedSlots() int {
+	return countSlots(r.coverage(func(*RedisClusterNode) bool { return true }))
+}
+
+// CoveredSlots returns the number of slots served by masters that are not failing
+func (r *RedisClusterNodes) CoveredSlots() int {
+	return countSlots(r.coverage(isServing))
+}
+
+// UncoveredSlots returns the slot ranges that are not served by a master that
+// is not failing
+func (r *RedisClusterNodes) UncoveredSlots() SlotRanges {
+	return missingSlots(r.coverage(isServing))
+}
+
+// UnassignedSlots returns the slot ranges that are not assigned to any master
+func (r *RedisClusterNodes) UnassignedSlots() SlotRanges {
+	return missingSlots(r.coverage(func(*RedisClusterNode) bool { return true }))
+}
+
+// IsFullyCovered returns true if all the slots are served by masters that are not failing
+func (r *RedisClusterNodes) IsFullyCovered() bool {
+	return r.CoveredSlots() == ClusterSlotCount
+}
+
+// OpenSlots returns the open slots of all the nodes indexed by the ID of the
+// node reporting them
+func (r *RedisClusterNodes) OpenSlots() map[string][]OpenSlot {
+	openSlots := make(map[string][]OpenSlot)
+	for _, node := range *r {
+		if len(node.OpenSlots) != 0 {
+			openSlots[node.ID] = node.OpenSlots
+		}
+	}
+	return openSlots
+}
+
+// SlotsByNode returns the slot ranges served by every master, indexed by node ID
+// and sorted
+func (r *RedisClusterNodes) SlotsByNode() map[string]SlotRanges {
+	slots := make(map[string]SlotRanges)
+	for _, node := range *r {
+		if node.IsMaster() && node.HasSlots() {
+			ranges := append(SlotRanges(nil), node.Slots...)
+			sort.Slice(ranges, func(i, j int) bool { return ranges[i].First < ranges[j].First })
+			slots[node.ID] = ranges
+		}
+	}
+	return slots
+}
+
+func isServing(node *RedisClusterNode) bool {
+	return !node.IsFailing()
+}
+
+// Returns the ranges of the slots that are not covered
+func missingSlots(covered [ClusterSlotCount]bool) SlotRanges {
+	var missing SlotRanges
+	for slot := 0; slot < ClusterSlotCount; slot++ {
+		if covered[slot] {
+			continue
+		}
+		if last := len(missing) - 1; last >= 0 && missing[last].Last == slot-1 {
+			missing[last].Last = slot
+		} else {
+			missing = append(missing, SlotRange{First: slot, Last: slot})
+		}
+	}
+	return missing
+}
+
+func countSlots(slots [ClusterSlotCount]bool) int {
+	count := 0
+	for _, covered := range slots {
+		if covered {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,146 @@
+package rediscli
+
+import (
+	"reflect"
+	"testing"
+)
+
+const clusterNodesOutput = `07c37dfeb235213a872192d90877d0cd55635b91 10.0.0.4:6379@16379 slave e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 0 1426238317239 4 connected
+67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1 10.0.0.2:6379@16379,redis-node-1.default.svc master - 0 1426238316232 2 connected 5461-10922 [5461->-292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f]
+292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f 10.0.0.3:6379@16379 master - 0 1426238318243 3 connected 10923-16383 [5461-<-67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1]
+6ec23923021cf3ffec47632106199cb7f496ce01 10.0.0.5:6379@16379 slave,fail 67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1 1426238316232 1426238315232 5 disconnected
+824fe116063bc5fcf9f4ffd895bc17aee7731ac3 :0@0 handshake,noaddr - 0 0 0 disconnected
+e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 10.0.0.1:6379@16379 myself,master - 0 0 1 connected 0-5460
+`
+
+func TestNewRedisClusterNodes(t *testing.T) {
+	nodes := NewRedisClusterNodes(clusterNodesOutput)
+	if len(*nodes) != 6 {
+		t.Fatalf("Expected 6 nodes, found %d", len(*nodes))
+	}
+
+	myself := nodes.Myself()
+	if myself == nil || myself.ID != "e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca" {
+		t.Fatalf("Unexpected myself node %+v", myself)
+	}
+	if !myself.Flags.Master || myself.Flags.Slave || myself.Leader != "" || myself.ConfigEpoch != 1 {
+		t.Errorf("Unexpected myself node %+v", myself)
+	}
+	if !reflect.DeepEqual(myself.Slots, SlotRanges{{0, 5460}}) || myself.Slots.Count() != 5461 {
+		t.Errorf("Unexpected slots %v", myself.Slots)
+	}
+
+	migrating := nodes.GetNodeByID("67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1")
+	if migrating.Hostname() != "redis-node-1.default.svc" || migrating.HostPort() != "10.0.0.2:6379" || migrating.BusPort() != "16379" {
+		t.Errorf("Unexpected address %+v", migrating.Address)
+	}
+	expected := []OpenSlot{{Slot: 5461, State: SlotMigrating, NodeID: "292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f"}}
+	if !reflect.DeepEqual(migrating.OpenSlots, expected) || migrating.Slots.String() != "5461-10922" {
+		t.Errorf("Unexpected slots %v and open slots %+v", migrating.Slots, migrating.OpenSlots)
+	}
+	importing := nodes.GetNodeByID("292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f")
+	if len(importing.OpenSlots) != 1 || importing.OpenSlots[0].State != SlotImporting {
+		t.Errorf("Unexpected open slots %+v", importing.OpenSlots)
+	}
+	if openSlots := nodes.OpenSlots(); len(openSlots) != 2 {
+		t.Errorf("Expected open slots on 2 nodes, found %v", openSlots)
+	}
+
+	failed := nodes.GetNodeByID("6ec23923021cf3ffec47632106199cb7f496ce01")
+	if !failed.IsFailing() || failed.IsMaster() || failed.Leader != migrating.ID || failed.PingSent != 1426238316232 || failed.LinkState != "disconnected" {
+		t.Errorf("Unexpected failed node %+v", failed)
+	}
+	handshake := nodes.GetNodeByID("824fe116063bc5fcf9f4ffd895bc17aee7731ac3")
+	if !handshake.Flags.Handshake || !handshake.Flags.NoAddr || handshake.HostPort() != "" {
+		t.Errorf("Unexpected handshake node %+v", handshake)
+	}
+
+	if masters := nodes.Masters(); len(masters) != 3 {
+		t.Errorf("Expected 3 masters, found %d", len(masters))
+	}
+	if replicas := nodes.Replicas(myself.ID); len(replicas) != 1 || replicas[0].ID != "07c37dfeb235213a872192d90877d0cd55635b91" {
+		t.Errorf("Unexpected replicas %+v", replicas)
+	}
+	if owner := nodes.SlotOwner(10923); owner == nil || owner.ID != importing.ID {
+		t.Errorf("Unexpected owner of slot 10923: %+v", owner)
+	}
+	if !nodes.IsFullyCovered() || nodes.AssignedSlots() != 16384 {
+		t.Errorf("Expected all the slots to be covered, found %d", nodes.CoveredSlots())
+	}
+}
+
+func TestClusterNodesCoverage(t *testing.T) {
+	nodes := NewRedisClusterNodes(`a 10.0.0.1:6379@16379 myself,master - 0 0 1 connected 0-5460
+b 10.0.0.2:6379@16379 master,fail - 0 0 2 disconnected 5461-10922
+c 10.0.0.3:6379@16379 master - 0 0 3 connected 10923-16382`)
+
+	if covered := nodes.CoveredSlots(); covered != 16384-5462-1 {
+		t.Errorf("Unexpected number of covered slots %d", covered)
+	}
+	if assigned := nodes.AssignedSlots(); assigned != 16383 {
+		t.Errorf("Unexpected number of assigned slots %d", assigned)
+	}
+	expected := SlotRanges{{5461, 10922}, {16383, 16383}}
+	if uncovered := nodes.UncoveredSlots(); !reflect.DeepEqual(uncovered, expected) {
+		t.Errorf("Unexpected uncovered slots %v", uncovered)
+	}
+	if unassigned := nodes.UnassignedSlots(); !reflect.DeepEqual(unassigned, SlotRanges{{16383, 16383}}) {
+		t.Errorf("Unexpected unassigned slots %v", unassigned)
+	}
+	if slots := nodes.SlotsByNode(); len(slots) != 3 || slots["c"].String() != "10923-16382" {
+		t.Errorf("Unexpected slots by node %v", slots)
+	}
+}
+
+func TestNewRedisClusterNodesReplicas(t *testing.T) {
+	nodes := NewRedisClusterNodes("1) 07c37dfeb235213a872192d90877d0cd55635b91 10.0.0.4:6379@16379 slave e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 0 1426238317239 4 connected")
+	if len(*nodes) != 1 || (*nodes)[0].ID != "07c37dfeb235213a872192d90877d0cd55635b91" || (*nodes)[0].Leader != "e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca" {
+		t.Errorf("Unexpected replicas %+v", nodes)
+	}
+}
+
+func TestParseSlotRange(t *testing.T) {
+	tests := []struct {
+		slots    string
+		expected SlotRange
+		ok       bool
+	}{
+		{"0", SlotRange{0, 0}, true},
+		{"0-16383", SlotRange{0, 16383}, true},
+		{"10-5", SlotRange{}, false},
+		{"16384", SlotRange{}, false},
+		{"-1", SlotRange{}, false},
+		{"a-b", SlotRange{}, false},
+	}
+	for _, test := range tests {
+		if slotRange, ok := ParseSlotRange(test.slots); slotRange != test.expected || ok != test.ok {
+			t.Errorf("ParseSlotRange(%q) = %v, %t, expected %v, %t", test.slots, slotRange, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func TestParseSlotRanges(t *testing.T) {
+	ranges, ok := ParseSlotRanges("0-99,200,300-310")
+	if !ok || ranges.Count() != 112 || ranges.String() != "0-99,200,300-310" {
+		t.Errorf("Unexpected slot ranges %v, %t", ranges, ok)
+	}
+	if ranges, ok := ParseSlotRanges(""); !ok || len(ranges) != 0 {
+		t.Errorf("Unexpected slot ranges %v, %t for an empty string", ranges, ok)
+	}
+	if _, ok := ParseSlotRanges("0-99,x"); ok {
+		t.Errorf("Malformed slot ranges were parsed")
+	}
+}
+
+func TestParseNodeFlags(t *testing.T) {
+	flags := ParseNodeFlags("myself,slave,fail?,nofailover")
+	if !flags.Myself || !flags.Slave || !flags.PFail || flags.Fail || !flags.NoFailover {
+		t.Errorf("Unexpected flags %+v", flags)
+	}
+	if flags.String() != "myself,slave,fail?,nofailover" {
+		t.Errorf("Unexpected flags string %s", flags)
+	}
+	if flags := ParseNodeFlags("noflags"); flags != (NodeFlags{}) || flags.String() != "noflags" {
+		t.Errorf("Unexpected flags %+v", flags)
+	}
+}
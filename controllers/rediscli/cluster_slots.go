@@ -0,0 +1,277 @@
+package rediscli
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// RedisClusterSlots command: https://redis.io/commands/cluster-slots
+type RedisClusterSlots []ClusterSlot
+
+// ClusterSlot is an entry of the CLUSTER SLOTS reply: a slot range with the
+// master serving it and its replicas
+type ClusterSlot struct {
+	Slots    SlotRange
+	Master   SlotNode
+	Replicas []SlotNode
+}
+
+// SlotNode is a node serving a slot range; the address has no bus port
+type SlotNode struct {
+	ID      string
+	Address NodeAddress
+}
+
+// RedisClusterShards command: https://redis.io/commands/cluster-shards
+type RedisClusterShards []ClusterShard
+
+// ClusterShard is an entry of the CLUSTER SHARDS reply: the slot ranges of a
+// shard and all its nodes, master and replicas
+type ClusterShard struct {
+	Slots SlotRanges
+	Nodes []ShardNode
+}
+
+// ShardNode is a node of a shard
+type ShardNode struct {
+	ID      string
+	Address NodeAddress
+	// Role is "master" or "replica"
+	Role              string
+	ReplicationOffset int64
+	// Health is "online", "failed" or "loading"
+	Health string
+}
+
+const (
+	ShardRoleMaster  = "master"
+	ShardRoleReplica = "replica"
+
+	ShardHealthOnline  = "online"
+	ShardHealthFailed  = "failed"
+	ShardHealthLoading = "loading"
+)
+
+// Owner returns the entry of the slot or nil if the slot is not assigned
+func (r *RedisClusterSlots) Owner(slot int) *ClusterSlot {
+	for i := range *r {
+		if (*r)[i].Slots.Contains(slot) {
+			return &(*r)[i]
+		}
+	}
+	return nil
+}
+
+// Master returns the master of the shard or nil if it has none
+func (s *ClusterShard) Master() *ShardNode {
+	for i := range s.Nodes {
+		if s.Nodes[i].Role == ShardRoleMaster {
+			return &s.Nodes[i]
+		}
+	}
+	return nil
+}
+
+// Replicas returns the replicas of the shard
+func (s *ClusterShard) Replicas() []ShardNode {
+	var replicas []ShardNode
+	for _, node := range s.Nodes {
+		if node.Role == ShardRoleReplica {
+			replicas = append(replicas, node)
+		}
+	}
+	return replicas
+}
+
+// ShardOf returns the shard the node belongs to or nil if the node is unknown
+func (r *RedisClusterShards) ShardOf(nodeID string) *ClusterShard {
+	for i := range *r {
+		for _, node := range (*r)[i].Nodes {
+			if node.ID == nodeID {
+				return &(*r)[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Parses the CLUSTER SLOTS reply:
+// [[first, last, [ip, port, id, [metadata]], [ip, port, id, [metadata]]...]...]
+func parseClusterSlots(reply interface{}) (*RedisClusterSlots, error) {
+	entries, ok := reply.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("unexpected reply type %T", reply)
+	}
+	slots := RedisClusterSlots{}
+	for _, entry := range entries {
+		fields, ok := entry.([]interface{})
+		if !ok || len(fields) < 3 {
+			return nil, errors.Errorf("malformed slots entry %v", entry)
+		}
+		first, firstOK := fields[0].(int64)
+		last, lastOK := fields[1].(int64)
+		if !firstOK || !lastOK || first < 0 || last < first || last >= ClusterSlotCount {
+			return nil, errors.Errorf("malformed slot range %v-%v", fields[0], fields[1])
+		}
+		clusterSlot := ClusterSlot{Slots: SlotRange{First: int(first), Last: int(last)}}
+		for i, field := range fields[2:] {
+			node, err := parseSlotNode(field)
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 {
+				clusterSlot.Master = node
+			} else {
+				clusterSlot.Replicas = append(clusterSlot.Replicas, node)
+			}
+		}
+		slots = append(slots, clusterSlot)
+	}
+	return &slots, nil
+}
+
+// Parses a node of the CLUSTER SLOTS reply: [ip, port, id, [metadata]]; the
+// metadata holds the hostname since Redis 7
+func parseSlotNode(reply interface{}) (SlotNode, error) {
+	fields, ok := reply.([]interface{})
+	if !ok || len(fields) < 2 {
+		return SlotNode{}, errors.Errorf("malformed slots node %v", reply)
+	}
+	ip, _ := fields[0].(string)
+	port, ok := fields[1].(int64)
+	if !ok {
+		return SlotNode{}, errors.Errorf("malformed port of slots node %v", reply)
+	}
+	node := SlotNode{Address: NodeAddress{IP: ip, Port: strconv.FormatInt(port, 10)}}
+	if len(fields) > 2 {
+		node.ID, _ = fields[2].(string)
+	}
+	if len(fields) > 3 {
+		metadata := replyMap(fields[3])
+		node.Address.Hostname, _ = metadata["hostname"].(string)
+	}
+	return node, nil
+}
+
+// Parses the CLUSTER SHARDS reply, a list of maps sent as flat key/value
+// arrays in RESP2: [["slots", [first, last...], "nodes", [[key, value...]...]]...]
+func parseClusterShards(reply interface{}) (*RedisClusterShards, error) {
+	entries, ok := reply.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("unexpected reply type %T", reply)
+	}
+	shards := RedisClusterShards{}
+	for _, entry := range entries {
+		fields := replyMap(entry)
+		if fields == nil {
+			return nil, errors.Errorf("malformed shards entry %v", entry)
+		}
+		var shard ClusterShard
+		bounds, _ := fields["slots"].([]interface{})
+		if len(bounds)%2 != 0 {
+			return nil, errors.Errorf("malformed shard slots %v", bounds)
+		}
+		for i := 0; i < len(bounds); i += 2 {
+			first, firstOK := bounds[i].(int64)
+			last, lastOK := bounds[i+1].(int64)
+			if !firstOK || !lastOK || first < 0 || last < first || last >= ClusterSlotCount {
+				return nil, errors.Errorf("malformed shard slot range %v-%v", bounds[i], bounds[i+1])
+			}
+			shard.Slots = append(shard.Slots, SlotRange{First: int(first), Last: int(last)})
+		}
+		nodes, _ := fields["nodes"].([]interface{})
+		for _, nodeEntry := range nodes {
+			nodeFields := replyMap(nodeEntry)
+			if nodeFields == nil {
+				return nil, errors.Errorf("malformed shard node %v", nodeEntry)
+			}
+			node := ShardNode{}
+			node.ID, _ = nodeFields["id"].(string)
+			node.Address.IP, _ = nodeFields["ip"].(string)
+			node.Address.Hostname, _ = nodeFields["hostname"].(string)
+			if port, ok := nodeFields["port"].(int64); ok {
+				node.Address.Port = strconv.FormatInt(port, 10)
+			} else if port, ok := nodeFields["tls-port"].(int64); ok {
+				node.Address.Port = strconv.FormatInt(port, 10)
+			}
+			node.Role, _ = nodeFields["role"].(string)
+			node.ReplicationOffset, _ = nodeFields["replication-offset"].(int64)
+			node.Health, _ = nodeFields["health"].(string)
+			shard.Nodes = append(shard.Nodes, node)
+		}
+		shards = append(shards, shard)
+	}
+	return &shards, nil
+}
+
+// Returns the map sent as a flat array of keys and values, or nil if the reply
+// is not such an array
+func replyMap(reply interface{}) map[string]interface{} {
+	fields, ok := reply.([]interface{})
+	if !ok || len(fields)%2 != 0 {
+		return nil
+	}
+	values := make(map[string]interface{}, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		key, ok := fields[i].(string)
+		if !ok {
+			return nil
+		}
+		values[key] = fields[i+1]
+	}
+	return values
+}
+
+// ClusterSlots returns the CLUSTER SLOTS view of the nodes, used on Redis
+// versions without the command: failing replicas are left out, like Redis does
+func (r *RedisClusterNodes) ClusterSlots() *RedisClusterSlots {
+	slots := RedisClusterSlots{}
+	for _, master := range r.Masters() {
+		var replicas []SlotNode
+		for _, replica := range r.Replicas(master.ID) {
+			if !replica.IsFailing() {
+				replicas = append(replicas, slotNode(replica))
+			}
+		}
+		for _, slotRange := range master.Slots {
+			slots = append(slots, ClusterSlot{Slots: slotRange, Master: slotNode(master), Replicas: replicas})
+		}
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Slots.First < slots[j].Slots.First })
+	return &slots
+}
+
+// ClusterShards returns the CLUSTER SHARDS view of the nodes, used on Redis
+// versions without the command: every master makes a shard with its replicas.
+// CLUSTER NODES has no replication offsets, they are left to zero.
+func (r *RedisClusterNodes) ClusterShards() *RedisClusterShards {
+	shards := RedisClusterShards{}
+	slotsByNode := r.SlotsByNode()
+	for _, master := range r.Masters() {
+		shard := ClusterShard{Slots: slotsByNode[master.ID], Nodes: []ShardNode{shardNode(master, ShardRoleMaster)}}
+		for _, replica := range r.Replicas(master.ID) {
+			shard.Nodes = append(shard.Nodes, shardNode(replica, ShardRoleReplica))
+		}
+		shards = append(shards, shard)
+	}
+	return &shards
+}
+
+func slotNode(node *RedisClusterNode) SlotNode {
+	return SlotNode{ID: node.ID, Address: NodeAddress{IP: node.Address.IP, Port: node.Address.Port, Hostname: node.Address.Hostname}}
+}
+
+func shardNode(node *RedisClusterNode, role string) ShardNode {
+	health := ShardHealthOnline
+	if node.IsFailing() || node.Flags.PFail {
+		health = ShardHealthFailed
+	}
+	return ShardNode{
+		ID:      node.ID,
+		Address: NodeAddress{IP: node.Address.IP, Port: node.Address.Port, Hostname: node.Address.Hostname},
+		Role:    role,
+		Health:  health,
+	}
+}
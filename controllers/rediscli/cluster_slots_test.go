@@ -0,0 +1,153 @@
+package rediscli
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseClusterSlots(t *testing.T) {
+	reply := []interface{}{
+		[]interface{}{int64(0), int64(5460),
+			[]interface{}{"10.0.0.1", int64(6379), "e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca", []interface{}{"hostname", "redis-node-0"}},
+			[]interface{}{"10.0.0.4", int64(6379), "07c37dfeb235213a872192d90877d0cd55635b91", []interface{}{}},
+		},
+		[]interface{}{int64(5461), int64(10922),
+			[]interface{}{"fd00::a:2", int64(7000), "67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1"},
+		},
+	}
+	slots, err := parseClusterSlots(reply)
+	if err != nil {
+		t.Fatalf("Failed to parse CLUSTER SLOTS: %v", err)
+	}
+	expected := RedisClusterSlots{
+		{
+			Slots:    SlotRange{0, 5460},
+			Master:   SlotNode{ID: "e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca", Address: NodeAddress{IP: "10.0.0.1", Port: "6379", Hostname: "redis-node-0"}},
+			Replicas: []SlotNode{{ID: "07c37dfeb235213a872192d90877d0cd55635b91", Address: NodeAddress{IP: "10.0.0.4", Port: "6379"}}},
+		},
+		{
+			Slots:  SlotRange{5461, 10922},
+			Master: SlotNode{ID: "67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1", Address: NodeAddress{IP: "fd00::a:2", Port: "7000"}},
+		},
+	}
+	if !reflect.DeepEqual(*slots, expected) {
+		t.Errorf("Unexpected slots %+v", *slots)
+	}
+	if owner := slots.Owner(6000); owner == nil || owner.Master.ID != "67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1" {
+		t.Errorf("Unexpected owner of slot 6000 %+v", owner)
+	}
+	if owner := slots.Owner(16000); owner != nil {
+		t.Errorf("Unexpected owner of slot 16000 %+v", owner)
+	}
+
+	for _, malformed := range []interface{}{
+		"OK",
+		[]interface{}{[]interface{}{int64(10), int64(5), []interface{}{"10.0.0.1", int64(6379)}}},
+		[]interface{}{[]interface{}{int64(0), int64(16384), []interface{}{"10.0.0.1", int64(6379)}}},
+		[]interface{}{[]interface{}{int64(0), int64(10), []interface{}{"10.0.0.1", "6379"}}},
+	} {
+		if _, err := parseClusterSlots(malformed); err == nil {
+			t.Errorf("Expected an error for %v", malformed)
+		}
+	}
+}
+
+func TestParseClusterShards(t *testing.T) {
+	reply := []interface{}{
+		[]interface{}{
+			"slots", []interface{}{int64(0), int64(5460), int64(10923), int64(10923)},
+			"nodes", []interface{}{
+				[]interface{}{"id", "e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca", "port", int64(6379), "ip", "10.0.0.1", "endpoint", "10.0.0.1",
+					"hostname", "redis-node-0", "role", "master", "replication-offset", int64(72156), "health", "online"},
+				[]interface{}{"id", "07c37dfeb235213a872192d90877d0cd55635b91", "tls-port", int64(6380), "ip", "10.0.0.4", "endpoint", "10.0.0.4",
+					"role", "replica", "replication-offset", int64(72150), "health", "loading"},
+			},
+		},
+		[]interface{}{"slots", []interface{}{}, "nodes", []interface{}{
+			[]interface{}{"id", "824fe116063bc5fcf9f4ffd895bc17aee7731ac3", "port", int64(6379), "ip", "10.0.0.6", "role", "master", "health", "online"},
+		}},
+	}
+	shards, err := parseClusterShards(reply)
+	if err != nil {
+		t.Fatalf("Failed to parse CLUSTER SHARDS: %v", err)
+	}
+	if len(*shards) != 2 {
+		t.Fatalf("Expected 2 shards, found %d", len(*shards))
+	}
+	shard := (*shards)[0]
+	if shard.Slots.String() != "0-5460,10923" {
+		t.Errorf("Unexpected shard slots %v", shard.Slots)
+	}
+	expectedMaster := ShardNode{
+		ID:                "e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca",
+		Address:           NodeAddress{IP: "10.0.0.1", Port: "6379", Hostname: "redis-node-0"},
+		Role:              ShardRoleMaster,
+		ReplicationOffset: 72156,
+		Health:            ShardHealthOnline,
+	}
+	if master := shard.Master(); master == nil || *master != expectedMaster {
+		t.Errorf("Unexpected master %+v", master)
+	}
+	replicas := shard.Replicas()
+	if len(replicas) != 1 || replicas[0].Address.Port != "6380" || replicas[0].Health != ShardHealthLoading || replicas[0].ReplicationOffset != 72150 {
+		t.Errorf("Unexpected replicas %+v", replicas)
+	}
+	if empty := shards.ShardOf("824fe116063bc5fcf9f4ffd895bc17aee7731ac3"); empty == nil || len(empty.Slots) != 0 {
+		t.Errorf("Unexpected shard of the empty master %+v", empty)
+	}
+
+	for _, malformed := range []interface{}{
+		"OK",
+		[]interface{}{[]interface{}{"slots"}},
+		[]interface{}{[]interface{}{"slots", []interface{}{int64(0)}, "nodes", []interface{}{}}},
+		[]interface{}{[]interface{}{"slots", []interface{}{}, "nodes", []interface{}{[]interface{}{"id"}}}},
+	} {
+		if _, err := parseClusterShards(malformed); err == nil {
+			t.Errorf("Expected an error for %v", malformed)
+		}
+	}
+}
+
+func TestClusterNodesFallback(t *testing.T) {
+	nodes := NewRedisClusterNodes(clusterNodesOutput)
+
+	slots := nodes.ClusterSlots()
+	if len(*slots) != 3 {
+		t.Fatalf("Expected 3 slot ranges, found %+v", *slots)
+	}
+	first := (*slots)[0]
+	if first.Slots != (SlotRange{0, 5460}) || first.Master.ID != "e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca" || len(first.Replicas) != 1 {
+		t.Errorf("Unexpected first slot range %+v", first)
+	}
+	// the failing replica is not listed
+	if second := (*slots)[1]; second.Master.Address.Hostname != "redis-node-1.default.svc" || len(second.Replicas) != 0 {
+		t.Errorf("Unexpected second slot range %+v", second)
+	}
+
+	shards := nodes.ClusterShards()
+	if len(*shards) != 3 {
+		t.Fatalf("Expected 3 shards, found %+v", *shards)
+	}
+	shard := shards.ShardOf("6ec23923021cf3ffec47632106199cb7f496ce01")
+	if shard == nil || shard.Master().ID != "67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1" || shard.Slots.String() != "5461-10922" {
+		t.Fatalf("Unexpected shard %+v", shard)
+	}
+	if replicas := shard.Replicas(); len(replicas) != 1 || replicas[0].Health != ShardHealthFailed {
+		t.Errorf("Unexpected replicas %+v", replicas)
+	}
+}
+
+func TestIsUnknownCommand(t *testing.T) {
+	for _, reply := range []string{
+		"ERR unknown subcommand 'shards'. Try CLUSTER HELP.",
+		"ERR Unknown subcommand or wrong number of arguments for 'shards'. Try CLUSTER HELP",
+		"ERR unknown command `cluster`, with args beginning with: `shards`, ",
+	} {
+		if !IsUnknownCommand(NewRedisError("10.0.0.1:6379", "CLUSTER SHARDS", reply)) {
+			t.Errorf("Expected %q to be an unknown command error", reply)
+		}
+	}
+	if IsUnknownCommand(NewRedisError("10.0.0.1:6379", "CLUSTER SHARDS", "ERR This instance has cluster support disabled")) {
+		t.Errorf("Unexpected unknown command error")
+	}
+}
@@ -1,48 +1,171 @@
 /* Error strings reference:
-https://github.com/redis/redis/blob/unstable/src/redis-cli.c
+https://github.com/redis/redis/blob/unstable/src/server.c
 https://github.com/redis/redis/blob/unstable/src/cluster.c
 */
 
 package rediscli
 
 import (
+	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
 )
 
-// https://github.com/redis/redis/blob/899c85ae67631f4f9f866a254ac5e149b86e5529/src/server.c#L2493
-var SHARED_ERR_STRINGS = map[string]string{
-	"wrongtype":     "WRONGTYPE Operation against a key holding the wrong kind of value",
-	"nokeyerr":      "ERR no such key",
-	"syntaxerr":     "ERR syntax error",
-	"srcdsterr":     "ERR source and destination objects are the same",
-	"indexrangeerr": "ERR index out of range",
-	"noscript":      "NOSCRIPT No matching script. Please use EVAL",
-	"loading":       "LOADING Redis is loading the dataset in memory",
-	"busy":          "BUSY Redis is busy running a script. You can only call SCRIPT KILL or SHUTDOWN NOSAVE",
-	"masterdown":    "MASTERDOWN Link with MASTER is down and replica-serve-stale-data is set to 'no'",
-	"misconf":       "MISCONF Redis is configured to save RDB snapshots, but it is currently not able to persist on disk",
-	"readonly":      "READONLY You can't write against a read only replica",
-	"noauth":        "NOAUTH Authentication required",
-	"oom":           "OOM command not allowed when used memory > 'maxmemory'",
-	"execabort":     "EXECABORT Transaction discarded because of previous errors",
-	"noreplicas":    "NOREPLICAS Not enough good replicas to write",
-	"busykey":       "BUSYKEY Target key name already exists",
-}
-
-var ERR_STRINGS = map[string]string{
-	"idnotdef":        "No such node ID",
-	"nodenotmaster":   "The specified node is not a master", // https://github.com/redis/redis/blob/29ac9aea5de2f395960834b262b3d94f9efedbd8/src/cluster.c#L4858
-	"unknown":         "Unknown node",                       // https://github.com/redis/redis/blob/29ac9aea5de2f395960834b262b3d94f9efedbd8/src/cluster.c#L4601
-	"failoverreplica": "ERR You should send CLUSTER FAILOVER to a replica",
-}
-
-func errorStringPrefix(err error, keywords string) bool {
-	if err == nil {
-		return false
+// RedisError is an error reply sent by a node. The reply kinds handled by the
+// operator have their own types, all of them unwrapping to a *RedisError, so
+// callers can check them with errors.As:
+//
+//	var moved *rediscli.MovedError
+//	if errors.As(err, &moved) { ... }
+type RedisError struct {
+	// Addr is the address of the node and Command the name of the command
+	// that got the reply, like "CLUSTER FORGET"
+	Addr    string
+	Command string
+	Reply   ReplyError
+}
+
+func (e *RedisError) Error() string {
+	return string(e.Reply)
+}
+
+func (e *RedisError) Unwrap() error {
+	return e.Reply
+}
+
+// MovedError is a MOVED redirection: the slot is served by another node
+type MovedError struct {
+	RedisError
+	Slot int
+	// Target is the host:port address of the node serving the slot
+	Target string
+}
+
+func (e *MovedError) Unwrap() error { return &e.RedisError }
+
+// AskError is an ASK redirection: the slot is being migrated to another node
+type AskError struct {
+	RedisError
+	Slot int
+	// Target is the host:port address of the node importing the slot
+	Target string
+}
+
+func (e *AskError) Unwrap() error { return &e.RedisError }
+
+// ClusterDownError is returned while the cluster can't serve the slot
+type ClusterDownError struct{ RedisError }
+
+func (e *ClusterDownError) Unwrap() error { return &e.RedisError }
+
+// TryAgainError is returned when the keys of a multi-key command are split by
+// an ongoing slot migration
+type TryAgainError struct{ RedisError }
+
+func (e *TryAgainError) Unwrap() error { return &e.RedisError }
+
+// LoadingError is returned while the node loads its dataset in memory
+type LoadingError struct{ RedisError }
+
+func (e *LoadingError) Unwrap() error { return &e.RedisError }
+
+// NoAuthError is returned when the node requires authentication
+type NoAuthError struct{ RedisError }
+
+func (e *NoAuthError) Unwrap() error { return &e.RedisError }
+
+// ReadOnlyError is returned when a write command is sent to a replica
+type ReadOnlyError struct{ RedisError }
+
+func (e *ReadOnlyError) Unwrap() error { return &e.RedisError }
+
+// UnknownNodeError is returned when a command refers to a node ID unknown to
+// the node receiving the command
+type UnknownNodeError struct {
+	RedisError
+	NodeID string
+}
+
+func (e *UnknownNodeError) Unwrap() error { return &e.RedisError }
+
+// NodeNotMasterError is returned when a command expects the ID of a master and
+// got the ID of a replica
+type NodeNotMasterError struct{ RedisError }
+
+func (e *NodeNotMasterError) Unwrap() error { return &e.RedisError }
+
+// Prefixes of the replies referring to an unknown node ID, followed by the ID
+var unknownNodeReplies = []string{
+	"ERR Unknown node ",
+	"ERR No such node ID ",
+	"ERR I don't know about node ",
+}
+
+// NewRedisError returns the typed error of an error reply sent by the node at
+// addr to the command
+func NewRedisError(addr string, command string, reply string) error {
+	base := RedisError{Addr: addr, Command: command, Reply: ReplyError(reply)}
+	kind := reply
+	if i := strings.IndexByte(reply, ' '); i >= 0 {
+		kind = reply[:i]
+	}
+
+	switch kind {
+	case "MOVED", "ASK":
+		fields := strings.Fields(reply)
+		var slot int
+		var target string
+		if len(fields) == 3 {
+			slot, _ = strconv.Atoi(fields[1])
+			target = fields[2]
+		}
+		if kind == "MOVED" {
+			return &MovedError{RedisError: base, Slot: slot, Target: target}
+		}
+		return &AskError{RedisError: base, Slot: slot, Target: target}
+	case "CLUSTERDOWN":
+		return &ClusterDownError{base}
+	case "TRYAGAIN":
+		return &TryAgainError{base}
+	case "LOADING":
+		return &LoadingError{base}
+	case "NOAUTH":
+		return &NoAuthError{base}
+	case "READONLY":
+		return &ReadOnlyError{base}
+	}
+
+	for _, prefix := range unknownNodeReplies {
+		if strings.HasPrefix(strings.ToLower(reply), strings.ToLower(prefix)) {
+			nodeID := strings.Fields(reply[len(prefix):])
+			unknown := &UnknownNodeError{RedisError: base}
+			if len(nodeID) != 0 {
+				unknown.NodeID = nodeID[0]
+			}
+			return unknown
+		}
+	}
+	if errorStringMatch(base.Reply, "The specified node is not a master") {
+		return &NodeNotMasterError{base}
+	}
+	return &base
+}
+
+// Returns the name of a command for the errors: the subcommand is kept for the
+// container commands like CLUSTER and CONFIG
+func commandName(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	name := strings.ToUpper(args[0])
+	switch name {
+	case "CLUSTER", "CONFIG", "CLIENT", "MEMORY", "SCRIPT":
+		if len(args) > 1 {
+			name += " " + strings.ToUpper(args[1])
+		}
 	}
-	return strings.HasPrefix(strings.ToLower(err.Error()), strings.ToLower(keywords))
+	return name
 }
 
 func errorStringMatch(err error, keywords string) bool {
@@ -52,37 +175,32 @@ func errorStringMatch(err error, keywords string) bool {
 	return strings.Contains(strings.ToLower(err.Error()), strings.ToLower(keywords))
 }
 
+// Checks if the error is a reply of the node matching the keywords
+func replyMatch(err error, keywords string) bool {
+	var redisErr *RedisError
+	return errors.As(err, &redisErr) && errorStringMatch(redisErr.Reply, keywords)
+}
+
 func IsNodeIsNotMaster(err error) bool {
-	return errorStringMatch(err, ERR_STRINGS["nodenotmaster"])
+	var notMaster *NodeNotMasterError
+	return errors.As(err, &notMaster)
 }
 
 func IsLoading(err error) bool {
-	return errorStringMatch(err, SHARED_ERR_STRINGS["loading"])
+	var loading *LoadingError
+	return errors.As(err, &loading)
 }
 
-func IsFailoverNotOnReplica(err error) bool {
-	return errorStringMatch(err, ERR_STRINGS["failoverreplica"])
+func IsUnknownNode(err error) bool {
+	var unknown *UnknownNodeError
+	return errors.As(err, &unknown)
 }
 
-// Checks if an error is prefixed by the generic ERR string
-func IsGenericError(err error) bool {
-	return errorStringPrefix(err, "ERR") || errorStringPrefix(err, "\\[ERR\\]")
+func IsFailoverNotOnReplica(err error) bool {
+	return replyMatch(err, "ERR You should send CLUSTER FAILOVER to a replica")
 }
 
-// Checks if a given string matches any supported redis-cli error types
-func IsError(message string) bool {
-	for _, errstr := range SHARED_ERR_STRINGS {
-		if strings.Contains(strings.ToLower(message), strings.ToLower(errstr)) {
-			return true
-		}
-	}
-	for _, errstr := range ERR_STRINGS {
-		if strings.Contains(strings.ToLower(message), strings.ToLower(errstr)) {
-			return true
-		}
-	}
-	if IsGenericError(errors.Errorf(message)) {
-		return true
-	}
-	return false
+// Checks if the command or subcommand is not supported by the Redis version of the node
+func IsUnknownCommand(err error) bool {
+	return replyMatch(err, "ERR unknown command") || replyMatch(err, "ERR unknown subcommand")
 }
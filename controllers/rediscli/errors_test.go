@@ -0,0 +1,83 @@
+package rediscli
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestNewRedisError(t *testing.T) {
+	const addr = "10.0.0.1:6379"
+	wrap := func(command string, reply string) error {
+		return errors.Wrapf(NewRedisError(addr, command, reply), "Failed to execute %s (%s)", command, addr)
+	}
+
+	var moved *MovedError
+	if err := wrap("GET", "MOVED 3999 10.0.0.2:6379"); !errors.As(err, &moved) || moved.Slot != 3999 || moved.Target != "10.0.0.2:6379" || moved.Command != "GET" {
+		t.Errorf("Unexpected MOVED error %+v", moved)
+	}
+	var ask *AskError
+	if err := wrap("GET", "ASK 42 [fd00::a:2]:6379"); !errors.As(err, &ask) || ask.Slot != 42 || ask.Target != "[fd00::a:2]:6379" {
+		t.Errorf("Unexpected ASK error %+v", ask)
+	}
+	var unknown *UnknownNodeError
+	err := wrap("CLUSTER FORGET", "ERR Unknown node 67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1")
+	if !errors.As(err, &unknown) || unknown.NodeID != "67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1" || unknown.Addr != addr || !IsUnknownNode(err) {
+		t.Errorf("Unexpected unknown node error %+v", unknown)
+	}
+	if err.Error() != "Failed to execute CLUSTER FORGET (10.0.0.1:6379): ERR Unknown node 67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1" {
+		t.Errorf("Unexpected error message %q", err)
+	}
+	if err := wrap("CLUSTER SETSLOT", "ERR I don't know about node abc"); !errors.As(err, &unknown) || unknown.NodeID != "abc" {
+		t.Errorf("Unexpected unknown node error %+v", unknown)
+	}
+
+	checks := []struct {
+		reply string
+		check func(error) bool
+	}{
+		{"CLUSTERDOWN The cluster is down", func(err error) bool { var target *ClusterDownError; return errors.As(err, &target) }},
+		{"TRYAGAIN Multiple keys request during rehashing of slot", func(err error) bool { var target *TryAgainError; return errors.As(err, &target) }},
+		{"LOADING Redis is loading the dataset in memory", IsLoading},
+		{"NOAUTH Authentication required.", func(err error) bool { var target *NoAuthError; return errors.As(err, &target) }},
+		{"READONLY You can't write against a read only replica.", func(err error) bool { var target *ReadOnlyError; return errors.As(err, &target) }},
+		{"ERR The specified node is not a master", IsNodeIsNotMaster},
+		{"ERR You should send CLUSTER FAILOVER to a replica", IsFailoverNotOnReplica},
+	}
+	for _, check := range checks {
+		err := wrap("CLUSTER REPLICAS", check.reply)
+		if !check.check(err) {
+			t.Errorf("Unexpected type %T for %q", errors.Cause(err), check.reply)
+		}
+		var redisErr *RedisError
+		if !errors.As(err, &redisErr) || redisErr.Addr != addr || redisErr.Command != "CLUSTER REPLICAS" || string(redisErr.Reply) != check.reply {
+			t.Errorf("Unexpected Redis error %+v for %q", redisErr, check.reply)
+		}
+	}
+
+	generic := wrap("CLUSTER RESET", "ERR CLUSTER RESET can't be called with master nodes containing keys")
+	if IsLoading(generic) || IsUnknownNode(generic) || IsNodeIsNotMaster(generic) {
+		t.Errorf("Unexpected type %T for a generic error", errors.Cause(generic))
+	}
+	if _, isRedisErr := errors.Cause(generic).(*RedisError); !isRedisErr {
+		t.Errorf("Unexpected type %T for a generic error", errors.Cause(generic))
+	}
+	// the errors that are not replies are never classified
+	if IsLoading(errors.New("LOADING Redis is loading the dataset in memory")) {
+		t.Errorf("A plain error was classified as a reply")
+	}
+}
+
+func TestCommandName(t *testing.T) {
+	tests := map[string][]string{
+		"CLUSTER ADDSLOTS": {"cluster", "addslots", "0", "1"},
+		"CONFIG SET":       {"config", "set", "cluster-announce-ip", "10.0.0.1"},
+		"INFO":             {"info"},
+		"":                 nil,
+	}
+	for expected, args := range tests {
+		if name := commandName(args); name != expected {
+			t.Errorf("commandName(%v) = %q, expected %q", args, name, expected)
+		}
+	}
+}
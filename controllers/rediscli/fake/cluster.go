@@ -0,0 +1,1229 @@
+// Package fake provides an in-memory Redis Cluster simulator implementing
+// rediscli.RedisAdmin, used to test the operator logic without Redis pods.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/PayU/Redis-Operator/controllers/rediscli"
+)
+
+const (
+	slotCount = 16384
+
+	// The memory reported by an empty node and the memory used by a key
+	baseMemory = 1 << 20
+	keyMemory  = 100
+)
+
+// Node is the state of a simulated Redis process
+type Node struct {
+	ID          string
+	IP          string
+	Port        int
+	BusPort     int
+	MasterID    string
+	Up          bool
+	Detached    bool
+	ConfigEpoch int
+	Keys        int
+	Hostname    string
+
+	// address set with cluster-announce-ip, -port and -bus-port; the announced
+	// address is reachable as well, like through a load balancer
+	AnnounceIP      string
+	AnnouncePort    int
+	AnnounceBusPort int
+
+	known       map[string]struct{}
+	syncPending bool
+	// writes of the master the replica has not processed yet
+	replLag int
+	// open slots, indexed by slot: the ID of the node the slot is migrated to
+	// or imported from
+	migrating map[int]string
+	importing map[int]string
+}
+
+// IsMaster returns true when the node does not replicate another node
+func (n *Node) IsMaster() bool {
+	return n.MasterID == ""
+}
+
+// Cluster simulates the nodes of a Redis Cluster. The topology (roles and slot
+// ownership) is shared by all nodes, while each node keeps its own table of
+// known nodes so MEET and FORGET can be observed.
+type Cluster struct {
+	// AutoFailover enables the promotion of a replica when its master stops,
+	// as long as the majority of masters is still reachable
+	AutoFailover bool
+
+	mu           sync.Mutex
+	nodes        map[string]*Node
+	addrs        map[string]string
+	slots        [slotCount]string
+	nextID       int
+	currentEpoch int
+	// keys stored by every master, per slot; the replicas hold the keys of their master
+	slotKeys map[string]map[int]int
+	// nodes cut off from the others by a network partition, see Partition
+	isolated map[string]struct{}
+}
+
+var _ rediscli.RedisAdmin = &Cluster{}
+
+func NewCluster() *Cluster {
+	return &Cluster{
+		AutoFailover: true,
+		nodes:        make(map[string]*Node),
+		addrs:        make(map[string]string),
+		slotKeys:     make(map[string]map[int]int),
+		isolated:     make(map[string]struct{}),
+	}
+}
+
+func (c *Cluster) newID() string {
+	c.nextID++
+	return fmt.Sprintf("%040x", c.nextID)
+}
+
+// AddNode starts a new empty Redis process reachable on the given IP and the default ports
+func (c *Cluster) AddNode(ip string) *Node {
+	return c.AddNodeWithPorts(ip, rediscli.DefaultPort, 0)
+}
+
+// AddNodeWithPorts starts a new empty Redis process listening on the given
+// client and cluster bus ports; a zero bus port means port + 10000
+func (c *Cluster) AddNodeWithPorts(ip string, port int, busPort int) *Node {
+	if busPort == 0 {
+		busPort = port + 10000
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id, found := c.addrs[ip]; found {
+		c.nodes[id].Detached = true
+		c.nodes[id].Up = false
+	}
+	node := &Node{
+		ID:        c.newID(),
+		IP:        ip,
+		Port:      port,
+		BusPort:   busPort,
+		Up:        true,
+		known:     make(map[string]struct{}),
+		migrating: make(map[int]string),
+		importing: make(map[int]string),
+	}
+	node.known[node.ID] = struct{}{}
+	c.nodes[node.ID] = node
+	c.addrs[ip] = node.ID
+	return node
+}
+
+// StopNode makes the Redis process on the given IP unreachable (the pod is still running)
+func (c *Cluster) StopNode(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id, found := c.addrs[ip]; found {
+		c.stop(c.nodes[id])
+	}
+}
+
+// RemoveNode stops the Redis process on the given IP and detaches it from the IP (the pod is deleted)
+func (c *Cluster) RemoveNode(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id, found := c.addrs[ip]; found {
+		c.stop(c.nodes[id])
+		c.nodes[id].Detached = true
+		delete(c.addrs, ip)
+	}
+}
+
+// Partition cuts the nodes on the given IPs off from the other nodes; they can
+// still talk to each other and to the clients. No failover is triggered.
+func (c *Cluster) Partition(ips ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ip := range ips {
+		if id, found := c.addrs[ip]; found {
+			c.isolated[id] = struct{}{}
+		}
+	}
+}
+
+// Heal ends the network partitions
+func (c *Cluster) Heal() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.isolated = make(map[string]struct{})
+}
+
+// Returns true if the nodes are on the same side of the partition
+func (c *Cluster) linked(node *Node, other *Node) bool {
+	_, nodeIsolated := c.isolated[node.ID]
+	_, otherIsolated := c.isolated[other.ID]
+	return nodeIsolated == otherIsolated
+}
+
+// Returns true if the node can talk to the majority of the masters serving slots
+func (c *Cluster) reachesMajority(node *Node) bool {
+	owners := c.slotOwners()
+	linked := 0
+	for _, id := range owners {
+		if c.nodes[id].Up && c.linked(node, c.nodes[id]) {
+			linked++
+		}
+	}
+	return linked > len(owners)/2
+}
+
+// SetReplicationLag makes the replica on the given IP report a replication
+// offset behind the offset of its master
+func (c *Cluster) SetReplicationLag(ip string, lag int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id, found := c.addrs[ip]; found {
+		c.nodes[id].replLag = lag
+	}
+}
+
+// SetKeys sets the number of keys stored on a master and its replicas; the keys
+// are spread evenly over the slots of the master, or stored in slot 0 if it has none
+func (c *Cluster) SetKeys(ip string, keys int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, found := c.addrs[ip]
+	if !found {
+		return
+	}
+	var owned []int
+	for slot, owner := range c.slots {
+		if owner == id {
+			owned = append(owned, slot)
+		}
+	}
+	if len(owned) == 0 {
+		owned = []int{0}
+	}
+	slotKeys := make(map[int]int)
+	for i, slot := range owned {
+		if count := keys/len(owned) + boolToInt(i < keys%len(owned)); count > 0 {
+			slotKeys[slot] = count
+		}
+	}
+	c.slotKeys[id] = slotKeys
+	c.syncKeys(id)
+}
+
+// SetSlotKeys sets the number of keys a master stores in a slot
+func (c *Cluster) SetSlotKeys(ip string, slot int, keys int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id, found := c.addrs[ip]; found {
+		if c.slotKeys[id] == nil {
+			c.slotKeys[id] = make(map[int]int)
+		}
+		c.slotKeys[id][slot] = keys
+		c.syncKeys(id)
+	}
+}
+
+// SlotKeys returns the number of keys a master stores in a slot
+func (c *Cluster) SlotKeys(ip string, slot int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.slotKeys[c.addrs[ip]][slot]
+}
+
+// SlotOwner returns the ID of the master owning the slot, empty if the slot is not assigned
+func (c *Cluster) SlotOwner(slot int) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.slots[slot]
+}
+
+// DelSlots unassigns slots, like a shard lost with its data would leave them
+func (c *Cluster) DelSlots(first int, last int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for slot := first; slot <= last; slot++ {
+		if owner := c.slots[slot]; owner != "" {
+			delete(c.slotKeys[owner], slot)
+			c.slots[slot] = ""
+			c.syncKeys(owner)
+		}
+	}
+}
+
+// SetOpenSlot leaves a slot open on a node, like an interrupted migration does;
+// state is "migrating" or "importing"
+func (c *Cluster) SetOpenSlot(ip string, slot int, state string, nodeID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id, found := c.addrs[ip]; found {
+		if state == "migrating" {
+			c.nodes[id].migrating[slot] = nodeID
+		} else {
+			c.nodes[id].importing[slot] = nodeID
+		}
+	}
+}
+
+// Recomputes the key count of a master and of its replicas from its slot keys
+func (c *Cluster) syncKeys(masterID string) {
+	total := 0
+	for _, keys := range c.slotKeys[masterID] {
+		total += keys
+	}
+	if master, found := c.nodes[masterID]; found {
+		master.Keys = total
+	}
+	for _, node := range c.nodes {
+		if node.MasterID == masterID {
+			node.Keys = total
+		}
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// GetNode returns a copy of the node reachable on the given IP
+func (c *Cluster) GetNode(ip string) (Node, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, found := c.addrs[ip]
+	if !found {
+		return Node{}, false
+	}
+	return *c.nodes[id], true
+}
+
+// Masters returns copies of the reachable masters that own slots, sorted by ID
+func (c *Cluster) Masters() []Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var masters []Node
+	for _, id := range c.slotOwners() {
+		if node := c.nodes[id]; node.Up {
+			masters = append(masters, *node)
+		}
+	}
+	return masters
+}
+
+// Replicas returns copies of the reachable replicas of a master, sorted by ID
+func (c *Cluster) Replicas(masterID string) []Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var replicas []Node
+	for _, id := range c.sortedIDs() {
+		if node := c.nodes[id]; node.Up && node.MasterID == masterID {
+			replicas = append(replicas, *node)
+		}
+	}
+	return replicas
+}
+
+// CoveredSlots returns the number of slots owned by reachable masters
+func (c *Cluster) CoveredSlots() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	covered := 0
+	for _, owner := range c.slots {
+		if owner != "" && c.nodes[owner].Up {
+			covered++
+		}
+	}
+	return covered
+}
+
+func (c *Cluster) sortedIDs() []string {
+	var ids []string
+	for id := range c.nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Returns the IDs of the nodes that own at least one slot, sorted
+func (c *Cluster) slotOwners() []string {
+	ownerSet := make(map[string]struct{})
+	for _, owner := range c.slots {
+		if owner != "" {
+			ownerSet[owner] = struct{}{}
+		}
+	}
+	var owners []string
+	for id := range ownerSet {
+		owners = append(owners, id)
+	}
+	sort.Strings(owners)
+	return owners
+}
+
+func (c *Cluster) hasQuorum() bool {
+	owners := c.slotOwners()
+	up := 0
+	for _, id := range owners {
+		if c.nodes[id].Up {
+			up++
+		}
+	}
+	return up > len(owners)/2
+}
+
+func (c *Cluster) stop(node *Node) {
+	if !node.Up {
+		return
+	}
+	node.Up = false
+	if !c.AutoFailover || !node.IsMaster() || !c.hasQuorum() {
+		return
+	}
+	for _, id := range c.sortedIDs() {
+		if replica := c.nodes[id]; replica.Up && replica.MasterID == node.ID {
+			c.promote(replica)
+			return
+		}
+	}
+}
+
+// Makes a replica the master of its shard; the slots of the old master are
+// moved to it and the other replicas follow it
+func (c *Cluster) promote(node *Node) {
+	oldMaster := c.nodes[node.MasterID]
+	node.MasterID = ""
+	c.currentEpoch++
+	node.ConfigEpoch = c.currentEpoch
+	if oldMaster == nil {
+		return
+	}
+	c.slotKeys[node.ID] = c.slotKeys[oldMaster.ID]
+	delete(c.slotKeys, oldMaster.ID)
+	for slot, owner := range c.slots {
+		if owner == oldMaster.ID {
+			c.slots[slot] = node.ID
+		}
+	}
+	for _, other := range c.nodes {
+		if other.MasterID == oldMaster.ID {
+			other.MasterID = node.ID
+		}
+	}
+	if oldMaster.Up {
+		oldMaster.MasterID = node.ID
+	}
+}
+
+// Returns the running node listening on the address, resolved like RedisCLI does
+func (c *Cluster) reachable(ctx context.Context, nodeIP string) (*Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	host, port, _ := rediscli.ResolveNodeAddress(ctx, nodeIP)
+	if id, found := c.addrs[host]; found && c.nodes[id].Up && strconv.Itoa(c.nodes[id].Port) == port {
+		return c.nodes[id], nil
+	}
+	for _, node := range c.nodes {
+		if node.Up && !node.Detached && node.AnnounceIP == host && strconv.Itoa(node.announcedPorts()[0]) == port {
+			return node, nil
+		}
+	}
+	return nil, errors.Errorf("dial tcp %s: connect: connection refused", net.JoinHostPort(host, port))
+}
+
+// Returns the client and cluster bus ports the node announces
+func (n *Node) announcedPorts() [2]int {
+	ports := [2]int{n.Port, n.BusPort}
+	if n.AnnouncePort != 0 {
+		ports[0] = n.AnnouncePort
+	}
+	if n.AnnounceBusPort != 0 {
+		ports[1] = n.AnnounceBusPort
+	}
+	return ports
+}
+
+func (c *Cluster) slotRanges(id string) []string {
+	var ranges []string
+	for slot := 0; slot < slotCount; slot++ {
+		if c.slots[slot] != id {
+			continue
+		}
+		last := slot
+		for last+1 < slotCount && c.slots[last+1] == id {
+			last++
+		}
+		if last == slot {
+			ranges = append(ranges, strconv.Itoa(slot))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", slot, last))
+		}
+		slot = last
+	}
+	return ranges
+}
+
+// Renders a CLUSTER NODES line of a node as seen by the viewer node
+func (c *Cluster) nodeLine(viewer *Node, node *Node) string {
+	var flags []string
+	if node.ID == viewer.ID {
+		flags = append(flags, "myself")
+	}
+	master := "-"
+	if node.IsMaster() {
+		flags = append(flags, "master")
+	} else {
+		flags = append(flags, "slave")
+		master = node.MasterID
+	}
+	linkState := "connected"
+	if !node.Up {
+		// without the majority of the masters the failure is never confirmed
+		if c.hasQuorum() {
+			flags = append(flags, "fail")
+		} else {
+			flags = append(flags, "fail?")
+		}
+		linkState = "disconnected"
+	} else if !c.linked(viewer, node) {
+		flags = append(flags, "fail?")
+		linkState = "disconnected"
+	}
+	ip := node.IP
+	if node.AnnounceIP != "" {
+		ip = node.AnnounceIP
+	}
+	ports := node.announcedPorts()
+	addr := fmt.Sprintf("%s:%d@%d", ip, ports[0], ports[1])
+	if node.Hostname != "" {
+		addr += "," + node.Hostname
+	}
+	fields := []string{
+		node.ID,
+		addr,
+		strings.Join(flags, ","),
+		master,
+		"0",
+		"0",
+		strconv.Itoa(node.ConfigEpoch),
+		linkState,
+	}
+	if node.IsMaster() {
+		fields = append(fields, c.slotRanges(node.ID)...)
+	}
+	// like Redis, a node shows only its own open slots
+	if node.ID == viewer.ID {
+		fields = append(fields, openSlots(node)...)
+	}
+	return strings.Join(fields, " ")
+}
+
+func openSlots(node *Node) []string {
+	var slots []int
+	for slot := range node.migrating {
+		slots = append(slots, slot)
+	}
+	for slot := range node.importing {
+		if _, migrating := node.migrating[slot]; !migrating {
+			slots = append(slots, slot)
+		}
+	}
+	sort.Ints(slots)
+	var entries []string
+	for _, slot := range slots {
+		if id, found := node.migrating[slot]; found {
+			entries = append(entries, fmt.Sprintf("[%d->-%s]", slot, id))
+		}
+		if id, found := node.importing[slot]; found {
+			entries = append(entries, fmt.Sprintf("[%d-<-%s]", slot, id))
+		}
+	}
+	return entries
+}
+
+func (c *Cluster) knownNodes(viewer *Node) []*Node {
+	var ids []string
+	for id := range viewer.known {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var nodes []*Node
+	for _, id := range ids {
+		nodes = append(nodes, c.nodes[id])
+	}
+	return nodes
+}
+
+func (c *Cluster) meet(node *Node, other *Node) {
+	merged := make(map[string]struct{})
+	for id := range node.known {
+		merged[id] = struct{}{}
+	}
+	for id := range other.known {
+		merged[id] = struct{}{}
+	}
+	for id := range merged {
+		if member := c.nodes[id]; member.Up {
+			for knownID := range merged {
+				member.known[knownID] = struct{}{}
+			}
+		}
+	}
+}
+
+func (c *Cluster) forgetEverywhere(except string, id string) {
+	for _, node := range c.nodes {
+		if node.ID != except && node.Up {
+			delete(node.known, id)
+		}
+	}
+}
+
+func (c *Cluster) ClusterCreate(ctx context.Context, leaderIPs []string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var leaders []*Node
+	for _, ip := range leaderIPs {
+		node, err := c.reachable(ctx, ip)
+		if err != nil {
+			return "", errors.Wrapf(err, "Failed to execute cluster create (%v)", leaderIPs)
+		}
+		leaders = append(leaders, node)
+	}
+	var summary []string
+	for i, node := range leaders {
+		first := i * slotCount / len(leaders)
+		last := (i+1)*slotCount/len(leaders) - 1
+		for slot := first; slot <= last; slot++ {
+			c.slots[slot] = node.ID
+		}
+		c.currentEpoch++
+		node.ConfigEpoch = c.currentEpoch
+		summary = append(summary, fmt.Sprintf("%s: slots %d-%d", node.IP, first, last))
+	}
+	for _, node := range leaders[1:] {
+		c.meet(leaders[0], node)
+	}
+	return strings.Join(summary, "\n"), nil
+}
+
+func (c *Cluster) ClusterCheck(ctx context.Context, nodeIP string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node, err := c.reachable(ctx, nodeIP)
+	if err != nil {
+		return "", errors.Wrapf(err, "Cluster check result: (%s)", nodeIP)
+	}
+	var problems []string
+	for _, known := range c.knownNodes(node) {
+		if !known.Up {
+			problems = append(problems, fmt.Sprintf("node %s (%s) is failing", known.ID, known.IP))
+		} else if len(known.migrating) != 0 || len(known.importing) != 0 {
+			problems = append(problems, fmt.Sprintf("node %s (%s) has open slots", known.ID, known.IP))
+		}
+	}
+	covered := 0
+	for _, owner := range c.slots {
+		if owner != "" && c.nodes[owner].Up {
+			covered++
+		}
+	}
+	if covered != slotCount {
+		problems = append(problems, fmt.Sprintf("%d slots covered", covered))
+	}
+	if len(problems) != 0 {
+		return strings.Join(problems, "\n"), errors.Errorf("Cluster check result: (%s): %s", nodeIP, strings.Join(problems, "; "))
+	}
+	return "[OK] All nodes agree about slots configuration.\n[OK] All 16384 slots covered.", nil
+}
+
+func (c *Cluster) AddFollower(ctx context.Context, newNodeIP string, nodeIP string, leaderID string) (string, error) {
+	host, port, busPort := rediscli.ResolveNodeAddress(ctx, nodeIP)
+	if _, err := c.ClusterMeet(ctx, newNodeIP, host, port, busPort); err != nil {
+		return "", errors.Wrapf(err, "Failed to execute cluster add node (%s, %s, %s)", newNodeIP, nodeIP, leaderID)
+	}
+	if _, err := c.ClusterReplicate(ctx, newNodeIP, leaderID); err != nil {
+		return "", errors.Wrapf(err, "Failed to execute cluster add node (%s, %s, %s)", newNodeIP, nodeIP, leaderID)
+	}
+	return "[OK] New node added correctly.", nil
+}
+
+func (c *Cluster) DelNode(ctx context.Context, nodeIP string, nodeID string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.reachable(ctx, nodeIP); err != nil {
+		return "", errors.Wrapf(err, "Failed to execute cluster del-node (%s, %s)", nodeIP, nodeID)
+	}
+	c.forgetEverywhere(nodeID, nodeID)
+	if removed, found := c.nodes[nodeID]; found {
+		removed.Up = false
+	}
+	return "[OK] Node removed.", nil
+}
+
+func (c *Cluster) ClusterInfo(ctx context.Context, nodeIP string) (*rediscli.RedisClusterInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node, err := c.reachable(ctx, nodeIP)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to execute CLUSTER INFO (%s)", nodeIP)
+	}
+	state := "fail"
+	assigned := 0
+	covered := 0
+	// slots of masters on the other side of a partition, only suspected to fail
+	unlinked := 0
+	for _, owner := range c.slots {
+		if owner != "" {
+			assigned++
+			if !c.nodes[owner].Up {
+				continue
+			}
+			if c.linked(node, c.nodes[owner]) {
+				covered++
+			} else {
+				unlinked++
+			}
+		}
+	}
+	joined := len(node.known) > 1 || len(c.slotRanges(node.ID)) > 0
+	if joined && c.hasQuorum() && c.reachesMajority(node) && covered+unlinked == slotCount {
+		state = "ok"
+	}
+	pfail, fail := unlinked, assigned-covered-unlinked
+	if !c.hasQuorum() {
+		pfail, fail = pfail+fail, 0
+	}
+	lines := []string{
+		"cluster_state:" + state,
+		"cluster_slots_assigned:" + strconv.Itoa(assigned),
+		"cluster_slots_ok:" + strconv.Itoa(covered),
+		"cluster_slots_pfail:" + strconv.Itoa(pfail),
+		"cluster_slots_fail:" + strconv.Itoa(fail),
+		"cluster_known_nodes:" + strconv.Itoa(len(node.known)),
+		"cluster_size:" + strconv.Itoa(len(c.slotOwners())),
+		"cluster_current_epoch:" + strconv.Itoa(c.currentEpoch),
+		"cluster_my_epoch:" + strconv.Itoa(node.ConfigEpoch),
+	}
+	return rediscli.NewRedisClusterInfo(strings.Join(lines, "\r\n")), nil
+}
+
+func (c *Cluster) Info(ctx context.Context, nodeIP string) (*rediscli.RedisInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node, err := c.reachable(ctx, nodeIP)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to execute INFO (%s)", nodeIP)
+	}
+	lines := []string{"# Server", "redis_version:6.2.1", "tcp_port:" + strconv.Itoa(node.Port), "", "# Replication"}
+	if node.IsMaster() {
+		lines = append(lines, "role:master")
+		replicaCount := 0
+		for _, id := range c.sortedIDs() {
+			replica := c.nodes[id]
+			if replica.Up && replica.MasterID == node.ID {
+				lines = append(lines, fmt.Sprintf("slave%d:ip=%s,port=%d,state=online,offset=%d,lag=0", replicaCount, replica.IP, replica.Port, replica.Keys))
+				replicaCount++
+			}
+		}
+		lines = append(lines, "connected_slaves:"+strconv.Itoa(replicaCount), "master_repl_offset:"+strconv.Itoa(node.Keys))
+	} else {
+		master := c.nodes[node.MasterID]
+		linkStatus := "up"
+		if !master.Up {
+			linkStatus = "down"
+		}
+		syncInProgress := "0"
+		if node.syncPending {
+			syncInProgress = "1"
+		}
+		lines = append(lines,
+			"role:slave",
+			"master_host:"+master.IP,
+			"master_port:"+strconv.Itoa(master.Port),
+			"master_link_status:"+linkStatus,
+			"master_sync_in_progress:"+syncInProgress,
+			"slave_repl_offset:"+strconv.Itoa(node.Keys-node.replLag))
+		if node.syncPending {
+			lines = append(lines, "master_sync_perc:50.00")
+			// the sync is reported as running only once
+			node.syncPending = false
+		}
+	}
+	lines = append(lines, "", "# Memory", "used_memory:"+strconv.Itoa(baseMemory+node.Keys*keyMemory))
+	lines = append(lines, "", "# Persistence", "loading:0", "", "# Keyspace")
+	if node.Keys > 0 {
+		lines = append(lines, fmt.Sprintf("db0:keys=%d,expires=0,avg_ttl=0", node.Keys))
+	}
+	return rediscli.NewRedisInfo(strings.Join(lines, "\r\n")), nil
+}
+
+func (c *Cluster) Ping(ctx context.Context, nodeIP string, message ...string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.reachable(ctx, nodeIP); err != nil {
+		return "", errors.Wrapf(err, "Failed to execute PING (%s)", nodeIP)
+	}
+	if len(message) != 0 {
+		return message[0], nil
+	}
+	return "PONG", nil
+}
+
+func (c *Cluster) ClusterNodes(ctx context.Context, nodeIP string) (*rediscli.RedisClusterNodes, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node, err := c.reachable(ctx, nodeIP)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to execute CLUSTER NODES(%s)", nodeIP)
+	}
+	var lines []string
+	for _, known := range c.knownNodes(node) {
+		lines = append(lines, c.nodeLine(node, known))
+	}
+	return rediscli.NewRedisClusterNodes(strings.Join(lines, "\n")), nil
+}
+
+func (c *Cluster) ClusterSlots(ctx context.Context, nodeIP string) (*rediscli.RedisClusterSlots, error) {
+	clusterNodes, err := c.ClusterNodes(ctx, nodeIP)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to execute CLUSTER SLOTS (%s)", nodeIP)
+	}
+	return clusterNodes.ClusterSlots(), nil
+}
+
+func (c *Cluster) ClusterShards(ctx context.Context, nodeIP string) (*rediscli.RedisClusterShards, error) {
+	clusterNodes, err := c.ClusterNodes(ctx, nodeIP)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to execute CLUSTER SHARDS (%s)", nodeIP)
+	}
+	return clusterNodes.ClusterShards(), nil
+}
+
+func (c *Cluster) MyClusterID(ctx context.Context, nodeIP string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node, err := c.reachable(ctx, nodeIP)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to execute MYID(%s)", nodeIP)
+	}
+	return node.ID, nil
+}
+
+func (c *Cluster) ClusterForget(ctx context.Context, nodeIP string, forgetNodeID string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node, err := c.reachable(ctx, nodeIP)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to execute CLUSTER FORGET (%s, %s)", nodeIP, forgetNodeID)
+	}
+	var replyErr error
+	if forgetNodeID == node.ID {
+		replyErr = rediscli.NewRedisError(nodeIP, "CLUSTER FORGET", "ERR I tried hard but I can't forget myself...")
+	} else if forgetNodeID == node.MasterID {
+		replyErr = rediscli.NewRedisError(nodeIP, "CLUSTER FORGET", "ERR Can't forget my master!")
+	} else if _, known := node.known[forgetNodeID]; !known {
+		replyErr = rediscli.NewRedisError(nodeIP, "CLUSTER FORGET", "ERR Unknown node "+forgetNodeID)
+	}
+	if replyErr != nil {
+		return "", errors.Wrapf(replyErr, "Failed to execute CLUSTER FORGET (%s, %s)", nodeIP, forgetNodeID)
+	}
+	delete(node.known, forgetNodeID)
+	c.releaseForgottenSlots(forgetNodeID)
+	return "OK", nil
+}
+
+// The slots of a stopped master are unassigned once no running node knows it,
+// like the nodes clear the slots of a node they forget
+func (c *Cluster) releaseForgottenSlots(id string) {
+	if forgotten, found := c.nodes[id]; !found || forgotten.Up {
+		return
+	}
+	for _, node := range c.nodes {
+		if _, known := node.known[id]; known && node.Up && node.ID != id {
+			return
+		}
+	}
+	for slot, owner := range c.slots {
+		if owner == id {
+			c.slots[slot] = ""
+		}
+	}
+	delete(c.slotKeys, id)
+}
+
+func (c *Cluster) ClusterReplicas(ctx context.Context, nodeIP string, leaderNodeID string) (*rediscli.RedisClusterNodes, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node, err := c.reachable(ctx, nodeIP)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to execute CLUSTER REPLICAS (%s, %s)", nodeIP, leaderNodeID)
+	}
+	if _, known := node.known[leaderNodeID]; !known {
+		return nil, errors.Wrapf(rediscli.NewRedisError(nodeIP, "CLUSTER REPLICAS", "ERR Unknown node "+leaderNodeID), "Failed to execute CLUSTER REPLICAS (%s, %s)", nodeIP, leaderNodeID)
+	}
+	if !c.nodes[leaderNodeID].IsMaster() {
+		return nil, errors.Wrapf(rediscli.NewRedisError(nodeIP, "CLUSTER REPLICAS", "ERR The specified node is not a master"), "Failed to execute CLUSTER REPLICAS (%s, %s)", nodeIP, leaderNodeID)
+	}
+	var lines []string
+	for _, known := range c.knownNodes(node) {
+		if known.MasterID == leaderNodeID {
+			lines = append(lines, c.nodeLine(node, known))
+		}
+	}
+	return rediscli.NewRedisClusterNodes(strings.Join(lines, "\n")), nil
+}
+
+func (c *Cluster) ClusterFailover(ctx context.Context, nodeIP string, opt ...string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node, err := c.reachable(ctx, nodeIP)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to execute CLUSTER FAILOVER (%s, %v)", nodeIP, opt)
+	}
+	option := ""
+	if len(opt) != 0 {
+		option = strings.ToLower(opt[0])
+	}
+	if node.IsMaster() {
+		return "", errors.Wrapf(rediscli.NewRedisError(nodeIP, "CLUSTER FAILOVER", "ERR You should send CLUSTER FAILOVER to a replica"), "Failed to execute CLUSTER FAILOVER (%s, %v)", nodeIP, opt)
+	}
+	switch option {
+	case "":
+		if !c.nodes[node.MasterID].Up {
+			return "", errors.Wrapf(rediscli.NewRedisError(nodeIP, "CLUSTER FAILOVER", "ERR Master is down or failed, please use CLUSTER FAILOVER FORCE"), "Failed to execute CLUSTER FAILOVER (%s, %v)", nodeIP, opt)
+		}
+		c.promote(node)
+	case "force":
+		// a forced failover still needs the votes of the majority of masters
+		if c.hasQuorum() {
+			c.promote(node)
+		}
+	case "takeover":
+		c.promote(node)
+	}
+	return "OK", nil
+}
+
+func (c *Cluster) ClusterMeet(ctx context.Context, nodeIP string, newNodeIP string, newNodePort string, newNodeBusPort ...string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node, err := c.reachable(ctx, nodeIP)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to execute CLUSTER MEET (%s, %s, %s, %v)", nodeIP, newNodeIP, newNodePort, newNodeBusPort)
+	}
+	// the handshake with an unreachable node never completes, neither does the
+	// one started on a wrong client or cluster bus port
+	other, err := c.reachable(ctx, net.JoinHostPort(newNodeIP, newNodePort))
+	if err == nil && (len(newNodeBusPort) == 0 || newNodeBusPort[0] == strconv.Itoa(other.BusPort)) {
+		c.meet(node, other)
+	}
+	return "OK", nil
+}
+
+func (c *Cluster) ClusterReset(ctx context.Context, nodeIP string, opt ...string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node, err := c.reachable(ctx, nodeIP)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to execute CLUSTER RESET (%s, %v)", nodeIP, opt)
+	}
+	if node.IsMaster() && node.Keys > 0 {
+		return "", errors.Wrapf(rediscli.NewRedisError(nodeIP, "CLUSTER RESET", "ERR CLUSTER RESET can't be called with master nodes containing keys"), "Failed to execute CLUSTER RESET (%s, %v)", nodeIP, opt)
+	}
+	for slot, owner := range c.slots {
+		if owner == node.ID {
+			c.slots[slot] = ""
+		}
+	}
+	delete(c.slotKeys, node.ID)
+	node.migrating = make(map[int]string)
+	node.importing = make(map[int]string)
+	node.MasterID = ""
+	node.Keys = 0
+	node.known = map[string]struct{}{node.ID: {}}
+	if len(opt) != 0 && strings.ToLower(opt[0]) == "hard" {
+		tombstone := *node
+		tombstone.Up = false
+		tombstone.Detached = true
+		c.nodes[tombstone.ID] = &tombstone
+		node.ID = c.newID()
+		node.ConfigEpoch = 0
+		node.known = map[string]struct{}{node.ID: {}}
+		c.nodes[node.ID] = node
+		c.addrs[node.IP] = node.ID
+	}
+	return "OK", nil
+}
+
+func (c *Cluster) Flushall(ctx context.Context, nodeIP string, opt ...string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node, err := c.reachable(ctx, nodeIP)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to execute FLUSHALL (%s, %v)", nodeIP, opt)
+	}
+	if !node.IsMaster() {
+		return "", errors.Wrapf(rediscli.NewRedisError(nodeIP, "FLUSHALL", "READONLY You can't write against a read only replica."), "Failed to execute FLUSHALL (%s, %v)", nodeIP, opt)
+	}
+	delete(c.slotKeys, node.ID)
+	c.syncKeys(node.ID)
+	return "OK", nil
+}
+
+func (c *Cluster) ClusterReplicate(ctx context.Context, nodeIP string, leaderID string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node, err := c.reachable(ctx, nodeIP)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to execute CLUSTER REPLICATE (%s, %s)", nodeIP, leaderID)
+	}
+	var replyErr error
+	leader, found := c.nodes[leaderID]
+	if _, known := node.known[leaderID]; !known || !found {
+		replyErr = rediscli.NewRedisError(nodeIP, "CLUSTER REPLICATE", "ERR Unknown node "+leaderID)
+	} else if leaderID == node.ID {
+		replyErr = rediscli.NewRedisError(nodeIP, "CLUSTER REPLICATE", "ERR Can't replicate myself")
+	} else if !leader.IsMaster() {
+		replyErr = rediscli.NewRedisError(nodeIP, "CLUSTER REPLICATE", "ERR I can only replicate a master, not a replica.")
+	} else if node.IsMaster() && len(c.slotRanges(node.ID)) > 0 {
+		replyErr = rediscli.NewRedisError(nodeIP, "CLUSTER REPLICATE", "ERR To set a master the node must be empty and without assigned slots.")
+	}
+	if replyErr != nil {
+		return "", errors.Wrapf(replyErr, "Failed to execute CLUSTER REPLICATE (%s, %s)", nodeIP, leaderID)
+	}
+	if node.MasterID != leaderID {
+		delete(c.slotKeys, node.ID)
+		node.MasterID = leaderID
+		node.Keys = leader.Keys
+		node.syncPending = true
+		node.replLag = 0
+	}
+	return "OK", nil
+}
+
+// ConfigSet supports the parameters used by the operator
+func (c *Cluster) ConfigSet(ctx context.Context, nodeIP string, parameter string, value string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node, err := c.reachable(ctx, nodeIP)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to execute CONFIG SET (%s, %s, %s)", nodeIP, parameter, value)
+	}
+	switch strings.ToLower(parameter) {
+	case "cluster-announce-hostname":
+		node.Hostname = value
+	case "cluster-preferred-endpoint-type":
+	case "cluster-announce-ip":
+		node.AnnounceIP = value
+	case "cluster-announce-port", "cluster-announce-bus-port":
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 0 || port > 65535 {
+			return "", errors.Wrapf(rediscli.NewRedisError(nodeIP, "CONFIG SET", "ERR Invalid argument '"+value+"' for CONFIG SET '"+parameter+"'"),
+				"Failed to execute CONFIG SET (%s, %s, %s)", nodeIP, parameter, value)
+		}
+		if strings.ToLower(parameter) == "cluster-announce-port" {
+			node.AnnouncePort = port
+		} else {
+			node.AnnounceBusPort = port
+		}
+	default:
+		return "", errors.Wrapf(rediscli.NewRedisError(nodeIP, "CONFIG SET", "ERR Unknown option or number of arguments for CONFIG SET - '"+parameter+"'"),
+			"Failed to execute CONFIG SET (%s, %s, %s)", nodeIP, parameter, value)
+	}
+	return "OK", nil
+}
+
+func (c *Cluster) ClusterAddSlots(ctx context.Context, nodeIP string, slots ...int) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node, err := c.reachable(ctx, nodeIP)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to execute CLUSTER ADDSLOTS (%s, %d slots)", nodeIP, len(slots))
+	}
+	for _, slot := range slots {
+		var reply string
+		if slot < 0 || slot >= slotCount {
+			reply = "ERR Invalid or out of range slot"
+		} else if c.slots[slot] != "" {
+			reply = fmt.Sprintf("ERR Slot %d is already busy", slot)
+		}
+		if reply != "" {
+			return "", errors.Wrapf(rediscli.NewRedisError(nodeIP, "CLUSTER ADDSLOTS", reply), "Failed to execute CLUSTER ADDSLOTS (%s, %d slots)", nodeIP, len(slots))
+		}
+	}
+	for _, slot := range slots {
+		c.slots[slot] = node.ID
+	}
+	return "OK", nil
+}
+
+func (c *Cluster) ClusterSetSlot(ctx context.Context, nodeIP string, slot int, subcommand string, nodeID ...string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node, err := c.reachable(ctx, nodeIP)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to execute CLUSTER SETSLOT (%s, %d, %s, %v)", nodeIP, slot, subcommand, nodeID)
+	}
+	id := ""
+	if len(nodeID) != 0 {
+		id = nodeID[0]
+	}
+	_, known := node.known[id]
+	reply := ""
+	action := strings.ToLower(subcommand)
+	if !node.IsMaster() {
+		action = "replica"
+	}
+	switch action {
+	case "importing":
+		if !known {
+			reply = "ERR I don't know about node " + id
+		} else if c.slots[slot] == node.ID {
+			reply = fmt.Sprintf("ERR I'm already the owner of hash slot %d", slot)
+		} else {
+			node.importing[slot] = id
+		}
+	case "migrating":
+		if !known {
+			reply = "ERR I don't know about node " + id
+		} else if c.slots[slot] != node.ID {
+			reply = fmt.Sprintf("ERR I'm not the owner of hash slot %d", slot)
+		} else {
+			node.migrating[slot] = id
+		}
+	case "stable":
+		delete(node.migrating, slot)
+		delete(node.importing, slot)
+	case "node":
+		if !known {
+			reply = "ERR Unknown node " + id
+		} else if !c.nodes[id].IsMaster() {
+			reply = "ERR Target node is not a master"
+		} else if c.slots[slot] == node.ID && id != node.ID && c.slotKeys[node.ID][slot] > 0 {
+			reply = fmt.Sprintf("ERR Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot)
+		} else {
+			c.slots[slot] = id
+			delete(node.migrating, slot)
+			if id == node.ID {
+				delete(node.importing, slot)
+				c.currentEpoch++
+				node.ConfigEpoch = c.currentEpoch
+			}
+		}
+	case "replica":
+		reply = "ERR Please use SETSLOT only with masters."
+	default:
+		reply = "ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP"
+	}
+	if reply != "" {
+		return "", errors.Wrapf(rediscli.NewRedisError(nodeIP, "CLUSTER SETSLOT", reply), "Failed to execute CLUSTER SETSLOT (%s, %d, %s, %v)", nodeIP, slot, subcommand, nodeID)
+	}
+	return "OK", nil
+}
+
+// Returns the ID of the node holding the keys of a node: itself for a master,
+// its master for a replica
+func dataOwner(node *Node) string {
+	if node.IsMaster() {
+		return node.ID
+	}
+	return node.MasterID
+}
+
+func (c *Cluster) ClusterCountKeysInSlot(ctx context.Context, nodeIP string, slot int) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node, err := c.reachable(ctx, nodeIP)
+	if err != nil {
+		return 0, errors.Wrapf(err, "Failed to execute CLUSTER COUNTKEYSINSLOT (%s, %d)", nodeIP, slot)
+	}
+	return int64(c.slotKeys[dataOwner(node)][slot]), nil
+}
+
+func (c *Cluster) ClusterCountKeysInSlots(ctx context.Context, nodeIP string, slots ...int) (map[int]int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node, err := c.reachable(ctx, nodeIP)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to execute CLUSTER COUNTKEYSINSLOT (%s, %d slots)", nodeIP, len(slots))
+	}
+	counts := make(map[int]int64, len(slots))
+	for _, slot := range slots {
+		counts[slot] = int64(c.slotKeys[dataOwner(node)][slot])
+	}
+	return counts, nil
+}
+
+// The keys of a slot are named <slot>:<index>
+func (c *Cluster) ClusterGetKeysInSlot(ctx context.Context, nodeIP string, slot int, count int) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node, err := c.reachable(ctx, nodeIP)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to execute CLUSTER GETKEYSINSLOT (%s, %d, %d)", nodeIP, slot, count)
+	}
+	keys := []string{}
+	for i := 0; i < c.slotKeys[dataOwner(node)][slot] && i < count; i++ {
+		keys = append(keys, fmt.Sprintf("%d:%d", slot, i))
+	}
+	return keys, nil
+}
+
+func (c *Cluster) Migrate(ctx context.Context, nodeIP string, targetIP string, keys []string, timeout time.Duration) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node, err := c.reachable(ctx, nodeIP)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to execute MIGRATE (%s, %s, %d keys)", nodeIP, targetIP, len(keys))
+	}
+	if !node.IsMaster() {
+		return "", errors.Wrapf(rediscli.NewRedisError(nodeIP, "MIGRATE", "READONLY You can't write against a read only replica."), "Failed to execute MIGRATE (%s, %s, %d keys)", nodeIP, targetIP, len(keys))
+	}
+	target, err := c.reachable(ctx, targetIP)
+	if err != nil {
+		return "", errors.Wrapf(rediscli.NewRedisError(nodeIP, "MIGRATE", "IOERR error or timeout connecting to the client"), "Failed to execute MIGRATE (%s, %s, %d keys)", nodeIP, targetIP, len(keys))
+	}
+	if !target.IsMaster() {
+		return "", errors.Wrapf(rediscli.NewRedisError(nodeIP, "MIGRATE", "ERR Target instance replied with error: MOVED"), "Failed to execute MIGRATE (%s, %s, %d keys)", nodeIP, targetIP, len(keys))
+	}
+	moved := 0
+	for _, key := range keys {
+		slot, err := strconv.Atoi(strings.SplitN(key, ":", 2)[0])
+		if err != nil || c.slotKeys[node.ID][slot] == 0 {
+			continue
+		}
+		c.slotKeys[node.ID][slot]--
+		if c.slotKeys[node.ID][slot] == 0 {
+			delete(c.slotKeys[node.ID], slot)
+		}
+		if c.slotKeys[target.ID] == nil {
+			c.slotKeys[target.ID] = make(map[int]int)
+		}
+		c.slotKeys[target.ID][slot]++
+		moved++
+	}
+	c.syncKeys(node.ID)
+	c.syncKeys(target.ID)
+	if moved == 0 {
+		return "NOKEY", nil
+	}
+	return "OK", nil
+}
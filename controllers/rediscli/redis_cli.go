@@ -1,224 +1,443 @@
 package rediscli
 
 import (
-	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
-	"os/exec"
+	"math"
+	"net"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
+// RedisCLIOptions holds the connection settings used for every Redis node
+type RedisCLIOptions struct {
+	// Username used for AUTH; requires Password to be set
+	Username string
+	// Password used for AUTH; no authentication is done if empty
+	Password string
+	// TLSConfig enables TLS connections when not nil
+	TLSConfig *tls.Config
+	// DialTimeout limits the time spent opening a new connection
+	DialTimeout time.Duration
+	// CommandTimeout is the deadline of a single command
+	CommandTimeout time.Duration
+	// MaxIdleConns is the number of idle connections kept per node
+	MaxIdleConns int
+	// IdleTimeout closes the connections left idle for longer and drops the
+	// pools of the nodes that were not used for that long
+	IdleTimeout time.Duration
+}
+
+// RedisCLI is a native Redis client that keeps a pool of connections
+// for each Redis node it talks to
 type RedisCLI struct {
-	Log logr.Logger
+	Log     logr.Logger
+	Options RedisCLIOptions
+
+	pools     map[string]*nodePool
+	poolsLock sync.Mutex
+	// lastPrune is the last time the idle connections were pruned
+	lastPrune time.Time
 }
 
 func NewRedisCLI(log logr.Logger) *RedisCLI {
+	return NewRedisCLIWithOptions(log, RedisCLIOptions{})
+}
+
+func NewRedisCLIWithOptions(log logr.Logger, opts RedisCLIOptions) *RedisCLI {
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = defaultDialTimeout
+	}
+	if opts.CommandTimeout == 0 {
+		opts.CommandTimeout = defaultRedisCliTimeout
+	}
+	if opts.MaxIdleConns == 0 {
+		opts.MaxIdleConns = defaultMaxIdleConns
+	}
+	if opts.IdleTimeout == 0 {
+		opts.IdleTimeout = defaultIdleTimeout
+	}
 	return &RedisCLI{
-		Log: log,
+		Log:     log,
+		Options: opts,
+		pools:   make(map[string]*nodePool),
 	}
 }
 
 const (
 	defaultRedisCliTimeout = 20 * time.Second
+	defaultDialTimeout     = 5 * time.Second
+	defaultMaxIdleConns    = 4
+	defaultIdleTimeout     = 5 * time.Minute
+
+	// Maximum number of commands sent in a single round trip by a pipeline
+	pipelineBatchSize = 1000
+
+	clusterJoinInterval = 500 * time.Millisecond
+	clusterJoinTimeout  = 20 * time.Second
 )
 
+// ClusterSlotCount is the number of hash slots of a Redis cluster
+const ClusterSlotCount = 16384
+
+// Returns the host:port address of a node given as a bare IP or as host:port;
+// the port of the bare IPs comes from the context (see WithNodePorts)
+func nodeAddr(ctx context.Context, nodeIP string) string {
+	host, port, _ := ResolveNodeAddress(ctx, nodeIP)
+	return net.JoinHostPort(host, port)
+}
+
 /*
- * executeCommand returns the exec command stdout and stderr response and an error
- * The error is non-nil if execution was unsuccessful, stderr is not empty or stdout
- * contains an error message
+ * executeCommand sends a command to the node and returns its reply
+ * The error is non-nil if the node could not be reached or the reply is an error reply,
+ * in which case it is one of the typed errors of NewRedisError
  */
-func (r *RedisCLI) executeCommand(args []string) (string, string, error) {
-	var stdout, stderr bytes.Buffer
+func (r *RedisCLI) executeCommand(ctx context.Context, nodeIP string, args ...string) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Options.CommandTimeout)
+	defer cancel()
 
-	ctx, cancel := context.WithTimeout(context.Background(), defaultRedisCliTimeout)
+	addr := nodeAddr(ctx, nodeIP)
+	reply, err := r.roundTrip(ctx, addr, args...)
+	if replyErr, isReplyErr := err.(ReplyError); isReplyErr {
+		return nil, NewRedisError(addr, commandName(args), string(replyErr))
+	}
+	return reply, err
+}
+
+// executePipeline sends the commands to the node in a single round trip. The
+// error replies are returned as ReplyError elements of the replies; the error
+// is non-nil if the node could not be reached.
+func (r *RedisCLI) executePipeline(ctx context.Context, nodeIP string, commands ...[]string) ([]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Options.CommandTimeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "redis-cli", args...)
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	return r.pipelineRoundTrip(ctx, nodeAddr(ctx, nodeIP), commands...)
+}
 
-	if err := cmd.Start(); err != nil {
-		return stdout.String(), stderr.String(), err
+// executeStringCommand runs a command that replies with a simple or bulk string
+func (r *RedisCLI) executeStringCommand(ctx context.Context, nodeIP string, args ...string) (string, error) {
+	reply, err := r.executeCommand(ctx, nodeIP, args...)
+	if err != nil {
+		return "", err
 	}
+	switch value := reply.(type) {
+	case string:
+		return strings.TrimSpace(value), nil
+	case nil:
+		return "", nil
+	}
+	return "", errors.Errorf("Unexpected reply type for %v: %T", args, reply)
+}
 
-	if err := cmd.Wait(); err != nil {
-		if e, ok := err.(*exec.ExitError); ok {
+// executeOKCommand runs a command that replies with +OK
+func (r *RedisCLI) executeOKCommand(ctx context.Context, nodeIP string, args ...string) (string, error) {
+	reply, err := r.executeStringCommand(ctx, nodeIP, args...)
+	if err != nil {
+		return reply, err
+	}
+	if reply != "OK" {
+		return reply, errors.Errorf("Unexpected reply for %v: %s", args, reply)
+	}
+	return reply, nil
+}
 
-			// If the process exited by itself, just return the error to the caller
-			if e.Exited() {
-				return stdout.String(), stderr.String(), e
-			}
+// Splits the hash slots in contiguous ranges of (almost) equal size, the same
+// way as 'redis-cli --cluster create' does
+func splitSlots(nodeCount int) [][2]int {
+	var ranges [][2]int
+	slotsPerNode := float64(ClusterSlotCount) / float64(nodeCount)
+	first := 0
+	cursor := 0.0
+	for i := 0; i < nodeCount; i++ {
+		last := int(math.Round(cursor + slotsPerNode - 1))
+		if last > ClusterSlotCount-1 || i == nodeCount-1 {
+			last = ClusterSlotCount - 1
+		}
+		if last < first {
+			last = first
+		}
+		ranges = append(ranges, [2]int{first, last})
+		first = last + 1
+		cursor += slotsPerNode
+	}
+	return ranges
+}
 
-			// We know now that the process could be started, but didn't exit
-			// by itself. Something must have killed it. If the context is done,
-			// we can *assume* that it has been killed by the exec.Command.
-			// Let's return ctx.Err() so our user knows that this *might* be
-			// the case.
+// ClusterCreate creates a cluster out of a list of empty nodes: the slots are split
+// evenly between the nodes, each node gets a distinct config epoch and all nodes
+// are introduced to the first one
+func (r *RedisCLI) ClusterCreate(ctx context.Context, leaderIPs []string) (string, error) {
+	if len(leaderIPs) == 0 {
+		return "", errors.New("Failed to execute cluster create: no nodes")
+	}
 
-			select {
-			case <-ctx.Done():
-				return stdout.String(), stderr.String(), errors.Errorf("exec of %v failed with: %v", args, ctx.Err())
-			default:
-				return stdout.String(), stderr.String(), errors.Errorf("exec of %v failed with: %v", args, e)
-			}
+	var summary []string
+	for i, slots := range splitSlots(len(leaderIPs)) {
+		args := []string{"cluster", "addslots"}
+		for slot := slots[0]; slot <= slots[1]; slot++ {
+			args = append(args, strconv.Itoa(slot))
+		}
+		if _, err := r.executeOKCommand(ctx, leaderIPs[i], args...); err != nil {
+			return strings.Join(summary, "\n"), errors.Wrapf(err, "Failed to execute cluster create (%v): ADDSLOTS %d-%d on %s", leaderIPs, slots[0], slots[1], leaderIPs[i])
 		}
-		return stdout.String(), stderr.String(), err
+		summary = append(summary, fmt.Sprintf("%s: slots %d-%d", leaderIPs[i], slots[0], slots[1]))
 	}
 
-	stdOutput := strings.TrimSpace(stdout.String())
-	errOutput := strings.TrimSpace(stderr.String())
-
-	if errOutput != "" {
-		return stdOutput, errOutput, errors.New(errOutput)
+	for i, leaderIP := range leaderIPs {
+		if _, err := r.executeOKCommand(ctx, leaderIP, "cluster", "set-config-epoch", strconv.Itoa(i+1)); err != nil {
+			// the epoch can't be set on a node that already knows other nodes; this is harmless
+			r.Log.Info(fmt.Sprintf("Warning: could not set config epoch on %s: %v", leaderIP, err))
+		}
 	}
-	if stdOutput != "" && strings.Contains(strings.ToLower(stdOutput), "error:") {
-		return stdOutput, stdOutput, errors.New(stdOutput)
+
+	for _, leaderIP := range leaderIPs[1:] {
+		host, port, busPort := ResolveNodeAddress(ctx, leaderIP)
+		if _, err := r.ClusterMeet(ctx, leaderIPs[0], host, port, busPort); err != nil {
+			return strings.Join(summary, "\n"), errors.Wrapf(err, "Failed to execute cluster create (%v)", leaderIPs)
+		}
 	}
-	return stdOutput, errOutput, nil
+	return strings.Join(summary, "\n"), nil
 }
 
-// ClusterCreate uses the '--cluster create' option on redis-cli to create a cluster using a list of nodes
-func (r *RedisCLI) ClusterCreate(leaderIPs []string) (string, error) {
-	var leaderAddrs []string
-	for _, leaderIP := range leaderIPs {
-		leaderAddrs = append(leaderAddrs, leaderIP+":6379")
+// ClusterCheck verifies that all nodes known by the given node agree about the slots
+// configuration, that all the slots are covered and that there are no open slots
+func (r *RedisCLI) ClusterCheck(ctx context.Context, nodeIP string) (string, error) {
+	clusterNodes, err := r.ClusterNodes(ctx, nodeIP)
+	if err != nil {
+		return "", errors.Wrapf(err, "Cluster check result: (%s)", nodeIP)
 	}
-	args := append([]string{"--cluster", "create"}, leaderAddrs...)
 
-	// this will run the command non-interactively
-	args = append(args, "--cluster-yes")
-
-	stdout, stderr, err := r.executeCommand(args)
-	if err != nil || strings.TrimSpace(stderr) != "" || IsError(strings.TrimSpace(stdout)) {
-		return stdout, errors.Errorf("Failed to execute cluster create (%v): %s | %s | %v", leaderAddrs, stdout, stderr, err)
+	var problems []string
+	for _, node := range *clusterNodes {
+		ip := node.HostPort()
+		if node.IsFailing() || ip == "" {
+			problems = append(problems, fmt.Sprintf("node %s (%s) is failing", node.ID, node.Addr))
+			continue
+		}
+		nodeView, err := r.ClusterNodes(ctx, ip)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("node %s (%s) is unreachable: %v", node.ID, node.Addr, err))
+			continue
+		}
+		if len(nodeView.OpenSlots()) != 0 {
+			problems = append(problems, fmt.Sprintf("node %s (%s) has open slots", node.ID, node.Addr))
+		}
+		clusterInfo, err := r.ClusterInfo(ctx, ip)
+		if err != nil || clusterInfo == nil {
+			problems = append(problems, fmt.Sprintf("node %s (%s) did not reply to CLUSTER INFO: %v", node.ID, node.Addr, err))
+			continue
+		}
+		if (*clusterInfo)["cluster_slots_assigned"] != strconv.Itoa(ClusterSlotCount) {
+			problems = append(problems, fmt.Sprintf("node %s (%s) sees %s assigned slots", node.ID, node.Addr, (*clusterInfo)["cluster_slots_assigned"]))
+		}
+		if (*clusterInfo)["cluster_known_nodes"] != strconv.Itoa(len(*clusterNodes)) {
+			problems = append(problems, fmt.Sprintf("node %s (%s) knows %s nodes", node.ID, node.Addr, (*clusterInfo)["cluster_known_nodes"]))
+		}
 	}
-	return stdout, nil
-}
-
-func (r *RedisCLI) ClusterCheck(nodeIP string) (string, error) {
-	args := []string{"--cluster", "check", nodeIP + ":6379"}
 
-	stdout, stderr, err := r.executeCommand(args)
-	if err != nil || strings.TrimSpace(stderr) != "" || IsError(strings.TrimSpace(stdout)) {
-		return stdout, errors.Errorf("Cluster check result: (%s): %s | %s | %v", nodeIP, stdout, stderr, err)
+	if len(problems) != 0 {
+		return strings.Join(problems, "\n"), errors.Errorf("Cluster check result: (%s): %s", nodeIP, strings.Join(problems, "; "))
 	}
-	return stdout, nil
+	return "[OK] All nodes agree about slots configuration.\n[OK] All 16384 slots covered.", nil
 }
 
-// AddFollower uses the '--cluster add-node' option on redis-cli to add a node to the cluster
+// AddFollower makes a new node join the cluster as a replica of a leader
 // newNodeIP: IP of the follower that will join the cluster
 // nodeIP: 		IP of a node in the cluster
 // leaderID: 	Redis ID of the leader that the new follower will replicate
-func (r *RedisCLI) AddFollower(newNodeIP string, nodeIP string, leaderID string) (string, error) {
-	args := []string{"--cluster", "add-node", newNodeIP + ":6379", nodeIP + ":6379", "--cluster-slave", "--cluster-master-id", leaderID}
+func (r *RedisCLI) AddFollower(ctx context.Context, newNodeIP string, nodeIP string, leaderID string) (string, error) {
+	host, port, busPort := ResolveNodeAddress(ctx, nodeIP)
+	if _, err := r.ClusterMeet(ctx, newNodeIP, host, port, busPort); err != nil {
+		return "", errors.Wrapf(err, "Failed to execute cluster add node (%s, %s, %s)", newNodeIP, nodeIP, leaderID)
+	}
+
+	// the new node can replicate the leader only after it learned about it through gossip
+	joinCtx, cancel := context.WithTimeout(ctx, clusterJoinTimeout)
+	defer cancel()
+	if pollErr := wait.PollImmediateUntil(clusterJoinInterval, func() (bool, error) {
+		clusterNodes, err := r.ClusterNodes(ctx, newNodeIP)
+		if err != nil {
+			return false, nil
+		}
+		for _, node := range *clusterNodes {
+			if node.ID == leaderID {
+				return true, nil
+			}
+		}
+		return false, nil
+	}, joinCtx.Done()); pollErr != nil {
+		return "", errors.Errorf("Failed to execute cluster add node (%s, %s, %s): leader not known by new node: %v", newNodeIP, nodeIP, leaderID, pollErr)
+	}
 
-	stdout, stderr, err := r.executeCommand(args)
-	if err != nil || strings.TrimSpace(stderr) != "" || IsError(strings.TrimSpace(stdout)) {
-		return stdout, errors.Errorf("Failed to execute cluster add node (%s, %s, %s): %s | %s | %v", newNodeIP, nodeIP, leaderID, stdout, stderr, err)
+	if _, err := r.ClusterReplicate(ctx, newNodeIP, leaderID); err != nil {
+		return "", errors.Wrapf(err, "Failed to execute cluster add node (%s, %s, %s)", newNodeIP, nodeIP, leaderID)
 	}
-	return stdout, nil
+	return "[OK] New node added correctly.", nil
 }
 
-// DelNode uses the '--cluster del-node' option of redis-cli to remove a node from the cluster
+// DelNode removes a node from the cluster: all the other nodes forget it and the
+// removed node is shut down
 // nodeIP: any node of the cluster
 // nodeID: node that needs to be removed
-func (r *RedisCLI) DelNode(nodeIP string, nodeID string) (string, error) {
-	args := []string{"--cluster", "del-node", nodeIP + ":6379", nodeID}
-	stdout, stderr, err := r.executeCommand(args)
-	if err != nil || stderr != "" || IsError(strings.TrimSpace(stdout)) {
-		return stdout, errors.Errorf("Failed to execute cluster del-node (%s, %s): %s | %s | %v", nodeIP, nodeID, stdout, stderr, err)
+func (r *RedisCLI) DelNode(ctx context.Context, nodeIP string, nodeID string) (string, error) {
+	clusterNodes, err := r.ClusterNodes(ctx, nodeIP)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to execute cluster del-node (%s, %s)", nodeIP, nodeID)
+	}
+
+	var removedIP string
+	if removed := clusterNodes.GetNodeByID(nodeID); removed != nil {
+		removedIP = removed.HostPort()
+	}
+	for _, node := range *clusterNodes {
+		ip := node.HostPort()
+		if node.ID == nodeID || node.IsFailing() || ip == "" {
+			continue
+		}
+		if _, err := r.ClusterForget(ctx, ip, nodeID); err != nil {
+			return "", errors.Wrapf(err, "Failed to execute cluster del-node (%s, %s)", nodeIP, nodeID)
+		}
 	}
-	return stdout, nil
+
+	if removedIP != "" {
+		// the connection is closed by the server on shutdown, so the reply is not checked
+		r.executeCommand(ctx, removedIP, "shutdown")
+	}
+	return "[OK] Node removed.", nil
 }
 
 // https://redis.io/commands/cluster-info
-func (r *RedisCLI) ClusterInfo(nodeIP string) (*RedisClusterInfo, error) {
-	args := []string{"-h", nodeIP, "cluster", "info"}
-
-	stdout, stderr, err := r.executeCommand(args)
-	if err != nil || strings.TrimSpace(stderr) != "" || IsError(strings.TrimSpace(stdout)) {
-		return nil, errors.Errorf("Failed to execute CLUSTER INFO (%s): %s | %s | %v", nodeIP, stdout, stderr, err)
+func (r *RedisCLI) ClusterInfo(ctx context.Context, nodeIP string) (*RedisClusterInfo, error) {
+	reply, err := r.executeStringCommand(ctx, nodeIP, "cluster", "info")
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to execute CLUSTER INFO (%s)", nodeIP)
 	}
-	return NewRedisClusterInfo(stdout), nil
+	return NewRedisClusterInfo(reply), nil
 }
 
 // https://redis.io/commands/info
-func (r *RedisCLI) Info(nodeIP string) (*RedisInfo, error) {
-	args := []string{"-h", nodeIP, "info"}
-
-	stdout, stderr, err := r.executeCommand(args)
-	if err != nil || strings.TrimSpace(stderr) != "" || IsError(strings.TrimSpace(stdout)) {
-		return nil, errors.Errorf("Failed to execute INFO (%s): %s | %s | %v", nodeIP, stdout, stderr, err)
+func (r *RedisCLI) Info(ctx context.Context, nodeIP string) (*RedisInfo, error) {
+	reply, err := r.executeStringCommand(ctx, nodeIP, "info")
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to execute INFO (%s)", nodeIP)
 	}
-	return NewRedisInfo(stdout), nil
+	return NewRedisInfo(reply), nil
 }
 
 // https://redis.io/commands/ping
-func (r *RedisCLI) Ping(nodeIP string, message ...string) (string, error) {
-	args := []string{"-h", nodeIP, "ping"}
+func (r *RedisCLI) Ping(ctx context.Context, nodeIP string, message ...string) (string, error) {
+	args := []string{"ping"}
 	if len(message) != 0 {
 		args = append(args, message[0])
 	}
-	stdout, stderr, err := r.executeCommand(args)
-	if err != nil || strings.TrimSpace(stderr) != "" || IsError(strings.TrimSpace(stdout)) {
-		return stdout, errors.Errorf("Failed to execute INFO (%s): %s | %s | %v", nodeIP, stdout, stderr, err)
+	reply, err := r.executeStringCommand(ctx, nodeIP, args...)
+	if err != nil {
+		return reply, errors.Wrapf(err, "Failed to execute PING (%s)", nodeIP)
 	}
-	return strings.TrimSpace(stdout), nil
+	return reply, nil
 }
 
 // https://redis.io/commands/cluster-nodes
-func (r *RedisCLI) ClusterNodes(nodeIP string) (*RedisClusterNodes, error) {
-	args := []string{"-h", nodeIP, "cluster", "nodes"}
+func (r *RedisCLI) ClusterNodes(ctx context.Context, nodeIP string) (*RedisClusterNodes, error) {
+	reply, err := r.executeStringCommand(ctx, nodeIP, "cluster", "nodes")
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to execute CLUSTER NODES(%s)", nodeIP)
+	}
+	return NewRedisClusterNodes(reply), nil
+}
 
-	stdout, stderr, err := r.executeCommand(args)
-	if err != nil || strings.TrimSpace(stderr) != "" || IsError(strings.TrimSpace(stdout)) {
-		return nil, errors.Errorf("Failed to execute CLUSTER NODES(%s): %s | %s | %v", nodeIP, stdout, stderr, err)
+// https://redis.io/commands/cluster-slots
+// Falls back to the CLUSTER NODES output on nodes that don't support the command
+func (r *RedisCLI) ClusterSlots(ctx context.Context, nodeIP string) (*RedisClusterSlots, error) {
+	reply, err := r.executeCommand(ctx, nodeIP, "cluster", "slots")
+	if IsUnknownCommand(err) {
+		clusterNodes, err := r.ClusterNodes(ctx, nodeIP)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to execute CLUSTER SLOTS (%s)", nodeIP)
+		}
+		return clusterNodes.ClusterSlots(), nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to execute CLUSTER SLOTS (%s)", nodeIP)
+	}
+	slots, err := parseClusterSlots(reply)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to execute CLUSTER SLOTS (%s)", nodeIP)
+	}
+	return slots, nil
+}
+
+// https://redis.io/commands/cluster-shards
+// Falls back to the CLUSTER NODES output on nodes older than Redis 7
+func (r *RedisCLI) ClusterShards(ctx context.Context, nodeIP string) (*RedisClusterShards, error) {
+	reply, err := r.executeCommand(ctx, nodeIP, "cluster", "shards")
+	if IsUnknownCommand(err) {
+		clusterNodes, err := r.ClusterNodes(ctx, nodeIP)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to execute CLUSTER SHARDS (%s)", nodeIP)
+		}
+		return clusterNodes.ClusterShards(), nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to execute CLUSTER SHARDS (%s)", nodeIP)
+	}
+	shards, err := parseClusterShards(reply)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to execute CLUSTER SHARDS (%s)", nodeIP)
 	}
-	return NewRedisClusterNodes(stdout), nil
+	return shards, nil
 }
 
 // https://redis.io/commands/cluster-myid
-func (r *RedisCLI) MyClusterID(nodeIP string) (string, error) {
-	args := []string{"-h", nodeIP, "cluster", "myid"}
-	stdout, stderr, err := r.executeCommand(args)
-	if err != nil || strings.TrimSpace(stderr) != "" || IsError(strings.TrimSpace(stdout)) {
-		return stdout, errors.Errorf("Failed to execute MYID(%s): %s | %s | %v", nodeIP, stdout, stderr, err)
+func (r *RedisCLI) MyClusterID(ctx context.Context, nodeIP string) (string, error) {
+	reply, err := r.executeStringCommand(ctx, nodeIP, "cluster", "myid")
+	if err != nil {
+		return reply, errors.Wrapf(err, "Failed to execute MYID(%s)", nodeIP)
 	}
-	return strings.TrimSpace(stdout), nil
+	return reply, nil
 }
 
 // ForgetNode command is used in order to remove a node, specified via its node ID, from the set of known nodes of the Redis Cluster node receiving the command.
 // In other words the specified node is removed from the nodes table of the node receiving the command.
 // https://redis.io/commands/cluster-forget
-func (r *RedisCLI) ClusterForget(nodeIP string, forgetNodeID string) (string, error) {
-	args := []string{"-h", nodeIP, "cluster", "forget", forgetNodeID}
-
-	stdout, stderr, err := r.executeCommand(args)
-	if err != nil || strings.TrimSpace(stderr) != "" || strings.TrimSpace(stdout) != "OK" {
-		return stdout, errors.Errorf("Failed to execute CLUSTER FORGET (%s, %s): %s | %s | %v", nodeIP, forgetNodeID, stdout, stderr, err)
+func (r *RedisCLI) ClusterForget(ctx context.Context, nodeIP string, forgetNodeID string) (string, error) {
+	reply, err := r.executeOKCommand(ctx, nodeIP, "cluster", "forget", forgetNodeID)
+	if err != nil {
+		return reply, errors.Wrapf(err, "Failed to execute CLUSTER FORGET (%s, %s)", nodeIP, forgetNodeID)
 	}
-	return stdout, nil
+	return reply, nil
 }
 
 // ClusterReplicas command provides a list of replica nodes replicating from a specified leader node
 // https://redis.io/commands/cluster-replicas
-func (r *RedisCLI) ClusterReplicas(nodeIP string, leaderNodeID string) (*RedisClusterNodes, error) {
-	args := []string{"-h", nodeIP, "cluster", "replicas", leaderNodeID}
-
-	stdout, stderr, err := r.executeCommand(args)
-	if err != nil || strings.TrimSpace(stderr) != "" || IsError(strings.TrimSpace(stdout)) {
-		return nil, errors.Errorf("Failed to execute CLUSTER REPLICAS (%s, %s): %s | %s | %v", nodeIP, leaderNodeID, stdout, stderr, err)
+func (r *RedisCLI) ClusterReplicas(ctx context.Context, nodeIP string, leaderNodeID string) (*RedisClusterNodes, error) {
+	reply, err := r.executeCommand(ctx, nodeIP, "cluster", "replicas", leaderNodeID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to execute CLUSTER REPLICAS (%s, %s)", nodeIP, leaderNodeID)
 	}
-	return NewRedisClusterNodes(stdout), err
+	replicas, ok := reply.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("Failed to execute CLUSTER REPLICAS (%s, %s): unexpected reply type %T", nodeIP, leaderNodeID, reply)
+	}
+	var lines []string
+	for _, replica := range replicas {
+		if line, ok := replica.(string); ok {
+			lines = append(lines, line)
+		}
+	}
+	return NewRedisClusterNodes(strings.Join(lines, "\n")), nil
 }
 
 // https://redis.io/commands/cluster-failover
-func (r *RedisCLI) ClusterFailover(nodeIP string, opt ...string) (string, error) {
-	args := []string{"-h", nodeIP, "cluster", "failover"}
+func (r *RedisCLI) ClusterFailover(ctx context.Context, nodeIP string, opt ...string) (string, error) {
+	args := []string{"cluster", "failover"}
 
 	if len(opt) != 0 && opt[0] != "" {
 		if strings.ToLower(opt[0]) != "force" && strings.ToLower(opt[0]) != "takeover" {
@@ -228,29 +447,29 @@ func (r *RedisCLI) ClusterFailover(nodeIP string, opt ...string) (string, error)
 		}
 	}
 
-	stdout, stderr, err := r.executeCommand(args)
-	if err != nil || strings.TrimSpace(stderr) != "" || strings.TrimSpace(stdout) != "OK" {
-		return stdout, errors.Errorf("Failed to execute CLUSTER FAILOVER (%s, %v): %s | %s | %v", nodeIP, opt, stdout, stderr, err)
+	reply, err := r.executeOKCommand(ctx, nodeIP, args...)
+	if err != nil {
+		return reply, errors.Wrapf(err, "Failed to execute CLUSTER FAILOVER (%s, %v)", nodeIP, opt)
 	}
-	return stdout, nil
+	return reply, nil
 }
 
 // https://redis.io/commands/cluster-meet
-func (r *RedisCLI) ClusterMeet(nodeIP string, newNodeIP string, newNodePort string, newNodeBusPort ...string) (string, error) {
-	args := []string{"-h", nodeIP, "cluster", "meet", newNodeIP, newNodePort}
+func (r *RedisCLI) ClusterMeet(ctx context.Context, nodeIP string, newNodeIP string, newNodePort string, newNodeBusPort ...string) (string, error) {
+	args := []string{"cluster", "meet", newNodeIP, newNodePort}
 	if len(newNodeBusPort) != 0 {
 		args = append(args, newNodeBusPort[0])
 	}
-	stdout, stderr, err := r.executeCommand(args)
-	if err != nil || strings.TrimSpace(stderr) != "" || strings.TrimSpace(stdout) != "OK" {
-		return stdout, errors.Errorf("Failed to execute CLUSTER MEET (%s, %s, %s, %v): %s | %s | %v", nodeIP, newNodeIP, newNodePort, newNodeBusPort, stdout, stderr, err)
+	reply, err := r.executeOKCommand(ctx, nodeIP, args...)
+	if err != nil {
+		return reply, errors.Wrapf(err, "Failed to execute CLUSTER MEET (%s, %s, %s, %v)", nodeIP, newNodeIP, newNodePort, newNodeBusPort)
 	}
-	return stdout, nil
+	return reply, nil
 }
 
 // https://redis.io/commands/cluster-reset
-func (r *RedisCLI) ClusterReset(nodeIP string, opt ...string) (string, error) {
-	args := []string{"-h", nodeIP, "cluster", "reset"}
+func (r *RedisCLI) ClusterReset(ctx context.Context, nodeIP string, opt ...string) (string, error) {
+	args := []string{"cluster", "reset"}
 	if len(opt) != 0 {
 		if strings.ToLower(opt[0]) != "hard" && strings.ToLower(opt[0]) != "soft" {
 			r.Log.Info(fmt.Sprintf("Warning: CLUSTER RESET called with wrong option - %s", opt[0]))
@@ -258,16 +477,16 @@ func (r *RedisCLI) ClusterReset(nodeIP string, opt ...string) (string, error) {
 			args = append(args, opt[0])
 		}
 	}
-	stdout, stderr, err := r.executeCommand(args)
-	if err != nil || strings.TrimSpace(stderr) != "" || strings.TrimSpace(stdout) != "OK" {
-		return stdout, errors.Errorf("Failed to execute CLUSTER RESET (%s, %v): %s | %s | %v", nodeIP, opt, stdout, stderr, err)
+	reply, err := r.executeOKCommand(ctx, nodeIP, args...)
+	if err != nil {
+		return reply, errors.Wrapf(err, "Failed to execute CLUSTER RESET (%s, %v)", nodeIP, opt)
 	}
-	return stdout, nil
+	return reply, nil
 }
 
 // https://redis.io/commands/flushall
-func (r *RedisCLI) Flushall(nodeIP string, opt ...string) (string, error) {
-	args := []string{"-h", nodeIP, "flushall"}
+func (r *RedisCLI) Flushall(ctx context.Context, nodeIP string, opt ...string) (string, error) {
+	args := []string{"flushall"}
 	if len(opt) != 0 {
 		if strings.ToLower(opt[0]) != "async" {
 			r.Log.Info(fmt.Sprintf("Warning: FLUSHALL called with wrong option - %s", opt[0]))
@@ -275,19 +494,154 @@ func (r *RedisCLI) Flushall(nodeIP string, opt ...string) (string, error) {
 			args = append(args, opt[0])
 		}
 	}
-	stdout, stderr, err := r.executeCommand(args)
-	if err != nil || strings.TrimSpace(stderr) != "" || IsError(strings.TrimSpace(stdout)) {
-		return stdout, errors.Errorf("Failed to execute FLUSHALL (%s, %v): %s | %s | %v", nodeIP, opt, stdout, stderr, err)
+	reply, err := r.executeOKCommand(ctx, nodeIP, args...)
+	if err != nil {
+		return reply, errors.Wrapf(err, "Failed to execute FLUSHALL (%s, %v)", nodeIP, opt)
 	}
-	return stdout, nil
+	return reply, nil
 }
 
 // https://redis.io/commands/cluster-replicate
-func (r *RedisCLI) ClusterReplicate(nodeIP string, leaderID string) (string, error) {
-	args := []string{"-h", nodeIP, "cluster", "replicate", leaderID}
-	stdout, stderr, err := r.executeCommand(args)
-	if err != nil || strings.TrimSpace(stderr) != "" || strings.TrimSpace(stdout) != "OK" {
-		return stdout, errors.Errorf("Failed to execute CLUSTER REPLICATE (%s, %s): %s | %s | %v", nodeIP, leaderID, stdout, stderr, err)
+func (r *RedisCLI) ClusterReplicate(ctx context.Context, nodeIP string, leaderID string) (string, error) {
+	reply, err := r.executeOKCommand(ctx, nodeIP, "cluster", "replicate", leaderID)
+	if err != nil {
+		return reply, errors.Wrapf(err, "Failed to execute CLUSTER REPLICATE (%s, %s)", nodeIP, leaderID)
+	}
+	return reply, nil
+}
+
+// https://redis.io/commands/config-set
+func (r *RedisCLI) ConfigSet(ctx context.Context, nodeIP string, parameter string, value string) (string, error) {
+	reply, err := r.executeOKCommand(ctx, nodeIP, "config", "set", parameter, value)
+	if err != nil {
+		return reply, errors.Wrapf(err, "Failed to execute CONFIG SET (%s, %s, %s)", nodeIP, parameter, value)
+	}
+	return reply, nil
+}
+
+// https://redis.io/commands/cluster-addslots
+func (r *RedisCLI) ClusterAddSlots(ctx context.Context, nodeIP string, slots ...int) (string, error) {
+	args := []string{"cluster", "addslots"}
+	for _, slot := range slots {
+		args = append(args, strconv.Itoa(slot))
+	}
+	reply, err := r.executeOKCommand(ctx, nodeIP, args...)
+	if err != nil {
+		return reply, errors.Wrapf(err, "Failed to execute CLUSTER ADDSLOTS (%s, %d slots)", nodeIP, len(slots))
+	}
+	return reply, nil
+}
+
+// https://redis.io/commands/cluster-setslot
+// subcommand: 'importing', 'migrating' and 'node' take the ID of a node, 'stable' takes none
+func (r *RedisCLI) ClusterSetSlot(ctx context.Context, nodeIP string, slot int, subcommand string, nodeID ...string) (string, error) {
+	args := []string{"cluster", "setslot", strconv.Itoa(slot), subcommand}
+	if len(nodeID) != 0 {
+		args = append(args, nodeID[0])
+	}
+	reply, err := r.executeOKCommand(ctx, nodeIP, args...)
+	if err != nil {
+		return reply, errors.Wrapf(err, "Failed to execute CLUSTER SETSLOT (%s, %d, %s, %v)", nodeIP, slot, subcommand, nodeID)
+	}
+	return reply, nil
+}
+
+// https://redis.io/commands/cluster-countkeysinslot
+func (r *RedisCLI) ClusterCountKeysInSlot(ctx context.Context, nodeIP string, slot int) (int64, error) {
+	reply, err := r.executeCommand(ctx, nodeIP, "cluster", "countkeysinslot", strconv.Itoa(slot))
+	if err != nil {
+		return 0, errors.Wrapf(err, "Failed to execute CLUSTER COUNTKEYSINSLOT (%s, %d)", nodeIP, slot)
+	}
+	count, ok := reply.(int64)
+	if !ok {
+		return 0, errors.Errorf("Failed to execute CLUSTER COUNTKEYSINSLOT (%s, %d): unexpected reply type %T", nodeIP, slot, reply)
+	}
+	return count, nil
+}
+
+// ClusterCountKeysInSlots counts the keys of the slots with CLUSTER
+// COUNTKEYSINSLOT commands pipelined by batches of pipelineBatchSize. The slots
+// whose command failed are missing from the counts and the first of these
+// failures is returned along with the counts of the other slots. The counts
+// are nil when the node could not be reached.
+func (r *RedisCLI) ClusterCountKeysInSlots(ctx context.Context, nodeIP string, slots ...int) (map[int]int64, error) {
+	counts := make(map[int]int64, len(slots))
+	var slotErr error
+	for start := 0; start < len(slots); start += pipelineBatchSize {
+		end := start + pipelineBatchSize
+		if end > len(slots) {
+			end = len(slots)
+		}
+		batch := slots[start:end]
+		commands := make([][]string, len(batch))
+		for i, slot := range batch {
+			commands[i] = []string{"cluster", "countkeysinslot", strconv.Itoa(slot)}
+		}
+		replies, err := r.executePipeline(ctx, nodeIP, commands...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to execute CLUSTER COUNTKEYSINSLOT (%s, %d slots)", nodeIP, len(slots))
+		}
+		for i, reply := range replies {
+			switch value := reply.(type) {
+			case int64:
+				counts[batch[i]] = value
+				continue
+			case ReplyError:
+				err = NewRedisError(nodeAddr(ctx, nodeIP), "CLUSTER COUNTKEYSINSLOT", string(value))
+			default:
+				err = errors.Errorf("unexpected reply type %T", reply)
+			}
+			if slotErr == nil {
+				slotErr = errors.Wrapf(err, "Failed to execute CLUSTER COUNTKEYSINSLOT (%s, %d)", nodeIP, batch[i])
+			}
+		}
+	}
+	return counts, slotErr
+}
+
+// https://redis.io/commands/cluster-getkeysinslot
+func (r *RedisCLI) ClusterGetKeysInSlot(ctx context.Context, nodeIP string, slot int, count int) ([]string, error) {
+	reply, err := r.executeCommand(ctx, nodeIP, "cluster", "getkeysinslot", strconv.Itoa(slot), strconv.Itoa(count))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to execute CLUSTER GETKEYSINSLOT (%s, %d, %d)", nodeIP, slot, count)
+	}
+	values, ok := reply.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("Failed to execute CLUSTER GETKEYSINSLOT (%s, %d, %d): unexpected reply type %T", nodeIP, slot, count, reply)
+	}
+	keys := make([]string, 0, len(values))
+	for _, value := range values {
+		if key, ok := value.(string); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Migrate moves keys from a node to another one; the reply is NOKEY when none
+// of the keys exists on the source node. Keys already on the target are
+// replaced, so that a batch retried after a partial move does not fail with
+// BUSYKEY, and the source node authenticates on the target with the
+// credentials of the client.
+// https://redis.io/commands/migrate
+func (r *RedisCLI) Migrate(ctx context.Context, nodeIP string, targetIP string, keys []string, timeout time.Duration) (string, error) {
+	host, port, _ := ResolveNodeAddress(ctx, targetIP)
+	args := []string{"migrate", host, port, "", "0", strconv.FormatInt(timeout.Milliseconds(), 10), "replace"}
+	if r.Options.Password != "" {
+		if r.Options.Username != "" {
+			args = append(args, "auth2", r.Options.Username, r.Options.Password)
+		} else {
+			args = append(args, "auth", r.Options.Password)
+		}
+	}
+	args = append(args, "keys")
+	args = append(args, keys...)
+	reply, err := r.executeStringCommand(ctx, nodeIP, args...)
+	if err != nil {
+		return reply, errors.Wrapf(err, "Failed to execute MIGRATE (%s, %s, %d keys)", nodeIP, targetIP, len(keys))
+	}
+	if reply != "OK" && reply != "NOKEY" {
+		return reply, errors.Errorf("Failed to execute MIGRATE (%s, %s, %d keys): unexpected reply %s", nodeIP, targetIP, len(keys), reply)
 	}
-	return stdout, nil
+	return reply, nil
 }
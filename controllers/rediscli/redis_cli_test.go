@@ -0,0 +1,54 @@
+package rediscli
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	logrtesting "github.com/go-logr/logr/testing"
+)
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  RedisCLIOptions
+		auth     []string
+		expected []string
+	}{
+		{
+			name:     "no password",
+			expected: []string{"migrate", "10.0.0.2", "6379", "", "0", "1000", "replace", "keys", "a", "b"},
+		},
+		{
+			name:     "password",
+			options:  RedisCLIOptions{Password: "secret"},
+			auth:     []string{"AUTH", "secret"},
+			expected: []string{"migrate", "10.0.0.2", "6379", "", "0", "1000", "replace", "auth", "secret", "keys", "a", "b"},
+		},
+		{
+			name:     "username and password",
+			options:  RedisCLIOptions{Username: "operator", Password: "secret"},
+			auth:     []string{"AUTH", "operator", "secret"},
+			expected: []string{"migrate", "10.0.0.2", "6379", "", "0", "1000", "replace", "auth2", "operator", "secret", "keys", "a", "b"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := newFakeNode(t, func(args []string) string {
+				return "+OK\r\n"
+			})
+			r := NewRedisCLIWithOptions(logrtesting.NullLogger{}, test.options)
+			reply, err := r.Migrate(context.Background(), node.addr(), "10.0.0.2", []string{"a", "b"}, time.Second)
+			if err != nil || reply != "OK" {
+				t.Fatalf("Unexpected MIGRATE result %q: %v", reply, err)
+			}
+			if auth := node.received("auth"); test.auth == nil && len(auth) != 0 || test.auth != nil && (len(auth) != 1 || !reflect.DeepEqual(auth[0], test.auth)) {
+				t.Errorf("Unexpected AUTH commands %q", auth)
+			}
+			if migrate := node.received("migrate"); len(migrate) != 1 || !reflect.DeepEqual(migrate[0], test.expected) {
+				t.Errorf("Unexpected MIGRATE commands %q, expected %q", migrate, test.expected)
+			}
+		})
+	}
+}
@@ -1,11 +1,16 @@
 package rediscli
 
 import (
-	"regexp"
+	"math"
+	"strconv"
 	"strings"
 )
 
 // https://redis.io/commands/info
+// The well known sections have their own field; all the sections, including the
+// ones added by newer Redis versions (Errorstats, Latencystats...), are kept in
+// Sections indexed by the name in their header. Fields that come before any
+// header are kept in the section with the empty name.
 type RedisInfo struct {
 	Server      map[string]string
 	Clients     map[string]string
@@ -17,144 +22,253 @@ type RedisInfo struct {
 	Cluster     map[string]string
 	Modules     map[string]string
 	Keyspace    map[string]string
-}
-
-// https://redis.io/commands/cluster-info
-type RedisClusterInfo map[string]string
 
-// RedisClusterNodes command: https://redis.io/commands/cluster-nodes
-type RedisClusterNodes []RedisClusterNode
+	Sections map[string]map[string]string
+}
 
-type RedisClusterNode struct {
-	ID          string
-	Addr        string
-	Flags       string
-	Leader      string
-	PingSend    string
-	PingRecv    string
-	ConfigEpoch string
-	LinkState   string
+// ReplicaInfo is the state of a replica as reported by its master in the
+// slaveN fields of the Replication section
+type ReplicaInfo struct {
+	IP     string
+	Port   int
+	State  string
+	Offset int64
+	Lag    int64
 }
 
 func NewRedisInfo(rawInfo string) *RedisInfo {
-	var currentInfo *map[string]string
-	var currentInfoLabel string
 	if rawInfo == "" {
 		return nil
 	}
-	lines := strings.Split(rawInfo, "\n")
-	info := RedisInfo{}
-	for _, line := range lines {
+	info := RedisInfo{Sections: make(map[string]map[string]string)}
+	currentSection := ""
+	for _, line := range strings.Split(rawInfo, "\n") {
 		line = strings.TrimSpace(line)
-		if line != "" {
-			if line[0] == '#' {
-				currentInfoLabel = strings.TrimSpace(line[2:])
-				switch currentInfoLabel {
-				case "Server":
-					info.Server = make(map[string]string)
-					currentInfo = &info.Server
-				case "Clients":
-					info.Clients = make(map[string]string)
-					currentInfo = &info.Clients
-				case "Memory":
-					info.Memory = make(map[string]string)
-					currentInfo = &info.Memory
-				case "Persistence":
-					info.Persistence = make(map[string]string)
-					currentInfo = &info.Persistence
-				case "Stats":
-					info.Stats = make(map[string]string)
-					currentInfo = &info.Stats
-				case "Replication":
-					info.Replication = make(map[string]string)
-					currentInfo = &info.Replication
-				case "CPU":
-					info.CPU = make(map[string]string)
-					currentInfo = &info.CPU
-				case "Modules":
-					info.Modules = make(map[string]string)
-					currentInfo = &info.Modules
-				case "Cluster":
-					info.Cluster = make(map[string]string)
-					currentInfo = &info.Cluster
-				case "Keyspace":
-					info.Keyspace = make(map[string]string)
-					currentInfo = &info.Keyspace
-				}
-			} else {
-				lineInfo := strings.Split(line, ":")
-				(*currentInfo)[lineInfo[0]] = lineInfo[1]
-			}
+		if line == "" {
+			continue
+		}
+		if line[0] == '#' {
+			currentSection = strings.TrimSpace(line[1:])
+			info.section(currentSection)
+			continue
 		}
+		// only the first colon separates the key, values may contain colons (IPv6 addresses)
+		key, value := line, ""
+		if i := strings.IndexByte(line, ':'); i != -1 {
+			key, value = line[:i], line[i+1:]
+		}
+		info.section(currentSection)[key] = value
 	}
 	return &info
 }
 
-func NewRedisClusterInfo(rawData string) *RedisClusterInfo {
-	if rawData == "" {
-		return nil
+// Returns the fields of a section, creating the section if it is missing
+func (r *RedisInfo) section(name string) map[string]string {
+	if fields, found := r.Sections[name]; found {
+		return fields
+	}
+	fields := make(map[string]string)
+	r.Sections[name] = fields
+	switch name {
+	case "Server":
+		r.Server = fields
+	case "Clients":
+		r.Clients = fields
+	case "Memory":
+		r.Memory = fields
+	case "Persistence":
+		r.Persistence = fields
+	case "Stats":
+		r.Stats = fields
+	case "Replication":
+		r.Replication = fields
+	case "CPU":
+		r.CPU = fields
+	case "Modules":
+		r.Modules = fields
+	case "Cluster":
+		r.Cluster = fields
+	case "Keyspace":
+		r.Keyspace = fields
 	}
+	return fields
+}
 
-	info := RedisClusterInfo{}
-	lines := strings.Split(rawData, "\r\n")
-	for _, line := range lines {
-		lineInfo := strings.Split(line, ":")
-		if len(lineInfo) < 2 {
-			return nil
+// Get returns the value of a field of a section
+func (r *RedisInfo) Get(section string, key string) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	value, found := r.Sections[section][key]
+	return value, found
+}
+
+// Int returns the value of a numeric field; ok is false if the field is missing
+// or is not an integer
+func (r *RedisInfo) Int(section string, key string) (int64, bool) {
+	value, found := r.Get(section, key)
+	if !found {
+		return 0, false
+	}
+	number, err := strconv.ParseInt(value, 10, 64)
+	return number, err == nil
+}
+
+// Float returns the value of a decimal field like master_sync_perc
+func (r *RedisInfo) Float(section string, key string) (float64, bool) {
+	value, found := r.Get(section, key)
+	if !found {
+		return 0, false
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	return number, err == nil && !math.IsNaN(number) && !math.IsInf(number, 0)
+}
+
+// Returns true if the field is a flag set to 1
+func (r *RedisInfo) flag(section string, key string) bool {
+	value, _ := r.Int(section, key)
+	return value == 1
+}
+
+// UsedMemory returns the number of bytes allocated by Redis
+func (r *RedisInfo) UsedMemory() (int64, bool) {
+	return r.Int("Memory", "used_memory")
+}
+
+// MaxMemory returns the memory limit in bytes; 0 means no limit
+func (r *RedisInfo) MaxMemory() (int64, bool) {
+	return r.Int("Memory", "maxmemory")
+}
+
+// Role returns master or slave
+func (r *RedisInfo) Role() string {
+	role, _ := r.Get("Replication", "role")
+	return role
+}
+
+// MasterReplOffset returns the replication offset of the node; on a replica
+// it is the offset of the master it has processed
+func (r *RedisInfo) MasterReplOffset() (int64, bool) {
+	return r.Int("Replication", "master_repl_offset")
+}
+
+// SlaveReplOffset returns the replication offset processed by a replica
+func (r *RedisInfo) SlaveReplOffset() (int64, bool) {
+	return r.Int("Replication", "slave_repl_offset")
+}
+
+// IsMasterLinkUp returns true if the node is a replica connected to its master
+func (r *RedisInfo) IsMasterLinkUp() bool {
+	status, _ := r.Get("Replication", "master_link_status")
+	return r.Role() == "slave" && status == "up"
+}
+
+// IsSyncing returns true if the node is a replica receiving the dataset of its master
+func (r *RedisInfo) IsSyncing() bool {
+	return r.Role() == "slave" && r.flag("Replication", "master_sync_in_progress")
+}
+
+// IsLoading returns true if the node is loading a dump file
+func (r *RedisInfo) IsLoading() bool {
+	return r.flag("Persistence", "loading")
+}
+
+// IsSaving returns true if an RDB save or an AOF rewrite is in progress
+func (r *RedisInfo) IsSaving() bool {
+	return r.flag("Persistence", "rdb_bgsave_in_progress") || r.flag("Persistence", "aof_rewrite_in_progress")
+}
+
+// Replicas returns the replicas reported by a master, in the slaveN order.
+// Malformed entries are skipped.
+func (r *RedisInfo) Replicas() []ReplicaInfo {
+	var replicas []ReplicaInfo
+	if r == nil {
+		return replicas
+	}
+	for i := 0; ; i++ {
+		value, found := r.Sections["Replication"]["slave"+strconv.Itoa(i)]
+		if !found {
+			return replicas
+		}
+		if replica, ok := parseReplicaInfo(value); ok {
+			replicas = append(replicas, replica)
 		}
-		info[lineInfo[0]] = lineInfo[1]
 	}
-	return &info
 }
 
-// NewRedisClusterNodes is a constructor for RedisClusterNodes
-func NewRedisClusterNodes(rawData string) *RedisClusterNodes {
-	nodes := RedisClusterNodes{}
-	nodeLines := strings.Split(rawData, "\n")
-	for _, nodeLine := range nodeLines {
-		nodeLine := strings.TrimSpace(nodeLine)
-		nodeInfo := strings.Split(nodeLine, " ")
-		if strings.Contains(nodeInfo[0], ")") { // special case for CLUSTER REPLICAS output
-			nodeInfo = nodeInfo[1:]
+// Parses ip=10.0.0.2,port=6379,state=online,offset=1234,lag=0
+func parseReplicaInfo(value string) (ReplicaInfo, bool) {
+	var replica ReplicaInfo
+	for _, field := range strings.Split(value, ",") {
+		keyValue := strings.SplitN(field, "=", 2)
+		if len(keyValue) != 2 {
+			return replica, false
 		}
-		if len(nodeInfo) >= 8 {
-			nodes = append(nodes, RedisClusterNode{
-				ID:          nodeInfo[0],
-				Addr:        nodeInfo[1],
-				Flags:       nodeInfo[2],
-				Leader:      nodeInfo[3],
-				PingSend:    nodeInfo[4],
-				PingRecv:    nodeInfo[5],
-				ConfigEpoch: nodeInfo[6],
-				LinkState:   nodeInfo[7],
-			})
+		var err error
+		switch keyValue[0] {
+		case "ip":
+			replica.IP = keyValue[1]
+		case "port":
+			replica.Port, err = strconv.Atoi(keyValue[1])
+		case "state":
+			replica.State = keyValue[1]
+		case "offset":
+			replica.Offset, err = strconv.ParseInt(keyValue[1], 10, 64)
+		case "lag":
+			replica.Lag, err = strconv.ParseInt(keyValue[1], 10, 64)
+		}
+		if err != nil {
+			return replica, false
+		}
+	}
+	return replica, replica.IP != ""
+}
 
+// KeyCount returns the number of keys of all the databases
+func (r *RedisInfo) KeyCount() int64 {
+	var count int64
+	if r == nil {
+		return count
+	}
+	for _, value := range r.Sections["Keyspace"] {
+		// db0:keys=1,expires=0,avg_ttl=0
+		for _, field := range strings.Split(value, ",") {
+			if keys := strings.TrimPrefix(field, "keys="); keys != field {
+				if n, err := strconv.ParseInt(keys, 10, 64); err == nil && n > 0 {
+					count += n
+				}
+			}
 		}
 	}
-	return &nodes
+	return count
 }
 
-// IsFailing method return true when the current redis node is in failing state
-// and needs to be forgotten by the cluster
-func (r *RedisClusterNode) IsFailing() bool {
-	match, err := regexp.MatchString(".*fail!?\\z", r.Flags)
+// https://redis.io/commands/cluster-info
+type RedisClusterInfo map[string]string
 
-	if err != nil {
-		return false
+func NewRedisClusterInfo(rawData string) *RedisClusterInfo {
+	if rawData == "" {
+		return nil
 	}
 
-	return match
+	info := RedisClusterInfo{}
+	lines := strings.Split(rawData, "\r\n")
+	for _, line := range lines {
+		lineInfo := strings.SplitN(line, ":", 2)
+		if len(lineInfo) < 2 {
+			return nil
+		}
+		info[lineInfo[0]] = lineInfo[1]
+	}
+	return &info
 }
 
 // Returns the estimated completion percentage or the empty string if SYNC is
 // not in progress
 func (r *RedisInfo) GetSyncStatus() string {
-	if r.Replication["role"] == "slave" {
-		if r.Replication["master_sync_in_progress"] != "0" {
-			p, found := r.Replication["master_sync_perc"]
-			if found {
-				return p
-			}
+	if r.IsSyncing() {
+		if p, found := r.Get("Replication", "master_sync_perc"); found {
+			return p
 		}
 	}
 	return ""
@@ -167,34 +281,10 @@ func (r *RedisClusterInfo) IsClusterFail() bool {
 // GetLoadETA indicating if the load of a dump file is on-going
 // If a load operation is on-going, it returns the ETA to finish.
 func (r *RedisInfo) GetLoadETA() string {
-	if r.Persistence["loading"] != "0" {
-		eta, found := r.Persistence["loading_eta_seconds"]
-		if found {
+	if r.IsLoading() {
+		if eta, found := r.Get("Persistence", "loading_eta_seconds"); found {
 			return eta
 		}
 	}
 	return ""
 }
-
-// Returns the IP and port for a given Redis ID or empty strings if ID not found
-func (r *RedisClusterNodes) GetIPForID(id string) (string, string) {
-	for _, info := range *r {
-		if info.ID == id {
-			ipPort := strings.Split(info.Addr, ":")
-			return ipPort[0], ipPort[1]
-		}
-	}
-	return "", ""
-}
-
-// Returns the Redis node ID for a specified IP or empty string if IP not found
-// Supports the IP and IP:port format
-func (r *RedisClusterNodes) GetIDForIP(ip string) string {
-	ipPort := strings.Split(ip, ":")
-	for _, info := range *r {
-		if strings.Split(info.Addr, ":")[0] == ipPort[0] {
-			return info.ID
-		}
-	}
-	return ""
-}
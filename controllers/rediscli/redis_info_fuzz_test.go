@@ -0,0 +1,54 @@
+//go:build go1.18
+// +build go1.18
+
+package rediscli
+
+import (
+	"strconv"
+	"testing"
+)
+
+func FuzzNewRedisInfo(f *testing.F) {
+	f.Add(infoOutput)
+	f.Add("# Replication\nrole:slave\nmaster_link_status:up\nmaster_sync_in_progress:1\nmaster_sync_perc:12.5\nslave_repl_offset:1\n")
+	f.Add("role:master\n#\n# Replication\nslave0:ip=10.0.0.2,port=abc\n")
+	f.Fuzz(func(t *testing.T, raw string) {
+		info := NewRedisInfo(raw)
+		if info == nil {
+			if raw != "" {
+				t.Fatalf("No info parsed from %q", raw)
+			}
+			return
+		}
+		for section, fields := range info.Sections {
+			for key, value := range fields {
+				if got, found := info.Get(section, key); !found || got != value {
+					t.Fatalf("Get(%q, %q) = %q, %t, expected %q", section, key, got, found, value)
+				}
+				if number, ok := info.Int(section, key); ok && strconv.FormatInt(number, 10) != value && "+"+strconv.FormatInt(number, 10) != value {
+					if parsed, err := strconv.ParseInt(value, 10, 64); err != nil || parsed != number {
+						t.Fatalf("Int(%q, %q) = %d for %q", section, key, number, value)
+					}
+				}
+			}
+		}
+		info.UsedMemory()
+		info.MaxMemory()
+		info.MasterReplOffset()
+		info.SlaveReplOffset()
+		info.IsMasterLinkUp()
+		info.IsSyncing()
+		info.IsLoading()
+		info.IsSaving()
+		info.GetSyncStatus()
+		info.GetLoadETA()
+		for _, replica := range info.Replicas() {
+			if replica.IP == "" {
+				t.Fatalf("Replica without IP parsed from %q", raw)
+			}
+		}
+		if info.KeyCount() < 0 {
+			t.Fatalf("Negative key count parsed from %q", raw)
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package rediscli
+
+import (
+	"reflect"
+	"testing"
+)
+
+const infoOutput = "# Server\r\nredis_version:7.0.5\r\nexecutable:/usr/local/bin/redis-server\r\n\r\n" +
+	"# Memory\r\nused_memory:1048576\r\nmaxmemory:2147483648\r\nmaxmemory_human:2.00G\r\n\r\n" +
+	"# Persistence\r\nloading:0\r\nrdb_bgsave_in_progress:1\r\naof_rewrite_in_progress:0\r\n\r\n" +
+	"# Replication\r\nrole:master\r\nconnected_slaves:2\r\n" +
+	"slave0:ip=10.0.0.2,port=6379,state=online,offset=1000,lag=0\r\n" +
+	"slave1:ip=fd00::a:3,port=7000,state=wait_bgsave,offset=0,lag=1\r\n" +
+	"master_repl_offset:1024\r\n\r\n" +
+	"# Errorstats\r\nerrorstat_ERR:count=3\r\nerrorstat_MOVED:count=12\r\n\r\n" +
+	"# Latencystats\r\nlatency_percentiles_usec_get:p50=1.003,p99=3.007,p99.9=12.031\r\n\r\n" +
+	"# Keyspace\r\ndb0:keys=10,expires=0,avg_ttl=0\r\ndb1:keys=5,expires=1,avg_ttl=100\r\n"
+
+func TestNewRedisInfo(t *testing.T) {
+	info := NewRedisInfo(infoOutput)
+	if info.Server["executable"] != "/usr/local/bin/redis-server" || info.Role() != "master" {
+		t.Errorf("Unexpected server and replication sections %v %v", info.Server, info.Replication)
+	}
+	if used, ok := info.UsedMemory(); !ok || used != 1048576 {
+		t.Errorf("Unexpected used memory %d", used)
+	}
+	if max, ok := info.MaxMemory(); !ok || max != 2147483648 {
+		t.Errorf("Unexpected max memory %d", max)
+	}
+	if _, ok := info.Int("Memory", "maxmemory_human"); ok {
+		t.Errorf("A human readable value was parsed as an integer")
+	}
+	if offset, ok := info.MasterReplOffset(); !ok || offset != 1024 {
+		t.Errorf("Unexpected replication offset %d", offset)
+	}
+	if _, ok := info.SlaveReplOffset(); ok {
+		t.Errorf("Unexpected replica offset on a master")
+	}
+	if info.IsLoading() || !info.IsSaving() || info.IsSyncing() || info.IsMasterLinkUp() {
+		t.Errorf("Unexpected persistence and replication flags %v %v", info.Persistence, info.Replication)
+	}
+	expected := []ReplicaInfo{
+		{IP: "10.0.0.2", Port: 6379, State: "online", Offset: 1000},
+		{IP: "fd00::a:3", Port: 7000, State: "wait_bgsave", Lag: 1},
+	}
+	if replicas := info.Replicas(); !reflect.DeepEqual(replicas, expected) {
+		t.Errorf("Unexpected replicas %+v", replicas)
+	}
+	if value, _ := info.Get("Errorstats", "errorstat_MOVED"); value != "count=12" {
+		t.Errorf("Unexpected error stats %v", info.Sections["Errorstats"])
+	}
+	if value, _ := info.Get("Latencystats", "latency_percentiles_usec_get"); value != "p50=1.003,p99=3.007,p99.9=12.031" {
+		t.Errorf("Unexpected latency stats %v", info.Sections["Latencystats"])
+	}
+	if keys := info.KeyCount(); keys != 15 {
+		t.Errorf("Unexpected key count %d", keys)
+	}
+}
+
+func TestNewRedisInfoReplica(t *testing.T) {
+	info := NewRedisInfo("# Replication\nrole:slave\nmaster_host:10.0.0.1\nmaster_link_status:up\n" +
+		"master_sync_in_progress:1\nmaster_sync_perc:42.50\nslave_repl_offset:900\n\n# Persistence\nloading:1\nloading_eta_seconds:7\n")
+	if !info.IsMasterLinkUp() || !info.IsSyncing() || info.GetSyncStatus() != "42.50" {
+		t.Errorf("Unexpected replication state %v", info.Replication)
+	}
+	if perc, ok := info.Float("Replication", "master_sync_perc"); !ok || perc != 42.5 {
+		t.Errorf("Unexpected sync percentage %f", perc)
+	}
+	if offset, ok := info.SlaveReplOffset(); !ok || offset != 900 {
+		t.Errorf("Unexpected replica offset %d", offset)
+	}
+	if !info.IsLoading() || info.GetLoadETA() != "7" {
+		t.Errorf("Unexpected persistence state %v", info.Persistence)
+	}
+}
+
+func TestNewRedisInfoMalformed(t *testing.T) {
+	info := NewRedisInfo("role:master\n#\n# Replication\nslave0:ip=10.0.0.2,port=abc\nslave1:garbage\nbroken\n")
+	if value, _ := info.Get("", "role"); value != "master" {
+		t.Errorf("The field before the first header was lost: %v", info.Sections)
+	}
+	if replicas := info.Replicas(); len(replicas) != 0 {
+		t.Errorf("Malformed replicas were parsed: %+v", replicas)
+	}
+	if _, found := info.Get("Replication", "broken"); !found {
+		t.Errorf("The field without value was lost: %v", info.Replication)
+	}
+	if NewRedisInfo("") != nil {
+		t.Errorf("Expected no info for an empty reply")
+	}
+	var missing *RedisInfo
+	if missing.Role() != "" || missing.IsLoading() || missing.KeyCount() != 0 || len(missing.Replicas()) != 0 {
+		t.Errorf("Unexpected values for a missing info")
+	}
+}
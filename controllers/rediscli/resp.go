@@ -0,0 +1,355 @@
+package rediscli
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ReplyError is an error reply sent by the Redis server (RESP '-' type).
+// The connection that received it is still usable.
+type ReplyError string
+
+func (e ReplyError) Error() string {
+	return string(e)
+}
+
+// redisConn is a single RESP connection to a Redis node
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+	broken bool
+	// idleSince is the time the connection was given back to its pool
+	idleSince time.Time
+}
+
+// nodePool keeps idle connections to a single Redis node
+type nodePool struct {
+	addr string
+	idle chan *redisConn
+	// lastUsed is the last time a connection was taken from or given back to
+	// the pool
+	lastUsed time.Time
+}
+
+func (r *RedisCLI) dial(ctx context.Context, addr string) (*redisConn, error) {
+	dialer := &net.Dialer{Timeout: r.Options.DialTimeout}
+	var conn net.Conn
+	var err error
+	if r.Options.TLSConfig != nil {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: r.Options.TLSConfig}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn)}
+	if r.Options.Password != "" {
+		args := []string{"AUTH", r.Options.Password}
+		if r.Options.Username != "" {
+			args = []string{"AUTH", r.Options.Username, r.Options.Password}
+		}
+		if _, err := c.do(ctx, args...); err != nil {
+			conn.Close()
+			if replyErr, isReplyErr := err.(ReplyError); isReplyErr {
+				err = NewRedisError(addr, "AUTH", string(replyErr))
+			}
+			return nil, errors.Wrapf(err, "Failed to authenticate on %s", addr)
+		}
+	}
+	return c, nil
+}
+
+// Returns an idle connection to the node, or a new one. The boolean is true
+// if the connection comes from the pool and may have been closed by the node.
+func (r *RedisCLI) getConn(ctx context.Context, addr string) (*redisConn, bool, error) {
+	if c := r.takeIdleConn(addr); c != nil {
+		return c, true, nil
+	}
+	c, err := r.dial(ctx, addr)
+	return c, false, err
+}
+
+func (r *RedisCLI) takeIdleConn(addr string) *redisConn {
+	r.poolsLock.Lock()
+	defer r.poolsLock.Unlock()
+	now := time.Now()
+	r.pruneIdleConns(now)
+	pool, found := r.pools[addr]
+	if !found {
+		return nil
+	}
+	for {
+		select {
+		case c := <-pool.idle:
+			if now.Sub(c.idleSince) > r.Options.IdleTimeout {
+				c.conn.Close()
+				continue
+			}
+			pool.lastUsed = now
+			return c
+		default:
+			return nil
+		}
+	}
+}
+
+func (r *RedisCLI) putConn(addr string, c *redisConn) {
+	if c.broken {
+		c.conn.Close()
+		return
+	}
+	r.poolsLock.Lock()
+	defer r.poolsLock.Unlock()
+	pool, found := r.pools[addr]
+	if !found {
+		pool = &nodePool{addr: addr, idle: make(chan *redisConn, r.Options.MaxIdleConns)}
+		r.pools[addr] = pool
+	}
+	c.idleSince = time.Now()
+	pool.lastUsed = c.idleSince
+	select {
+	case pool.idle <- c:
+	default:
+		c.conn.Close()
+	}
+}
+
+// Sends a command on a pooled connection to the address
+func (r *RedisCLI) roundTrip(ctx context.Context, addr string, args ...string) (interface{}, error) {
+	var reply interface{}
+	err := r.withConn(ctx, addr, func(conn *redisConn) error {
+		var err error
+		reply, err = conn.do(ctx, args...)
+		return err
+	})
+	return reply, err
+}
+
+// Sends the commands in a single round trip on a pooled connection to the
+// address, see redisConn.pipeline
+func (r *RedisCLI) pipelineRoundTrip(ctx context.Context, addr string, commands ...[]string) ([]interface{}, error) {
+	var replies []interface{}
+	err := r.withConn(ctx, addr, func(conn *redisConn) error {
+		var err error
+		replies, err = conn.pipeline(ctx, commands...)
+		return err
+	})
+	return replies, err
+}
+
+// Runs an exchange on a pooled connection to the address. When the node
+// closed the idle connection, like on a restart or after its timeout, the
+// exchange is run once more on a new connection.
+func (r *RedisCLI) withConn(ctx context.Context, addr string, exchange func(conn *redisConn) error) error {
+	conn, pooled, err := r.getConn(ctx, addr)
+	if err != nil {
+		return err
+	}
+	err = exchange(conn)
+	r.putConn(addr, conn)
+	if pooled && isClosedConnError(err) && ctx.Err() == nil {
+		if conn, err = r.dial(ctx, addr); err != nil {
+			return err
+		}
+		err = exchange(conn)
+		r.putConn(addr, conn)
+	}
+	return err
+}
+
+// Closes the connections idle for longer than the idle timeout and removes
+// the pools left without connections, so that the pools of the nodes that are
+// gone, like deleted pods, do not pile up. Runs at most once per idle timeout
+// and must be called with the pools lock held.
+func (r *RedisCLI) pruneIdleConns(now time.Time) {
+	if now.Sub(r.lastPrune) < r.Options.IdleTimeout {
+		return
+	}
+	r.lastPrune = now
+	for addr, pool := range r.pools {
+		var kept []*redisConn
+	drain:
+		for {
+			select {
+			case c := <-pool.idle:
+				if now.Sub(c.idleSince) > r.Options.IdleTimeout {
+					c.conn.Close()
+				} else {
+					kept = append(kept, c)
+				}
+			default:
+				break drain
+			}
+		}
+		for _, c := range kept {
+			pool.idle <- c
+		}
+		if len(kept) == 0 && now.Sub(pool.lastUsed) > r.Options.IdleTimeout {
+			delete(r.pools, addr)
+		}
+	}
+}
+
+// Returns true if the error means that the other end closed the connection
+func isClosedConnError(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// do sends a command on the connection and reads its reply. Deadlines and
+// cancellation are taken from the context.
+func (c *redisConn) do(ctx context.Context, args ...string) (interface{}, error) {
+	replies, err := c.pipeline(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	if replyErr, isReplyErr := replies[0].(ReplyError); isReplyErr {
+		return nil, replyErr
+	}
+	return replies[0], nil
+}
+
+// pipeline sends all the commands on the connection before reading their
+// replies in order. Error replies are returned as ReplyError elements of the
+// replies, like the ones nested in arrays. Deadlines and cancellation are
+// taken from the context.
+func (c *redisConn) pipeline(ctx context.Context, commands ...[]string) ([]interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Time{}
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		c.broken = true
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	defer func() {
+		close(done)
+		<-exited
+	}()
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			// unblocks any pending read or write
+			c.conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	for _, args := range commands {
+		c.writeCommand(args)
+	}
+	if err := c.writer.Flush(); err != nil {
+		c.broken = true
+		return nil, c.contextError(ctx, err)
+	}
+	replies := make([]interface{}, len(commands))
+	for i := range replies {
+		reply, err := c.readReply()
+		if replyErr, isReplyErr := err.(ReplyError); isReplyErr {
+			reply, err = replyErr, nil
+		}
+		if err != nil {
+			c.broken = true
+			return nil, c.contextError(ctx, err)
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+func (c *redisConn) contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return errors.Errorf("%v (%v)", ctx.Err(), err)
+	}
+	return err
+}
+
+// Buffers a command; the buffer is flushed when it is full and by pipeline
+func (c *redisConn) writeCommand(args []string) {
+	c.writer.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		c.writer.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		c.writer.WriteString(arg)
+		c.writer.WriteString("\r\n")
+	}
+}
+
+func (c *redisConn) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", errors.Errorf("Malformed RESP line: %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+// readReply parses a single RESP2 reply. Simple strings are returned as
+// string, bulk strings as string, integers as int64, arrays as []interface{}
+// and null replies as nil. An error reply is returned as a ReplyError; error
+// replies nested in arrays are kept as ReplyError elements.
+func (c *redisConn) readReply() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, ReplyError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errors.Errorf("Malformed RESP bulk length: %q", line)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errors.Errorf("Malformed RESP array length: %q", line)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		array := make([]interface{}, size)
+		for i := range array {
+			element, err := c.readReply()
+			if err != nil {
+				if replyErr, isReplyErr := err.(ReplyError); isReplyErr {
+					array[i] = replyErr
+					continue
+				}
+				return nil, err
+			}
+			array[i] = element
+		}
+		return array, nil
+	}
+	return nil, errors.Errorf("Unknown RESP reply type: %q", line)
+}
@@ -0,0 +1,261 @@
+package rediscli
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	logrtesting "github.com/go-logr/logr/testing"
+)
+
+// Returns a connection whose peer reads a command and answers it with the
+// raw reply, along with the command the peer received
+func newPipeConn(t *testing.T, reply string) (*redisConn, <-chan []interface{}) {
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	received := make(chan []interface{}, 1)
+	go func() {
+		peer := &redisConn{conn: server, reader: bufio.NewReader(server), writer: bufio.NewWriter(server)}
+		command, err := peer.readReply()
+		if err != nil {
+			return
+		}
+		received <- command.([]interface{})
+		if reply != "" {
+			server.Write([]byte(reply))
+		}
+	}()
+	return &redisConn{conn: client, reader: bufio.NewReader(client), writer: bufio.NewWriter(client)}, received
+}
+
+// fakeNode is a Redis node listening on localhost that records the commands
+// it receives and answers them with the reply of a handler
+type fakeNode struct {
+	listener net.Listener
+	handler  func(args []string) string
+
+	mu       sync.Mutex
+	commands [][]string
+	conns    []net.Conn
+}
+
+func newFakeNode(t *testing.T, handler func(args []string) string) *fakeNode {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	node := &fakeNode{listener: listener, handler: handler}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			node.mu.Lock()
+			node.conns = append(node.conns, conn)
+			node.mu.Unlock()
+			go node.serve(conn)
+		}
+	}()
+	return node
+}
+
+func (n *fakeNode) serve(conn net.Conn) {
+	defer conn.Close()
+	c := &redisConn{conn: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn)}
+	for {
+		request, err := c.readReply()
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range request.([]interface{}) {
+			args = append(args, arg.(string))
+		}
+		n.mu.Lock()
+		n.commands = append(n.commands, args)
+		n.mu.Unlock()
+		if _, err := conn.Write([]byte(n.handler(args))); err != nil {
+			return
+		}
+	}
+}
+
+// Closes the connections accepted so far, like a restarted node; returns
+// their number
+func (n *fakeNode) closeConns() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, conn := range n.conns {
+		conn.Close()
+	}
+	count := len(n.conns)
+	n.conns = nil
+	return count
+}
+
+func (n *fakeNode) addr() string {
+	return n.listener.Addr().String()
+}
+
+// Returns the commands received with the given name, whatever its case
+func (n *fakeNode) received(name string) [][]string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var commands [][]string
+	for _, args := range n.commands {
+		if strings.EqualFold(args[0], name) {
+			commands = append(commands, args)
+		}
+	}
+	return commands
+}
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name     string
+		reply    string
+		expected interface{}
+		err      error
+	}{
+		{"simple string", "+OK\r\n", "OK", nil},
+		{"error", "-ERR unknown command\r\n", nil, ReplyError("ERR unknown command")},
+		{"integer", ":-42\r\n", int64(-42), nil},
+		{"bulk string", "$5\r\nhe\r\no\r\n", "he\r\no", nil},
+		{"empty bulk string", "$0\r\n\r\n", "", nil},
+		{"null bulk string", "$-1\r\n", nil, nil},
+		{"array", "*3\r\n+a\r\n$1\r\nb\r\n:3\r\n", []interface{}{"a", "b", int64(3)}, nil},
+		{"empty array", "*0\r\n", []interface{}{}, nil},
+		{"null array", "*-1\r\n", nil, nil},
+		{"nested arrays", "*2\r\n*2\r\n:0\r\n:5460\r\n*1\r\n*-1\r\n", []interface{}{[]interface{}{int64(0), int64(5460)}, []interface{}{nil}}, nil},
+		{"error in array", "*2\r\n-MOVED 3999 10.0.0.1:6379\r\n$-1\r\n", []interface{}{ReplyError("MOVED 3999 10.0.0.1:6379"), nil}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, received := newPipeConn(t, test.reply)
+			reply, err := c.do(context.Background(), "GET", "key")
+			if !reflect.DeepEqual(reply, test.expected) || err != test.err {
+				t.Errorf("Reply %#v (%v), expected %#v (%v)", reply, err, test.expected, test.err)
+			}
+			if command := <-received; !reflect.DeepEqual(command, []interface{}{"GET", "key"}) {
+				t.Errorf("Unexpected command %q", command)
+			}
+			if c.broken {
+				t.Errorf("The connection is broken after the reply")
+			}
+		})
+	}
+}
+
+func TestReadMalformedReply(t *testing.T) {
+	for _, reply := range []string{"?\r\n", "+OK\n", "$x\r\n", "*x\r\n", ":x\r\n"} {
+		c, _ := newPipeConn(t, reply)
+		if _, err := c.do(context.Background(), "PING"); err == nil {
+			t.Errorf("No error for the malformed reply %q", reply)
+		}
+	}
+}
+
+func TestCancelRead(t *testing.T) {
+	c, received := newPipeConn(t, "")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := c.do(ctx, "BLPOP", "list", "0")
+		done <- err
+	}()
+	<-received
+	cancel()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("No error for a cancelled command")
+		}
+		if !c.broken {
+			t.Errorf("The connection of a cancelled command is not broken")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Cancelling the context did not unblock the read")
+	}
+}
+
+// A pooled connection closed by the node is replaced by a new one
+func TestRetryOnClosedConn(t *testing.T) {
+	node := newFakeNode(t, func(args []string) string {
+		return "+PONG\r\n"
+	})
+	r := NewRedisCLIWithOptions(logrtesting.NullLogger{}, RedisCLIOptions{})
+	ctx := context.Background()
+	if _, err := r.roundTrip(ctx, node.addr(), "PING"); err != nil {
+		t.Fatalf("PING failed: %v", err)
+	}
+	if closed := node.closeConns(); closed != 1 {
+		t.Fatalf("Expected 1 connection to the node, found %d", closed)
+	}
+	if _, err := r.roundTrip(ctx, node.addr(), "PING"); err != nil {
+		t.Errorf("PING on a closed pooled connection failed: %v", err)
+	}
+	if pings := node.received("ping"); len(pings) != 2 {
+		t.Errorf("Expected 2 PING commands, received %d", len(pings))
+	}
+}
+
+// The idle connections and the pools of the nodes no longer used are removed
+func TestPruneIdleConns(t *testing.T) {
+	handler := func(args []string) string {
+		return "+PONG\r\n"
+	}
+	gone, alive := newFakeNode(t, handler), newFakeNode(t, handler)
+	r := NewRedisCLIWithOptions(logrtesting.NullLogger{}, RedisCLIOptions{IdleTimeout: 10 * time.Millisecond})
+	ctx := context.Background()
+	if _, err := r.roundTrip(ctx, gone.addr(), "PING"); err != nil {
+		t.Fatalf("PING failed: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := r.roundTrip(ctx, alive.addr(), "PING"); err != nil {
+		t.Fatalf("PING failed: %v", err)
+	}
+	r.poolsLock.Lock()
+	defer r.poolsLock.Unlock()
+	if _, found := r.pools[gone.addr()]; found || len(r.pools) != 1 {
+		t.Errorf("The pool of the unused node was not removed: %d pools", len(r.pools))
+	}
+}
+
+// The keys of the slots are counted with pipelined commands; a failed slot
+// does not drop the counts of the others
+func TestClusterCountKeysInSlots(t *testing.T) {
+	node := newFakeNode(t, func(args []string) string {
+		if args[2] == "1500" {
+			return "-ERR Invalid slot\r\n"
+		}
+		return ":" + args[2] + "\r\n"
+	})
+	r := NewRedisCLIWithOptions(logrtesting.NullLogger{}, RedisCLIOptions{})
+	var slots []int
+	for slot := 0; slot < 2500; slot++ {
+		slots = append(slots, slot)
+	}
+	counts, err := r.ClusterCountKeysInSlots(context.Background(), node.addr(), slots...)
+	if err == nil || !strings.Contains(err.Error(), "Invalid slot") {
+		t.Errorf("Expected the error of slot 1500, got %v", err)
+	}
+	if _, found := counts[1500]; found || len(counts) != 2499 || counts[2499] != 2499 {
+		t.Errorf("Unexpected counts of %d slots, slot 2499: %d", len(counts), counts[2499])
+	}
+	if commands := node.received("cluster"); len(commands) != 2500 {
+		t.Errorf("Expected 2500 commands, received %d", len(commands))
+	}
+	if conns := node.closeConns(); conns != 1 {
+		t.Errorf("Expected a single connection to the node, found %d", conns)
+	}
+}
@@ -1,14 +1,17 @@
 package controllers
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 
 	"github.com/pkg/errors"
@@ -19,25 +22,81 @@ import (
 
 var EMPTY struct{}
 
+const (
+	defaultLeaderRestorationInterval = 5 * time.Minute
+	defaultMaxReplicationLag         = 1 << 20
+)
+
 // Representation of a cluster, each element contains information about a leader
 type RedisClusterView []LeaderNode
 
+// LeaderNode is the node that Redis reports as the master of a shard. A shard
+// is the group of pods sharing the same leader-number label; the pod where the
+// node-number is equal to the leader-number is the designated leader, but
+// after a failover any other pod of the shard can be the actual leader.
 type LeaderNode struct {
-	Pod         *corev1.Pod
-	NodeNumber  string
-	RedisID     string
-	Failed      bool
-	Terminating bool
-	Followers   []FollowerNode
+	Pod          *corev1.Pod
+	NodeNumber   string
+	LeaderNumber string
+	RedisID      string
+	Failed       bool
+	Terminating  bool
+	Followers    []FollowerNode
 }
 
+// FollowerNode is any node of a shard that is not its leader. LeaderID is the
+// ID of the master the node replicates according to Redis, it is empty if the
+// node is a master itself.
 type FollowerNode struct {
 	Pod          *corev1.Pod
 	NodeNumber   string
 	LeaderNumber string
 	RedisID      string
+	LeaderID     string
 	Failed       bool
 	Terminating  bool
+	// Surge is true for the temporary followers attached during a failover
+	Surge bool
+}
+
+// IsDesignated returns true if the leader runs on the pod labelled as leader of the shard
+func (l *LeaderNode) IsDesignated() bool {
+	return l.NodeNumber == l.LeaderNumber
+}
+
+// Returns the follower running on the designated leader pod of the shard or nil
+func (l *LeaderNode) DesignatedFollower() *FollowerNode {
+	for i := range l.Followers {
+		if l.Followers[i].NodeNumber == l.LeaderNumber {
+			return &l.Followers[i]
+		}
+	}
+	return nil
+}
+
+// Returns true if at least one follower of the shard is up
+func (l *LeaderNode) hasHealthyFollowers() bool {
+	for _, follower := range l.Followers {
+		if follower.Pod != nil && !follower.Failed && !follower.Terminating {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the healthy followers that Redis does not replicate from the leader
+// of their shard: replicas of another master or masters without followers
+func (l *LeaderNode) MisplacedFollowers() []FollowerNode {
+	var followers []FollowerNode
+	if l.Failed || l.Terminating || l.RedisID == "" {
+		return nil
+	}
+	for _, follower := range l.Followers {
+		if follower.Pod != nil && !follower.Failed && !follower.Terminating && follower.LeaderID != l.RedisID {
+			followers = append(followers, follower)
+		}
+	}
+	return followers
 }
 
 // In-place sort of a cluster view - ascending alphabetical order by node number
@@ -84,23 +143,45 @@ func (v *RedisClusterView) String() string {
 	return result
 }
 
-func (r *RedisClusterReconciler) NewRedisClusterView(redisCluster *dbv1.RedisCluster) (*RedisClusterView, error) {
+// A pod slot of a shard: index 0 is the designated leader, the others are the followers
+type shardMember struct {
+	pod          *corev1.Pod
+	nodeNumber   string
+	leaderNumber string
+	node         *NodeSnapshot
+	myself       *rediscli.RedisClusterNode
+	surge        bool
+}
+
+func (m *shardMember) healthy() bool {
+	return m.pod != nil && m.pod.DeletionTimestamp == nil && m.node.Healthy() && m.myself != nil
+}
+
+func (m *shardMember) isMaster() bool {
+	return m.healthy() && m.myself.IsMaster()
+}
+
+// Builds the cluster view from the roles reported by Redis. The pod labels are
+// only used to group the pods in shards and to know which pods are missing.
+// Healthy surge pods are added as extra members of their shard, the others
+// are left out: they are never recovered.
+func (r *RedisClusterReconciler) NewRedisClusterView(ctx context.Context, redisCluster *dbv1.RedisCluster) (*RedisClusterView, error) {
 	var cv RedisClusterView
 
-	pods, err := r.getRedisClusterPods(redisCluster)
+	snapshot, err := r.getClusterSnapshot(ctx, redisCluster)
 	if err != nil {
 		return nil, err
 	}
+	pods := snapshot.Pods
 
+	shards := make([][]shardMember, redisCluster.Spec.LeaderCount)
 	followerCounter := redisCluster.Spec.LeaderCount
-	for i := 0; i < redisCluster.Spec.LeaderCount; i++ {
-		leader := LeaderNode{Pod: nil, NodeNumber: strconv.Itoa(i), RedisID: "", Failed: true, Terminating: false, Followers: nil}
+	for i := range shards {
+		shards[i] = append(shards[i], shardMember{nodeNumber: strconv.Itoa(i), leaderNumber: strconv.Itoa(i)})
 		for j := 0; j < redisCluster.Spec.LeaderFollowersCount; j++ {
-			follower := FollowerNode{Pod: nil, NodeNumber: strconv.Itoa(followerCounter), LeaderNumber: strconv.Itoa(i), RedisID: "", Failed: true, Terminating: false}
-			leader.Followers = append(leader.Followers, follower)
+			shards[i] = append(shards[i], shardMember{nodeNumber: strconv.Itoa(followerCounter), leaderNumber: strconv.Itoa(i)})
 			followerCounter++
 		}
-		cv = append(cv, leader)
 	}
 
 	for i, pod := range pods {
@@ -112,39 +193,146 @@ func (r *RedisClusterReconciler) NewRedisClusterView(redisCluster *dbv1.RedisClu
 		if err != nil {
 			return nil, errors.Errorf("Failed to parse leader-number label: %s (%s)", pod.Labels["leader-number"], pod.Name)
 		}
-		if nn == ln {
-			cv[ln].Pod = &pods[i]
-			cv[ln].NodeNumber = pod.Labels["node-number"]
-			if pod.ObjectMeta.DeletionTimestamp != nil {
-				cv[ln].Terminating = true
-			} else {
-				if pod.Status.PodIP != "" {
-					clusterInfo, err := r.RedisCLI.ClusterInfo(pod.Status.PodIP)
-					if err == nil && clusterInfo != nil && (*clusterInfo)["cluster_state"] == "ok" {
-						cv[ln].Failed = false
-					}
-				}
+		if isSurgePod(&pod) {
+			if ln < 0 || ln >= len(shards) {
+				return nil, errors.Errorf("Surge pod %s does not belong to the cluster layout (leader %d)", pod.Name, ln)
 			}
-		} else {
-			index := (nn - redisCluster.Spec.LeaderCount) % redisCluster.Spec.LeaderFollowersCount
-			cv[ln].Followers[index].Pod = &pods[i]
-			cv[ln].Followers[index].NodeNumber = pod.Labels["node-number"]
-			cv[ln].Followers[index].LeaderNumber = pod.Labels["leader-number"]
-			if pod.ObjectMeta.DeletionTimestamp != nil {
-				cv[ln].Followers[index].Terminating = true
-			} else {
-				if pod.Status.PodIP != "" {
-					clusterInfo, err := r.RedisCLI.ClusterInfo(pod.Status.PodIP)
-					if err == nil && clusterInfo != nil && (*clusterInfo)["cluster_state"] == "ok" {
-						cv[ln].Followers[index].Failed = false
-					}
-				}
+			member := shardMember{pod: &pods[i], nodeNumber: pod.Labels["node-number"], leaderNumber: pod.Labels["leader-number"], surge: true}
+			if member.node = snapshot.Node(pod.Status.PodIP); member.node != nil && member.node.Nodes != nil {
+				member.myself = member.node.Nodes.Myself()
 			}
+			if member.healthy() {
+				shards[ln] = append(shards[ln], member)
+			}
+			continue
+		}
+		index := 0
+		if nn != ln {
+			if redisCluster.Spec.LeaderFollowersCount == 0 {
+				return nil, errors.Errorf("Unexpected follower pod %s in a cluster without followers", pod.Name)
+			}
+			index = 1 + (nn-redisCluster.Spec.LeaderCount)%redisCluster.Spec.LeaderFollowersCount
+		}
+		if ln < 0 || ln >= len(shards) || index < 0 || shards[ln][index].nodeNumber != pod.Labels["node-number"] {
+			return nil, errors.Errorf("Pod %s does not belong to the cluster layout (node %d, leader %d)", pod.Name, nn, ln)
+		}
+		member := &shards[ln][index]
+		member.pod = &pods[i]
+		if member.node = snapshot.Node(pod.Status.PodIP); member.node != nil && member.node.Nodes != nil {
+			member.myself = member.node.Nodes.Myself()
 		}
 	}
+
+	for _, shard := range shards {
+		cv = append(cv, newLeaderNode(shard, findShardLeader(shard)))
+	}
 	return &cv, nil
 }
 
+// Returns the index of the member that leads the shard. Among the healthy
+// masters the one serving slots wins, then the one with most replicas in the
+// shard, then the designated leader. If the shard has no healthy master the
+// member Redis still considers master is returned, falling back to the first
+// missing or failed member so that it can be recovered.
+func findShardLeader(shard []shardMember) int {
+	replicaCount := make(map[string]int)
+	masterID := ""
+	for _, member := range shard {
+		if member.healthy() && !member.myself.IsMaster() {
+			replicaCount[member.myself.Leader]++
+			masterID = member.myself.Leader
+		}
+	}
+
+	leader := -1
+	for i, member := range shard {
+		if !member.isMaster() {
+			continue
+		}
+		if leader == -1 {
+			leader = i
+			continue
+		}
+		current := shard[leader]
+		if member.myself.HasSlots() != current.myself.HasSlots() {
+			if member.myself.HasSlots() {
+				leader = i
+			}
+			continue
+		}
+		if replicaCount[member.myself.ID] > replicaCount[current.myself.ID] {
+			leader = i
+		}
+	}
+	if leader != -1 {
+		return leader
+	}
+
+	if masterID != "" {
+		for i, member := range shard {
+			if member.node != nil && member.node.ID == masterID {
+				return i
+			}
+		}
+		for _, member := range shard {
+			if !member.healthy() {
+				continue
+			}
+			node := member.node.Nodes.GetNodeByID(masterID)
+			if node == nil {
+				continue
+			}
+			for i := range shard {
+				if shard[i].pod != nil && isPodOfClusterNode(shard[i].pod, node) {
+					return i
+				}
+			}
+		}
+	}
+
+	for i, member := range shard {
+		if !member.healthy() {
+			return i
+		}
+	}
+	return 0
+}
+
+func newLeaderNode(shard []shardMember, leaderIndex int) LeaderNode {
+	leaderMember := shard[leaderIndex]
+	leader := LeaderNode{
+		Pod:          leaderMember.pod,
+		NodeNumber:   leaderMember.nodeNumber,
+		LeaderNumber: leaderMember.leaderNumber,
+		Failed:       !leaderMember.healthy(),
+		Terminating:  leaderMember.pod != nil && leaderMember.pod.DeletionTimestamp != nil,
+	}
+	if leaderMember.node != nil {
+		leader.RedisID = leaderMember.node.ID
+	}
+	for i, member := range shard {
+		if i == leaderIndex {
+			continue
+		}
+		follower := FollowerNode{
+			Pod:          member.pod,
+			NodeNumber:   member.nodeNumber,
+			LeaderNumber: member.leaderNumber,
+			Failed:       !member.healthy(),
+			Terminating:  member.pod != nil && member.pod.DeletionTimestamp != nil,
+			Surge:        member.surge,
+		}
+		if member.node != nil {
+			follower.RedisID = member.node.ID
+		}
+		if member.healthy() && !member.myself.IsMaster() {
+			follower.LeaderID = member.myself.Leader
+		}
+		leader.Followers = append(leader.Followers, follower)
+	}
+	return leader
+}
+
 // Returns a list with all the IPs of the Redis nodes
 func (v *RedisClusterView) IPs() []string {
 	var ips []string
@@ -178,55 +366,45 @@ func (r *RedisClusterView) HealthyNodeIPs() []string {
 	return ips
 }
 
-type NodeNumbers [2]string // 0: node number, 1: leader number
-
-func (r *RedisClusterReconciler) getLeaderIP(followerIP string) (string, error) {
-	info, err := r.RedisCLI.Info(followerIP)
-	if err != nil {
-		return "", err
+// Returns a mapping between the leader numbers of the shards and the IPs of
+// their healthy leaders
+func (v *RedisClusterView) LeaderIPs() map[string]string {
+	ips := make(map[string]string)
+	for _, leader := range *v {
+		if leader.Pod != nil && !(leader.Failed || leader.Terminating) {
+			ips[leader.LeaderNumber] = leader.Pod.Status.PodIP
+		}
 	}
-	return info.Replication["master_host"], nil
+	return ips
 }
 
-// Returns the node number and leader number from a pod
-func (r *RedisClusterReconciler) getRedisNodeNumbersFromIP(namespace string, podIP string) (string, string, error) {
-	pod, err := r.getPodByIP(namespace, podIP)
-	if err != nil {
-		return "", "", err
-	}
-	return pod.Labels["node-number"], pod.Labels["leader-number"], err
-}
+type NodeNumbers [2]string // 0: node number, 1: leader number
 
-// Returns a mapping between node numbers and IPs
-func (r *RedisClusterReconciler) getNodeIPs(redisCluster *dbv1.RedisCluster) (map[string]string, error) {
-	nodeIPs := make(map[string]string)
-	pods, err := r.getRedisClusterPods(redisCluster)
+func (r *RedisClusterReconciler) getLeaderIP(ctx context.Context, followerIP string) (string, error) {
+	info, err := r.RedisCLI.Info(ctx, followerIP)
 	if err != nil {
-		return nil, err
-	}
-	for _, pod := range pods {
-		nodeIPs[pod.Labels["node-number"]] = pod.Status.PodIP
+		return "", err
 	}
-	return nodeIPs, nil
+	return info.Replication["master_host"], nil
 }
 
-func (r *RedisClusterReconciler) createNewRedisCluster(redisCluster *dbv1.RedisCluster) error {
+func (r *RedisClusterReconciler) createNewRedisCluster(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
 	r.Log.Info("Creating new cluster...")
 
-	if _, err := r.createRedisService(redisCluster); err != nil {
+	if err := r.createMissingServices(ctx, redisCluster); err != nil {
 		return err
 	}
 
-	if err := r.initializeCluster(redisCluster); err != nil {
+	if err := r.initializeCluster(ctx, redisCluster); err != nil {
 		return err
 	}
 	r.Log.Info("[OK] Redis cluster initialized successfully")
 	return nil
 }
 
-func (r *RedisClusterReconciler) initializeFollowers(redisCluster *dbv1.RedisCluster) error {
+func (r *RedisClusterReconciler) initializeFollowers(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
 	r.Log.Info("Initializing followers...")
-	leaderPods, err := r.getRedisClusterPods(redisCluster, "leader")
+	leaderPods, err := r.getRedisClusterPods(ctx, redisCluster, "leader")
 	if err != nil {
 		return err
 	}
@@ -235,12 +413,16 @@ func (r *RedisClusterReconciler) initializeFollowers(redisCluster *dbv1.RedisClu
 	nodeNumber := redisCluster.Spec.LeaderCount // first node numbers are reserved for leaders
 	for _, leaderPod := range leaderPods {
 		for i := 0; i < redisCluster.Spec.LeaderFollowersCount; i++ {
-			nodeNumbers = append(nodeNumbers, NodeNumbers{strconv.Itoa(nodeNumber), leaderPod.Labels["node-number"]})
+			nodeNumbers = append(nodeNumbers, NodeNumbers{strconv.Itoa(nodeNumber), leaderPod.Labels["leader-number"]})
 			nodeNumber++
 		}
 	}
 
-	err = r.addFollowers(redisCluster, nodeNumbers...)
+	if len(nodeNumbers) == 0 {
+		r.Log.Info("No followers to initialize")
+		return nil
+	}
+	err = r.addFollowers(ctx, redisCluster, nodeNumbers...)
 	if err != nil {
 		return err
 	}
@@ -249,7 +431,7 @@ func (r *RedisClusterReconciler) initializeFollowers(redisCluster *dbv1.RedisClu
 	return nil
 }
 
-func (r *RedisClusterReconciler) initializeCluster(redisCluster *dbv1.RedisCluster) error {
+func (r *RedisClusterReconciler) initializeCluster(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
 	var leaderNumbers []string
 	// leaders are created first to increase the chance they get scheduled on different
 	// AZs when using soft affinity rules
@@ -257,74 +439,87 @@ func (r *RedisClusterReconciler) initializeCluster(redisCluster *dbv1.RedisClust
 		leaderNumbers = append(leaderNumbers, strconv.Itoa(leaderNumber))
 	}
 
-	newLeaderPods, err := r.createRedisLeaderPods(redisCluster, leaderNumbers...)
+	newLeaderPods, err := r.createRedisLeaderPods(ctx, redisCluster, leaderNumbers...)
 	if err != nil {
 		return err
 	}
 
 	var nodeIPs []string
 	for _, leaderPod := range newLeaderPods {
-		r.RedisCLI.Flushall(leaderPod.Status.PodIP)
-		r.RedisCLI.ClusterReset(leaderPod.Status.PodIP)
+		r.RedisCLI.Flushall(ctx, leaderPod.Status.PodIP)
+		r.RedisCLI.ClusterReset(ctx, leaderPod.Status.PodIP)
 		nodeIPs = append(nodeIPs, leaderPod.Status.PodIP)
 	}
 
-	if _, err := r.waitForPodReady(newLeaderPods...); err != nil {
+	if _, err := r.waitForPodReady(ctx, newLeaderPods...); err != nil {
 		return err
 	}
 
-	if err := r.waitForRedis(nodeIPs...); err != nil {
+	if err := r.waitForRedis(ctx, nodeIPs...); err != nil {
 		return err
 	}
 
-	if _, err = r.RedisCLI.ClusterCreate(nodeIPs); err != nil {
+	if err := r.announceHostnames(ctx, redisCluster, newLeaderPods...); err != nil {
+		return err
+	}
+	if err := r.announceExternalEndpoints(ctx, redisCluster, newLeaderPods...); err != nil {
 		return err
 	}
 
-	return r.waitForClusterCreate(nodeIPs)
+	if _, err = r.RedisCLI.ClusterCreate(ctx, nodeIPs); err != nil {
+		return err
+	}
+	r.invalidateClusterSnapshot()
+
+	if err = r.waitForClusterCreate(ctx, nodeIPs); err != nil {
+		return err
+	}
+	return r.assignPinnedSlots(ctx, redisCluster, newLeaderPods)
 }
 
 // Make a new Redis node join the cluster as a follower and wait until data sync is complete
-func (r *RedisClusterReconciler) replicateLeader(followerIP string, leaderIP string) error {
+func (r *RedisClusterReconciler) replicateLeader(ctx context.Context, followerIP string, leaderIP string) error {
 	r.Log.Info(fmt.Sprintf("Replicating leader: %s->%s", followerIP, leaderIP))
-	leaderID, err := r.RedisCLI.MyClusterID(leaderIP)
+	leaderID, err := r.RedisCLI.MyClusterID(ctx, leaderIP)
 	if err != nil {
 		return err
 	}
 
-	followerID, err := r.RedisCLI.MyClusterID(followerIP)
+	followerID, err := r.RedisCLI.MyClusterID(ctx, followerIP)
 	if err != nil {
 		return err
 	}
 
-	if stdout, err := r.RedisCLI.AddFollower(followerIP, leaderIP, leaderID); err != nil {
+	defer r.invalidateClusterSnapshot()
+	if stdout, err := r.RedisCLI.AddFollower(ctx, followerIP, leaderIP, leaderID); err != nil {
 		if !strings.Contains(stdout, "All nodes agree about slots configuration") {
 			return err
 		}
 	}
 
-	if err = r.waitForRedisMeet(leaderIP, followerIP); err != nil {
+	if err = r.waitForRedisMeet(ctx, leaderIP, followerID); err != nil {
 		return err
 	}
 
 	r.Log.Info(fmt.Sprintf("Replication successful"))
 
-	if err = r.waitForRedisReplication(leaderIP, leaderID, followerID); err != nil {
+	if err = r.waitForRedisReplication(ctx, leaderIP, leaderID, followerID); err != nil {
 		return err
 	}
 
-	return r.waitForRedisSync(followerIP)
+	return r.waitForRedisSync(ctx, followerIP)
 }
 
 // Triggeres a failover command on the specified node and waits for the follower
 // to become leader
-func (r *RedisClusterReconciler) doFailover(followerIP string, opt string) error {
+func (r *RedisClusterReconciler) doFailover(ctx context.Context, followerIP string, opt string) error {
 	r.Log.Info(fmt.Sprintf("Running 'cluster failover %s' on %s", opt, followerIP))
-	_, err := r.RedisCLI.ClusterFailover(followerIP, opt)
+	defer r.invalidateClusterSnapshot()
+	_, err := r.RedisCLI.ClusterFailover(ctx, followerIP, opt)
 	if err != nil {
 		return err
 	}
-	if err := r.waitForManualFailover(followerIP); err != nil {
+	if err := r.waitForManualFailover(ctx, followerIP); err != nil {
 		return err
 	}
 	return nil
@@ -333,40 +528,47 @@ func (r *RedisClusterReconciler) doFailover(followerIP string, opt string) error
 // Changes the role of a leader with one of its healthy followers
 // Returns the IP of the promoted follower
 // leaderIP: IP of leader that will be turned into a follower
-// opt: the type of failover operation ('', 'force', 'takeover')
+// opt: the type of failover operation (”, 'force', 'takeover')
 // followerIP (optional): followers that should be considered for the failover process
-func (r *RedisClusterReconciler) doLeaderFailover(leaderIP string, opt string, followerIPs ...string) (string, error) {
-	var promotedFollowerIP string
-	leaderID, err := r.RedisCLI.MyClusterID(leaderIP)
+// The best ranked follower is promoted, see rankFailoverCandidates
+func (r *RedisClusterReconciler) doLeaderFailover(ctx context.Context, redisCluster *dbv1.RedisCluster, leaderIP string, opt string, followerIPs ...string) (string, error) {
+	leaderID, err := r.RedisCLI.MyClusterID(ctx, leaderIP)
 	if err != nil {
 		return "", err
 	}
 
 	r.Log.Info(fmt.Sprintf("Starting manual failover on leader: %s(%s)", leaderIP, leaderID))
 
-	if len(followerIPs) != 0 {
-		for i, followerIP := range followerIPs {
-			if _, pingErr := r.RedisCLI.Ping(followerIP); pingErr == nil {
-				promotedFollowerIP = followerIPs[i]
-				break
-			}
-		}
-	} else {
-		followers, err := r.RedisCLI.ClusterReplicas(leaderIP, leaderID)
+	if len(followerIPs) == 0 {
+		followers, err := r.RedisCLI.ClusterReplicas(ctx, leaderIP, leaderID)
 		if err != nil {
 			return "", err
 		}
 		if len(*followers) == 0 {
-			return "", errors.Errorf("Attempted FAILOVER on a leader (%s) with no followers. This case is not supported yet.", leaderIP)
+			return "", errors.Errorf("Attempted FAILOVER on a leader (%s) with no followers", leaderIP)
+		}
+		// the replicas are given by the pod IP: the callers look the promoted
+		// pod up by it, and the announced address may be an external one
+		snapshot, err := r.getClusterSnapshot(ctx, redisCluster)
+		if err != nil {
+			return "", err
 		}
 		for i := range *followers {
-			if !(*followers)[i].IsFailing() {
-				promotedFollowerIP = strings.Split((*followers)[i].Addr, ":")[0]
+			if pod := snapshot.PodOf(&(*followers)[i]); pod != nil && !(*followers)[i].IsFailing() {
+				followerIPs = append(followerIPs, pod.Status.PodIP)
 			}
 		}
 	}
+	candidates, err := r.chooseFailoverCandidates(ctx, redisCluster, leaderIP, followerIPs)
+	if err != nil {
+		return "", err
+	}
+	if len(candidates) == 0 {
+		return "", errors.Errorf("Attempted FAILOVER on a leader (%s) with no reachable followers", leaderIP)
+	}
+	promotedFollowerIP := candidates[0].IP
 
-	if err := r.doFailover(promotedFollowerIP, opt); err != nil {
+	if err := r.doFailover(ctx, promotedFollowerIP, opt); err != nil {
 		return "", err
 	}
 
@@ -376,35 +578,38 @@ func (r *RedisClusterReconciler) doLeaderFailover(leaderIP string, opt string, f
 
 // Recreates a leader based on a replica that took its place in a failover process;
 // the old leader pod must be already deleted
-func (r *RedisClusterReconciler) recreateLeader(redisCluster *dbv1.RedisCluster, promotedFollowerIP string) error {
-	nodeNumber, oldLeaderNumber, err := r.getRedisNodeNumbersFromIP(redisCluster.Namespace, promotedFollowerIP)
+func (r *RedisClusterReconciler) recreateLeader(ctx context.Context, redisCluster *dbv1.RedisCluster, oldLeaderNumber string, promotedFollowerIP string) error {
+	r.Log.Info(fmt.Sprintf("Recreating leader [%s] using node [%s]", oldLeaderNumber, promotedFollowerIP))
+
+	newLeaderPods, err := r.createRedisLeaderPods(ctx, redisCluster, oldLeaderNumber)
 	if err != nil {
 		return err
 	}
-	r.Log.Info(fmt.Sprintf("Recreating leader [%s] using node [%s]", oldLeaderNumber, nodeNumber))
+	newLeaderIP := newLeaderPods[0].Status.PodIP
 
-	newLeaderPods, err := r.createRedisLeaderPods(redisCluster, oldLeaderNumber)
+	newLeaderPods, err = r.waitForPodReady(ctx, newLeaderPods...)
 	if err != nil {
 		return err
 	}
-	newLeaderIP := newLeaderPods[0].Status.PodIP
 
-	newLeaderPods, err = r.waitForPodReady(newLeaderPods...)
-	if err != nil {
+	if err := r.waitForRedis(ctx, newLeaderIP); err != nil {
 		return err
 	}
 
-	if err := r.waitForRedis(newLeaderIP); err != nil {
+	if err := r.announceHostnames(ctx, redisCluster, newLeaderPods...); err != nil {
+		return err
+	}
+	if err := r.announceExternalEndpoints(ctx, redisCluster, newLeaderPods...); err != nil {
 		return err
 	}
 
-	if err = r.replicateLeader(newLeaderIP, promotedFollowerIP); err != nil {
+	if err = r.replicateLeader(ctx, newLeaderIP, promotedFollowerIP); err != nil {
 		return err
 	}
 
 	r.Log.Info("Leader replication successful")
 
-	if _, err = r.doLeaderFailover(promotedFollowerIP, "", newLeaderIP); err != nil {
+	if _, err = r.doLeaderFailover(ctx, redisCluster, promotedFollowerIP, "", newLeaderIP); err != nil {
 		return err
 	}
 
@@ -412,33 +617,228 @@ func (r *RedisClusterReconciler) recreateLeader(redisCluster *dbv1.RedisCluster,
 	return nil
 }
 
+// Recreates the pod of a leader that was replaced by a failover. If the lost
+// node was the designated leader of its shard it takes back the leadership,
+// otherwise it joins as a follower of the promoted node.
+func (r *RedisClusterReconciler) replaceLostLeader(ctx context.Context, redisCluster *dbv1.RedisCluster, leader *LeaderNode, promotedFollowerIP string) error {
+	if leader.IsDesignated() {
+		return r.recreateLeader(ctx, redisCluster, leader.LeaderNumber, promotedFollowerIP)
+	}
+	r.Log.Info(fmt.Sprintf("Recreating node [%s] of leader [%s] as follower", leader.NodeNumber, leader.LeaderNumber))
+	return r.addFollowers(ctx, redisCluster, NodeNumbers{leader.NodeNumber, leader.LeaderNumber})
+}
+
+// Re-attaches the followers that replicate a node other than the leader of
+// their shard. A leader running outside of its designated pod is only
+// reported, moving the leadership back is a separate decision.
+func (r *RedisClusterReconciler) fixRoleDrift(ctx context.Context, clusterView *RedisClusterView) error {
+	for _, leader := range *clusterView {
+		if !leader.Failed && !leader.IsDesignated() {
+			r.Log.Info(fmt.Sprintf("Leader [%s] is running on node [%s]", leader.LeaderNumber, leader.NodeNumber))
+		}
+		for _, follower := range leader.MisplacedFollowers() {
+			r.Log.Info(fmt.Sprintf("Follower [%s] of leader [%s] replicates [%s] instead of [%s]", follower.NodeNumber, leader.LeaderNumber, follower.LeaderID, leader.RedisID))
+			if err := r.replicateLeader(ctx, follower.Pod.Status.PodIP, leader.Pod.Status.PodIP); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Runs a graceful failover on the designated leader pod of the first shard led
+// by another pod. Only one shard is handled per call and the calls are spaced
+// by the interval of the preferredLeaders spec; shards with lagging followers
+// are skipped.
+func (r *RedisClusterReconciler) restorePreferredLeaders(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
+	preferredLeaders := redisCluster.Spec.PreferredLeaders
+	if preferredLeaders == nil || !preferredLeaders.Enabled {
+		return nil
+	}
+	interval := defaultLeaderRestorationInterval
+	if preferredLeaders.MinInterval != nil {
+		interval = preferredLeaders.MinInterval.Duration
+	}
+	maxLag := preferredLeaders.MaxReplicationLag
+	if maxLag == 0 {
+		maxLag = defaultMaxReplicationLag
+	}
+	if last := redisCluster.Status.LastLeaderRestoration; last != nil && time.Since(last.Time) < interval {
+		return nil
+	}
+
+	snapshot, err := r.getClusterSnapshot(ctx, redisCluster)
+	if err != nil {
+		return err
+	}
+	clusterView, err := r.NewRedisClusterView(ctx, redisCluster)
+	if err != nil {
+		return err
+	}
+	for _, leader := range *clusterView {
+		if leader.Failed || leader.Terminating || leader.IsDesignated() {
+			continue
+		}
+		designated := leader.DesignatedFollower()
+		if designated == nil || designated.Failed || designated.Terminating || designated.LeaderID != leader.RedisID {
+			r.Log.Info(fmt.Sprintf("Designated node of leader [%s] is not a healthy follower, skipping the leader restoration", leader.LeaderNumber))
+			continue
+		}
+		if lag, inSync := shardReplicationLag(snapshot, &leader); !inSync || lag > maxLag {
+			r.Log.Info(fmt.Sprintf("Replication of leader [%s] is lagging (%d bytes), skipping the leader restoration", leader.LeaderNumber, lag))
+			continue
+		}
+		r.Log.Info(fmt.Sprintf("Restoring leader [%s] on node [%s]", leader.LeaderNumber, designated.NodeNumber))
+		now := metav1.Now()
+		redisCluster.Status.LastLeaderRestoration = &now
+		return r.doFailover(ctx, designated.Pod.Status.PodIP, "")
+	}
+	return nil
+}
+
+// Returns the largest difference between the replication offset of a leader
+// and the offsets of its healthy followers. The shard is not in sync if the
+// offsets are unknown or a follower is still syncing or lost the link to the leader.
+func shardReplicationLag(snapshot *ClusterSnapshot, leader *LeaderNode) (int64, bool) {
+	leaderNode := snapshot.Node(leader.Pod.Status.PodIP)
+	if leaderNode == nil || leaderNode.Info == nil {
+		return 0, false
+	}
+	leaderOffset, ok := leaderNode.Info.MasterReplOffset()
+	if !ok {
+		return 0, false
+	}
+	var maxLag int64
+	for _, follower := range leader.Followers {
+		if follower.Pod == nil || follower.Failed || follower.LeaderID != leader.RedisID {
+			continue
+		}
+		followerNode := snapshot.Node(follower.Pod.Status.PodIP)
+		if followerNode == nil || followerNode.Info == nil {
+			return maxLag, false
+		}
+		if !followerNode.Info.IsMasterLinkUp() || followerNode.Info.IsSyncing() {
+			return maxLag, false
+		}
+		followerOffset, ok := followerNode.Info.SlaveReplOffset()
+		if !ok {
+			return maxLag, false
+		}
+		if lag := leaderOffset - followerOffset; lag > maxLag {
+			maxLag = lag
+		}
+	}
+	return maxLag, true
+}
+
+// Returns the stable DNS name of a node, resolved by its headless Service
+func nodeHostname(redisCluster *dbv1.RedisCluster, nodeNumber string) string {
+	return fmt.Sprintf("redis-node-%s.%s.svc", nodeNumber, redisCluster.Namespace)
+}
+
+// Returns true if the Redis node runs in the pod. The node is matched by the
+// ID annotation of the pod, then by its announced stable hostname and only
+// when neither is known by IP. The hostname of an external endpoint does not
+// tell the pod. syncPodRoleLabels keeps the annotation in line with the ID the
+// node answers with.
+func isPodOfClusterNode(pod *corev1.Pod, node *rediscli.RedisClusterNode) bool {
+	if id := pod.Annotations[redisNodeIDAnnotation]; id != "" {
+		return id == node.ID
+	}
+	if name := strings.SplitN(node.Hostname(), ".", 2)[0]; strings.HasPrefix(name, "redis-node-") {
+		return name == pod.Name
+	}
+	ip, _ := node.IPAndPort()
+	return ip == pod.Status.PodIP
+}
+
+// Makes the Redis nodes of the pods announce their stable hostnames and
+// redirect clients to them; does nothing unless announceHostnames is set
+func (r *RedisClusterReconciler) announceHostnames(ctx context.Context, redisCluster *dbv1.RedisCluster, pods ...corev1.Pod) error {
+	if !redisCluster.Spec.AnnounceHostnames {
+		return nil
+	}
+	for _, pod := range pods {
+		hostname := nodeHostname(redisCluster, pod.Labels["node-number"])
+		r.Log.Info(fmt.Sprintf("Announcing hostname %s on %s", hostname, pod.Status.PodIP))
+		if _, err := r.RedisCLI.ConfigSet(ctx, pod.Status.PodIP, "cluster-announce-hostname", hostname); err != nil {
+			return err
+		}
+		if _, err := r.RedisCLI.ConfigSet(ctx, pod.Status.PodIP, "cluster-preferred-endpoint-type", "hostname"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Announces the hostname again on the nodes that don't report it, e.g. after
+// a restart of the Redis process. The nodes announcing the hostname of their
+// external endpoint are left to syncExternalEndpoints.
+func (r *RedisClusterReconciler) syncAnnouncedHostnames(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
+	if !redisCluster.Spec.AnnounceHostnames {
+		return nil
+	}
+	snapshot, err := r.getClusterSnapshot(ctx, redisCluster)
+	if err != nil {
+		return err
+	}
+	var pods []corev1.Pod
+	for _, pod := range snapshot.Pods {
+		node := snapshot.Node(pod.Status.PodIP)
+		if pod.DeletionTimestamp != nil || node == nil || node.Err != nil || node.Nodes == nil {
+			continue
+		}
+		if myself := node.Nodes.Myself(); myself != nil && myself.Hostname() == "" {
+			pods = append(pods, pod)
+		}
+	}
+	if len(pods) == 0 {
+		return nil
+	}
+	defer r.invalidateClusterSnapshot()
+	return r.announceHostnames(ctx, redisCluster, pods...)
+}
+
 // Adds one or more follower pods to the cluster
-func (r *RedisClusterReconciler) addFollowers(redisCluster *dbv1.RedisCluster, nodeNumbers ...NodeNumbers) error {
+func (r *RedisClusterReconciler) addFollowers(ctx context.Context, redisCluster *dbv1.RedisCluster, nodeNumbers ...NodeNumbers) error {
 	if len(nodeNumbers) == 0 {
 		return errors.Errorf("Failed to add followers - no node numbers: (%s)", nodeNumbers)
 	}
 
-	newFollowerPods, err := r.createRedisFollowerPods(redisCluster, nodeNumbers...)
+	clusterView, err := r.NewRedisClusterView(ctx, redisCluster)
 	if err != nil {
 		return err
 	}
+	leaderIPs := clusterView.LeaderIPs()
+	for _, nodeNumber := range nodeNumbers {
+		if _, found := leaderIPs[nodeNumber[1]]; !found {
+			return errors.Errorf("Failed to add follower %s - leader %s has no healthy node", nodeNumber[0], nodeNumber[1])
+		}
+	}
 
-	nodeIPs, err := r.getNodeIPs(redisCluster)
+	newFollowerPods, err := r.createRedisFollowerPods(ctx, redisCluster, nodeNumbers...)
 	if err != nil {
 		return err
 	}
 
-	pods, err := r.waitForPodReady(newFollowerPods...)
+	pods, err := r.waitForPodReady(ctx, newFollowerPods...)
 	if err != nil {
 		return err
 	}
 
 	for _, followerPod := range pods {
-		if err := r.waitForRedis(followerPod.Status.PodIP); err != nil {
+		if err := r.waitForRedis(ctx, followerPod.Status.PodIP); err != nil {
 			return err
 		}
-		r.Log.Info(fmt.Sprintf("Replicating: %s %s", followerPod.Name, "redis-node-"+followerPod.Labels["leader-number"]))
-		if err = r.replicateLeader(followerPod.Status.PodIP, nodeIPs[followerPod.Labels["leader-number"]]); err != nil {
+		if err := r.announceHostnames(ctx, redisCluster, followerPod); err != nil {
+			return err
+		}
+		if err := r.announceExternalEndpoints(ctx, redisCluster, followerPod); err != nil {
+			return err
+		}
+		leaderIP := leaderIPs[followerPod.Labels["leader-number"]]
+		r.Log.Info(fmt.Sprintf("Replicating: %s %s", followerPod.Name, leaderIP))
+		if err = r.replicateLeader(ctx, followerPod.Status.PodIP, leaderIP); err != nil {
 			return err
 		}
 	}
@@ -447,50 +847,37 @@ func (r *RedisClusterReconciler) addFollowers(redisCluster *dbv1.RedisCluster, n
 
 // Removes all nodes the cluster node table entries with IDs of nodes not available
 // Recives the list of healthy cluster nodes (Redis is reachable and has cluster mode on)
-func (r *RedisClusterReconciler) forgetLostNodes(redisCluster *dbv1.RedisCluster) error {
-
-	clusterView, err := r.NewRedisClusterView(redisCluster)
+func (r *RedisClusterReconciler) forgetLostNodes(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
+	snapshot, err := r.getClusterSnapshot(ctx, redisCluster)
 	if err != nil {
 		return err
 	}
 
 	lostNodeIDSet := make(map[string]struct{})
-	nodeMap := make(map[string]string)
+	healthyNodeIDs := make(map[string]struct{})
+	var healthyNodeIPs []string
 
 	r.Log.Info("Forgetting lost nodes...")
-	healthyNodeIPs := clusterView.HealthyNodeIPs()
-
-	for _, healthyNodeIP := range healthyNodeIPs {
-		healthyNodeID, err := r.RedisCLI.MyClusterID(healthyNodeIP)
-		if err != nil {
-			r.Log.Error(err, fmt.Sprintf("Could not reach node %s", healthyNodeIP))
-			return err
-		}
-		nodeMap[healthyNodeIP] = healthyNodeID
+	healthyNodes := snapshot.HealthyNodes()
+	for _, node := range healthyNodes {
+		healthyNodeIDs[node.ID] = EMPTY
+		healthyNodeIPs = append(healthyNodeIPs, node.IP)
 	}
 
-	for healthyNodeIP := range nodeMap {
-		nodeTable, err := r.RedisCLI.ClusterNodes(healthyNodeIP)
-		if err != nil || nodeTable == nil || len(*nodeTable) == 0 {
-			r.Log.Info(fmt.Sprintf("[WARN] Could not forget lost nodes on node %s", healthyNodeIP))
+	for _, healthyNode := range healthyNodes {
+		if healthyNode.Nodes == nil || len(*healthyNode.Nodes) == 0 {
+			r.Log.Info(fmt.Sprintf("[WARN] Could not forget lost nodes on node %s", healthyNode.IP))
 			continue
 		}
-		for _, node := range *nodeTable {
-			lost := true
-			for _, id := range nodeMap {
-				if node.ID == id {
-					lost = false
-					break
-				}
-			}
-			if lost {
+		for _, node := range *healthyNode.Nodes {
+			if _, healthy := healthyNodeIDs[node.ID]; !healthy {
 				lostNodeIDSet[node.ID] = EMPTY
 			}
 		}
 	}
 
 	for id := range lostNodeIDSet {
-		r.forgetNode(healthyNodeIPs, id)
+		r.forgetNode(ctx, healthyNodeIPs, id)
 	}
 	return nil
 }
@@ -498,7 +885,8 @@ func (r *RedisClusterReconciler) forgetLostNodes(redisCluster *dbv1.RedisCluster
 // Removes a node from the cluster nodes table of all specified nodes
 // nodeIPs: 	list of active node IPs
 // removedID: ID of node to be removed
-func (r *RedisClusterReconciler) forgetNode(nodeIPs []string, removedID string) error {
+func (r *RedisClusterReconciler) forgetNode(ctx context.Context, nodeIPs []string, removedID string) error {
+	defer r.invalidateClusterSnapshot()
 	var wg sync.WaitGroup
 	errs := make(chan error, len(nodeIPs))
 
@@ -507,8 +895,12 @@ func (r *RedisClusterReconciler) forgetNode(nodeIPs []string, removedID string)
 		go func(ip string, wg *sync.WaitGroup) {
 			defer wg.Done()
 			r.Log.Info(fmt.Sprintf("Running cluster FORGET with: %s %s", ip, removedID))
-			if _, err := r.RedisCLI.ClusterForget(ip, removedID); err != nil {
-				// TODO we should chatch here the error thrown when the ID was already removed
+			if _, err := r.RedisCLI.ClusterForget(ctx, ip, removedID); err != nil {
+				var unknown *rediscli.UnknownNodeError
+				if errors.As(err, &unknown) {
+					// the node was already forgotten
+					return
+				}
 				errs <- err
 			}
 		}(nodeIP, &wg)
@@ -525,17 +917,18 @@ func (r *RedisClusterReconciler) forgetNode(nodeIPs []string, removedID string)
 	return nil
 }
 
-func (r *RedisClusterReconciler) cleanupNodeList(podIPs []string) error {
+func (r *RedisClusterReconciler) cleanupNodeList(ctx context.Context, podIPs []string) error {
 	var wg sync.WaitGroup
 	errs := make(chan error, len(podIPs))
 
 	r.Log.Info(fmt.Sprintf("Cleanning up: %v", podIPs))
+	defer r.invalidateClusterSnapshot()
 
 	for _, podIP := range podIPs {
 		wg.Add(1)
 		go func(ip string, wg *sync.WaitGroup) {
 			defer wg.Done()
-			clusterNodes, err := r.RedisCLI.ClusterNodes(ip)
+			clusterNodes, err := r.RedisCLI.ClusterNodes(ctx, ip)
 			if err != nil {
 				// TODO node is not reachable => nothing to clean; we could consider throwing an error instead
 				return
@@ -543,7 +936,7 @@ func (r *RedisClusterReconciler) cleanupNodeList(podIPs []string) error {
 			for _, clusterNode := range *clusterNodes {
 				if clusterNode.IsFailing() {
 					// TODO opportunity for higher concurrency - spawn a routine for each ClusterForget command
-					if _, err := r.RedisCLI.ClusterForget(ip, clusterNode.ID); err != nil {
+					if _, err := r.RedisCLI.ClusterForget(ctx, ip, clusterNode.ID); err != nil {
 						errs <- err
 						return
 					}
@@ -564,35 +957,53 @@ func (r *RedisClusterReconciler) cleanupNodeList(podIPs []string) error {
 }
 
 // Handles the failover process for a leader. Waits for automatic failover, then
-// attempts a forced failover and eventually a takeover
-// Returns the ip of the promoted follower
-func (r *RedisClusterReconciler) handleFailover(redisCluster *dbv1.RedisCluster, leader *LeaderNode) (string, error) {
+// attempts a forced failover and eventually a takeover, trying the followers
+// in the order of rankFailoverCandidates
+// Returns the ip of the promoted follower, or a NoFailoverCandidateError if
+// none of the followers can be promoted
+func (r *RedisClusterReconciler) handleFailover(ctx context.Context, redisCluster *dbv1.RedisCluster, leader *LeaderNode) (string, error) {
+	// the roles change even when Redis completes the failover on its own
+	defer r.invalidateClusterSnapshot()
 	var promotedPodIP string = ""
 
-	promotedPodIP, err := r.waitForFailover(redisCluster, leader)
+	promotedPodIP, err := r.waitForFailover(ctx, redisCluster, leader)
 	if err != nil || promotedPodIP == "" {
 		r.Log.Info(fmt.Sprintf("[WARN] Automatic failover failed for leader [%s]. Attempting forced failover.", leader.NodeNumber))
 	} else {
 		return promotedPodIP, nil
 	}
 
-	// Automatic failover failed. Attempt to force failover on a healthy follower.
+	var followerIPs []string
 	for _, follower := range leader.Followers {
 		if follower.Pod != nil && !follower.Failed {
-			if _, pingErr := r.RedisCLI.Ping(follower.Pod.Status.PodIP); pingErr == nil {
-				if forcedFailoverErr := r.doFailover(follower.Pod.Status.PodIP, "force"); forcedFailoverErr != nil {
-					if rediscli.IsFailoverNotOnReplica(forcedFailoverErr) {
-						r.Log.Info(fmt.Sprintf("Forced failover successful on [%s](%s)", follower.NodeNumber, follower.Pod.Status.PodIP))
-						promotedPodIP = follower.Pod.Status.PodIP
-						break
-					}
-					r.Log.Error(forcedFailoverErr, fmt.Sprintf("[WARN] Failed forced attempt to make node [%s](%s) leader", follower.NodeNumber, follower.Pod.Status.PodIP))
-				} else {
-					r.Log.Info(fmt.Sprintf("Forced failover successful on [%s](%s)", follower.NodeNumber, follower.Pod.Status.PodIP))
-					promotedPodIP = follower.Pod.Status.PodIP
-					break
-				}
+			followerIPs = append(followerIPs, follower.Pod.Status.PodIP)
+		}
+	}
+	leaderIP := ""
+	if leader.Pod != nil {
+		leaderIP = leader.Pod.Status.PodIP
+	}
+	candidates, err := r.chooseFailoverCandidates(ctx, redisCluster, leaderIP, followerIPs)
+	if err != nil {
+		return "", err
+	}
+	if len(candidates) == 0 {
+		return "", &NoFailoverCandidateError{LeaderNumber: leader.LeaderNumber}
+	}
+
+	// Automatic failover failed. Attempt to force failover on a healthy follower.
+	for _, candidate := range candidates {
+		if forcedFailoverErr := r.doFailover(ctx, candidate.IP, "force"); forcedFailoverErr != nil {
+			if rediscli.IsFailoverNotOnReplica(forcedFailoverErr) {
+				r.Log.Info(fmt.Sprintf("Forced failover successful on [%s](%s)", candidate.NodeNumber, candidate.IP))
+				promotedPodIP = candidate.IP
+				break
 			}
+			r.Log.Error(forcedFailoverErr, fmt.Sprintf("[WARN] Failed forced attempt to make node [%s](%s) leader", candidate.NodeNumber, candidate.IP))
+		} else {
+			r.Log.Info(fmt.Sprintf("Forced failover successful on [%s](%s)", candidate.NodeNumber, candidate.IP))
+			promotedPodIP = candidate.IP
+			break
 		}
 	}
 
@@ -601,30 +1012,35 @@ func (r *RedisClusterReconciler) handleFailover(redisCluster *dbv1.RedisCluster,
 	}
 
 	// Forced failover failed. Attempt to takeover on a healthy follower.
-	for _, follower := range leader.Followers {
-		if follower.Pod != nil && !follower.Failed {
-			if _, pingErr := r.RedisCLI.Ping(follower.Pod.Status.PodIP); pingErr == nil {
-				if forcedFailoverErr := r.doFailover(follower.Pod.Status.PodIP, "takeover"); forcedFailoverErr != nil {
-					if rediscli.IsFailoverNotOnReplica(forcedFailoverErr) {
-						r.Log.Info(fmt.Sprintf("Takeover successful on [%s](%s)", follower.NodeNumber, follower.Pod.Status.PodIP))
-						promotedPodIP = follower.Pod.Status.PodIP
-						break
-					}
-					r.Log.Error(forcedFailoverErr, fmt.Sprintf("[WARN] Failed takeover attempt to make node [%s](%s) leader", follower.NodeNumber, follower.Pod.Status.PodIP))
-				} else {
-					r.Log.Info(fmt.Sprintf("Takeover successful on [%s](%s)", follower.NodeNumber, follower.Pod.Status.PodIP))
-					promotedPodIP = follower.Pod.Status.PodIP
-					break
-				}
+	for _, candidate := range candidates {
+		if forcedFailoverErr := r.doFailover(ctx, candidate.IP, "takeover"); forcedFailoverErr != nil {
+			if rediscli.IsFailoverNotOnReplica(forcedFailoverErr) {
+				r.Log.Info(fmt.Sprintf("Takeover successful on [%s](%s)", candidate.NodeNumber, candidate.IP))
+				promotedPodIP = candidate.IP
+				break
 			}
+			r.Log.Error(forcedFailoverErr, fmt.Sprintf("[WARN] Failed takeover attempt to make node [%s](%s) leader", candidate.NodeNumber, candidate.IP))
+		} else {
+			r.Log.Info(fmt.Sprintf("Takeover successful on [%s](%s)", candidate.NodeNumber, candidate.IP))
+			promotedPodIP = candidate.IP
+			break
 		}
 	}
+	if promotedPodIP == "" {
+		return "", errors.Errorf("Failed to promote a follower of leader [%s]", leader.LeaderNumber)
+	}
 	return promotedPodIP, nil
 }
 
-func (r *RedisClusterReconciler) recoverCluster(redisCluster *dbv1.RedisCluster) error {
+func (r *RedisClusterReconciler) recoverCluster(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
 	var runLeaderRecover bool = false
-	clusterView, err := r.NewRedisClusterView(redisCluster)
+	var waitingShards []string
+	// without the majority of the masters no node looks healthy and the
+	// shards would be recovered as lost ones
+	if err := r.recoverQuorum(ctx, redisCluster); err != nil {
+		return err
+	}
+	clusterView, err := r.NewRedisClusterView(ctx, redisCluster)
 	if err != nil {
 		return err
 	}
@@ -634,32 +1050,43 @@ func (r *RedisClusterReconciler) recoverCluster(redisCluster *dbv1.RedisCluster)
 		if leader.Failed {
 			runLeaderRecover = true
 
+			if !leader.hasHealthyFollowers() {
+				recovered, err := r.recoverLostShard(ctx, redisCluster, &(*clusterView)[i])
+				if err != nil {
+					return err
+				}
+				if !recovered {
+					waitingShards = append(waitingShards, leader.LeaderNumber)
+				}
+				continue
+			}
+
 			if leader.Terminating {
-				if err = r.waitForPodDelete(*leader.Pod); err != nil {
+				if err = r.waitForPodDelete(ctx, *leader.Pod); err != nil {
 					return errors.Errorf("Failed to wait for leader pod to be deleted %s: %v", leader.NodeNumber, err)
 				}
 			}
 
-			promotedPodIP, err := r.handleFailover(redisCluster, &(*clusterView)[i])
+			promotedPodIP, err := r.handleFailover(ctx, redisCluster, &(*clusterView)[i])
 			if err != nil {
 				return err
 			}
 
 			if leader.Pod != nil && !leader.Terminating {
-				_, err := r.deletePodsByIP(redisCluster.Namespace, leader.Pod.Status.PodIP)
+				_, err := r.deletePods(ctx, *leader.Pod)
 				if err != nil {
 					return err
 				}
-				if err = r.waitForPodDelete(*leader.Pod); err != nil {
+				if err = r.waitForPodDelete(ctx, *leader.Pod); err != nil {
 					return err
 				}
 			}
 
-			if err := r.forgetLostNodes(redisCluster); err != nil {
+			if err := r.forgetLostNodes(ctx, redisCluster); err != nil {
 				return err
 			}
 
-			if err := r.recreateLeader(redisCluster, promotedPodIP); err != nil {
+			if err := r.replaceLostLeader(ctx, redisCluster, &leader, promotedPodIP); err != nil {
 				return err
 			}
 		}
@@ -668,7 +1095,7 @@ func (r *RedisClusterReconciler) recoverCluster(redisCluster *dbv1.RedisCluster)
 	if runLeaderRecover {
 		// we fetch again the cluster view in case the state has changed
 		// since the last check (before handling the failed leaders)
-		clusterView, err = r.NewRedisClusterView(redisCluster)
+		clusterView, err = r.NewRedisClusterView(ctx, redisCluster)
 		if err != nil {
 			return err
 		}
@@ -678,101 +1105,119 @@ func (r *RedisClusterReconciler) recoverCluster(redisCluster *dbv1.RedisCluster)
 
 	for _, leader := range *clusterView {
 		var missingFollowers []NodeNumbers
-		var failedFollowerIPs []string
-		var terminatingFollowerIPs []string
+		var failedFollowerPods []corev1.Pod
 		var terminatingFollowerPods []corev1.Pod
 
+		if leader.Failed {
+			// the shard waits for its data, there is no leader to follow
+			continue
+		}
+
 		for _, follower := range leader.Followers {
 			if follower.Pod == nil {
 				missingFollowers = append(missingFollowers, NodeNumbers{follower.NodeNumber, follower.LeaderNumber})
 			} else if follower.Terminating {
 				terminatingFollowerPods = append(terminatingFollowerPods, *follower.Pod)
-				terminatingFollowerIPs = append(terminatingFollowerIPs, follower.Pod.Status.PodIP)
 				missingFollowers = append(missingFollowers, NodeNumbers{follower.NodeNumber, follower.LeaderNumber})
 			} else if follower.Failed {
-				failedFollowerIPs = append(failedFollowerIPs, follower.Pod.Status.PodIP)
+				failedFollowerPods = append(failedFollowerPods, *follower.Pod)
 				missingFollowers = append(missingFollowers, NodeNumbers{follower.NodeNumber, follower.LeaderNumber})
 			}
 		}
-		deletedPods, err := r.deletePodsByIP(redisCluster.Namespace, failedFollowerIPs...)
+		deletedPods, err := r.deletePods(ctx, failedFollowerPods...)
 		if err != nil {
 			return err
 		}
-		if err = r.waitForPodDelete(append(terminatingFollowerPods, deletedPods...)...); err != nil {
+		if err = r.waitForPodDelete(ctx, append(terminatingFollowerPods, deletedPods...)...); err != nil {
 			return err
 		}
 
 		if len(missingFollowers) > 0 {
-			if err := r.forgetLostNodes(redisCluster); err != nil {
+			if err := r.forgetLostNodes(ctx, redisCluster); err != nil {
 				return err
 			}
-			if err := r.addFollowers(redisCluster, missingFollowers...); err != nil {
+			if err := r.addFollowers(ctx, redisCluster, missingFollowers...); err != nil {
 				return err
 			}
 		}
 	}
 
-	complete, err := r.isClusterComplete(redisCluster)
+	if len(waitingShards) != 0 {
+		return errors.Errorf("Shards %v lost all their nodes and wait for their data", waitingShards)
+	}
+	complete, err := r.isClusterComplete(ctx, redisCluster)
 	if err != nil || !complete {
 		return errors.Errorf("Cluster recovery not complete")
 	}
 	return nil
 }
 
-func (r *RedisClusterReconciler) updateFollower(redisCluster *dbv1.RedisCluster, followerIP string) error {
-	pod, err := r.getPodByIP(redisCluster.Namespace, followerIP)
-	if err != nil {
-		return err
-	}
-
-	deletedPods, err := r.deletePodsByIP(redisCluster.Namespace, followerIP)
+// Replaces the pod of a follower by a new one with the same node number
+func (r *RedisClusterReconciler) updateFollower(ctx context.Context, redisCluster *dbv1.RedisCluster, follower *FollowerNode) error {
+	deletedPods, err := r.deletePods(ctx, *follower.Pod)
 	if err != nil {
 		return err
 	} else {
-		if err := r.waitForPodDelete(deletedPods...); err != nil {
+		if err := r.waitForPodDelete(ctx, deletedPods...); err != nil {
 			return err
 		}
 	}
 
-	if err := r.forgetLostNodes(redisCluster); err != nil {
+	if err := r.forgetLostNodes(ctx, redisCluster); err != nil {
 		return err
 	}
 
-	r.Log.Info(fmt.Sprintf("Starting to add follower: (%s %s)", pod.Labels["node-number"], pod.Labels["leader-number"]))
-	if err := r.addFollowers(redisCluster, NodeNumbers{pod.Labels["node-number"], pod.Labels["leader-number"]}); err != nil {
+	r.Log.Info(fmt.Sprintf("Starting to add follower: (%s %s)", follower.NodeNumber, follower.LeaderNumber))
+	if err := r.addFollowers(ctx, redisCluster, NodeNumbers{follower.NodeNumber, follower.LeaderNumber}); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (r *RedisClusterReconciler) updateLeader(redisCluster *dbv1.RedisCluster, leaderIP string) error {
-	// TODO handle the case where a leader has no followers
-	promotedFollowerIP, err := r.doLeaderFailover(leaderIP, "")
+// Replaces the pod of a leader after failing over to one of its followers. A
+// leader without healthy followers first gets a surge follower, which leads the
+// shard until the new pod takes back the leadership and is then removed.
+func (r *RedisClusterReconciler) updateLeader(ctx context.Context, redisCluster *dbv1.RedisCluster, leader *LeaderNode) error {
+	var followerIPs []string
+	var surgePod *corev1.Pod
+	if !leader.hasHealthyFollowers() {
+		pod, err := r.attachSurgeFollower(ctx, redisCluster, leader)
+		if err != nil {
+			return err
+		}
+		surgePod = pod
+		followerIPs = append(followerIPs, surgePod.Status.PodIP)
+	}
+
+	promotedFollowerIP, err := r.doLeaderFailover(ctx, redisCluster, leader.Pod.Status.PodIP, "", followerIPs...)
 	if err != nil {
 		return err
 	}
 
-	if deletedPods, err := r.deletePodsByIP(redisCluster.Namespace, leaderIP); err != nil {
+	if deletedPods, err := r.deletePods(ctx, *leader.Pod); err != nil {
 		return err
 	} else {
-		if err := r.waitForPodDelete(deletedPods...); err != nil {
+		if err := r.waitForPodDelete(ctx, deletedPods...); err != nil {
 			return err
 		}
 	}
 
-	if err := r.forgetLostNodes(redisCluster); err != nil {
+	if err := r.forgetLostNodes(ctx, redisCluster); err != nil {
 		return err
 	}
 
-	if err := r.recreateLeader(redisCluster, promotedFollowerIP); err != nil {
+	if err := r.replaceLostLeader(ctx, redisCluster, leader, promotedFollowerIP); err != nil {
 		return err
 	}
+	if surgePod != nil {
+		return r.removeSurgePods(ctx, redisCluster)
+	}
 	return nil
 }
 
-func (r *RedisClusterReconciler) updateCluster(redisCluster *dbv1.RedisCluster) error {
-	clusterView, err := r.NewRedisClusterView(redisCluster)
+func (r *RedisClusterReconciler) updateCluster(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
+	clusterView, err := r.NewRedisClusterView(ctx, redisCluster)
 	if err != nil {
 		return err
 	}
@@ -780,54 +1225,51 @@ func (r *RedisClusterReconciler) updateCluster(redisCluster *dbv1.RedisCluster)
 	r.Log.Info("Updating...")
 	for _, leader := range *clusterView {
 		for _, follower := range leader.Followers {
-			podUpToDate, err := r.isPodUpToDate(redisCluster, follower.Pod)
+			if follower.Surge {
+				continue
+			}
+			podUpToDate, err := r.isPodUpToDate(ctx, redisCluster, follower.Pod)
 			if err != nil {
 				return err
 			}
 			if !podUpToDate {
-				if err = r.updateFollower(redisCluster, follower.Pod.Status.PodIP); err != nil {
+				if err = r.updateFollower(ctx, redisCluster, &follower); err != nil {
 					return err
 				}
 			} else {
-				if _, pollErr := r.waitForPodReady(*follower.Pod); pollErr != nil {
+				if _, pollErr := r.waitForPodReady(ctx, *follower.Pod); pollErr != nil {
 					return pollErr
 				}
-				if pollErr := r.waitForRedis(follower.Pod.Status.PodIP); pollErr != nil {
+				if pollErr := r.waitForRedis(ctx, follower.Pod.Status.PodIP); pollErr != nil {
 					return pollErr
 				}
 			}
 		}
-		podUpToDate, err := r.isPodUpToDate(redisCluster, leader.Pod)
+		podUpToDate, err := r.isPodUpToDate(ctx, redisCluster, leader.Pod)
 		if err != nil {
 			return err
 		}
 		if !podUpToDate {
-			if err = r.updateLeader(redisCluster, leader.Pod.Status.PodIP); err != nil {
-				// >>> TODO the logic of checking if a leader pod (frst N pods) is indeed a Redis leader must be handled separately
-				if rediscli.IsNodeIsNotMaster(err) {
-					if _, errDel := r.deletePodsByIP(redisCluster.Namespace, leader.Pod.Status.PodIP); errDel != nil {
-						return errDel
-					}
-				}
+			if err = r.updateLeader(ctx, redisCluster, &leader); err != nil {
 				return err
 			}
 		}
 	}
-	if err = r.cleanupNodeList(clusterView.HealthyNodeIPs()); err != nil {
+	if err = r.cleanupNodeList(ctx, clusterView.HealthyNodeIPs()); err != nil {
 		return err
 	}
 	return nil
 }
 
 // TODO replace with a readyness probe on the redis container
-func (r *RedisClusterReconciler) waitForRedis(nodeIPs ...string) error {
+func (r *RedisClusterReconciler) waitForRedis(ctx context.Context, nodeIPs ...string) error {
 	for _, nodeIP := range nodeIPs {
 		r.Log.Info("Waiting for Redis on " + nodeIP)
 		if nodeIP == "" {
 			return errors.Errorf("Missing IP")
 		}
-		if pollErr := wait.PollImmediate(genericCheckInterval, genericCheckTimeout, func() (bool, error) {
-			reply, err := r.RedisCLI.Ping(nodeIP)
+		if pollErr := pollImmediate(ctx, genericCheckInterval, genericCheckTimeout, func() (bool, error) {
+			reply, err := r.RedisCLI.Ping(ctx, nodeIP)
 			if err != nil {
 				return false, err
 			}
@@ -842,18 +1284,18 @@ func (r *RedisClusterReconciler) waitForRedis(nodeIPs ...string) error {
 	return nil
 }
 
-func (r *RedisClusterReconciler) waitForClusterCreate(leaderIPs []string) error {
+func (r *RedisClusterReconciler) waitForClusterCreate(ctx context.Context, leaderIPs []string) error {
 	r.Log.Info("Waiting for cluster create execution to complete...")
-	return wait.Poll(clusterCreateInterval, clusterCreateTimeout, func() (bool, error) {
+	return poll(ctx, clusterCreateInterval, clusterCreateTimeout, func() (bool, error) {
 		for _, leaderIP := range leaderIPs {
-			clusterInfo, err := r.RedisCLI.ClusterInfo(leaderIP)
+			clusterInfo, err := r.RedisCLI.ClusterInfo(ctx, leaderIP)
 			if err != nil {
 				return false, err
 			}
 			if clusterInfo.IsClusterFail() {
 				return false, nil
 			}
-			clusterNodes, err := r.RedisCLI.ClusterNodes(leaderIP)
+			clusterNodes, err := r.RedisCLI.ClusterNodes(ctx, leaderIP)
 			if err != nil {
 				return false, err
 			}
@@ -866,10 +1308,10 @@ func (r *RedisClusterReconciler) waitForClusterCreate(leaderIPs []string) error
 }
 
 // Safe to be called with both followers and leaders, the call on a leader will be ignored
-func (r *RedisClusterReconciler) waitForRedisSync(nodeIP string) error {
+func (r *RedisClusterReconciler) waitForRedisSync(ctx context.Context, nodeIP string) error {
 	r.Log.Info("Waiting for SYNC to start on " + nodeIP)
-	if err := wait.PollImmediate(syncCheckInterval, syncCheckTimeout, func() (bool, error) {
-		redisInfo, err := r.RedisCLI.Info(nodeIP)
+	if err := pollImmediate(ctx, syncCheckInterval, syncCheckTimeout, func() (bool, error) {
+		redisInfo, err := r.RedisCLI.Info(ctx, nodeIP)
 		if err != nil {
 			return false, err
 		}
@@ -887,8 +1329,8 @@ func (r *RedisClusterReconciler) waitForRedisSync(nodeIP string) error {
 		r.Log.Info(fmt.Sprintf("[WARN] Timeout waiting for SYNC process to start on %s", nodeIP))
 	}
 
-	return wait.PollImmediate(genericCheckInterval, genericCheckTimeout, func() (bool, error) {
-		redisInfo, err := r.RedisCLI.Info(nodeIP)
+	return pollImmediate(ctx, genericCheckInterval, genericCheckTimeout, func() (bool, error) {
+		redisInfo, err := r.RedisCLI.Info(ctx, nodeIP)
 		if err != nil {
 			return false, err
 		}
@@ -909,10 +1351,10 @@ func (r *RedisClusterReconciler) waitForRedisSync(nodeIP string) error {
 	})
 }
 
-func (r *RedisClusterReconciler) waitForRedisLoad(nodeIP string) error {
+func (r *RedisClusterReconciler) waitForRedisLoad(ctx context.Context, nodeIP string) error {
 	r.Log.Info(fmt.Sprintf("Waiting for node %s to start LOADING", nodeIP))
-	if err := wait.PollImmediate(loadCheckInterval, loadCheckTimeout, func() (bool, error) {
-		redisInfo, err := r.RedisCLI.Info(nodeIP)
+	if err := pollImmediate(ctx, loadCheckInterval, loadCheckTimeout, func() (bool, error) {
+		redisInfo, err := r.RedisCLI.Info(ctx, nodeIP)
 		if err != nil {
 			return false, err
 		}
@@ -932,8 +1374,8 @@ func (r *RedisClusterReconciler) waitForRedisLoad(nodeIP string) error {
 	}
 
 	// waiting for loading process to finish
-	return wait.PollImmediate(genericCheckInterval, genericCheckTimeout, func() (bool, error) {
-		redisInfo, err := r.RedisCLI.Info(nodeIP)
+	return pollImmediate(ctx, genericCheckInterval, genericCheckTimeout, func() (bool, error) {
+		redisInfo, err := r.RedisCLI.Info(ctx, nodeIP)
 		if err != nil {
 			return false, err
 		}
@@ -949,11 +1391,16 @@ func (r *RedisClusterReconciler) waitForRedisLoad(nodeIP string) error {
 	})
 }
 
-func (r *RedisClusterReconciler) waitForRedisReplication(leaderIP string, leaderID string, followerID string) error {
+func (r *RedisClusterReconciler) waitForRedisReplication(ctx context.Context, leaderIP string, leaderID string, followerID string) error {
 	r.Log.Info(fmt.Sprintf("Waiting for CLUSTER REPLICATION (%s, %s)", leaderIP, followerID))
-	return wait.PollImmediate(genericCheckInterval, genericCheckTimeout, func() (bool, error) {
-		replicas, err := r.RedisCLI.ClusterReplicas(leaderIP, leaderID)
+	return pollImmediate(ctx, genericCheckInterval, genericCheckTimeout, func() (bool, error) {
+		replicas, err := r.RedisCLI.ClusterReplicas(ctx, leaderIP, leaderID)
 		if err != nil {
+			var loading *rediscli.LoadingError
+			if errors.As(err, &loading) {
+				// the leader restarted and is still loading its data
+				return false, nil
+			}
 			return false, err
 		}
 		for _, replica := range *replicas {
@@ -965,31 +1412,27 @@ func (r *RedisClusterReconciler) waitForRedisReplication(leaderIP string, leader
 	})
 }
 
-func (r *RedisClusterReconciler) waitForRedisMeet(nodeIP string, newNodeIP string) error {
-	r.Log.Info(fmt.Sprintf("Waiting for CLUSTER MEET (%s, %s)", nodeIP, newNodeIP))
-	return wait.PollImmediate(genericCheckInterval, genericCheckTimeout, func() (bool, error) {
-		clusterNodes, err := r.RedisCLI.ClusterNodes(nodeIP)
+// Waits until the node knows the node with the given ID
+func (r *RedisClusterReconciler) waitForRedisMeet(ctx context.Context, nodeIP string, newNodeID string) error {
+	r.Log.Info(fmt.Sprintf("Waiting for CLUSTER MEET (%s, %s)", nodeIP, newNodeID))
+	return pollImmediate(ctx, genericCheckInterval, genericCheckTimeout, func() (bool, error) {
+		clusterNodes, err := r.RedisCLI.ClusterNodes(ctx, nodeIP)
 		if err != nil {
 			return false, err
 		}
-		for _, node := range *clusterNodes {
-			if strings.Split(node.Addr, ":")[0] == newNodeIP {
-				return true, nil
-			}
-		}
-		return false, nil
+		return clusterNodes.GetNodeByID(newNodeID) != nil, nil
 	})
 }
 
 // Waits for a specified pod to be marked as master
-func (r *RedisClusterReconciler) waitForManualFailover(podIP string) error {
+func (r *RedisClusterReconciler) waitForManualFailover(ctx context.Context, podIP string) error {
 	r.Log.Info(fmt.Sprintf("Waiting for [%s] to become leader", podIP))
-	return wait.PollImmediate(genericCheckInterval, genericCheckTimeout, func() (bool, error) {
-		info, err := r.RedisCLI.Info(podIP)
+	return pollImmediate(ctx, genericCheckInterval, genericCheckTimeout, func() (bool, error) {
+		info, err := r.RedisCLI.Info(ctx, podIP)
 		if err != nil {
 			return false, err
 		}
-		if info.Replication["role"] == "master" {
+		if info.Role() == "master" {
 			return true, nil
 		}
 		return false, nil
@@ -998,7 +1441,7 @@ func (r *RedisClusterReconciler) waitForManualFailover(podIP string) error {
 
 // Waits for Redis to pick a new leader
 // Returns the IP of the promoted follower
-func (r *RedisClusterReconciler) waitForFailover(redisCluster *dbv1.RedisCluster, leader *LeaderNode) (string, error) {
+func (r *RedisClusterReconciler) waitForFailover(ctx context.Context, redisCluster *dbv1.RedisCluster, leader *LeaderNode) (string, error) {
 	r.Log.Info(fmt.Sprintf("Waiting for leader [%s] failover", leader.NodeNumber))
 	failedFollowers := 0
 	var promotedFollowerIP string
@@ -1010,21 +1453,21 @@ func (r *RedisClusterReconciler) waitForFailover(redisCluster *dbv1.RedisCluster
 	}
 
 	if failedFollowers == redisCluster.Spec.LeaderFollowersCount {
-		return "", errors.Errorf("Failing leader [%s] lost all followers. Recovery unsupported.", leader.NodeNumber)
+		return "", errors.Errorf("Failing leader [%s] lost all followers", leader.NodeNumber)
 	}
 
-	return promotedFollowerIP, wait.PollImmediate(genericCheckInterval, genericCheckTimeout, func() (bool, error) {
+	return promotedFollowerIP, pollImmediate(ctx, genericCheckInterval, genericCheckTimeout, func() (bool, error) {
 		for _, follower := range leader.Followers {
 			if follower.Failed {
 				continue
 			}
 
-			info, err := r.RedisCLI.Info(follower.Pod.Status.PodIP)
+			info, err := r.RedisCLI.Info(ctx, follower.Pod.Status.PodIP)
 			if err != nil {
 				continue
 			}
 
-			if info.Replication["role"] == "master" {
+			if info.Role() == "master" {
 				promotedFollowerIP = follower.Pod.Status.PodIP
 				return true, nil
 			}
@@ -1033,7 +1476,7 @@ func (r *RedisClusterReconciler) waitForFailover(redisCluster *dbv1.RedisCluster
 	})
 }
 
-func (r *RedisClusterReconciler) isPodUpToDate(redisCluster *dbv1.RedisCluster, pod *corev1.Pod) (bool, error) {
+func (r *RedisClusterReconciler) isPodUpToDate(ctx context.Context, redisCluster *dbv1.RedisCluster, pod *corev1.Pod) (bool, error) {
 	for _, container := range pod.Spec.Containers {
 		for _, crContainer := range redisCluster.Spec.RedisPodSpec.Containers {
 			if crContainer.Name == container.Name {
@@ -1047,13 +1490,13 @@ func (r *RedisClusterReconciler) isPodUpToDate(redisCluster *dbv1.RedisCluster,
 }
 
 // Checks if the image declared by the custom resource is the same as the image in the pods
-func (r *RedisClusterReconciler) isClusterUpToDate(redisCluster *dbv1.RedisCluster) (bool, error) {
-	pods, err := r.getRedisClusterPods(redisCluster)
+func (r *RedisClusterReconciler) isClusterUpToDate(ctx context.Context, redisCluster *dbv1.RedisCluster) (bool, error) {
+	pods, err := r.getRedisClusterPods(ctx, redisCluster)
 	if err != nil {
 		return false, err
 	}
 	for _, pod := range pods {
-		podUpdated, err := r.isPodUpToDate(redisCluster, &pod)
+		podUpdated, err := r.isPodUpToDate(ctx, redisCluster, &pod)
 		if err != nil {
 			return false, err
 		}
@@ -1064,8 +1507,8 @@ func (r *RedisClusterReconciler) isClusterUpToDate(redisCluster *dbv1.RedisClust
 	return true, nil
 }
 
-func (r *RedisClusterReconciler) isClusterComplete(redisCluster *dbv1.RedisCluster) (bool, error) {
-	clusterView, err := r.NewRedisClusterView(redisCluster)
+func (r *RedisClusterReconciler) isClusterComplete(ctx context.Context, redisCluster *dbv1.RedisCluster) (bool, error) {
+	clusterView, err := r.NewRedisClusterView(ctx, redisCluster)
 	if err != nil {
 		return false, err
 	}
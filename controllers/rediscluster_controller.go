@@ -19,13 +19,22 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	dbv1 "github.com/PayU/Redis-Operator/api/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -38,58 +47,96 @@ type RedisClusterReconciler struct {
 	client.Client
 	Log      logr.Logger
 	Scheme   *runtime.Scheme
-	RedisCLI *rediscli.RedisCLI
+	RedisCLI rediscli.RedisAdmin
 	State    RedisClusterState
+
+	// ResyncInterval is the period after which a cluster is reconciled again
+	// even if no Kubernetes event was received; zero disables the resync
+	ResyncInterval time.Duration
+
+	// HealthEvents receives reconcile requests from the RedisHealthMonitor
+	HealthEvents <-chan event.GenericEvent
+
+	// Recorder publishes the Kubernetes events of the RedisCluster resources
+	Recorder record.EventRecorder
+
+	// ctx is cancelled when the manager stops so that in-flight Redis commands
+	// and polls are interrupted instead of blocking the shutdown
+	ctx context.Context
+
+	// snapshots caches the state of the Redis nodes for the current reconcile loop
+	snapshots    map[types.NamespacedName]*ClusterSnapshot
+	snapshotLock sync.Mutex
 }
 
 // +kubebuilder:rbac:groups=db.payu.com,resources=redisclusters,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=db.payu.com,resources=redisclusters/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=*,resources=pods;services;configmaps,verbs=create;update;patch;get;list;watch;delete
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *RedisClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	r.Log.Info("Reconciling RedisCluster")
 
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
 	r.Status()
+	r.invalidateClusterSnapshot()
 
 	var redisCluster dbv1.RedisCluster
 	var err error
 
-	if err = r.Get(context.Background(), req.NamespacedName, &redisCluster); err != nil {
+	if err = r.Get(ctx, req.NamespacedName, &redisCluster); err != nil {
 		r.Log.Info("Unable to fetch RedisCluster resource")
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	ctx = withNodePorts(ctx, &redisCluster)
 	r.State = getCurrentClusterState(&redisCluster)
+	originalStatus := redisCluster.Status.DeepCopy()
 
 	switch r.State {
 	case NotExists:
 		redisCluster.Status.ClusterState = string(InitializingCluster)
-		err = r.handleInitializingCluster(&redisCluster)
+		err = r.handleInitializingCluster(ctx, &redisCluster)
 		break
 	case InitializingCluster:
-		err = r.handleInitializingCluster(&redisCluster)
+		err = r.handleInitializingCluster(ctx, &redisCluster)
 		break
 	case InitializingFollowers:
-		err = r.handleInitializingFollowers(&redisCluster)
+		err = r.handleInitializingFollowers(ctx, &redisCluster)
 		break
 	case Ready:
-		err = r.handleReadyState(&redisCluster)
+		err = r.handleReadyState(ctx, &redisCluster)
 		break
 	case Recovering:
-		err = r.handleRecoveringState(&redisCluster)
+		err = r.handleRecoveringState(ctx, &redisCluster)
 		break
 	case Updating:
-		err = r.handleUpdatingState(&redisCluster)
+		err = r.handleUpdatingState(ctx, &redisCluster)
 		break
 	}
 
 	if err != nil {
 		r.Log.Error(err, "Handling error")
+	} else if state := getCurrentClusterState(&redisCluster); state != InitializingCluster && state != InitializingFollowers {
+		if err = r.syncAnnouncedHostnames(ctx, &redisCluster); err != nil {
+			r.Log.Error(err, "Failed to announce the node hostnames")
+		}
+		if err = r.syncExternalEndpoints(ctx, &redisCluster); err != nil {
+			r.Log.Error(err, "Failed to announce the external addresses")
+		}
+		if err = r.syncPodRoleLabels(ctx, &redisCluster); err != nil {
+			r.Log.Error(err, "Failed to update the pod roles")
+		}
 	}
 
 	clusterState := getCurrentClusterState(&redisCluster)
-	if clusterState != r.State {
-		err := r.Status().Update(context.Background(), &redisCluster)
+	if clusterState != r.State || !reflect.DeepEqual(originalStatus, &redisCluster.Status) {
+		err := r.Status().Update(ctx, &redisCluster)
 		if err != nil && !apierrors.IsConflict(err) {
 			r.Log.Info("Failed to update state to " + string(clusterState))
 			return ctrl.Result{}, err
@@ -103,19 +150,41 @@ func (r *RedisClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error
 		r.Log.Info(fmt.Sprintf("Updated state to: [%s]", clusterState))
 	}
 
-	return ctrl.Result{}, nil
+	result := ctrl.Result{RequeueAfter: r.ResyncInterval}
+	if rebalanceInProgress(&redisCluster) {
+		requeueWithin(&result, rebalanceRequeueInterval)
+	}
+	if partitionSuspected(&redisCluster) {
+		requeueWithin(&result, partitionRequeueInterval)
+	}
+	return result, nil
+}
+
+// Shortens the delay of the next reconcile loop to the interval, if it is longer
+func requeueWithin(result *ctrl.Result, interval time.Duration) {
+	if result.RequeueAfter == 0 || result.RequeueAfter > interval {
+		result.RequeueAfter = interval
+	}
 }
 
 func (r *RedisClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.Pod{}, "status.podIP", func(rawObj runtime.Object) []string {
-		pod := rawObj.(*corev1.Pod)
-		return []string{pod.Status.PodIP}
-	}); err != nil {
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		<-stop
+		cancel()
+		return nil
+	})); err != nil {
+		cancel()
 		return err
 	}
-	return ctrl.NewControllerManagedBy(mgr).
+	r.ctx = ctx
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&dbv1.RedisCluster{}).
 		Owns(&corev1.Pod{}).
-		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
-		Complete(r)
+		Owns(&corev1.Service{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 1})
+	if r.HealthEvents != nil {
+		builder = builder.Watches(&source.Channel{Source: r.HealthEvents}, &handler.EnqueueRequestForObject{})
+	}
+	return builder.Complete(r)
 }
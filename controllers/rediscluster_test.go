@@ -0,0 +1,1230 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	dbv1 "github.com/PayU/Redis-Operator/api/v1"
+	"github.com/PayU/Redis-Operator/controllers/rediscli"
+	"github.com/PayU/Redis-Operator/controllers/rediscli/fake"
+)
+
+// Name of the Kubernetes node running all the pods
+const testNodeName = "k8s-node-1"
+
+// testClient wraps the controller-runtime fake client to play the role of the
+// kubelet: created pods get an IP and a running Redis node in the fake cluster,
+// deleted pods take their Redis node down.
+type testClient struct {
+	client.Client
+	redis  *fake.Cluster
+	nextIP int
+	ipv6   bool
+	// loadBalancerHostnames gives the LoadBalancer Services a hostname instead
+	// of an IP, like the AWS ELBs
+	loadBalancerHostnames bool
+	// deletedNodes are the Redis nodes of the deleted pods as they were when
+	// their pod was deleted, by pod name
+	deletedNodes map[string]fake.Node
+	// persistentKeys are the keys per slot that the Redis node of a pod loads
+	// on startup, like from a persistent volume, by pod name
+	persistentKeys map[string]map[int]int
+	// backupKeys are the keys per slot of the latest backup of a shard, by
+	// leader number, loaded by the pods running the restore container
+	backupKeys map[string]map[int]int
+}
+
+func (c *testClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	if service, isService := obj.(*corev1.Service); isService {
+		// the external Services get an address right away
+		c.nextIP++
+		switch service.Spec.Type {
+		case corev1.ServiceTypeLoadBalancer:
+			service.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: fmt.Sprintf("192.0.2.%d", c.nextIP%250+1)}}
+			if c.loadBalancerHostnames {
+				service.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{Hostname: fmt.Sprintf("%s.elb.example.com", service.Name)}}
+			}
+		case corev1.ServiceTypeNodePort:
+			for i := range service.Spec.Ports {
+				service.Spec.Ports[i].NodePort = int32(30000 + 10*c.nextIP + i)
+			}
+		}
+	}
+	pod, isPod := obj.(*corev1.Pod)
+	if !isPod {
+		return c.Client.Create(ctx, obj, opts...)
+	}
+	pod.Spec.NodeName = testNodeName
+	c.nextIP++
+	pod.Status.PodIP = fmt.Sprintf("10.0.%d.%d", c.nextIP/250, c.nextIP%250+1)
+	if c.ipv6 {
+		pod.Status.PodIP = fmt.Sprintf("fd00::a:%x", c.nextIP)
+	}
+	pod.Status.Phase = corev1.PodRunning
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	if err := c.Client.Create(ctx, obj, opts...); err != nil {
+		return err
+	}
+	// the Redis process listens on the ports declared by the pod
+	port, busPort := rediscli.DefaultPort, 0
+	for _, containerPort := range pod.Spec.Containers[0].Ports {
+		switch containerPort.Name {
+		case "redis-client":
+			port = int(containerPort.ContainerPort)
+		case "redis-bus":
+			busPort = int(containerPort.ContainerPort)
+		}
+	}
+	c.redis.AddNodeWithPorts(pod.Status.PodIP, port, busPort)
+	for slot, keys := range c.persistentKeys[pod.Name] {
+		c.redis.SetSlotKeys(pod.Status.PodIP, slot, keys)
+	}
+	if hasBackupRestore(pod) {
+		for slot, keys := range c.backupKeys[pod.Labels["leader-number"]] {
+			c.redis.SetSlotKeys(pod.Status.PodIP, slot, keys)
+		}
+	}
+	return nil
+}
+
+func (c *testClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	if pod, isPod := obj.(*corev1.Pod); isPod {
+		if node, found := c.redis.GetNode(pod.Status.PodIP); found {
+			if c.deletedNodes == nil {
+				c.deletedNodes = make(map[string]fake.Node)
+			}
+			c.deletedNodes[pod.Name] = node
+		}
+		c.redis.RemoveNode(pod.Status.PodIP)
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+type testEnv struct {
+	t            *testing.T
+	reconciler   *RedisClusterReconciler
+	client       *testClient
+	redis        *fake.Cluster
+	recorder     *record.FakeRecorder
+	redisCluster types.NamespacedName
+}
+
+func init() {
+	syncCheckInterval = time.Millisecond
+	syncCheckTimeout = 50 * time.Millisecond
+	loadCheckInterval = time.Millisecond
+	loadCheckTimeout = 50 * time.Millisecond
+	genericCheckInterval = time.Millisecond
+	genericCheckTimeout = 200 * time.Millisecond
+	clusterCreateInterval = time.Millisecond
+	clusterCreateTimeout = 200 * time.Millisecond
+	partitionGracePeriod = 100 * time.Millisecond
+}
+
+func newTestEnv(t *testing.T, leaderCount int, followersCount int) *testEnv {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = dbv1.AddToScheme(scheme)
+
+	redisCluster := &dbv1.RedisCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "rdc-test", Namespace: "default"},
+		Spec: dbv1.RedisClusterSpec{
+			LeaderCount:          leaderCount,
+			LeaderFollowersCount: followersCount,
+			PodLabelSelector:     map[string]string{"app": "redis-cluster-pod"},
+			RedisPodSpec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "redis-container", Image: "redis:testing"}},
+			},
+		},
+	}
+
+	redis := fake.NewCluster()
+	c := &testClient{Client: fakeclient.NewFakeClientWithScheme(scheme, redisCluster), redis: redis, persistentKeys: make(map[string]map[int]int), backupKeys: make(map[string]map[int]int)}
+	recorder := record.NewFakeRecorder(100)
+	return &testEnv{
+		t: t,
+		reconciler: &RedisClusterReconciler{
+			Client:   c,
+			Log:      ctrl.Log.WithName("test"),
+			Scheme:   scheme,
+			RedisCLI: redis,
+			State:    NotExists,
+			Recorder: recorder,
+		},
+		client:       c,
+		redis:        redis,
+		recorder:     recorder,
+		redisCluster: types.NamespacedName{Name: redisCluster.Name, Namespace: redisCluster.Namespace},
+	}
+}
+
+func (e *testEnv) getRedisCluster() *dbv1.RedisCluster {
+	var redisCluster dbv1.RedisCluster
+	if err := e.client.Get(context.Background(), e.redisCluster, &redisCluster); err != nil {
+		e.t.Fatalf("Failed to get RedisCluster: %v", err)
+	}
+	return &redisCluster
+}
+
+// Runs reconcile loops until the cluster reaches the expected state
+func (e *testEnv) reconcileUntil(state RedisClusterState, maxLoops int) {
+	for i := 0; i < maxLoops; i++ {
+		if _, err := e.reconciler.Reconcile(ctrl.Request{NamespacedName: e.redisCluster}); err != nil {
+			e.t.Fatalf("Reconcile failed: %v", err)
+		}
+		if getCurrentClusterState(e.getRedisCluster()) == state {
+			return
+		}
+	}
+	e.t.Fatalf("Cluster did not reach state %s, current state: %s", state, getCurrentClusterState(e.getRedisCluster()))
+}
+
+func (e *testEnv) getPod(name string) *corev1.Pod {
+	var pod corev1.Pod
+	if err := e.client.Get(context.Background(), types.NamespacedName{Name: name, Namespace: e.redisCluster.Namespace}, &pod); err != nil {
+		e.t.Fatalf("Failed to get pod %s: %v", name, err)
+	}
+	return &pod
+}
+
+func (e *testEnv) getPods() []corev1.Pod {
+	var pods corev1.PodList
+	if err := e.client.List(context.Background(), &pods, client.InNamespace(e.redisCluster.Namespace)); err != nil {
+		e.t.Fatalf("Failed to list pods: %v", err)
+	}
+	return pods.Items
+}
+
+func (e *testEnv) deletePod(name string) {
+	if err := e.client.Delete(context.Background(), e.getPod(name)); err != nil {
+		e.t.Fatalf("Failed to delete pod %s: %v", name, err)
+	}
+}
+
+// Checks that every shard has a master, the expected replicas and that all slots are covered
+func (e *testEnv) checkClusterHealthy(leaderCount int, followersCount int) {
+	masters := e.redis.Masters()
+	if len(masters) != leaderCount {
+		e.t.Fatalf("Expected %d masters, found %d", leaderCount, len(masters))
+	}
+	for _, master := range masters {
+		if replicas := e.redis.Replicas(master.ID); len(replicas) != followersCount {
+			e.t.Errorf("Master %s(%s) has %d replicas, expected %d", master.ID, master.IP, len(replicas), followersCount)
+		}
+	}
+	if covered := e.redis.CoveredSlots(); covered != 16384 {
+		e.t.Errorf("Only %d slots are covered", covered)
+	}
+	redisCluster := e.getRedisCluster()
+	complete, err := e.reconciler.isClusterComplete(withNodePorts(context.Background(), redisCluster), redisCluster)
+	if err != nil || !complete {
+		e.t.Errorf("Cluster is not complete: %v", err)
+	}
+}
+
+func TestCreateCluster(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	env.reconcileUntil(Ready, 5)
+	env.checkClusterHealthy(3, 1)
+}
+
+func TestFollowerFailure(t *testing.T) {
+	env := newTestEnv(t, 3, 2)
+	env.reconcileUntil(Ready, 5)
+
+	env.deletePod("redis-node-4")
+	env.reconcileUntil(Recovering, 2)
+	env.reconcileUntil(Ready, 5)
+	env.checkClusterHealthy(3, 2)
+}
+
+func TestLeaderProcessFailure(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	env.reconcileUntil(Ready, 5)
+
+	env.redis.StopNode(env.getPod("redis-node-1").Status.PodIP)
+	env.reconcileUntil(Recovering, 2)
+	env.reconcileUntil(Ready, 5)
+	env.checkClusterHealthy(3, 1)
+
+	// the promoted follower keeps the leadership and the recreated leader pod follows it
+	promoted, _ := env.redis.GetNode(env.getPod("redis-node-4").Status.PodIP)
+	if !promoted.IsMaster() {
+		t.Errorf("Promoted follower redis-node-4 is not a Redis master")
+	}
+	if node, found := env.redis.GetNode(env.getPod("redis-node-1").Status.PodIP); !found || node.MasterID != promoted.ID {
+		t.Errorf("Recreated leader pod redis-node-1 does not replicate redis-node-4")
+	}
+}
+
+// The followers are updated first, then every leader fails over to one of its
+// followers and is recreated with the new image. The nodes announce external
+// addresses, the promoted followers are still found by their pod.
+func TestRollingUpdate(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	env.enableExternalAccess(&dbv1.ExternalAccessSpec{Enabled: true})
+	env.reconcileUntil(Ready, 5)
+	slot, ip := env.firstSlotOf("redis-node-0")
+	env.redis.SetSlotKeys(ip, slot, 50)
+
+	redisCluster := env.getRedisCluster()
+	redisCluster.Spec.RedisPodSpec.Containers[0].Image = "redis:updated"
+	if err := env.client.Update(context.Background(), redisCluster); err != nil {
+		t.Fatalf("Failed to update RedisCluster: %v", err)
+	}
+	env.reconcileUntil(Updating, 2)
+	env.reconcileUntil(Ready, 1)
+
+	pods := env.getPods()
+	if len(pods) != 6 {
+		t.Errorf("Expected 6 pods after the update, found %d", len(pods))
+	}
+	for _, pod := range pods {
+		if image := pod.Spec.Containers[0].Image; image != "redis:updated" {
+			t.Errorf("Pod %s runs image %s", pod.Name, image)
+		}
+	}
+	if keys := env.redis.SlotKeys(env.getPod("redis-node-0").Status.PodIP, slot); keys != 50 {
+		t.Errorf("Expected 50 keys in slot %d after the update, found %d", slot, keys)
+	}
+	env.checkClusterHealthy(3, 1)
+	env.checkExternalEndpoints()
+}
+
+// Leaders without followers fail over to a surge follower while their pod is
+// replaced. The surge followers announce their hostname or external address
+// like the other nodes and their Services are deleted with them.
+func TestRollingUpdateWithoutFollowers(t *testing.T) {
+	tests := []struct {
+		name              string
+		announceHostnames bool
+		externalAccess    *dbv1.ExternalAccessSpec
+	}{
+		{name: "pod addresses"},
+		{name: "announced hostnames", announceHostnames: true},
+		{name: "external access", externalAccess: &dbv1.ExternalAccessSpec{Enabled: true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := newTestEnv(t, 3, 0)
+			redisCluster := env.getRedisCluster()
+			redisCluster.Spec.AnnounceHostnames = test.announceHostnames
+			redisCluster.Spec.ExternalAccess = test.externalAccess
+			if err := env.client.Update(context.Background(), redisCluster); err != nil {
+				t.Fatalf("Failed to update RedisCluster: %v", err)
+			}
+			env.reconcileUntil(Ready, 5)
+			slot, ip := env.firstSlotOf("redis-node-0")
+			env.redis.SetSlotKeys(ip, slot, 50)
+
+			redisCluster = env.getRedisCluster()
+			redisCluster.Spec.RedisPodSpec.Containers[0].Image = "redis:updated"
+			if err := env.client.Update(context.Background(), redisCluster); err != nil {
+				t.Fatalf("Failed to update RedisCluster: %v", err)
+			}
+			env.reconcileUntil(Updating, 2)
+			env.reconcileUntil(Ready, 2)
+
+			pods := env.getPods()
+			if len(pods) != 3 {
+				t.Errorf("Expected 3 pods after the update, found %d", len(pods))
+			}
+			for _, pod := range pods {
+				if isSurgePod(&pod) {
+					t.Errorf("Surge pod %s was not removed", pod.Name)
+				}
+				if image := pod.Spec.Containers[0].Image; image != "redis:updated" {
+					t.Errorf("Pod %s runs image %s", pod.Name, image)
+				}
+			}
+			if keys := env.redis.SlotKeys(env.getPod("redis-node-0").Status.PodIP, slot); keys != 50 {
+				t.Errorf("Expected 50 keys in slot %d after the update, found %d", slot, keys)
+			}
+
+			// the surge follower of the first shard, node 3, led it during the update
+			surge, found := env.client.deletedNodes["redis-node-3"]
+			if !found {
+				t.Fatalf("The surge pod redis-node-3 was not deleted")
+			}
+			if hostname := nodeHostname(redisCluster, "3"); test.announceHostnames && surge.Hostname != hostname {
+				t.Errorf("The surge node announced hostname %q instead of %s", surge.Hostname, hostname)
+			}
+			if test.externalAccess != nil && !strings.HasPrefix(surge.AnnounceIP, "192.0.2.") {
+				t.Errorf("The surge node announced %q instead of the address of its load balancer", surge.AnnounceIP)
+			}
+			for _, nodeNumber := range []string{"3", "4", "5"} {
+				for _, name := range []string{"redis-node-" + nodeNumber, externalServiceName(nodeNumber)} {
+					err := env.client.Get(context.Background(), types.NamespacedName{Name: name, Namespace: env.redisCluster.Namespace}, &corev1.Service{})
+					if !apierrors.IsNotFound(err) {
+						t.Errorf("Service %s of a surge node was not deleted: %v", name, err)
+					}
+				}
+			}
+			if test.externalAccess != nil {
+				env.checkExternalEndpoints()
+			}
+			env.checkClusterHealthy(3, 0)
+		})
+	}
+}
+
+func TestPodRoleLabels(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	env.reconcileUntil(Ready, 5)
+
+	env.redis.StopNode(env.getPod("redis-node-1").Status.PodIP)
+	env.reconcileUntil(Recovering, 2)
+	env.reconcileUntil(Ready, 5)
+	env.reconcileUntil(Ready, 1)
+
+	expectedRoles := map[string]string{
+		"redis-node-0": "leader", "redis-node-1": "follower", "redis-node-2": "leader",
+		"redis-node-3": "follower", "redis-node-4": "leader", "redis-node-5": "follower",
+	}
+	for name, role := range expectedRoles {
+		pod := env.getPod(name)
+		node, _ := env.redis.GetNode(pod.Status.PodIP)
+		if pod.Labels["redis-node-role"] != role {
+			t.Errorf("Pod %s has role %s, expected %s", name, pod.Labels["redis-node-role"], role)
+		}
+		if pod.Annotations[redisNodeIDAnnotation] != node.ID {
+			t.Errorf("Pod %s has node ID %s, expected %s", name, pod.Annotations[redisNodeIDAnnotation], node.ID)
+		}
+		if _, hasSlots := pod.Annotations[redisSlotsAnnotation]; hasSlots != (role == "leader") {
+			t.Errorf("Pod %s has slot annotation %q", name, pod.Annotations[redisSlotsAnnotation])
+		}
+	}
+}
+
+func TestServices(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	env.reconcileUntil(Ready, 5)
+	env.reconcileUntil(Ready, 1)
+
+	getService := func(name string) *corev1.Service {
+		var service corev1.Service
+		if err := env.client.Get(context.Background(), types.NamespacedName{Name: name, Namespace: env.redisCluster.Namespace}, &service); err != nil {
+			t.Fatalf("Failed to get service %s: %v", name, err)
+		}
+		return &service
+	}
+	if selector := getService("redis-cluster-leader-service").Spec.Selector; selector["redis-node-role"] != "leader" || selector["app"] != "redis-cluster-pod" {
+		t.Errorf("Unexpected leader service selector: %v", selector)
+	}
+	if selector := getService("redis-cluster-replica-service").Spec.Selector; selector["redis-node-role"] != "follower" || selector[replicaReadyLabel] != "true" {
+		t.Errorf("Unexpected replica service selector: %v", selector)
+	}
+	for nodeNumber := 0; nodeNumber < 6; nodeNumber++ {
+		service := getService(fmt.Sprintf("redis-node-%d", nodeNumber))
+		if service.Spec.ClusterIP != corev1.ClusterIPNone || service.Spec.Selector["node-number"] != fmt.Sprint(nodeNumber) {
+			t.Errorf("Unexpected node service %s: %v", service.Name, service.Spec)
+		}
+	}
+	for _, name := range []string{"redis-node-3", "redis-node-4", "redis-node-5"} {
+		if pod := env.getPod(name); pod.Labels[replicaReadyLabel] != "true" {
+			t.Errorf("Follower %s is not labelled as ready", name)
+		}
+	}
+
+	// scaling down deletes the Services of the removed nodes, except for the
+	// surge pods that follow the new layout
+	redisCluster := env.getRedisCluster()
+	redisCluster.Spec.LeaderFollowersCount = 0
+	surgePod := env.getPod("redis-node-3")
+	surgePod.Labels[surgeLabel] = "true"
+	if err := env.client.Update(context.Background(), surgePod); err != nil {
+		t.Fatalf("Failed to update pod %s: %v", surgePod.Name, err)
+	}
+	if err := env.reconciler.createMissingServices(context.Background(), redisCluster); err != nil {
+		t.Fatalf("Failed to sync the services: %v", err)
+	}
+	for nodeNumber := 0; nodeNumber < 6; nodeNumber++ {
+		name := fmt.Sprintf("redis-node-%d", nodeNumber)
+		err := env.client.Get(context.Background(), types.NamespacedName{Name: name, Namespace: env.redisCluster.Namespace}, &corev1.Service{})
+		if nodeNumber <= 3 && err != nil {
+			t.Errorf("Service %s was deleted: %v", name, err)
+		}
+		if nodeNumber > 3 && !apierrors.IsNotFound(err) {
+			t.Errorf("Service %s of a removed node was not deleted: %v", name, err)
+		}
+	}
+}
+
+func TestAnnounceHostnames(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	redisCluster := env.getRedisCluster()
+	redisCluster.Spec.AnnounceHostnames = true
+	if err := env.client.Update(context.Background(), redisCluster); err != nil {
+		t.Fatalf("Failed to update RedisCluster: %v", err)
+	}
+	env.reconcileUntil(Ready, 5)
+
+	// a restarted Redis process loses the runtime configuration
+	ip := env.getPod("redis-node-2").Status.PodIP
+	if _, err := env.redis.ConfigSet(context.Background(), ip, "cluster-announce-hostname", ""); err != nil {
+		t.Fatalf("Failed to reset hostname: %v", err)
+	}
+	env.reconcileUntil(Ready, 1)
+
+	for nodeNumber := 0; nodeNumber < 6; nodeNumber++ {
+		pod := env.getPod(fmt.Sprintf("redis-node-%d", nodeNumber))
+		node, _ := env.redis.GetNode(pod.Status.PodIP)
+		if expected := fmt.Sprintf("redis-node-%d.default.svc", nodeNumber); node.Hostname != expected {
+			t.Errorf("Node %s announces hostname %q, expected %q", pod.Name, node.Hostname, expected)
+		}
+	}
+}
+
+func TestPreferredLeaderRestoration(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	redisCluster := env.getRedisCluster()
+	redisCluster.Spec.PreferredLeaders = &dbv1.PreferredLeadersSpec{Enabled: true, MinInterval: &metav1.Duration{Duration: time.Hour}}
+	if err := env.client.Update(context.Background(), redisCluster); err != nil {
+		t.Fatalf("Failed to update RedisCluster: %v", err)
+	}
+	env.reconcileUntil(Ready, 5)
+
+	env.redis.StopNode(env.getPod("redis-node-1").Status.PodIP)
+	env.redis.StopNode(env.getPod("redis-node-2").Status.PodIP)
+	env.reconcileUntil(Recovering, 2)
+	env.reconcileUntil(Ready, 5)
+
+	// one shard is restored per interval
+	env.reconcileUntil(Ready, 1)
+	env.reconcileUntil(Ready, 1)
+	masters := 0
+	for _, name := range []string{"redis-node-0", "redis-node-1", "redis-node-2"} {
+		if node, _ := env.redis.GetNode(env.getPod(name).Status.PodIP); node.IsMaster() {
+			masters++
+		}
+	}
+	if masters != 2 {
+		t.Errorf("Expected 2 designated leaders to be masters, found %d", masters)
+	}
+	if env.getRedisCluster().Status.LastLeaderRestoration == nil {
+		t.Errorf("Leader restoration time not set in the status")
+	}
+
+	redisCluster = env.getRedisCluster()
+	redisCluster.Status.LastLeaderRestoration = nil
+	if err := env.client.Status().Update(context.Background(), redisCluster); err != nil {
+		t.Fatalf("Failed to update RedisCluster status: %v", err)
+	}
+	env.reconcileUntil(Ready, 1)
+	for _, name := range []string{"redis-node-0", "redis-node-1", "redis-node-2"} {
+		if node, _ := env.redis.GetNode(env.getPod(name).Status.PodIP); !node.IsMaster() {
+			t.Errorf("Designated leader %s is not a Redis master", name)
+		}
+	}
+	env.checkClusterHealthy(3, 1)
+}
+
+// Followers replicating the leader of another shard are moved back to their own shard
+func TestRoleDrift(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	env.reconcileUntil(Ready, 5)
+
+	leader0, _ := env.redis.GetNode(env.getPod("redis-node-0").Status.PodIP)
+	follower1 := env.getPod("redis-node-4").Status.PodIP
+	if _, err := env.redis.ClusterReplicate(context.Background(), follower1, leader0.ID); err != nil {
+		t.Fatalf("Failed to replicate: %v", err)
+	}
+
+	env.reconcileUntil(Ready, 1)
+	leader1, _ := env.redis.GetNode(env.getPod("redis-node-1").Status.PodIP)
+	if node, _ := env.redis.GetNode(follower1); node.MasterID != leader1.ID {
+		t.Errorf("Follower redis-node-4 replicates %s instead of %s", node.MasterID, leader1.ID)
+	}
+	env.checkClusterHealthy(3, 1)
+}
+
+func TestIPv6Cluster(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	env.client.ipv6 = true
+	env.reconcileUntil(Ready, 5)
+	env.checkClusterHealthy(3, 1)
+
+	env.redis.StopNode(env.getPod("redis-node-0").Status.PodIP)
+	env.deletePod("redis-node-5")
+	env.reconcileUntil(Recovering, 2)
+	env.reconcileUntil(Ready, 5)
+	env.checkClusterHealthy(3, 1)
+}
+
+func TestCustomPorts(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	redisCluster := env.getRedisCluster()
+	redisCluster.Spec.Port = 7000
+	redisCluster.Spec.BusPort = 7100
+	if err := env.client.Update(context.Background(), redisCluster); err != nil {
+		t.Fatalf("Failed to update RedisCluster: %v", err)
+	}
+	env.reconcileUntil(Ready, 5)
+	env.checkClusterHealthy(3, 1)
+
+	ports := env.getPod("redis-node-0").Spec.Containers[0].Ports
+	if len(ports) != 2 || ports[0].ContainerPort != 7000 || ports[1].ContainerPort != 7100 {
+		t.Errorf("Unexpected container ports: %v", ports)
+	}
+	var service corev1.Service
+	if err := env.client.Get(context.Background(), types.NamespacedName{Name: "redis-cluster-service", Namespace: env.redisCluster.Namespace}, &service); err != nil {
+		t.Fatalf("Failed to get service: %v", err)
+	}
+	if port := service.Spec.Ports[0]; port.Port != 7000 || port.TargetPort.IntValue() != 7000 {
+		t.Errorf("Unexpected service port: %v", port)
+	}
+	nodes, err := env.redis.ClusterNodes(rediscli.WithNodePorts(context.Background(), 7000, 7100), env.getPod("redis-node-0").Status.PodIP)
+	if err != nil {
+		t.Fatalf("Failed to get cluster nodes: %v", err)
+	}
+	for _, node := range *nodes {
+		if _, port := node.IPAndPort(); port != "7000" || node.BusPort() != "7100" {
+			t.Errorf("Unexpected address of node %s: %s", node.ID, node.Addr)
+		}
+	}
+
+	env.deletePod("redis-node-0")
+	env.reconcileUntil(Recovering, 2)
+	env.reconcileUntil(Ready, 5)
+	env.checkClusterHealthy(3, 1)
+}
+
+// Checks that every node announces the external address of its Service
+func (e *testEnv) checkExternalEndpoints() {
+	for _, pod := range e.getPods() {
+		endpoint, err := e.reconciler.getExternalEndpoint(context.Background(), e.getRedisCluster(), &pod)
+		if err != nil || endpoint == nil {
+			e.t.Fatalf("No external address for %s: %v", pod.Name, err)
+		}
+		node, _ := e.redis.GetNode(pod.Status.PodIP)
+		if node.AnnounceIP != endpoint.IP || node.Hostname != endpoint.Hostname || int32(node.AnnouncePort) != endpoint.Port || int32(node.AnnounceBusPort) != endpoint.BusPort {
+			e.t.Errorf("Node %s announces %s:%d@%d (%q) instead of %s", pod.Name, node.AnnounceIP, node.AnnouncePort, node.AnnounceBusPort, node.Hostname, endpoint)
+		}
+	}
+}
+
+func (e *testEnv) enableExternalAccess(externalAccess *dbv1.ExternalAccessSpec) {
+	redisCluster := e.getRedisCluster()
+	redisCluster.Spec.ExternalAccess = externalAccess
+	if err := e.client.Update(context.Background(), redisCluster); err != nil {
+		e.t.Fatalf("Failed to update RedisCluster: %v", err)
+	}
+}
+
+func TestExternalAccessLoadBalancer(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	env.enableExternalAccess(&dbv1.ExternalAccessSpec{Enabled: true})
+	env.reconcileUntil(Ready, 5)
+	env.checkClusterHealthy(3, 1)
+	env.checkExternalEndpoints()
+
+	// the recreated pods announce the address of the load balancer again
+	env.deletePod("redis-node-0")
+	env.deletePod("redis-node-4")
+	env.reconcileUntil(Recovering, 2)
+	env.reconcileUntil(Ready, 5)
+	env.reconcileUntil(Ready, 1)
+	env.checkClusterHealthy(3, 1)
+	env.checkExternalEndpoints()
+
+	// a restarted Redis process loses the runtime configuration
+	node1IP := env.getPod("redis-node-1").Status.PodIP
+	if _, err := env.redis.ConfigSet(context.Background(), node1IP, "cluster-announce-ip", ""); err != nil {
+		t.Fatalf("Failed to reset the announced IP: %v", err)
+	}
+	env.reconcileUntil(Ready, 1)
+	env.checkExternalEndpoints()
+
+	// disabling the external access removes the Services and the announced addresses
+	env.enableExternalAccess(nil)
+	env.reconcileUntil(Ready, 1)
+	for _, pod := range env.getPods() {
+		if node, _ := env.redis.GetNode(pod.Status.PodIP); node.AnnounceIP != "" || node.AnnouncePort != 0 {
+			t.Errorf("Node %s still announces %s:%d", pod.Name, node.AnnounceIP, node.AnnouncePort)
+		}
+		var service corev1.Service
+		err := env.client.Get(context.Background(), types.NamespacedName{Name: externalServiceName(pod.Labels["node-number"]), Namespace: pod.Namespace}, &service)
+		if !apierrors.IsNotFound(err) {
+			t.Errorf("External service of %s was not deleted: %v", pod.Name, err)
+		}
+	}
+}
+
+// Load balancers with a hostname and no IP are announced through the hostname
+func TestExternalAccessLoadBalancerHostname(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	env.client.loadBalancerHostnames = true
+	env.enableExternalAccess(&dbv1.ExternalAccessSpec{Enabled: true})
+	env.reconcileUntil(Ready, 5)
+	env.checkClusterHealthy(3, 1)
+	env.checkExternalEndpoints()
+	if node, _ := env.redis.GetNode(env.getPod("redis-node-0").Status.PodIP); node.Hostname != "redis-node-0-external.elb.example.com" {
+		t.Errorf("Unexpected announced hostname %q", node.Hostname)
+	}
+
+	// a restarted Redis process loses the runtime configuration
+	node1IP := env.getPod("redis-node-1").Status.PodIP
+	if _, err := env.redis.ConfigSet(context.Background(), node1IP, "cluster-announce-hostname", ""); err != nil {
+		t.Fatalf("Failed to reset the announced hostname: %v", err)
+	}
+	env.reconcileUntil(Ready, 1)
+	env.checkExternalEndpoints()
+
+	env.enableExternalAccess(nil)
+	env.reconcileUntil(Ready, 1)
+	for _, pod := range env.getPods() {
+		if node, _ := env.redis.GetNode(pod.Status.PodIP); node.Hostname != "" || node.AnnouncePort != 0 {
+			t.Errorf("Node %s still announces %s:%d", pod.Name, node.Hostname, node.AnnouncePort)
+		}
+	}
+}
+
+func TestExternalAccessNodePort(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: testNodeName},
+		Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeInternalIP, Address: "10.1.0.1"},
+			{Type: corev1.NodeExternalIP, Address: "203.0.113.1"},
+		}},
+	}
+	if err := env.client.Create(context.Background(), node); err != nil {
+		t.Fatalf("Failed to create node: %v", err)
+	}
+	env.enableExternalAccess(&dbv1.ExternalAccessSpec{Enabled: true, Type: corev1.ServiceTypeNodePort})
+	env.reconcileUntil(Ready, 5)
+	env.checkClusterHealthy(3, 1)
+	env.checkExternalEndpoints()
+	if node, _ := env.redis.GetNode(env.getPod("redis-node-0").Status.PodIP); node.AnnounceIP != "203.0.113.1" || node.AnnouncePort < 30000 {
+		t.Errorf("Unexpected announced address %s:%d", node.AnnounceIP, node.AnnouncePort)
+	}
+
+	env.redis.StopNode(env.getPod("redis-node-2").Status.PodIP)
+	env.reconcileUntil(Recovering, 2)
+	env.reconcileUntil(Ready, 5)
+	env.checkClusterHealthy(3, 1)
+	env.checkExternalEndpoints()
+}
+
+// Simulates the loss of an availability zone holding a leader and the follower of another leader
+func TestAZFailure(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	env.reconcileUntil(Ready, 5)
+
+	env.deletePod("redis-node-0")
+	env.deletePod("redis-node-4")
+	env.reconcileUntil(Recovering, 2)
+	env.reconcileUntil(Ready, 5)
+	env.checkClusterHealthy(3, 1)
+}
+
+// A cancelled context must stop the reconcile instead of waiting for the poll timeouts
+func TestReconcileCancelled(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	env.reconciler.ctx = ctx
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := env.reconciler.Reconcile(ctrl.Request{NamespacedName: env.redisCluster}); err != nil {
+			t.Fatalf("Reconcile failed: %v", err)
+		}
+	}
+	if state := getCurrentClusterState(env.getRedisCluster()); state == Ready {
+		t.Errorf("Cluster reached state %s with a cancelled context", state)
+	}
+	if elapsed := time.Since(start); elapsed > genericCheckTimeout {
+		t.Errorf("Reconcile took %v with a cancelled context", elapsed)
+	}
+}
+
+// A node ID annotation that no longer matches the node of the pod is replaced
+// by the pod metadata sync
+func TestStaleNodeIDAnnotation(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	env.reconcileUntil(Ready, 5)
+	env.reconcileUntil(Ready, 1)
+	follower := env.getPod("redis-node-3")
+	followerNode, _ := env.redis.GetNode(follower.Status.PodIP)
+	follower.Annotations[redisNodeIDAnnotation] = "0000000000000000000000000000000000000000"
+	if err := env.client.Update(context.Background(), follower); err != nil {
+		t.Fatalf("Failed to update pod %s: %v", follower.Name, err)
+	}
+
+	env.reconcileUntil(Ready, 1)
+	if id := env.getPod("redis-node-3").Annotations[redisNodeIDAnnotation]; id != followerNode.ID {
+		t.Errorf("Pod %s is annotated with node %s instead of %s", follower.Name, id, followerNode.ID)
+	}
+	env.checkClusterHealthy(3, 1)
+}
+
+func (e *testEnv) setSlotRepair(policy dbv1.SlotRepairPolicy) {
+	redisCluster := e.getRedisCluster()
+	redisCluster.Spec.SlotRepair = policy
+	if err := e.client.Update(context.Background(), redisCluster); err != nil {
+		e.t.Fatalf("Failed to update RedisCluster: %v", err)
+	}
+}
+
+// Returns the first slot served by the leader running in the pod
+func (e *testEnv) firstSlotOf(podName string) (int, string) {
+	ip := e.getPod(podName).Status.PodIP
+	node, _ := e.redis.GetNode(ip)
+	for slot := 0; slot < rediscli.ClusterSlotCount; slot++ {
+		if e.redis.SlotOwner(slot) == node.ID {
+			return slot, ip
+		}
+	}
+	e.t.Fatalf("Pod %s serves no slot", podName)
+	return 0, ""
+}
+
+func (e *testEnv) checkCondition(conditionType string, status corev1.ConditionStatus, reason string) {
+	condition := findCondition(&e.getRedisCluster().Status, conditionType)
+	if condition == nil {
+		e.t.Fatalf("Condition %s not set", conditionType)
+	}
+	if condition.Status != status || condition.Reason != reason {
+		e.t.Errorf("Condition %s is %s (%s: %s), expected %s (%s)", conditionType, condition.Status, condition.Reason, condition.Message, status, reason)
+	}
+}
+
+// Checks that an event with the reason was recorded since the last check
+func (e *testEnv) checkEvent(reason string) {
+	for {
+		select {
+		case event := <-e.recorder.Events:
+			if strings.Contains(event, " "+reason+" ") {
+				return
+			}
+		default:
+			e.t.Errorf("No %s event recorded", reason)
+			return
+		}
+	}
+}
+
+// Checks the phase of the recovery of a shard in the status
+func (e *testEnv) checkShardRecovery(leaderNumber string, phase dbv1.ShardRecoveryPhase) {
+	recovery := findShardRecovery(&e.getRedisCluster().Status, leaderNumber)
+	if recovery == nil {
+		e.t.Fatalf("No recovery of shard %s in the status", leaderNumber)
+	}
+	if recovery.Phase != phase {
+		e.t.Errorf("Recovery of shard %s is %s (%s), expected %s", leaderNumber, recovery.Phase, recovery.Message, phase)
+	}
+}
+
+// Interrupted migrations are completed when the target is reachable and rolled back otherwise
+func TestOpenSlotRepair(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	env.setSlotRepair(dbv1.SlotRepairOpenSlots)
+	env.reconcileUntil(Ready, 5)
+	env.reconcileUntil(Ready, 1)
+	env.checkCondition(ConditionSlotsCovered, corev1.ConditionTrue, "AllSlotsCovered")
+
+	migrated, sourceIP := env.firstSlotOf("redis-node-0")
+	source, _ := env.redis.GetNode(sourceIP)
+	rolledBack, ownerIP := env.firstSlotOf("redis-node-1")
+	owner, _ := env.redis.GetNode(ownerIP)
+	targetIP := env.getPod("redis-node-2").Status.PodIP
+	target, _ := env.redis.GetNode(targetIP)
+
+	env.redis.SetSlotKeys(sourceIP, migrated, 250)
+	env.redis.SetOpenSlot(sourceIP, migrated, "migrating", target.ID)
+	env.redis.SetOpenSlot(targetIP, migrated, "importing", source.ID)
+	// only the importing side of the second migration was opened
+	env.redis.SetSlotKeys(ownerIP, rolledBack, 10)
+	env.redis.SetSlotKeys(targetIP, rolledBack, 3)
+	env.redis.SetOpenSlot(targetIP, rolledBack, "importing", owner.ID)
+
+	env.reconcileUntil(Ready, 1)
+	if env.redis.SlotOwner(migrated) != target.ID || env.redis.SlotKeys(targetIP, migrated) != 250 || env.redis.SlotKeys(sourceIP, migrated) != 0 {
+		t.Errorf("Migration of slot %d was not completed", migrated)
+	}
+	if env.redis.SlotOwner(rolledBack) != owner.ID || env.redis.SlotKeys(ownerIP, rolledBack) != 13 || env.redis.SlotKeys(targetIP, rolledBack) != 0 {
+		t.Errorf("Migration of slot %d was not rolled back", rolledBack)
+	}
+	if _, err := env.redis.ClusterCheck(context.Background(), sourceIP); err != nil {
+		t.Errorf("Cluster check failed: %v", err)
+	}
+	env.checkCondition(ConditionSlotsCovered, corev1.ConditionTrue, "AllSlotsCovered")
+	env.checkCondition(ConditionSlotsRepaired, corev1.ConditionTrue, "Repaired")
+	env.checkClusterHealthy(3, 1)
+}
+
+// Unassigned slots are only reported until the slot repair policy allows to assign them
+func TestUncoveredSlots(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	env.reconcileUntil(Ready, 5)
+
+	first, _ := env.firstSlotOf("redis-node-0")
+	withKeys, keysIP := env.firstSlotOf("redis-node-1")
+	keysOwner, _ := env.redis.GetNode(keysIP)
+	env.redis.DelSlots(first, first+9)
+	env.redis.DelSlots(withKeys, withKeys)
+	env.redis.SetSlotKeys(keysIP, withKeys, 5)
+
+	env.reconcileUntil(Ready, 1)
+	env.checkCondition(ConditionSlotsCovered, corev1.ConditionFalse, "UncoveredSlots")
+	env.checkCondition(ConditionSlotsRepaired, corev1.ConditionFalse, "RepairDisabled")
+	if covered := env.redis.CoveredSlots(); covered != 16384-11 {
+		t.Errorf("Expected the uncovered slots to be left alone, %d slots are covered", covered)
+	}
+
+	env.setSlotRepair(dbv1.SlotRepairAll)
+	env.reconcileUntil(Ready, 1)
+	if owner := env.redis.SlotOwner(withKeys); owner != keysOwner.ID {
+		t.Errorf("Slot %d was assigned to %s instead of the node holding its keys", withKeys, owner)
+	}
+	env.checkCondition(ConditionSlotsCovered, corev1.ConditionTrue, "AllSlotsCovered")
+	env.checkCondition(ConditionSlotsRepaired, corev1.ConditionTrue, "Repaired")
+	env.checkClusterHealthy(3, 1)
+}
+
+// A trigger starts a rebalancing that migrates a limited number of slots per reconcile loop
+func TestRebalanceByKeys(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	env.reconcileUntil(Ready, 5)
+
+	first, ip := env.firstSlotOf("redis-node-0")
+	for slot := first; slot < first+12; slot++ {
+		env.redis.SetSlotKeys(ip, slot, 1000)
+	}
+	redisCluster := env.getRedisCluster()
+	redisCluster.Spec.Rebalance = &dbv1.RebalanceSpec{Trigger: "1", Metric: dbv1.RebalanceByKeys, SlotsPerReconcile: 3, KeysPerBatch: 300}
+	if err := env.client.Update(context.Background(), redisCluster); err != nil {
+		t.Fatalf("Failed to update RedisCluster: %v", err)
+	}
+
+	env.reconcileUntil(Ready, 1)
+	status := env.getRedisCluster().Status.Rebalance
+	if status == nil || status.Phase != dbv1.RebalanceInProgress || status.TotalSlots != 8 || status.MigratedSlots != 3 || len(status.Moves) == 0 {
+		t.Fatalf("Unexpected rebalance status %+v", status)
+	}
+	for i := 0; i < 3 && rebalanceInProgress(env.getRedisCluster()); i++ {
+		env.reconcileUntil(Ready, 1)
+	}
+	status = env.getRedisCluster().Status.Rebalance
+	if status.Phase != dbv1.RebalanceCompleted || status.MigratedSlots != 8 || status.CompletionTime == nil {
+		t.Errorf("Unexpected rebalance status %+v", status)
+	}
+	for _, master := range env.redis.Masters() {
+		if master.Keys != 4000 {
+			t.Errorf("Master %s has %d keys instead of 4000", master.IP, master.Keys)
+		}
+	}
+
+	// the trigger was handled, the automatic rebalancing is disabled
+	env.redis.SetSlotKeys(ip, first+100, 5000)
+	env.reconcileUntil(Ready, 1)
+	if status := env.getRedisCluster().Status.Rebalance; status.Phase != dbv1.RebalanceCompleted || status.TotalSlots != 8 {
+		t.Errorf("Unexpected rebalance status %+v", status)
+	}
+	env.checkClusterHealthy(3, 1)
+}
+
+// Pinned slots are assigned to their leader on creation and moved back by a rebalancing after a drift
+func TestSlotAssignments(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	redisCluster := env.getRedisCluster()
+	redisCluster.Spec.SlotAssignments = []dbv1.SlotAssignment{
+		{Name: "tenant-a", Slots: "0-99", LeaderNumber: 2},
+		{Name: "tenant-b", Slots: "16000", LeaderNumber: 0},
+	}
+	if err := env.client.Update(context.Background(), redisCluster); err != nil {
+		t.Fatalf("Failed to update RedisCluster: %v", err)
+	}
+	env.reconcileUntil(Ready, 5)
+	env.reconcileUntil(Ready, 1)
+
+	leaderIDs := make(map[string]string)
+	masters := make(map[string]string)
+	for _, name := range []string{"redis-node-0", "redis-node-1", "redis-node-2"} {
+		node, _ := env.redis.GetNode(env.getPod(name).Status.PodIP)
+		leaderIDs[name] = node.ID
+		masters[node.ID] = node.IP
+	}
+	for slot := 0; slot < 100; slot++ {
+		if owner := env.redis.SlotOwner(slot); owner != leaderIDs["redis-node-2"] {
+			t.Fatalf("Pinned slot %d is served by %s", slot, owner)
+		}
+	}
+	if owner := env.redis.SlotOwner(16000); owner != leaderIDs["redis-node-0"] {
+		t.Errorf("Pinned slot 16000 is served by %s", owner)
+	}
+	env.checkCondition(ConditionSlotAssignmentsApplied, corev1.ConditionTrue, "Applied")
+
+	ctx := withNodePorts(context.Background(), env.getRedisCluster())
+	if err := env.reconciler.migrateSlot(ctx, masters, 50, leaderIDs["redis-node-2"], leaderIDs["redis-node-1"], defaultMigrationThrottle); err != nil {
+		t.Fatalf("Failed to migrate slot 50: %v", err)
+	}
+	env.reconcileUntil(Ready, 1)
+	env.checkCondition(ConditionSlotAssignmentsApplied, corev1.ConditionFalse, "Drift")
+
+	// the drift is corrected without spec.rebalance
+	env.reconcileUntil(Ready, 1)
+	if owner := env.redis.SlotOwner(50); owner != leaderIDs["redis-node-2"] {
+		t.Errorf("Pinned slot 50 was not moved back, it is served by %s", owner)
+	}
+	if status := env.getRedisCluster().Status.Rebalance; status == nil || status.Phase != dbv1.RebalanceCompleted || status.MigratedSlots != 1 {
+		t.Errorf("Expected a completed migration of the drifted slot, found %+v", status)
+	}
+	env.checkCondition(ConditionSlotAssignmentsApplied, corev1.ConditionTrue, "Applied")
+	env.checkClusterHealthy(3, 1)
+}
+
+func TestInvalidSlotAssignments(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	redisCluster := env.getRedisCluster()
+	redisCluster.Spec.SlotAssignments = []dbv1.SlotAssignment{
+		{Name: "tenant-a", Slots: "0-99", LeaderNumber: 2},
+		{Name: "tenant-b", Slots: "90-110", LeaderNumber: 1},
+	}
+	if err := env.client.Update(context.Background(), redisCluster); err != nil {
+		t.Fatalf("Failed to update RedisCluster: %v", err)
+	}
+	env.reconcileUntil(Ready, 5)
+	env.reconcileUntil(Ready, 1)
+	env.checkCondition(ConditionSlotAssignmentsApplied, corev1.ConditionFalse, "InvalidAssignments")
+	env.checkClusterHealthy(3, 1)
+}
+
+// A shard that lost all its nodes waits for its data until the loss is allowed
+func TestLostShardDataLoss(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	env.reconcileUntil(Ready, 5)
+	env.reconcileUntil(Ready, 1)
+
+	env.redis.StopNode(env.getPod("redis-node-1").Status.PodIP)
+	env.redis.StopNode(env.getPod("redis-node-4").Status.PodIP)
+	env.reconcileUntil(Recovering, 2)
+	env.reconcileUntil(Recovering, 1)
+	env.reconcileUntil(Recovering, 1)
+	env.checkEvent("ShardLost")
+	env.checkEvent("PodsRecreated")
+	env.checkEvent("DataLossNotAllowed")
+	env.checkShardRecovery("1", dbv1.ShardRecoveryWaitingForData)
+	if covered := env.redis.CoveredSlots(); covered == 16384 {
+		t.Errorf("The slots of the lost shard were assigned without data")
+	}
+
+	redisCluster := env.getRedisCluster()
+	redisCluster.Spec.AllowDataLoss = true
+	if err := env.client.Update(context.Background(), redisCluster); err != nil {
+		t.Fatalf("Failed to update RedisCluster: %v", err)
+	}
+	env.reconcileUntil(Ready, 2)
+	env.checkEvent("SlotsReassigned")
+	env.checkShardRecovery("1", dbv1.ShardRecoveryDataLost)
+	env.checkClusterHealthy(3, 1)
+}
+
+// A shard that lost all its nodes takes its slots back when its new leader
+// restored the data
+func TestLostShardRestoredData(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	env.reconcileUntil(Ready, 5)
+	env.reconcileUntil(Ready, 1)
+	slot, _ := env.firstSlotOf("redis-node-1")
+	env.client.persistentKeys["redis-node-1"] = map[int]int{slot: 30}
+
+	env.redis.StopNode(env.getPod("redis-node-1").Status.PodIP)
+	env.redis.StopNode(env.getPod("redis-node-4").Status.PodIP)
+	env.reconcileUntil(Recovering, 2)
+	env.reconcileUntil(Ready, 2)
+	env.checkEvent("ShardLost")
+	env.checkEvent("DataRestored")
+	env.checkShardRecovery("1", dbv1.ShardRecoveryRestored)
+	if keys := env.redis.SlotKeys(env.getPod("redis-node-1").Status.PodIP, slot); keys != 30 {
+		t.Errorf("Expected 30 keys in slot %d, found %d", slot, keys)
+	}
+	env.checkClusterHealthy(3, 1)
+}
+
+// A shard that lost all its nodes is restored from its latest backup, even
+// when the backup is configured after the leader was recreated
+func TestLostShardRestoredBackup(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	env.reconcileUntil(Ready, 5)
+	env.reconcileUntil(Ready, 1)
+	slot, _ := env.firstSlotOf("redis-node-1")
+	env.client.backupKeys["1"] = map[int]int{slot: 40}
+
+	env.redis.StopNode(env.getPod("redis-node-1").Status.PodIP)
+	env.redis.StopNode(env.getPod("redis-node-4").Status.PodIP)
+	env.reconcileUntil(Recovering, 2)
+	env.reconcileUntil(Recovering, 1)
+	env.reconcileUntil(Recovering, 1)
+	env.checkEvent("DataLossNotAllowed")
+	env.checkShardRecovery("1", dbv1.ShardRecoveryWaitingForData)
+
+	redisCluster := env.getRedisCluster()
+	redisCluster.Spec.Backup = &dbv1.BackupSpec{Image: "restore:testing", Command: []string{"restore-latest"}, SecretName: "backup-credentials"}
+	if err := env.client.Update(context.Background(), redisCluster); err != nil {
+		t.Fatalf("Failed to update RedisCluster: %v", err)
+	}
+	env.reconcileUntil(Ready, 4)
+	env.checkEvent("DataRestored")
+	env.checkShardRecovery("1", dbv1.ShardRecoveryRestored)
+
+	pod := env.getPod("redis-node-1")
+	if !hasBackupRestore(pod) {
+		t.Fatalf("The recreated leader has no restore container: %v", pod.Spec.InitContainers)
+	}
+	restore := pod.Spec.InitContainers[0]
+	if restore.Image != "restore:testing" || len(restore.Env) != 1 || restore.Env[0].Value != "1" ||
+		len(restore.EnvFrom) != 1 || restore.EnvFrom[0].SecretRef.Name != "backup-credentials" {
+		t.Errorf("Unexpected restore container: %+v", restore)
+	}
+	if keys := env.redis.SlotKeys(pod.Status.PodIP, slot); keys != 40 {
+		t.Errorf("Expected 40 keys in slot %d, found %d", slot, keys)
+	}
+	env.checkClusterHealthy(3, 1)
+}
+
+// The replicas of the masters lost together with the majority are promoted
+// with TAKEOVER and keep their data
+func TestQuorumLoss(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	env.reconcileUntil(Ready, 5)
+	env.reconcileUntil(Ready, 1)
+	slot, _ := env.firstSlotOf("redis-node-0")
+	env.redis.SetSlotKeys(env.getPod("redis-node-0").Status.PodIP, slot, 20)
+
+	env.redis.AutoFailover = false
+	env.redis.StopNode(env.getPod("redis-node-0").Status.PodIP)
+	env.redis.StopNode(env.getPod("redis-node-1").Status.PodIP)
+	env.reconcileUntil(Recovering, 2)
+	env.reconcileUntil(Ready, 2)
+	env.checkEvent("QuorumLost")
+	env.checkEvent("Takeover")
+	env.checkEvent("QuorumRestored")
+	env.checkCondition(ConditionQuorumAvailable, corev1.ConditionTrue, "EpochsConverged")
+	if keys := env.redis.SlotKeys(env.getPod("redis-node-3").Status.PodIP, slot); keys != 20 {
+		t.Errorf("Expected 20 keys in slot %d, found %d", slot, keys)
+	}
+	env.checkClusterHealthy(3, 1)
+}
+
+// A leader cut off from the other nodes is not replaced while the partition
+// lasts, the cluster recovers on its own once it heals
+func TestNetworkPartition(t *testing.T) {
+	env := newTestEnv(t, 3, 1)
+	env.reconcileUntil(Ready, 5)
+	env.reconcileUntil(Ready, 1)
+	isolated := env.getPod("redis-node-0")
+	isolatedNode, _ := env.redis.GetNode(isolated.Status.PodIP)
+
+	env.redis.Partition(isolated.Status.PodIP)
+	env.reconcileUntil(Recovering, 1)
+	env.reconcileUntil(Recovering, 1)
+	env.checkCondition(ConditionPartitioned, corev1.ConditionUnknown, "NodesDisconnected")
+	if result, _ := env.reconciler.Reconcile(ctrl.Request{NamespacedName: env.redisCluster}); result.RequeueAfter != partitionRequeueInterval {
+		t.Errorf("Expected a requeue after %v while the nodes disagree, got %v", partitionRequeueInterval, result.RequeueAfter)
+	}
+	time.Sleep(partitionGracePeriod)
+	env.reconcileUntil(Recovering, 2)
+	env.checkEvent("PartitionDetected")
+	env.checkCondition(ConditionPartitioned, corev1.ConditionTrue, "NodesDisconnected")
+	if node, _ := env.redis.GetNode(env.getPod("redis-node-0").Status.PodIP); node.ID != isolatedNode.ID || !node.IsMaster() {
+		t.Errorf("The isolated leader was replaced during the partition")
+	}
+
+	env.redis.Heal()
+	env.reconcileUntil(Ready, 1)
+	env.checkEvent("PartitionHealed")
+	env.checkCondition(ConditionPartitioned, corev1.ConditionFalse, "NodesAgree")
+	if node, _ := env.redis.GetNode(env.getPod("redis-node-0").Status.PodIP); node.ID != isolatedNode.ID {
+		t.Errorf("The isolated leader was replaced after the partition")
+	}
+	env.checkClusterHealthy(3, 1)
+}
+
+// The followers are ranked by replication offset, then by zone
+func TestFailoverCandidates(t *testing.T) {
+	env := newTestEnv(t, 3, 2)
+	env.reconcileUntil(Ready, 5)
+	ctx := withNodePorts(context.Background(), env.getRedisCluster())
+	leaderIP := env.getPod("redis-node-0").Status.PodIP
+	followerIPs := []string{env.getPod("redis-node-3").Status.PodIP, env.getPod("redis-node-4").Status.PodIP}
+	env.redis.SetKeys(leaderIP, 100)
+
+	checkRanking := func(expectedFirst string, expectedReason string) {
+		candidates, reason, err := env.reconciler.rankFailoverCandidates(ctx, env.getRedisCluster(), leaderIP, followerIPs)
+		if err != nil || len(candidates) != 2 {
+			t.Fatalf("Expected 2 failover candidates, found %d: %v", len(candidates), err)
+		}
+		if candidates[0].NodeNumber != expectedFirst || reason != expectedReason {
+			t.Errorf("Best failover candidate is %s (%s), expected %s (%s)", candidates[0].NodeNumber, reason, expectedFirst, expectedReason)
+		}
+	}
+	checkRanking("3", "first of equal followers")
+
+	env.redis.SetReplicationLag(followerIPs[0], 10)
+	checkRanking("4", "highest replication offset")
+	promotedIP, err := env.reconciler.doLeaderFailover(ctx, env.getRedisCluster(), leaderIP, "")
+	if err != nil {
+		t.Fatalf("Leader failover failed: %v", err)
+	}
+	if promotedIP != followerIPs[1] {
+		t.Errorf("Promoted %s instead of the follower with the highest offset %s", promotedIP, followerIPs[1])
+	}
+	if _, err := env.reconciler.doLeaderFailover(ctx, env.getRedisCluster(), promotedIP, "", leaderIP); err != nil {
+		t.Fatalf("Leader failover back failed: %v", err)
+	}
+	env.redis.SetReplicationLag(followerIPs[0], 0)
+
+	for _, zone := range []string{"zone-a", "zone-b"} {
+		node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: zone, Labels: map[string]string{"topology.kubernetes.io/zone": zone}}}
+		if err := env.client.Create(context.Background(), node); err != nil {
+			t.Fatalf("Failed to create node: %v", err)
+		}
+	}
+	for name, zone := range map[string]string{"redis-node-0": "zone-a", "redis-node-3": "zone-a", "redis-node-4": "zone-b"} {
+		pod := env.getPod(name)
+		pod.Spec.NodeName = zone
+		if err := env.client.Update(context.Background(), pod); err != nil {
+			t.Fatalf("Failed to update pod %s: %v", name, err)
+		}
+	}
+	checkRanking("4", "outside of the zone of the leader")
+}
+
+// A leader whose followers all failed has no failover candidate
+func TestNoFailoverCandidate(t *testing.T) {
+	env := newTestEnv(t, 3, 2)
+	env.reconcileUntil(Ready, 5)
+	ctx := withNodePorts(context.Background(), env.getRedisCluster())
+	leader := &LeaderNode{
+		Pod:          env.getPod("redis-node-0"),
+		NodeNumber:   "0",
+		LeaderNumber: "0",
+		Failed:       true,
+		Followers: []FollowerNode{
+			{Pod: env.getPod("redis-node-3"), NodeNumber: "3", LeaderNumber: "0", Failed: true},
+		},
+	}
+	promotedIP, err := env.reconciler.handleFailover(ctx, env.getRedisCluster(), leader)
+	if !IsNoFailoverCandidate(err) {
+		t.Fatalf("Expected no failover candidate for leader 0, got %q: %v", promotedIP, err)
+	}
+	if err.Error() != "no failover candidate for leader 0" {
+		t.Errorf("Unexpected error message: %v", err)
+	}
+}
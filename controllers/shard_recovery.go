@@ -0,0 +1,335 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/pkg/errors"
+
+	dbv1 "github.com/PayU/Redis-Operator/api/v1"
+	"github.com/PayU/Redis-Operator/controllers/rediscli"
+)
+
+// The name of the init container restoring the backup of a lost shard
+const backupRestoreContainerName = "restore-backup"
+
+// Returns the recovery of the shard recorded in the status or nil
+func findShardRecovery(status *dbv1.RedisClusterStatus, leaderNumber string) *dbv1.ShardRecoveryStatus {
+	for i := range status.ShardRecoveries {
+		if status.ShardRecoveries[i].LeaderNumber == leaderNumber {
+			return &status.ShardRecoveries[i]
+		}
+	}
+	return nil
+}
+
+func isShardRecoveryActive(recovery *dbv1.ShardRecoveryStatus) bool {
+	return recovery != nil && recovery.CompletionTime == nil
+}
+
+// Records a new recovery of the shard in the status, replacing the previous one
+func startShardRecovery(status *dbv1.RedisClusterStatus, leaderNumber string, slots rediscli.SlotRanges) *dbv1.ShardRecoveryStatus {
+	now := metav1.Now()
+	recovery := findShardRecovery(status, leaderNumber)
+	if recovery == nil {
+		status.ShardRecoveries = append(status.ShardRecoveries, dbv1.ShardRecoveryStatus{LeaderNumber: leaderNumber})
+		recovery = &status.ShardRecoveries[len(status.ShardRecoveries)-1]
+	}
+	*recovery = dbv1.ShardRecoveryStatus{
+		LeaderNumber: leaderNumber,
+		Phase:        dbv1.ShardRecoveryRecreating,
+		Slots:        slots.String(),
+		StartTime:    &now,
+	}
+	return recovery
+}
+
+// Moves the recovery to the next phase and publishes the step as an event
+func (r *RedisClusterReconciler) recordShardRecoveryStep(redisCluster *dbv1.RedisCluster, recovery *dbv1.ShardRecoveryStatus, phase dbv1.ShardRecoveryPhase, eventType string, reason string, message string) {
+	recovery.Phase = phase
+	recovery.Message = message
+	if phase == dbv1.ShardRecoveryRestored || phase == dbv1.ShardRecoveryDataLost {
+		now := metav1.Now()
+		recovery.CompletionTime = &now
+	}
+	r.recordEvent(redisCluster, eventType, reason, fmt.Sprintf("Shard %s: %s", recovery.LeaderNumber, message))
+}
+
+// Recovers a shard that lost its leader and all its followers. The pods of the
+// shard are recreated and the new leader takes over the slots of the shard
+// when it loaded the data from its persistent volume or from the latest backup
+// of the shard. A leader that loaded no data gets the slots only when the spec
+// allows the data loss; otherwise the shard waits for the data and false is
+// returned. The missing followers are added back afterwards by the cluster
+// recovery.
+func (r *RedisClusterReconciler) recoverLostShard(ctx context.Context, redisCluster *dbv1.RedisCluster, leader *LeaderNode) (bool, error) {
+	recovery := findShardRecovery(&redisCluster.Status, leader.LeaderNumber)
+	if !isShardRecoveryActive(recovery) {
+		slots, err := r.lostShardSlots(ctx, redisCluster, leader)
+		if err != nil {
+			return false, err
+		}
+		recovery = startShardRecovery(&redisCluster.Status, leader.LeaderNumber, slots)
+		r.recordEvent(redisCluster, corev1.EventTypeWarning, "ShardLost",
+			fmt.Sprintf("Shard %s lost its leader and all its followers, slots %s are not served", leader.LeaderNumber, recovery.Slots))
+	}
+	slots, ok := rediscli.ParseSlotRanges(recovery.Slots)
+	if !ok {
+		return false, errors.Errorf("Malformed slots %s in the recovery of shard %s", recovery.Slots, leader.LeaderNumber)
+	}
+
+	leaderPod, recreated, err := r.recreateShardLeader(ctx, redisCluster, leader)
+	if err != nil {
+		return false, err
+	}
+	leaderIP := leaderPod.Status.PodIP
+	if recreated {
+		message := fmt.Sprintf("recreated leader pod %s", leaderPod.Name)
+		if hasBackupRestore(leaderPod) {
+			message += " with the latest backup of the shard"
+		}
+		r.recordShardRecoveryStep(redisCluster, recovery, dbv1.ShardRecoveryRecreating, corev1.EventTypeNormal, "PodsRecreated", message)
+	}
+
+	coverage, err := r.getSlotCoverage(ctx, redisCluster)
+	if err != nil {
+		return false, err
+	}
+	leaderID, err := r.RedisCLI.MyClusterID(ctx, leaderIP)
+	if err != nil {
+		return false, err
+	}
+	if node := coverage.view.GetNodeByID(leaderID); node != nil && node.HasSlots() {
+		// the node kept its identity and its slots in the nodes.conf of its
+		// persistent volume: the other nodes only need its new address
+		if err := r.meetNode(ctx, coverage.viewIP, leaderIP, leaderID); err != nil {
+			return false, err
+		}
+		r.recordShardRecoveryStep(redisCluster, recovery, dbv1.ShardRecoveryRestored, corev1.EventTypeNormal, "RestoredFromPersistentVolume",
+			fmt.Sprintf("leader %s rejoined the cluster with its node %s and slots %s", leaderPod.Name, leaderID, node.Slots.String()))
+		return true, nil
+	}
+
+	info, err := r.RedisCLI.Info(ctx, leaderIP)
+	if err != nil {
+		return false, err
+	}
+	keys := info.KeyCount()
+	if keys == 0 && redisCluster.Spec.Backup != nil && !hasBackupRestore(leaderPod) {
+		// the backup was configured after the leader was recreated: the pod
+		// is created again to restore it
+		if _, err := r.deletePods(ctx, *leaderPod); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+	if keys == 0 && !redisCluster.Spec.AllowDataLoss {
+		if recovery.Phase != dbv1.ShardRecoveryWaitingForData {
+			r.recordShardRecoveryStep(redisCluster, recovery, dbv1.ShardRecoveryWaitingForData, corev1.EventTypeWarning, "DataLossNotAllowed",
+				fmt.Sprintf("leader %s loaded no data, slots %s stay unserved until it loads the data from its persistent volume or a backup, or allowDataLoss is set", leaderPod.Name, recovery.Slots))
+		}
+		return false, nil
+	}
+
+	if err := r.forgetLostNodes(ctx, redisCluster); err != nil {
+		return false, err
+	}
+	if err := r.meetNode(ctx, coverage.viewIP, leaderIP, leaderID); err != nil {
+		return false, err
+	}
+	if err := r.addSlots(ctx, leaderIP, slots); err != nil {
+		return false, err
+	}
+	if keys > 0 {
+		r.recordShardRecoveryStep(redisCluster, recovery, dbv1.ShardRecoveryRestored, corev1.EventTypeNormal, "DataRestored",
+			fmt.Sprintf("leader %s restored %d keys and serves slots %s", leaderPod.Name, keys, recovery.Slots))
+	} else {
+		r.recordShardRecoveryStep(redisCluster, recovery, dbv1.ShardRecoveryDataLost, corev1.EventTypeWarning, "SlotsReassigned",
+			fmt.Sprintf("slots %s were assigned to the empty leader %s, their keys are lost", recovery.Slots, leaderPod.Name))
+	}
+	return true, nil
+}
+
+// Returns the slots served by the lost master of the shard according to the
+// reachable masters. The master is found by the node IDs of the shard pods;
+// when the pods are gone it is the first unreachable master serving slots that
+// no other shard is recovering. The slots that are not assigned at all are
+// returned when there is no such master.
+func (r *RedisClusterReconciler) lostShardSlots(ctx context.Context, redisCluster *dbv1.RedisCluster, leader *LeaderNode) (rediscli.SlotRanges, error) {
+	coverage, err := r.getSlotCoverage(ctx, redisCluster)
+	if err != nil {
+		return nil, err
+	}
+	shardIDs := map[string]struct{}{leader.RedisID: EMPTY}
+	if leader.Pod != nil {
+		shardIDs[leader.Pod.Annotations[redisNodeIDAnnotation]] = EMPTY
+	}
+	for _, follower := range leader.Followers {
+		shardIDs[follower.RedisID] = EMPTY
+		if follower.Pod != nil {
+			shardIDs[follower.Pod.Annotations[redisNodeIDAnnotation]] = EMPTY
+		}
+	}
+	recovering := make(map[string]struct{})
+	for _, recovery := range redisCluster.Status.ShardRecoveries {
+		if isShardRecoveryActive(&recovery) && recovery.LeaderNumber != leader.LeaderNumber {
+			recovering[recovery.Slots] = EMPTY
+		}
+	}
+
+	var lost []*rediscli.RedisClusterNode
+	for i := range *coverage.view {
+		node := &(*coverage.view)[i]
+		if !node.IsMaster() || !node.HasSlots() {
+			continue
+		}
+		if _, reachable := coverage.masters[node.ID]; reachable {
+			continue
+		}
+		if _, ofShard := shardIDs[node.ID]; ofShard && node.ID != "" {
+			return node.Slots, nil
+		}
+		if _, claimed := recovering[node.Slots.String()]; !claimed {
+			lost = append(lost, node)
+		}
+	}
+	if len(lost) == 0 {
+		return coverage.Unassigned, nil
+	}
+	sort.Slice(lost, func(i, j int) bool { return lost[i].ID < lost[j].ID })
+	return lost[0].Slots, nil
+}
+
+// Deletes the pods of the shard whose Redis node can't be reached and creates
+// the designated leader pod again, with the restore of the latest backup when
+// it is configured. A designated leader pod that is reachable, e.g. recreated
+// by a previous attempt, is kept. Returns the leader pod and true if it was
+// created.
+func (r *RedisClusterReconciler) recreateShardLeader(ctx context.Context, redisCluster *dbv1.RedisCluster, leader *LeaderNode) (*corev1.Pod, bool, error) {
+	snapshot, err := r.getClusterSnapshot(ctx, redisCluster)
+	if err != nil {
+		return nil, false, err
+	}
+	pods := []*corev1.Pod{leader.Pod}
+	for _, follower := range leader.Followers {
+		pods = append(pods, follower.Pod)
+	}
+
+	var leaderPod *corev1.Pod
+	var lostPods []corev1.Pod
+	var terminatingPods []corev1.Pod
+	for _, pod := range pods {
+		if pod == nil {
+			continue
+		}
+		if pod.DeletionTimestamp != nil {
+			terminatingPods = append(terminatingPods, *pod)
+		} else if node := snapshot.Node(pod.Status.PodIP); node == nil || node.Err != nil {
+			lostPods = append(lostPods, *pod)
+		} else if pod.Labels["node-number"] == leader.LeaderNumber {
+			leaderPod = pod
+		}
+	}
+	deletedPods, err := r.deletePods(ctx, lostPods...)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := r.waitForPodDelete(ctx, append(terminatingPods, deletedPods...)...); err != nil {
+		return nil, false, err
+	}
+	if leaderPod != nil {
+		return leaderPod, false, nil
+	}
+
+	pod, err := r.makeLeaderPod(ctx, redisCluster, leader.LeaderNumber)
+	if err != nil {
+		return nil, false, err
+	}
+	addBackupRestore(redisCluster, &pod)
+	newLeaderPods, err := r.createPods(ctx, pod)
+	if err != nil {
+		return nil, false, err
+	}
+	if newLeaderPods, err = r.waitForPodReady(ctx, newLeaderPods...); err != nil {
+		return nil, false, err
+	}
+	newLeaderIP := newLeaderPods[0].Status.PodIP
+	if err := r.waitForRedis(ctx, newLeaderIP); err != nil {
+		return nil, false, err
+	}
+	// the data of the persistent volume or of the backup is loaded on startup
+	if err := r.waitForRedisLoad(ctx, newLeaderIP); err != nil {
+		return nil, false, err
+	}
+	if err := r.announceHostnames(ctx, redisCluster, newLeaderPods...); err != nil {
+		return nil, false, err
+	}
+	if err := r.announceExternalEndpoints(ctx, redisCluster, newLeaderPods...); err != nil {
+		return nil, false, err
+	}
+	return &newLeaderPods[0], true, nil
+}
+
+// Adds the init container restoring the latest backup of the shard to a leader
+// pod. It mounts the volumes of the Redis container to reach its data
+// directory.
+func addBackupRestore(redisCluster *dbv1.RedisCluster, pod *corev1.Pod) {
+	backup := redisCluster.Spec.Backup
+	if backup == nil || len(pod.Spec.Containers) == 0 {
+		return
+	}
+	restore := corev1.Container{
+		Name:         backupRestoreContainerName,
+		Image:        backup.Image,
+		Command:      backup.Command,
+		Env:          []corev1.EnvVar{{Name: "LEADER_NUMBER", Value: pod.Labels["leader-number"]}},
+		VolumeMounts: pod.Spec.Containers[0].VolumeMounts,
+	}
+	if backup.SecretName != "" {
+		restore.EnvFrom = []corev1.EnvFromSource{{
+			SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: backup.SecretName}},
+		}}
+	}
+	pod.Spec.InitContainers = append(pod.Spec.InitContainers, restore)
+}
+
+func hasBackupRestore(pod *corev1.Pod) bool {
+	for _, container := range pod.Spec.InitContainers {
+		if container.Name == backupRestoreContainerName {
+			return true
+		}
+	}
+	return false
+}
+
+// Introduces a node to the cluster through a node of the cluster and waits
+// until the cluster node knows it
+func (r *RedisClusterReconciler) meetNode(ctx context.Context, clusterNodeIP string, nodeIP string, nodeID string) error {
+	defer r.invalidateClusterSnapshot()
+	host, port, busPort := rediscli.ResolveNodeAddress(ctx, nodeIP)
+	if _, err := r.RedisCLI.ClusterMeet(ctx, clusterNodeIP, host, port, busPort); err != nil {
+		return err
+	}
+	return r.waitForRedisMeet(ctx, clusterNodeIP, nodeID)
+}
+
+// Assigns the slot ranges to a master
+func (r *RedisClusterReconciler) addSlots(ctx context.Context, nodeIP string, slots rediscli.SlotRanges) error {
+	defer r.invalidateClusterSnapshot()
+	var slotList []int
+	for _, slotRange := range slots {
+		for slot := slotRange.First; slot <= slotRange.Last; slot++ {
+			slotList = append(slotList, slot)
+		}
+	}
+	if len(slotList) == 0 {
+		return nil
+	}
+	r.Log.Info(fmt.Sprintf("Assigning slots %s to %s", slots.String(), nodeIP))
+	_, err := r.RedisCLI.ClusterAddSlots(ctx, nodeIP, slotList...)
+	return err
+}
@@ -0,0 +1,187 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/pkg/errors"
+
+	dbv1 "github.com/PayU/Redis-Operator/api/v1"
+	"github.com/PayU/Redis-Operator/controllers/rediscli"
+)
+
+// Returns the leader number of every slot pinned by the slotAssignments of the
+// spec. Assignments with a malformed slot range, an unknown leader number or
+// overlapping another assignment are rejected.
+func pinnedSlots(redisCluster *dbv1.RedisCluster) (map[int]string, error) {
+	pinned := make(map[int]string)
+	owners := make(map[int]string)
+	for _, assignment := range redisCluster.Spec.SlotAssignments {
+		slots, ok := rediscli.ParseSlotRange(assignment.Slots)
+		if !ok {
+			return nil, errors.Errorf("Slot assignment %s has a malformed slot range %s", assignment.Name, assignment.Slots)
+		}
+		if assignment.LeaderNumber < 0 || assignment.LeaderNumber >= redisCluster.Spec.LeaderCount {
+			return nil, errors.Errorf("Slot assignment %s pins slots to unknown leader %d", assignment.Name, assignment.LeaderNumber)
+		}
+		for slot := slots.First; slot <= slots.Last; slot++ {
+			if owner, found := owners[slot]; found {
+				return nil, errors.Errorf("Slot assignments %s and %s overlap on slot %d", owner, assignment.Name, slot)
+			}
+			owners[slot] = assignment.Name
+			pinned[slot] = strconv.Itoa(assignment.LeaderNumber)
+		}
+	}
+	return pinned, nil
+}
+
+// Moves the pinned slots of a new cluster to their leaders; the cluster is
+// still empty so no key is migrated. Invalid assignments are only logged, they
+// are reported once the cluster is ready.
+func (r *RedisClusterReconciler) assignPinnedSlots(ctx context.Context, redisCluster *dbv1.RedisCluster, leaderPods []corev1.Pod) error {
+	pinned, err := pinnedSlots(redisCluster)
+	if err != nil {
+		r.Log.Info(fmt.Sprintf("Ignoring the slot assignments: %v", err))
+		return nil
+	}
+	if len(pinned) == 0 {
+		return nil
+	}
+	masters := make(map[string]string)
+	leaderIDs := make(map[string]string)
+	for _, pod := range leaderPods {
+		id, err := r.RedisCLI.MyClusterID(ctx, pod.Status.PodIP)
+		if err != nil {
+			return err
+		}
+		masters[id] = pod.Status.PodIP
+		leaderIDs[pod.Labels["leader-number"]] = id
+	}
+	nodes, err := r.RedisCLI.ClusterNodes(ctx, leaderPods[0].Status.PodIP)
+	if err != nil {
+		return err
+	}
+
+	r.Log.Info(fmt.Sprintf("Assigning %d pinned slots", len(pinned)))
+	for _, slot := range sortedSlots(pinned) {
+		target := leaderIDs[pinned[slot]]
+		owner := nodes.SlotOwner(slot)
+		if owner == nil || owner.ID == target {
+			continue
+		}
+		if err := r.migrateSlot(ctx, masters, slot, owner.ID, target, defaultMigrationThrottle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedSlots(pinned map[int]string) []int {
+	var slots []int
+	for slot := range pinned {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+	return slots
+}
+
+// Returns the pinned slots that are served by another leader than their own,
+// as the moves that put them back. Slots without an owner are left to the
+// slot repair.
+func driftedSlots(shards []*shardLoad, pinned map[int]string) []plannedMove {
+	var moves []plannedMove
+	for _, slot := range sortedSlots(pinned) {
+		for _, shard := range shards {
+			if shard.slotRanges.Contains(slot) && shard.leaderNumber != pinned[slot] {
+				moves = append(moves, plannedMove{slot: slot, from: shard.leaderNumber, to: pinned[slot]})
+			}
+		}
+	}
+	return moves
+}
+
+// Applies planned moves to the measured shards
+func applyMoves(shards []*shardLoad, moves []plannedMove) {
+	byNumber := make(map[string]*shardLoad)
+	for _, shard := range shards {
+		byNumber[shard.leaderNumber] = shard
+	}
+	for _, move := range moves {
+		from, to := byNumber[move.from], byNumber[move.to]
+		if from == nil || to == nil {
+			continue
+		}
+		size := from.slots[move.slot]
+		delete(from.slots, move.slot)
+		to.slots[move.slot] = size
+		from.size -= size
+		to.size += size
+	}
+}
+
+// Compares the slots served by every leader with the slotAssignments of the
+// spec and reports the drift through the SlotAssignmentsApplied condition.
+// The drifted slots are moved back to their leaders while all the slots are
+// covered and no rebalancing is in progress, see startDriftCorrection.
+func (r *RedisClusterReconciler) checkSlotAssignments(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
+	if len(redisCluster.Spec.SlotAssignments) == 0 {
+		removeCondition(&redisCluster.Status, ConditionSlotAssignmentsApplied)
+		return nil
+	}
+	pinned, err := pinnedSlots(redisCluster)
+	if err != nil {
+		setCondition(&redisCluster.Status, ConditionSlotAssignmentsApplied, corev1.ConditionFalse, "InvalidAssignments", err.Error())
+		return nil
+	}
+	shards, err := r.measureShards(ctx, redisCluster, dbv1.RebalanceByKeys)
+	if err != nil {
+		return err
+	}
+
+	drift := driftedSlots(shards, pinned)
+	if len(drift) == 0 {
+		setCondition(&redisCluster.Status, ConditionSlotAssignmentsApplied, corev1.ConditionTrue, "Applied",
+			fmt.Sprintf("%d pinned slots are served by their leaders", len(pinned)))
+		return nil
+	}
+	var drifted []string
+	for _, move := range groupSlotMoves(drift) {
+		drifted = append(drifted, fmt.Sprintf("slots %s served by leader %s instead of %s", move.Slots, move.From, move.To))
+	}
+	message := strings.Join(drifted, ", ")
+	r.Log.Info("Slot assignment drift: " + message)
+	setCondition(&redisCluster.Status, ConditionSlotAssignmentsApplied, corev1.ConditionFalse, "Drift", message)
+	covered := findCondition(&redisCluster.Status, ConditionSlotsCovered)
+	if covered != nil && covered.Status == corev1.ConditionTrue && !rebalanceInProgress(redisCluster) {
+		r.startDriftCorrection(redisCluster, drift)
+	}
+	return nil
+}
+
+// Records a rebalancing made of the moves that put the drifted slots back, so
+// that continueRebalance migrates them whether spec.rebalance is set or not.
+// The trigger of the previous rebalancing is kept: a new trigger of the spec
+// still starts a rebalancing afterwards.
+func (r *RedisClusterReconciler) startDriftCorrection(redisCluster *dbv1.RedisCluster, drift []plannedMove) {
+	now := metav1.Now()
+	trigger := ""
+	if previous := redisCluster.Status.Rebalance; previous != nil {
+		trigger = previous.Trigger
+	}
+	moves := groupSlotMoves(drift)
+	redisCluster.Status.Rebalance = &dbv1.RebalanceStatus{
+		Phase:      dbv1.RebalanceInProgress,
+		Trigger:    trigger,
+		Metric:     dbv1.RebalanceByKeys,
+		StartTime:  &now,
+		Moves:      moves,
+		TotalSlots: int32(len(drift)),
+	}
+	r.Log.Info(fmt.Sprintf("Moving %d pinned slots back to their leaders: %v", len(drift), moves))
+}
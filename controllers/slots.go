@@ -0,0 +1,356 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/pkg/errors"
+
+	dbv1 "github.com/PayU/Redis-Operator/api/v1"
+	"github.com/PayU/Redis-Operator/controllers/rediscli"
+)
+
+const (
+	// Number of keys moved by a single MIGRATE command
+	slotMigrationBatchSize = 100
+	slotMigrationTimeout   = 5 * time.Second
+)
+
+// migrationThrottle limits the load put on the nodes by a slot migration
+type migrationThrottle struct {
+	batchSize int
+	// interval is the pause between two MIGRATE commands
+	interval time.Duration
+}
+
+var defaultMigrationThrottle = migrationThrottle{batchSize: slotMigrationBatchSize}
+
+// openSlot is a slot left open on a node by a migration
+type openSlot struct {
+	rediscli.OpenSlot
+	nodeID string
+	nodeIP string
+}
+
+// slotCoverage is the state of the slots according to the reachable masters.
+// Open slots are only reported by the node holding them, so they are collected
+// from every node while the slot owners come from the view of a single master.
+type slotCoverage struct {
+	// masters maps the ID of the reachable masters to their IP
+	masters map[string]string
+	// view is the CLUSTER NODES output of the master at viewIP
+	view   *rediscli.RedisClusterNodes
+	viewIP string
+	// Uncovered are the slots that are not served by a master that is not
+	// failing, Unassigned the ones that are not assigned to any master
+	Uncovered  rediscli.SlotRanges
+	Unassigned rediscli.SlotRanges
+	OpenSlots  map[int][]openSlot
+}
+
+func (c *slotCoverage) isComplete() bool {
+	return len(c.Uncovered) == 0 && len(c.OpenSlots) == 0
+}
+
+// Returns the open slots sorted
+func (c *slotCoverage) openSlotNumbers() []int {
+	var slots []int
+	for slot := range c.OpenSlots {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+	return slots
+}
+
+func (c *slotCoverage) String() string {
+	var problems []string
+	if len(c.Uncovered) != 0 {
+		problems = append(problems, fmt.Sprintf("%d uncovered slots (%s)", c.Uncovered.Count(), c.Uncovered.String()))
+	}
+	if len(c.OpenSlots) != 0 {
+		var open []string
+		for _, slot := range c.openSlotNumbers() {
+			open = append(open, fmt.Sprint(slot))
+		}
+		problems = append(problems, fmt.Sprintf("%d open slots (%s)", len(c.OpenSlots), strings.Join(open, ",")))
+	}
+	if len(problems) == 0 {
+		return "all the slots are covered"
+	}
+	return strings.Join(problems, ", ")
+}
+
+// Collects the slot coverage from the cluster snapshot
+func (r *RedisClusterReconciler) getSlotCoverage(ctx context.Context, redisCluster *dbv1.RedisCluster) (*slotCoverage, error) {
+	snapshot, err := r.getClusterSnapshot(ctx, redisCluster)
+	if err != nil {
+		return nil, err
+	}
+	coverage := &slotCoverage{masters: make(map[string]string), OpenSlots: make(map[int][]openSlot)}
+	for _, pod := range snapshot.Pods {
+		node := snapshot.Node(pod.Status.PodIP)
+		if pod.DeletionTimestamp != nil || node == nil || node.Err != nil || node.Nodes == nil {
+			continue
+		}
+		myself := node.Nodes.Myself()
+		if myself == nil {
+			continue
+		}
+		if myself.IsMaster() {
+			coverage.masters[myself.ID] = node.IP
+			if coverage.view == nil {
+				coverage.view, coverage.viewIP = node.Nodes, node.IP
+			}
+		}
+		for _, slot := range myself.OpenSlots {
+			coverage.OpenSlots[slot.Slot] = append(coverage.OpenSlots[slot.Slot], openSlot{OpenSlot: slot, nodeID: myself.ID, nodeIP: node.IP})
+		}
+	}
+	if coverage.view == nil {
+		return nil, errors.Errorf("No reachable leader in cluster %s", redisCluster.Name)
+	}
+	coverage.Uncovered = coverage.view.UncoveredSlots()
+	coverage.Unassigned = coverage.view.UnassignedSlots()
+	return coverage, nil
+}
+
+// Checks that every slot is served and that no slot is left open, repairs what
+// the slotRepair policy of the spec allows and reports the result through the
+// status conditions
+func (r *RedisClusterReconciler) checkSlots(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
+	coverage, err := r.getSlotCoverage(ctx, redisCluster)
+	if err != nil {
+		return err
+	}
+	if coverage.isComplete() {
+		setSlotsCoveredCondition(&redisCluster.Status, coverage)
+		return nil
+	}
+	r.Log.Info(fmt.Sprintf("Found slot problems: %s", coverage.String()))
+
+	policy := redisCluster.Spec.SlotRepair
+	if policy == "" || policy == dbv1.SlotRepairDisabled {
+		setSlotsCoveredCondition(&redisCluster.Status, coverage)
+		setCondition(&redisCluster.Status, ConditionSlotsRepaired, corev1.ConditionFalse, "RepairDisabled", "Slot repair is disabled: "+coverage.String())
+		return nil
+	}
+
+	actions, err := r.repairSlots(ctx, coverage, policy == dbv1.SlotRepairAll)
+	if err == nil {
+		coverage, err = r.getSlotCoverage(ctx, redisCluster)
+	}
+	if err != nil {
+		setCondition(&redisCluster.Status, ConditionSlotsRepaired, corev1.ConditionFalse, "RepairFailed", err.Error())
+		return err
+	}
+	setSlotsCoveredCondition(&redisCluster.Status, coverage)
+	if !coverage.isComplete() {
+		setCondition(&redisCluster.Status, ConditionSlotsRepaired, corev1.ConditionFalse, "RepairIncomplete",
+			fmt.Sprintf("Slot repair policy %s left %s", policy, coverage.String()))
+		return nil
+	}
+	if _, err = r.RedisCLI.ClusterCheck(ctx, coverage.viewIP); err != nil {
+		setCondition(&redisCluster.Status, ConditionSlotsRepaired, corev1.ConditionFalse, "RepairFailed", err.Error())
+		return err
+	}
+	r.Log.Info("Repaired the slots: " + strings.Join(actions, ", "))
+	setCondition(&redisCluster.Status, ConditionSlotsRepaired, corev1.ConditionTrue, "Repaired", strings.Join(actions, ", "))
+	return nil
+}
+
+func setSlotsCoveredCondition(status *dbv1.RedisClusterStatus, coverage *slotCoverage) {
+	switch {
+	case len(coverage.Uncovered) != 0:
+		setCondition(status, ConditionSlotsCovered, corev1.ConditionFalse, "UncoveredSlots", coverage.String())
+	case len(coverage.OpenSlots) != 0:
+		setCondition(status, ConditionSlotsCovered, corev1.ConditionFalse, "OpenSlots", coverage.String())
+	default:
+		setCondition(status, ConditionSlotsCovered, corev1.ConditionTrue, "AllSlotsCovered", coverage.String())
+	}
+}
+
+// Closes the open slots and, if assignUncovered is set, assigns the slots that
+// have no owner. Returns a description of the actions taken.
+func (r *RedisClusterReconciler) repairSlots(ctx context.Context, coverage *slotCoverage, assignUncovered bool) ([]string, error) {
+	var actions []string
+	for _, slot := range coverage.openSlotNumbers() {
+		action, err := r.fixOpenSlot(ctx, coverage, slot)
+		if err != nil {
+			return actions, err
+		}
+		actions = append(actions, action)
+	}
+	if assignUncovered && len(coverage.Unassigned) != 0 {
+		if err := r.assignSlots(ctx, coverage); err != nil {
+			return actions, err
+		}
+		actions = append(actions, fmt.Sprintf("assigned slots %s", coverage.Unassigned.String()))
+	}
+	return actions, nil
+}
+
+// Closes an open slot. A migration whose source still owns the slot and whose
+// target is reachable is completed; otherwise the keys already imported are
+// moved back to the owner and the slot is made stable on every node.
+func (r *RedisClusterReconciler) fixOpenSlot(ctx context.Context, coverage *slotCoverage, slot int) (string, error) {
+	owner := coverage.view.SlotOwner(slot)
+	ownerIP := ""
+	if owner != nil {
+		ownerIP = coverage.masters[owner.ID]
+	}
+
+	if ownerIP != "" {
+		for _, open := range coverage.OpenSlots[slot] {
+			targetIP := coverage.masters[open.NodeID]
+			if open.nodeID != owner.ID || open.State != rediscli.SlotMigrating || targetIP == "" {
+				continue
+			}
+			r.Log.Info(fmt.Sprintf("Completing the migration of slot %d from [%s] to [%s]", slot, owner.ID, open.NodeID))
+			if err := r.migrateSlot(ctx, coverage.masters, slot, owner.ID, open.NodeID, defaultMigrationThrottle); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("migrated slot %d", slot), nil
+		}
+	}
+
+	r.Log.Info(fmt.Sprintf("Closing the migration of slot %d", slot))
+	for _, open := range coverage.OpenSlots[slot] {
+		if ownerIP != "" && open.nodeID != owner.ID {
+			if err := r.moveSlotKeys(ctx, slot, open.nodeIP, ownerIP, defaultMigrationThrottle); err != nil {
+				return "", err
+			}
+		}
+		if _, err := r.RedisCLI.ClusterSetSlot(ctx, open.nodeIP, slot, "STABLE"); err != nil {
+			return "", err
+		}
+		r.invalidateClusterSnapshot()
+	}
+	return fmt.Sprintf("closed slot %d", slot), nil
+}
+
+// Moves a slot between two masters: the keys are migrated in batches, then
+// all the masters are told about the new owner
+func (r *RedisClusterReconciler) migrateSlot(ctx context.Context, masters map[string]string, slot int, sourceID string, targetID string, throttle migrationThrottle) error {
+	sourceIP, targetIP := masters[sourceID], masters[targetID]
+	defer r.invalidateClusterSnapshot()
+	if _, err := r.RedisCLI.ClusterSetSlot(ctx, targetIP, slot, "IMPORTING", sourceID); err != nil {
+		return err
+	}
+	if _, err := r.RedisCLI.ClusterSetSlot(ctx, sourceIP, slot, "MIGRATING", targetID); err != nil {
+		return err
+	}
+	if err := r.moveSlotKeys(ctx, slot, sourceIP, targetIP, throttle); err != nil {
+		return err
+	}
+	// the target first so that the slot is never left without an owner
+	if _, err := r.RedisCLI.ClusterSetSlot(ctx, targetIP, slot, "NODE", targetID); err != nil {
+		return err
+	}
+	if _, err := r.RedisCLI.ClusterSetSlot(ctx, sourceIP, slot, "NODE", targetID); err != nil {
+		return err
+	}
+	for id, ip := range masters {
+		if id == sourceID || id == targetID {
+			continue
+		}
+		if _, err := r.RedisCLI.ClusterSetSlot(ctx, ip, slot, "NODE", targetID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Migrates all the keys of a slot from a node to another one in batches
+func (r *RedisClusterReconciler) moveSlotKeys(ctx context.Context, slot int, sourceIP string, targetIP string, throttle migrationThrottle) error {
+	for batch := 0; ; batch++ {
+		keys, err := r.RedisCLI.ClusterGetKeysInSlot(ctx, sourceIP, slot, throttle.batchSize)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		if batch > 0 && throttle.interval > 0 {
+			select {
+			case <-time.After(throttle.interval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		reply, err := r.RedisCLI.Migrate(ctx, sourceIP, targetIP, keys, slotMigrationTimeout)
+		if err != nil {
+			return err
+		}
+		if reply == "NOKEY" {
+			return errors.Errorf("Keys of slot %d on %s could not be migrated to %s", slot, sourceIP, targetIP)
+		}
+	}
+}
+
+// Assigns every unassigned slot to a reachable master: the one holding keys of
+// the slot if any, the master with the fewest slots otherwise. The keys of the
+// unassigned slots are counted with a single pipeline per master.
+func (r *RedisClusterReconciler) assignSlots(ctx context.Context, coverage *slotCoverage) error {
+	slotCounts := make(map[string]int)
+	for id, slots := range coverage.view.SlotsByNode() {
+		slotCounts[id] = slots.Count()
+	}
+	var masterIDs []string
+	for id := range coverage.masters {
+		masterIDs = append(masterIDs, id)
+	}
+	sort.Strings(masterIDs)
+
+	var unassigned []int
+	for _, slotRange := range coverage.Unassigned {
+		for slot := slotRange.First; slot <= slotRange.Last; slot++ {
+			unassigned = append(unassigned, slot)
+		}
+	}
+	keyCounts := make(map[string]map[int]int64)
+	for _, id := range masterIDs {
+		// a slot whose keys were not counted may hold keys on the master: no
+		// slot is assigned
+		counts, err := r.RedisCLI.ClusterCountKeysInSlots(ctx, coverage.masters[id], unassigned...)
+		if err != nil {
+			return err
+		}
+		keyCounts[id] = counts
+	}
+
+	assignments := make(map[string][]int)
+	for _, slot := range unassigned {
+		owner := ""
+		for _, id := range masterIDs {
+			if keyCounts[id][slot] > 0 {
+				owner = id
+				break
+			}
+		}
+		if owner == "" {
+			for _, id := range masterIDs {
+				if owner == "" || slotCounts[id] < slotCounts[owner] {
+					owner = id
+				}
+			}
+		}
+		assignments[owner] = append(assignments[owner], slot)
+		slotCounts[owner]++
+	}
+
+	defer r.invalidateClusterSnapshot()
+	for _, id := range masterIDs {
+		if slots := assignments[id]; len(slots) != 0 {
+			r.Log.Info(fmt.Sprintf("Assigning %d slots to [%s]", len(slots), id))
+			if _, err := r.RedisCLI.ClusterAddSlots(ctx, coverage.masters[id], slots...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
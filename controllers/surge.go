@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dbv1 "github.com/PayU/Redis-Operator/api/v1"
+)
+
+// Label set to true on the temporary followers attached to a leader without
+// followers, so that it can fail over while its pod is replaced
+const surgeLabel = "redis-surge-node"
+
+func isSurgePod(pod *corev1.Pod) bool {
+	return pod.Labels[surgeLabel] == "true"
+}
+
+// The surge pods use the node numbers that follow the cluster layout, one per shard
+func surgeNodeNumber(redisCluster *dbv1.RedisCluster, leaderNumber string) (string, error) {
+	ln, err := strconv.Atoi(leaderNumber)
+	if err != nil {
+		return "", err
+	}
+	nodeCount := redisCluster.Spec.LeaderCount * (redisCluster.Spec.LeaderFollowersCount + 1)
+	return strconv.Itoa(nodeCount + ln), nil
+}
+
+// Creates a surge pod for the leader and waits until it is a replica in sync
+// with the leader. The surge pod announces its hostname and external address
+// like the other nodes while it leads the shard.
+func (r *RedisClusterReconciler) attachSurgeFollower(ctx context.Context, redisCluster *dbv1.RedisCluster, leader *LeaderNode) (*corev1.Pod, error) {
+	nodeNumber, err := surgeNodeNumber(redisCluster, leader.LeaderNumber)
+	if err != nil {
+		return nil, err
+	}
+	r.Log.Info(fmt.Sprintf("Attaching surge follower [%s] to leader [%s]", nodeNumber, leader.NodeNumber))
+
+	if err := r.createSurgeServices(ctx, redisCluster, nodeNumber); err != nil {
+		return nil, err
+	}
+
+	pod, err := r.makeFollowerPod(ctx, redisCluster, nodeNumber, leader.LeaderNumber)
+	if err != nil {
+		return nil, err
+	}
+	pod.Labels[surgeLabel] = "true"
+
+	defer r.invalidateClusterSnapshot()
+	createOpts := []client.CreateOption{client.FieldOwner("redis-operator-controller")}
+	if err := r.Create(ctx, &pod, createOpts...); err != nil && !apierrors.IsAlreadyExists(err) {
+		return nil, err
+	}
+	pods, err := r.waitForPodNetworkInterface(ctx, pod)
+	if err != nil {
+		return nil, err
+	}
+	if pods, err = r.waitForPodReady(ctx, pods...); err != nil {
+		return nil, err
+	}
+	surgePod := pods[0]
+
+	if err := r.waitForRedis(ctx, surgePod.Status.PodIP); err != nil {
+		return nil, err
+	}
+	if err := r.announceHostnames(ctx, redisCluster, surgePod); err != nil {
+		return nil, err
+	}
+	if err := r.announceExternalEndpoints(ctx, redisCluster, surgePod); err != nil {
+		return nil, err
+	}
+	if err := r.replicateLeader(ctx, surgePod.Status.PodIP, leader.Pod.Status.PodIP); err != nil {
+		return nil, err
+	}
+	r.Log.Info(fmt.Sprintf("[OK] Surge follower [%s] in sync with leader [%s]", nodeNumber, leader.NodeNumber))
+	return &surgePod, nil
+}
+
+// Creates the Services of a surge node, which createMissingServices leaves out
+// as the node number follows the cluster layout: the headless Service resolving
+// its announced hostname and, with externalAccess, its external Service
+func (r *RedisClusterReconciler) createSurgeServices(ctx context.Context, redisCluster *dbv1.RedisCluster, nodeNumber string) error {
+	var services []corev1.Service
+	if redisCluster.Spec.AnnounceHostnames {
+		service, err := r.makeNodeService(ctx, redisCluster, nodeNumber)
+		if err != nil {
+			return err
+		}
+		services = append(services, service)
+	}
+	if isExternalAccessEnabled(redisCluster) {
+		service, err := r.makeExternalService(ctx, redisCluster, nodeNumber)
+		if err != nil {
+			return err
+		}
+		services = append(services, service)
+	}
+	for i := range services {
+		if err := r.Create(ctx, &services[i]); err != nil && !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deletes the surge pods and their Services and removes their nodes from the
+// cluster. Surge pods that lead their shard are kept: their shard is recovered
+// first, which makes them followers again.
+func (r *RedisClusterReconciler) removeSurgePods(ctx context.Context, redisCluster *dbv1.RedisCluster) error {
+	clusterView, err := r.NewRedisClusterView(ctx, redisCluster)
+	if err != nil {
+		return err
+	}
+	pods, err := r.getRedisClusterPods(ctx, redisCluster)
+	if err != nil {
+		return err
+	}
+
+	leaderPods := make(map[string]struct{})
+	for _, leader := range *clusterView {
+		if leader.Pod != nil {
+			leaderPods[leader.Pod.Name] = EMPTY
+		}
+	}
+	var surgePods []corev1.Pod
+	var surgeNames []string
+	for _, pod := range pods {
+		if !isSurgePod(&pod) {
+			continue
+		}
+		if _, isLeader := leaderPods[pod.Name]; isLeader {
+			r.Log.Info(fmt.Sprintf("Keeping surge pod %s, it leads shard %s", pod.Name, pod.Labels["leader-number"]))
+			continue
+		}
+		surgePods = append(surgePods, pod)
+		surgeNames = append(surgeNames, pod.Name)
+	}
+	if len(surgePods) == 0 {
+		return nil
+	}
+
+	r.Log.Info(fmt.Sprintf("Removing surge nodes: %v", surgeNames))
+	deletedPods, err := r.deletePods(ctx, surgePods...)
+	if err != nil {
+		return err
+	}
+	if err := r.waitForPodDelete(ctx, deletedPods...); err != nil {
+		return err
+	}
+	for _, pod := range deletedPods {
+		nodeNumber := pod.Labels["node-number"]
+		for _, name := range []string{fmt.Sprintf("redis-node-%s", nodeNumber), externalServiceName(nodeNumber)} {
+			service := corev1.Service{}
+			service.Name = name
+			service.Namespace = redisCluster.Namespace
+			if err := r.Delete(ctx, &service); err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+	return r.forgetLostNodes(ctx, redisCluster)
+}
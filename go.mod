@@ -5,6 +5,7 @@ go 1.15
 require (
 	github.com/go-logr/logr v0.1.0
 	github.com/pkg/errors v0.9.1
+	go.uber.org/zap v1.10.0
 	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e
 	k8s.io/api v0.18.6
 	k8s.io/apiextensions-apiserver v0.18.6
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
+	"io/ioutil"
 	"os"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	dbv1 "github.com/PayU/Redis-Operator/api/v1"
@@ -28,16 +33,44 @@ func init() {
 	// +kubebuilder:scaffold:scheme
 }
 
+// Returns the TLS configuration for the Redis connections; the system roots are
+// used when no CA file is provided
+func redisTLSConfig(caFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		return config, nil
+	}
+	caCert, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	config.RootCAs = x509.NewCertPool()
+	config.RootCAs.AppendCertsFromPEM(caCert)
+	return config, nil
+}
+
 // used in zap logger in order to configure settings
 func loggerOptions(*zap.Options) {}
 
 func main() {
 	var metricsAddr, namespace, enableLeaderElection string
+	var resyncInterval, healthCheckInterval time.Duration
+	var redisUsername, redisTLSCAFile string
+	var redisTLS bool
 	flag.StringVar(&metricsAddr, "metrics-addr", "0.0.0.0:9808", "The address the metric endpoint binds to.")
 	flag.StringVar(&namespace, "namespace", "default", "The namespace the operator will manage.")
 	flag.StringVar(&enableLeaderElection, "enable-leader-election", "true",
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&resyncInterval, "resync-interval", 5*time.Minute,
+		"The period after which a RedisCluster is reconciled even if no event was received. "+
+			"A value of 0 disables the periodic resync.")
+	flag.DurationVar(&healthCheckInterval, "health-check-interval", 30*time.Second,
+		"The period of the Redis health monitor checks. A value of 0 disables the health monitor.")
+	flag.StringVar(&redisUsername, "redis-username", "",
+		"The ACL user used by the operator to connect to Redis. The password is read from the REDIS_PASSWORD environment variable.")
+	flag.BoolVar(&redisTLS, "redis-tls", false, "Use TLS for the connections to the Redis nodes.")
+	flag.StringVar(&redisTLSCAFile, "redis-tls-ca-file", "", "The CA certificate file used to verify the Redis nodes when TLS is enabled.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(loggerOptions))
@@ -56,13 +89,44 @@ func main() {
 	}
 
 	log := ctrl.Log.WithName("controllers").WithName("RedisCluster")
+	redisOptions := rediscli.RedisCLIOptions{
+		Username: redisUsername,
+		Password: os.Getenv("REDIS_PASSWORD"),
+	}
+	if redisTLS {
+		redisOptions.TLSConfig, err = redisTLSConfig(redisTLSCAFile)
+		if err != nil {
+			setupLog.Error(err, "unable to load the Redis TLS configuration")
+			os.Exit(1)
+		}
+	}
+	redisCLI := rediscli.NewRedisCLIWithOptions(log, redisOptions)
+
+	var healthEvents chan event.GenericEvent
+	if healthCheckInterval > 0 {
+		healthEvents = make(chan event.GenericEvent)
+		if err = mgr.Add(&controllers.RedisHealthMonitor{
+			Client:    mgr.GetClient(),
+			Log:       ctrl.Log.WithName("controllers").WithName("RedisHealthMonitor"),
+			RedisCLI:  redisCLI,
+			Namespace: namespace,
+			Interval:  healthCheckInterval,
+			Events:    healthEvents,
+		}); err != nil {
+			setupLog.Error(err, "unable to add health monitor")
+			os.Exit(1)
+		}
+	}
 
 	if err = (&controllers.RedisClusterReconciler{
-		Client:   mgr.GetClient(),
-		Log:      log,
-		Scheme:   mgr.GetScheme(),
-		RedisCLI: rediscli.NewRedisCLI(log),
-		State:    controllers.NotExists,
+		Client:         mgr.GetClient(),
+		Log:            log,
+		Scheme:         mgr.GetScheme(),
+		RedisCLI:       redisCLI,
+		State:          controllers.NotExists,
+		ResyncInterval: resyncInterval,
+		HealthEvents:   healthEvents,
+		Recorder:       mgr.GetEventRecorderFor("redis-operator"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "RedisCluster")
 		os.Exit(1)